@@ -1,6 +1,6 @@
 module github.com/heijmans/independ
 
-go 1.16
+go 1.21
 
 require (
 	github.com/Masterminds/semver/v3 v3.1.1
@@ -11,4 +11,10 @@ require (
 	github.com/pelletier/go-toml v1.9.4
 	github.com/pkg/errors v0.9.1
 	github.com/xhit/go-simple-mail/v2 v2.10.0
+	golang.org/x/crypto v0.24.0
+)
+
+require (
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"flag"
 	"io/fs"
 	"log"
 
@@ -11,10 +12,38 @@ import (
 //go:embed public/*
 var embeddedFs embed.FS
 
-const CONFIG_PATH = "config.toml"
-
 func main() {
-	server.ReadConfig(CONFIG_PATH)
+	configPath := flag.String("config", "config.toml", "path of the config file")
+	port := flag.Int("port", 0, "listen on this port instead of the port in the config")
+	dbSource := flag.String("db", "", "use this database instead of the database in the config")
+	dryRun := flag.Bool("dry-run", false, "print the pending migrations and exit")
+	rollback := flag.Bool("rollback", false, "rollback the last applied migration and exit")
+	exportPath := flag.String("export", "", "export the cached analyses to a json file and exit")
+	importPath := flag.String("import", "", "import the cached analyses from a json file and exit")
+	flag.Parse()
+
+	server.ReadConfig(*configPath, server.ConfigOverrides{Port: *port, DbSource: *dbSource})
+	server.SetupLogging()
+	server.SetupHttpClient()
+	server.SetupSentry()
+
+	if *dryRun {
+		server.DryRunMigrations()
+		return
+	}
+	if *rollback {
+		server.RollbackMigration()
+		return
+	}
+	if *exportPath != "" {
+		server.ExportDb(*exportPath)
+		return
+	}
+	if *importPath != "" {
+		server.ImportDb(*importPath)
+		return
+	}
+
 	server.SetupDb()
 
 	publicFs, err := fs.Sub(embeddedFs, "public")
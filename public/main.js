@@ -15,4 +15,67 @@
             document.getElementById(id).classList.add("tab-active");
         });
     });
+
+    // a link to another page of a table opens its tab again
+    if (location.hash) {
+        const button = document.querySelector(".tab-button[data-tab-id='" + CSS.escape(location.hash.substring(1)) + "']");
+        if (button) {
+            button.click();
+        }
+    }
+
+    // the wait view shows the progress of the analysis, and reloads when the server sends a "ready" event
+    const wait = document.querySelector("[data-events]");
+    if (wait) {
+        const reload = () => document.location.reload();
+        if (window.EventSource) {
+            const events = new EventSource(wait.getAttribute("data-events"));
+            const progress = wait.querySelector(".wait-progress");
+            events.addEventListener("progress", (event) => {
+                progress.textContent = event.data;
+            });
+            events.addEventListener("ready", () => {
+                events.close();
+                reload();
+            });
+            events.addEventListener("error", () => {
+                // the browser reconnects by itself, unless the server refused the stream
+                if (events.readyState === EventSource.CLOSED) {
+                    setTimeout(reload, 2000);
+                }
+            });
+        } else {
+            setTimeout(reload, 2000);
+        }
+    }
+
+    // the package field suggests packages while typing, the datalist shows them
+    const search = document.querySelector("[data-search]");
+    if (search && window.fetch) {
+        const suggestions = document.getElementById(search.getAttribute("list"));
+        let timeout;
+        search.addEventListener("input", () => {
+            clearTimeout(timeout);
+            const query = search.value.trim();
+            if (query.length < 2) {
+                return;
+            }
+            timeout = setTimeout(() => {
+                fetch(search.getAttribute("data-search") + "?q=" + encodeURIComponent(query))
+                    .then((response) => response.ok ? response.json() : [])
+                    .then((results) => {
+                        if (search.value.trim() !== query) {
+                            return;
+                        }
+                        suggestions.replaceChildren(...results.map((result) => {
+                            const option = document.createElement("option");
+                            option.value = result.name;
+                            option.label = result.description || result.version;
+                            return option;
+                        }));
+                    })
+                    .catch(() => {});
+            }, 200);
+        });
+    }
 })();
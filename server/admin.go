@@ -0,0 +1,309 @@
+package server
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+func writeJson(writer http.ResponseWriter, code int, data interface{}) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		log.Panicln("could not marshal json", err)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	_, _ = writer.Write(bytes)
+}
+
+func jsonError(writer http.ResponseWriter, code int, message string, err error) {
+	slog.Warn("http error", "code", code, "message", message, "err", err)
+	if err != nil {
+		message += ": " + err.Error()
+	}
+	writeJson(writer, code, map[string]string{"error": message})
+}
+
+func isAdmin(request *http.Request) bool {
+	token := Config.Admin.Token
+	if token == "" {
+		return false
+	}
+	auth := request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	given := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// AdminOnly only allows requests with the admin token as bearer token
+func AdminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !isAdmin(request) {
+			jsonError(writer, http.StatusUnauthorized, "unauthorized", nil)
+			return
+		}
+		handler(writer, request)
+	}
+}
+
+// PurgePackage removes the cached package info and the given version (or all versions) from the database and the
+// pools, so they are fetched again on the next request
+func PurgePackage(name string, versionRaw string) (packages int64, versions int64, err error) {
+	if versionRaw == "" {
+		packages, err = DbDeletePackage(name)
+		if err != nil {
+			return
+		}
+		packagePool.Forget(func(key string) bool { return key == name })
+	}
+	versions, err = DbDeleteVersions(name, versionRaw)
+	if err != nil {
+		return
+	}
+	versionPool.Forget(func(key string) bool {
+		keyName, keyVersion := parseVersionKey(key)
+		return keyName == name && (versionRaw == "" || keyVersion == versionRaw)
+	})
+	return
+}
+
+func adminParams(writer http.ResponseWriter, request *http.Request) (string, string, bool) {
+	if request.Method != http.MethodPost {
+		jsonError(writer, http.StatusMethodNotAllowed, "use POST", nil)
+		return "", "", false
+	}
+	query := request.URL.Query()
+	name := query.Get("package")
+	if name == "" {
+		jsonError(writer, http.StatusBadRequest, "missing package parameter", nil)
+		return "", "", false
+	}
+	return name, query.Get("version"), true
+}
+
+func adminPurgeHandler(writer http.ResponseWriter, request *http.Request) {
+	name, versionRaw, ok := adminParams(writer, request)
+	if !ok {
+		return
+	}
+	packages, versions, err := PurgePackage(name, versionRaw)
+	if err != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not purge "+name, err)
+		return
+	}
+	slog.Info("purged", "package", name, "version", versionRaw, "packages", packages, "versions", versions)
+	writeJson(writer, http.StatusOK, map[string]interface{}{"packages": packages, "versions": versions})
+}
+
+func adminRefreshHandler(writer http.ResponseWriter, request *http.Request) {
+	name, versionRaw, ok := adminParams(writer, request)
+	if !ok {
+		return
+	}
+	// the package info is always refreshed, the version depends on it. Only the asked version is purged, or all
+	// versions when none is asked.
+	if _, err := DbDeletePackage(name); err != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not purge "+name, err)
+		return
+	}
+	packagePool.Forget(func(key string) bool { return key == name })
+	if _, _, err := PurgePackage(name, versionRaw); err != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not purge "+name, err)
+		return
+	}
+	packageInfo, err := GetPackageInfo(request.Context(), name)
+	if err != nil {
+		jsonError(writer, http.StatusBadGateway, "could not refresh "+name, err)
+		return
+	}
+	if versionRaw != "" {
+		if _, ok := packageInfo.Versions[versionRaw]; !ok {
+			jsonError(writer, http.StatusNotFound, "unknown version "+versionRaw, errors.New("not in registry"))
+			return
+		}
+		// start gathering in the background, the page will show the wait view until it is ready
+		versionPool.ProcessKey(name + "\t" + versionRaw)
+	}
+	slog.Info("refreshed", "package", name, "version", versionRaw)
+	writeJson(writer, http.StatusOK, map[string]string{"latest": packageInfo.DistTags.Latest})
+}
+
+func adminDbStatsHandler(writer http.ResponseWriter, request *http.Request) {
+	stats, err := DbStats()
+	if err != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not get db stats", err)
+		return
+	}
+	writeJson(writer, http.StatusOK, stats)
+}
+
+// the browser pages of the admin use a cookie with a random session, which is stored like the sessions of users. A
+// session ends at logout, or when the admin token changes.
+const ADMIN_COOKIE = "admin"
+const ADMIN_SESSION_DURATION = 7 * 24 * time.Hour
+
+func isAdminSession(request *http.Request) bool {
+	if Config.Admin.Token == "" {
+		return false
+	}
+	cookie, err := request.Cookie(ADMIN_COOKIE)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	exists, err := DbAdminSessionExists(sha256Hex([]byte(cookie.Value)), sha256Hex([]byte(Config.Admin.Token)))
+	if err != nil {
+		slog.Error("could not get admin session", "err", err)
+	}
+	return exists
+}
+
+func setAdminCookie(writer http.ResponseWriter, request *http.Request, value string, maxAge int) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     ADMIN_COOKIE,
+		Value:    value,
+		Path:     Href("/admin"),
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   isHttps(request),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// AdminPagesOnly redirects to the admin login, when the admin is not logged in the browser
+func AdminPagesOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !isAdminSession(request) {
+			http.Redirect(writer, request, Href("/admin/login"), http.StatusSeeOther)
+			return
+		}
+		handler(writer, request)
+	}
+}
+
+func adminLoginHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		WriteHtml(AdminLoginView(RequestLocale(request), "", CsrfToken(request)), writer)
+		return
+	}
+	token := request.FormValue("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(Config.Admin.Token)) != 1 {
+		l := RequestLocale(request)
+		WriteHtmlWithStatus(AdminLoginView(l, l.T("Wrong admin token."), CsrfToken(request)), http.StatusUnauthorized, writer)
+		return
+	}
+	session := secureToken()
+	err := DbCreateAdminSession(sha256Hex([]byte(session)), sha256Hex([]byte(Config.Admin.Token)),
+		time.Now().Add(ADMIN_SESSION_DURATION))
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not start session", err)
+		return
+	}
+	setAdminCookie(writer, request, session, int(ADMIN_SESSION_DURATION.Seconds()))
+	http.Redirect(writer, request, Href("/admin/pages"), http.StatusSeeOther)
+}
+
+func adminLogoutHandler(writer http.ResponseWriter, request *http.Request) {
+	if cookie, err := request.Cookie(ADMIN_COOKIE); err == nil {
+		if err := DbDeleteAdminSession(sha256Hex([]byte(cookie.Value))); err != nil {
+			slog.Error("could not delete admin session", "err", err)
+		}
+	}
+	setAdminCookie(writer, request, "", -1)
+	http.Redirect(writer, request, Href("/"), http.StatusSeeOther)
+}
+
+func adminPagesHandler(writer http.ResponseWriter, request *http.Request) {
+	pages, err := DbListPages()
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not get pages", err)
+		return
+	}
+	WriteHtml(AdminPagesView(RequestLocale(request), pages, CsrfToken(request)), writer)
+}
+
+// adminPageFromRequest returns the page of the id in the path, "new" is an empty page
+func adminPageFromRequest(writer http.ResponseWriter, request *http.Request) *PageRow {
+	rawId := mux.Vars(request)["id"]
+	if rawId == "new" {
+		return &PageRow{}
+	}
+	id, err := strconv.Atoi(rawId)
+	if err != nil {
+		httpError(writer, request, http.StatusNotFound, "unknown page "+rawId, err)
+		return nil
+	}
+	page, err := DbGetPage(id)
+	if err == sql.ErrNoRows {
+		httpError(writer, request, http.StatusNotFound, "unknown page "+rawId, err)
+		return nil
+	}
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not get page", err)
+		return nil
+	}
+	return page
+}
+
+func adminPageEditHandler(writer http.ResponseWriter, request *http.Request) {
+	page := adminPageFromRequest(writer, request)
+	if page == nil {
+		return
+	}
+	l := RequestLocale(request)
+	if request.Method != http.MethodPost {
+		WriteHtml(AdminPageEditView(l, *page, "", CsrfToken(request)), writer)
+		return
+	}
+	page.Path = strings.Trim(strings.TrimSpace(request.FormValue("path")), "/")
+	page.Title = strings.TrimSpace(request.FormValue("title"))
+	page.Content = strings.ReplaceAll(request.FormValue("content"), "\r\n", "\n")
+	page.Published = request.FormValue("published") != ""
+	message := ""
+	if !pagePathRE.MatchString(page.Path) {
+		message = l.T("The path may only contain lowercase letters, digits, - and /.")
+	} else if page.Title == "" {
+		message = l.T("The title is required.")
+	}
+	if message != "" {
+		WriteHtmlWithStatus(AdminPageEditView(l, *page, message, CsrfToken(request)), http.StatusBadRequest, writer)
+		return
+	}
+	if _, err := DbSavePage(*page); err == PagePathTakenError {
+		WriteHtmlWithStatus(AdminPageEditView(l, *page, l.T("There is already a page with this path."), CsrfToken(request)),
+			http.StatusConflict, writer)
+		return
+	} else if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not save page", err)
+		return
+	}
+	slog.Info("saved page", "path", page.Path, "published", page.Published)
+	LoadNavigation()
+	http.Redirect(writer, request, Href("/admin/pages"), http.StatusSeeOther)
+}
+
+func adminPageDeleteHandler(writer http.ResponseWriter, request *http.Request) {
+	page := adminPageFromRequest(writer, request)
+	if page == nil {
+		return
+	}
+	if page.Id != 0 {
+		if err := DbDeletePage(page.Id); err != nil {
+			httpError(writer, request, http.StatusInternalServerError, "could not delete page", err)
+			return
+		}
+		slog.Info("deleted page", "path", page.Path)
+		LoadNavigation()
+	}
+	http.Redirect(writer, request, Href("/admin/pages"), http.StatusSeeOther)
+}
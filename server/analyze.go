@@ -0,0 +1,105 @@
+package server
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// statuses of a job
+const (
+	JOB_PENDING = "pending"
+	JOB_DONE    = "done"
+)
+
+// JOB_RETRY_AFTER is the seconds until a pending job is polled again
+const JOB_RETRY_AFTER = 5
+
+// JOB_KEEP is how long a job goes on after a poll, so scripts can poll less often than ABANDON_GRACE. A job that nobody
+// polls for longer is cancelled, the next poll starts it again.
+const JOB_KEEP = 5 * time.Minute
+
+// Job is an analysis of a file for scripts, the analysis is there when it is done
+type Job struct {
+	Id        string   `json:"id"`
+	Status    string   `json:"status"`
+	Url       string   `json:"url"`    // to poll the job
+	Report    string   `json:"report"` // the page of the analysis, with the token to delete it after the upload
+	Done      int64    `json:"done"`   // steps of the analysis
+	Remaining int64    `json:"remaining"`
+	Analysis  *Version `json:"analysis,omitempty"`
+}
+
+// writeJob waits for the analysis of the file like the file page, and answers with the job: 200 when it is done,
+// else 202 with the url to poll in Location
+func writeJob(writer http.ResponseWriter, request *http.Request, id string, token string) {
+	job := Job{Id: id, Url: absoluteUrl("/api/analyze/" + id), Report: absoluteUrl(fileHref(id, token))}
+	future := FileFuture(id)
+	future.Keep(JOB_KEEP)
+	result := future.AwaitContext(request.Context(), waitDuration(request))
+	job.Done, job.Remaining = future.Progress().Get()
+	if result.Error == TimeoutError {
+		job.Status = JOB_PENDING
+		writer.Header().Set("Location", job.Url)
+		writer.Header().Set("Retry-After", strconv.Itoa(JOB_RETRY_AFTER))
+		writeJson(writer, http.StatusAccepted, job)
+		return
+	}
+	if result.Error == BusyError {
+		writer.Header().Set("Retry-After", strconv.Itoa(BUSY_RETRY_AFTER))
+		jsonError(writer, http.StatusServiceUnavailable, "the server is busy, try again later", nil)
+		return
+	}
+	if errors.Is(result.Error, sql.ErrNoRows) {
+		jsonError(writer, http.StatusNotFound, "no file "+id, nil)
+		return
+	}
+	if result.Error != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not analyze file", result.Error)
+		return
+	}
+	job.Status = JOB_DONE
+	job.Analysis = result.Data.(*Version)
+	writeJson(writer, http.StatusOK, job)
+}
+
+// analyzeHandler stores the package.json, package-lock.json or packages.lock.json in the body for analysis, and answers with the job
+func analyzeHandler(writer http.ResponseWriter, request *http.Request) {
+	bytes, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, MAX_UPLOAD_SIZE))
+	if err != nil {
+		jsonError(writer, http.StatusBadRequest, "the file is >1MB", err)
+		return
+	}
+	version, err := parseFile(bytes)
+	if err != nil {
+		jsonError(writer, http.StatusBadRequest, "could not parse file", err)
+		return
+	}
+	id, token, err := createFile(version, bytes, 0)
+	if err != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not store file", err)
+		return
+	}
+	writeJob(writer, request, id, token)
+}
+
+// analyzeJobHandler answers with the job of an earlier upload, until it is done. The file is looked up first, an
+// unknown id would start a job that only fails.
+func analyzeJobHandler(writer http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+	exists, err := DbFileExists(id)
+	if err != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not get file", err)
+		return
+	}
+	if !exists {
+		jsonError(writer, http.StatusNotFound, "no file "+id, nil)
+		return
+	}
+	writeJob(writer, request, id, "")
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	netmail "net/mail"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const SESSION_COOKIE = "session"
+const SESSION_DURATION = 30 * 24 * time.Hour
+const MIN_PASSWORD_LENGTH = 8
+
+type userContextKey struct{}
+
+// compared when the email address is unknown, so the response time does not reveal which addresses are registered
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// secureToken returns a random token for sessions, which must not be guessable
+func secureToken() string {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// Sessions adds the logged in user to the context of the request, if the session cookie is valid
+func Sessions(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if cookie, err := request.Cookie(SESSION_COOKIE); err == nil && cookie.Value != "" {
+			user, err := DbGetSessionUser(sha256Hex([]byte(cookie.Value)))
+			if err == nil {
+				request = request.WithContext(context.WithValue(request.Context(), userContextKey{}, user))
+			} else if err != sql.ErrNoRows {
+				slog.Error("could not get session", "err", err)
+			}
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// CurrentUser returns the logged in user, or nil for anonymous requests
+func CurrentUser(request *http.Request) *UserRow {
+	user, _ := request.Context().Value(userContextKey{}).(*UserRow)
+	return user
+}
+
+func currentUserId(request *http.Request) int {
+	if user := CurrentUser(request); user != nil {
+		return user.Id
+	}
+	return 0
+}
+
+func isHttps(request *http.Request) bool {
+	return request.TLS != nil || request.URL.Scheme == "https"
+}
+
+func setSessionCookie(writer http.ResponseWriter, request *http.Request, value string, maxAge int) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    value,
+		Path:     Href("/"),
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   isHttps(request),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func startSession(writer http.ResponseWriter, request *http.Request, userId int) error {
+	token := secureToken()
+	if err := DbCreateSession(sha256Hex([]byte(token)), userId, time.Now().Add(SESSION_DURATION)); err != nil {
+		return err
+	}
+	setSessionCookie(writer, request, token, int(SESSION_DURATION.Seconds()))
+	return nil
+}
+
+func signupHandler(writer http.ResponseWriter, request *http.Request) {
+	l := RequestLocale(request)
+	if request.Method != http.MethodPost {
+		WriteHtml(SignupView(l, "", CsrfToken(request)), writer)
+		return
+	}
+	address, err := netmail.ParseAddress(request.FormValue("email"))
+	if err != nil {
+		WriteHtmlWithStatus(SignupView(l, l.T("Invalid email address."), CsrfToken(request)), http.StatusBadRequest, writer)
+		return
+	}
+	password := request.FormValue("password")
+	if len(password) < MIN_PASSWORD_LENGTH {
+		WriteHtmlWithStatus(SignupView(l, l.T("The password is too short."), CsrfToken(request)), http.StatusBadRequest, writer)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not hash password", err)
+		return
+	}
+	userId, err := DbCreateUser(address.Address, string(hash))
+	if err == EmailTakenError {
+		WriteHtmlWithStatus(SignupView(l, l.T("This email address is already registered."), CsrfToken(request)), http.StatusConflict, writer)
+		return
+	}
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not create account", err)
+		return
+	}
+	if err := startSession(writer, request, userId); err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not start session", err)
+		return
+	}
+	http.Redirect(writer, request, Href("/account"), http.StatusSeeOther)
+}
+
+func loginHandler(writer http.ResponseWriter, request *http.Request) {
+	l := RequestLocale(request)
+	if request.Method != http.MethodPost {
+		WriteHtml(LoginView(l, "", CsrfToken(request)), writer)
+		return
+	}
+	user, err := DbGetUserByEmail(request.FormValue("email"))
+	if err != nil && err != sql.ErrNoRows {
+		httpError(writer, request, http.StatusInternalServerError, "could not get account", err)
+		return
+	}
+	passwordHash := dummyPasswordHash
+	if user != nil {
+		passwordHash = []byte(user.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(passwordHash, []byte(request.FormValue("password"))) != nil || user == nil {
+		WriteHtmlWithStatus(LoginView(l, l.T("Wrong email address or password."), CsrfToken(request)), http.StatusUnauthorized, writer)
+		return
+	}
+	if err := startSession(writer, request, user.Id); err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not start session", err)
+		return
+	}
+	http.Redirect(writer, request, Href("/account"), http.StatusSeeOther)
+}
+
+func logoutHandler(writer http.ResponseWriter, request *http.Request) {
+	if cookie, err := request.Cookie(SESSION_COOKIE); err == nil {
+		if err := DbDeleteSession(sha256Hex([]byte(cookie.Value))); err != nil {
+			slog.Error("could not delete session", "err", err)
+		}
+	}
+	setSessionCookie(writer, request, "", -1)
+	http.Redirect(writer, request, Href("/"), http.StatusSeeOther)
+}
+
+func accountHandler(writer http.ResponseWriter, request *http.Request) {
+	user := CurrentUser(request)
+	if user == nil {
+		http.Redirect(writer, request, Href("/login"), http.StatusSeeOther)
+		return
+	}
+	files, err := DbUserFiles(user.Id)
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not get files", err)
+		return
+	}
+	watches, err := DbUserWatches(user.Id)
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not get watches", err)
+		return
+	}
+	WriteHtml(AccountView(RequestLocale(request), user, files, watches, CsrfToken(request)), writer)
+}
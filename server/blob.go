@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BlobStore stores large documents outside the database, only the key is kept in the database
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// S3BlobStore is a minimal client for S3 compatible object storage, using path style urls and signature v4
+type S3BlobStore struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+func NewS3BlobStore(config BlobConfig) *S3BlobStore {
+	region := config.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3BlobStore{
+		endpoint:  strings.TrimRight(config.Endpoint, "/"),
+		bucket:    config.Bucket,
+		region:    region,
+		accessKey: config.AccessKey,
+		secretKey: config.SecretKey,
+	}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func (s *S3BlobStore) sign(request *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + request.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSha256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func (s *S3BlobStore) do(method string, key string, data []byte) ([]byte, error) {
+	u := s.endpoint + "/" + s.bucket + "/" + (&url.URL{Path: key}).EscapedPath()
+	request, err := http.NewRequest(method, u, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(request, sha256Hex(data), time.Now())
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.New(resp.Status + " in " + method + " " + u)
+	}
+	return body, nil
+}
+
+func (s *S3BlobStore) Put(key string, data []byte) error {
+	_, err := s.do(http.MethodPut, key, data)
+	return errors.Wrap(err, "could not put blob "+key)
+}
+
+func (s *S3BlobStore) Get(key string) ([]byte, error) {
+	data, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get blob "+key)
+	}
+	return data, nil
+}
+
+func (s *S3BlobStore) Delete(key string) error {
+	_, err := s.do(http.MethodDelete, key, nil)
+	return errors.Wrap(err, "could not delete blob "+key)
+}
+
+var blobStore BlobStore
+
+func setupBlobStore() {
+	if Config.Blob.Endpoint != "" {
+		blobStore = NewS3BlobStore(Config.Blob)
+	}
+}
+
+func packageBlobKey(name string) string {
+	return "packages/" + name + ".json"
+}
+
+func versionBlobKey(name string, versionRaw string) string {
+	return "versions/" + name + "/" + versionRaw + ".json"
+}
+
+// putContent stores the content in the blob store if it is configured and large enough. It returns the content
+// and the blob key to store in the database, one of which is empty.
+func putContent(key string, content []byte) ([]byte, string, error) {
+	if blobStore == nil || len(content) < Config.Blob.MinSize {
+		return content, "", nil
+	}
+	if err := blobStore.Put(key, content); err != nil {
+		return nil, "", err
+	}
+	return []byte{}, key, nil
+}
+
+func getContent(content string, blobKey string) ([]byte, error) {
+	if blobKey == "" {
+		return []byte(content), nil
+	}
+	if blobStore == nil {
+		return nil, errors.New("blob store is not configured for " + blobKey)
+	}
+	return blobStore.Get(blobKey)
+}
+
+func deleteBlobs(blobKeys []string) {
+	if blobStore == nil {
+		return
+	}
+	for _, key := range blobKeys {
+		if err := blobStore.Delete(key); err != nil {
+			slog.Error("could not delete blob", "key", key, "err", err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var RegistryUnavailableError = errors.New("the npm registry is unavailable, please try again later")
+
+// THREAD SAFE
+// CircuitBreaker fails fast after a number of consecutive failures. After the cooldown, one trial call is let through:
+// when it succeeds the breaker closes again, otherwise it stays open for another cooldown.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	m         sync.Mutex // protects the fields below
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+func (b *CircuitBreaker) allow() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *CircuitBreaker) record(failed bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.trial = false
+	if !failed {
+		if b.failures >= b.threshold {
+			slog.Info("circuit breaker closed", "name", b.name)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			slog.Warn("circuit breaker open", "name", b.name, "failures", b.failures)
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// Call calls f, unless the breaker is open. Only transient errors count as failures, e.g. a 404 does not.
+func (b *CircuitBreaker) Call(f func() error) error {
+	if !b.allow() {
+		return RegistryUnavailableError
+	}
+	err := f()
+	b.record(err != nil && isTransient(err))
+	return err
+}
+
+type StatusError struct {
+	Code   int
+	Status string
+	Url    string
+}
+
+func (e *StatusError) Error() string {
+	return e.Status + " in " + e.Url
+}
+
+// isTransient returns true for network errors and server errors, which may succeed when tried again later
+func isTransient(err error) bool {
+	if errors.Is(err, RegistryUnavailableError) {
+		return false
+	}
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return statusError.Code >= 500 || statusError.Code == 429
+	}
+	return true
+}
+
+var registryBreakersMutex sync.Mutex // protects registryBreakers
+var registryBreakers = map[string]*CircuitBreaker{}
+
+// registryBreaker returns the breaker of the host of a registry url, so a mirror stays available when the registry
+// fails
+func registryBreaker(rawUrl string) *CircuitBreaker {
+	host := rawUrl
+	if u, err := url.Parse(rawUrl); err == nil {
+		host = u.Host
+	}
+	registryBreakersMutex.Lock()
+	defer registryBreakersMutex.Unlock()
+	breaker, ok := registryBreakers[host]
+	if !ok {
+		breaker = NewCircuitBreaker("registry "+host, 5, 30*time.Second)
+		registryBreakers[host] = breaker
+	}
+	return breaker
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CHANGES_BATCH is the number of changes that is fetched at once, a full batch is followed by the next one right away
+const CHANGES_BATCH = 1000
+
+type changesRoot struct {
+	UpdateSeq json.RawMessage `json:"update_seq"`
+}
+
+type changesResponse struct {
+	Results []struct {
+		Id      string `json:"id"`
+		Deleted bool   `json:"deleted"`
+	} `json:"results"`
+	LastSeq json.RawMessage `json:"last_seq"`
+}
+
+// seqParam returns a sequence for the since parameter. CouchDB 1 has numbers, later versions opaque strings.
+func seqParam(seq json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(seq, &s); err == nil {
+		return s
+	}
+	return string(seq)
+}
+
+// currentSeq returns the sequence of the feed now, so the history is not replayed when the feed is first followed
+func currentSeq(ctx context.Context, feedUrl string) (string, error) {
+	var root changesRoot
+	if err := getJson(ctx, feedUrl, &root); err != nil {
+		return "", err
+	}
+	if len(root.UpdateSeq) == 0 {
+		return "", errors.Errorf("no update_seq in %s", feedUrl)
+	}
+	return seqParam(root.UpdateSeq), nil
+}
+
+// followChanges handles one batch of the changes feed after the sequence. It returns the sequence to continue from
+// and whether the batch was full.
+func followChanges(ctx context.Context, feedUrl string, since string) (string, bool, error) {
+	changesUrl := feedUrl + "_changes?since=" + url.QueryEscape(since) + "&limit=" + strconv.Itoa(CHANGES_BATCH)
+	var changes changesResponse
+	if err := getJson(ctx, changesUrl, &changes); err != nil {
+		return since, false, err
+	}
+	var names []string
+	for _, result := range changes.Results {
+		if result.Id != "" && !strings.HasPrefix(result.Id, "_design/") {
+			names = append(names, result.Id)
+		}
+	}
+	stale, err := DbMarkPackagesStale(names, time.Now())
+	if err != nil {
+		return since, false, err
+	}
+	if len(stale) > 0 {
+		slog.Info("packages changed in the registry", "count", len(stale))
+		changed := map[string]bool{}
+		for _, name := range stale {
+			changed[name] = true
+		}
+		packagePool.Forget(func(key string) bool { return changed[key] })
+	}
+	next := since
+	if len(changes.LastSeq) > 0 {
+		next = seqParam(changes.LastSeq)
+	}
+	if err := DbPutChangesSeq(feedUrl, next); err != nil {
+		return next, false, err
+	}
+	return next, len(changes.Results) >= CHANGES_BATCH, nil
+}
+
+// scheduleChanges follows the changes feed of the registry, a package that is changed upstream is marked stale so it
+// is revalidated on its next lookup, instead of when it expires. The sequence is stored, so after a restart no changes
+// are missed.
+func scheduleChanges() {
+	config := Config.Changes
+	if config.Url == "" {
+		return
+	}
+	interval := time.Duration(config.IntervalSeconds) * time.Second
+	ctx := context.Background()
+	for {
+		since, found, err := DbGetChangesSeq(config.Url)
+		if err == nil && !found {
+			since, err = currentSeq(ctx, config.Url)
+		}
+		if err == nil {
+			slog.Info("follow changes feed", "url", config.Url, "since", since)
+			for {
+				var full bool
+				since, full, err = followChanges(ctx, config.Url, since)
+				if err != nil {
+					break
+				}
+				if !full {
+					time.Sleep(interval)
+				}
+			}
+		}
+		slog.Error("could not follow changes feed", "url", config.Url, "err", err)
+		time.Sleep(interval)
+	}
+}
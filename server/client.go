@@ -0,0 +1,59 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const DEFAULT_USER_AGENT = "independ (+https://github.com/heijmans/independ)"
+
+// userAgentTransport sets the user agent on every outgoing request
+type userAgentTransport struct {
+	userAgent string
+	transport http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.Header.Set("User-Agent", t.userAgent)
+	return t.transport.RoundTrip(request)
+}
+
+// registryAuthTransport adds the credentials of the registries in the .npmrc, only to the urls of the registries
+type registryAuthTransport struct {
+	transport http.RoundTripper
+}
+
+func (t registryAuthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if authorization := Config.Registries.Authorization(request.URL); authorization != "" {
+		request = request.Clone(request.Context())
+		request.Header.Set("Authorization", authorization)
+	}
+	return t.transport.RoundTrip(request)
+}
+
+// httpClient is used for all outgoing requests
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// SetupHttpClient configures the outgoing requests. Without a proxy in the config, the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables are used.
+func SetupHttpClient() {
+	config := Config.Http
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		proxyUrl, err := url.Parse(config.Proxy)
+		if err != nil {
+			log.Fatalln("invalid proxy url", config.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	} else {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	transport.ProxyConnectHeader = http.Header{"User-Agent": {config.UserAgent}}
+	httpClient = &http.Client{
+		Transport: userAgentTransport{userAgent: config.UserAgent, transport: registryAuthTransport{transport}},
+		Timeout:   time.Duration(config.TimeoutSeconds) * time.Second,
+	}
+}
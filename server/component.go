@@ -0,0 +1,147 @@
+package server
+
+import (
+	"strconv"
+)
+
+// Component is a reusable part of a view, it renders its props. The props are a struct of the component, so the
+// callers name what they pass.
+type Component[P any] func(props P) Node
+
+type StatCardProps struct {
+	Label  string
+	Value  string
+	Detail string // optional, a line below the value
+}
+
+// StatCard shows a number with its label
+func StatCard(props StatCardProps) Node {
+	var detail Node
+	if props.Detail != "" {
+		detail = H(".stat-card-detail", props.Detail)
+	}
+	return H(".stat-card",
+		H(".stat-card-value", props.Value),
+		H(".stat-card-label", props.Label),
+		detail,
+	)
+}
+
+// StatCards puts the cards in a row, cards that are nil are skipped
+func StatCards(cards ...Node) Node {
+	return H(".stat-cards", cards)
+}
+
+type RankCardProps struct {
+	Label string
+	Items []RankItem // the highest first
+}
+
+type RankItem struct {
+	Label string
+	Href  string
+	Value string
+}
+
+// RankCard is a stat card with a short ranking, it is nil when there are no items
+func RankCard(props RankCardProps) Node {
+	if len(props.Items) == 0 {
+		return nil
+	}
+	return H(".stat-card",
+		H(".stat-card-label", props.Label),
+		H("ol.rank-card", ForEach(props.Items, func(item RankItem) Node {
+			return H("li", H("a href=%s", item.Href, item.Label), item.Value)
+		})),
+	)
+}
+
+type SeverityBadgeProps struct {
+	Locale   Locale
+	Severity Severity
+}
+
+// SeverityBadge colors the severity of a vulnerability, see the badge classes in main.css
+func SeverityBadge(props SeverityBadgeProps) Node {
+	return H("span.badge", props.Locale.T(string(props.Severity))).Class("badge-" + string(props.Severity))
+}
+
+type LicenseBadgeProps struct {
+	Locale        Locale
+	Compatibility LicenseCompatibility
+}
+
+// licenseBadgeClasses reuse the severity colors, a conflict is as bad as a critical vulnerability
+var licenseBadgeClasses = map[LicenseCompatibility]string{
+	LicenseCompatible:   "badge-ok",
+	LicenseReview:       "badge-medium",
+	LicenseConflict:     "badge-critical",
+	LicenseNotForOthers: "badge-critical",
+	LicenseNotKnown:     "badge-low",
+}
+
+// LicenseBadge colors the compatibility of a dependency license
+func LicenseBadge(props LicenseBadgeProps) Node {
+	return H("span.badge", props.Locale.T(string(props.Compatibility))).Class(licenseBadgeClasses[props.Compatibility])
+}
+
+type DataTableProps[T any] struct {
+	Columns []string
+	Heads   []Node // instead of Columns, when the headers are more than text, like sort links
+	Rows    []T
+	Row     Component[T] // renders a row as a tr
+	Empty   string       // shown instead of the table when there are no rows, optional
+}
+
+func DataTable[T any](props DataTableProps[T]) Node {
+	if len(props.Rows) == 0 && props.Empty != "" {
+		return H("p", props.Empty)
+	}
+	heads := props.Heads
+	if heads == nil {
+		heads = ForEach(props.Columns, func(column string) Node { return TextNode(column) })
+	}
+	return H("table",
+		H("tr", ForEach(heads, func(head Node) Node { return H("th", head) })),
+		ForEach(props.Rows, props.Row),
+	)
+}
+
+type PaginationProps struct {
+	Locale Locale
+	Page   int // starts at 1
+	Pages  int
+	Href   func(page int) string
+}
+
+// PAGINATION_WINDOW is the number of pages that are linked before and after the current page
+const PAGINATION_WINDOW = 3
+
+// Pagination links to the previous and next pages and the pages around the current page, it is nil for one page
+func Pagination(props PaginationProps) Node {
+	if props.Pages <= 1 {
+		return nil
+	}
+	l := props.Locale
+	link := func(page int, label string) Node {
+		if page == props.Page {
+			return H("b", label)
+		}
+		return H("a href=%s", props.Href(page), label)
+	}
+	var links []Node
+	if props.Page > 1 {
+		links = append(links, link(props.Page-1, l.T("previous")))
+	}
+	for page := 1; page <= props.Pages; page++ {
+		if page == 1 || page == props.Pages || (page >= props.Page-PAGINATION_WINDOW && page <= props.Page+PAGINATION_WINDOW) {
+			links = append(links, link(page, strconv.Itoa(page)))
+		} else if page == props.Page-PAGINATION_WINDOW-1 || page == props.Page+PAGINATION_WINDOW+1 {
+			links = append(links, H("span", "\u2026"))
+		}
+	}
+	if props.Page < props.Pages {
+		links = append(links, link(props.Page+1, l.T("next")))
+	}
+	return H(".pagination", links)
+}
@@ -1,42 +1,247 @@
 package server
 
 import (
+	"crypto/rsa"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
+	"net"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	toml "github.com/pelletier/go-toml"
 )
 
+type ChangesConfig struct {
+	Url             string // of the replication database of the registry, enables following its changes
+	IntervalSeconds int    `toml:"interval_seconds"`
+}
+
+type DebugConfig struct {
+	Enabled    bool
+	PrettyHtml bool `toml:"pretty_html"` // indent the html, instead of compact html
+}
+
 type DbConfig struct {
 	Source string
 }
 
+type BitbucketConfig struct {
+	Url    string // for bitbucket data center, its api is under the url
+	ApiUrl string `toml:"api_url"`
+	Token  string // optional access token for the rate limit, only used for public repositories
+}
+
+type GithubConfig struct {
+	ClientId     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+	Url          string // for github enterprise
+	ApiUrl       string `toml:"api_url"`
+}
+
+// GithubAppConfig enables the checks of pull requests, when a github app is installed on the repositories
+type GithubAppConfig struct {
+	AppId         int64  `toml:"app_id"`
+	PrivateKey    string `toml:"private_key"` // path of the pem file that github generated
+	WebhookSecret string `toml:"webhook_secret"`
+}
+
+type GitlabConfig struct {
+	Url    string // for a self-hosted gitlab
+	ApiUrl string `toml:"api_url"` // default the api of the url
+	Token  string // optional access token for the rate limit, only used for public repositories
+}
+
+type HttpConfig struct {
+	Proxy          string
+	UserAgent      string `toml:"user_agent"`
+	TimeoutSeconds int    `toml:"timeout_seconds"`
+}
+
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+type JsrConfig struct {
+	ApiUrl string `toml:"api_url"` // ending in /
+}
+
 type MailConfig struct {
-	Server   string
-	Username string
-	Password string
-	ErrorTo  string `toml:"error_to"`
+	Server     string
+	Port       int
+	Encryption string // none, ssl, tls or starttls
+	Auth       string // none, plain, login or cram-md5
+	Username   string
+	Password   string
+	From       string
+	ErrorTo    string `toml:"error_to"`
+	ContactTo  string `toml:"contact_to"` // enables the contact form
+}
+
+type FilesConfig struct {
+	RetentionDays int `toml:"retention_days"`
+}
+
+type NpmConfig struct {
+	Npmrc   string   // path of an .npmrc with the registries and their credentials
+	Mirrors []string // of the default registry, tried in order when it fails
+}
+
+type NugetConfig struct {
+	RegistrationUrl string `toml:"registration_url"` // base url of the registrations of a NuGet v3 feed, ending in /
+	TargetFramework string `toml:"target_framework"` // picks the dependency groups, like net8.0 or netstandard2.0
 }
 
 type PagesConfig struct {
 	Path    string
 	Buttons []string
+	Watch   bool // reload the navigation when the files change
+}
+
+// PolicyConfig are the rules of the ci check, an analysis that breaks one of them fails
+type PolicyConfig struct {
+	FailSeverity   string   `toml:"fail_severity"`   // vulnerabilities of this severity or higher fail, none for no check
+	MinScore       int      `toml:"min_score"`       // the lowest health score, 0 for no check
+	MaxPackages    int      `toml:"max_packages"`    // 0 for no limit
+	FailDeprecated bool     `toml:"fail_deprecated"` // fail on deprecated dependencies
+	DenyLicenses   []string `toml:"deny_licenses"`   // licenses that are not allowed in the dependencies
+	AllowErrors    bool     `toml:"allow_errors"`    // pass an analysis with errors, its results may be incomplete
+}
+
+type BlobConfig struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	MinSize   int    `toml:"min_size"`
+}
+
+type PoolsConfig struct {
+	MaxQueued   int `toml:"max_queued"`
+	WaitSeconds int `toml:"wait_seconds"`
+}
+
+type TarballsConfig struct {
+	Inspect   bool // download the tarballs of versions without file count and size in the registry
+	MaxSizeMb int  `toml:"max_size_mb"`
+}
+
+type StaleConfig struct {
+	Years int // a dependency without a release in this many years is unmaintained
+}
+
+type TablesConfig struct {
+	PageSize int `toml:"page_size"` // rows per page of the dependency and publisher tables
+}
+
+type RefreshConfig struct {
+	Top             int
+	Days            int
+	MarginMinutes   int `toml:"margin_minutes"`
+	IntervalMinutes int `toml:"interval_minutes"`
+}
+
+type SentryConfig struct {
+	Dsn         string
+	Environment string
 }
 
 type ServerConfig struct {
-	Port int
+	Host         string
+	Port         int
+	BasePath     string `toml:"base_path"`
+	TrustProxy   bool   `toml:"trust_proxy"`
+	PublicUrl    string `toml:"public_url"`    // used for links in emails and link previews
+	PreviewImage string `toml:"preview_image"` // default image of link previews, an absolute url or a path
+
+	ReadHeaderTimeoutSeconds int `toml:"read_header_timeout_seconds"`
+	ReadTimeoutSeconds       int `toml:"read_timeout_seconds"`
+	WriteTimeoutSeconds      int `toml:"write_timeout_seconds"`
+	IdleTimeoutSeconds       int `toml:"idle_timeout_seconds"`
+}
+
+type FooterLink struct {
+	Title string
+	Url   string
+}
+
+type ThemeConfig struct {
+	SiteName        string       `toml:"site_name"`
+	Logo            string       // absolute url or path of the logo in the header
+	AccentColor     string       `toml:"accent_color"`
+	AccentTextColor string       `toml:"accent_text_color"`
+	Footer          []FooterLink // links at the bottom of every page
+}
+
+type AdminConfig struct {
+	Token string
+}
+
+type TlsConfig struct {
+	Domains   []string
+	Email     string
+	CacheDir  string `toml:"cache_dir"`
+	HttpPort  int    `toml:"http_port"`
+	HttpsPort int    `toml:"https_port"`
+}
+
+type WebhooksConfig struct {
+	Slack   []string
+	Discord []string
+	Events  []string
 }
 
 type AppConfig struct {
-	Database DbConfig
-	Mail     MailConfig
-	Pages    PagesConfig
-	Server   ServerConfig
+	Admin     AdminConfig
+	Bitbucket BitbucketConfig
+	Changes   ChangesConfig
+	Database  DbConfig
+	Debug     DebugConfig
+	Files     FilesConfig
+	Github    GithubConfig
+	GithubApp GithubAppConfig `toml:"github_app"`
+	Gitlab    GitlabConfig
+	Http      HttpConfig
+	Jsr       JsrConfig
+	Log       LogConfig
+	Blob      BlobConfig
+	Mail      MailConfig
+	Npm       NpmConfig
+	Nuget     NugetConfig
+	Pages     PagesConfig
+	Policy    PolicyConfig
+	Pools     PoolsConfig
+	Refresh   RefreshConfig
+	Sentry    SentryConfig
+	Server    ServerConfig
+	Stale     StaleConfig
+	Tables    TablesConfig
+	Tarballs  TarballsConfig
+	Theme     ThemeConfig
+	Tls       TlsConfig
+	Webhooks  WebhooksConfig
+
+	Registries   Npmrc           `toml:"-"` // read from npm.npmrc
+	GithubAppKey *rsa.PrivateKey `toml:"-"` // read from github_app.private_key
 }
 
 var Config AppConfig
 
-func ReadConfig(path string) {
+// ConfigOverrides are set with command line flags, they replace the values in the config file
+type ConfigOverrides struct {
+	Port     int
+	DbSource string
+}
+
+func ReadConfig(path string, overrides ConfigOverrides) {
 	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		log.Fatalln("could not read config", path, err)
@@ -45,5 +250,346 @@ func ReadConfig(path string) {
 	if err := toml.Unmarshal(bytes, &config); err != nil {
 		log.Fatalln("could not parse config", path, err)
 	}
+	if overrides.Port != 0 {
+		config.Server.Port = overrides.Port
+	}
+	if overrides.DbSource != "" {
+		config.Database.Source = overrides.DbSource
+	}
+	config.setDefaults()
+	if problems := config.validate(); len(problems) > 0 {
+		log.Fatalln("invalid config " + path + ":\n  " + strings.Join(problems, "\n  "))
+	}
+	// validate has parsed it already
+	config.Registries, _ = ReadNpmrc(config.Npm.Npmrc)
+	for _, mirror := range config.Npm.Mirrors {
+		config.Registries.Mirrors = append(config.Registries.Mirrors, withSlash(mirror))
+	}
+	if config.GithubApp.AppId != 0 {
+		config.GithubAppKey, _ = ReadGithubAppKey(config.GithubApp.PrivateKey)
+	}
 	Config = config
 }
+
+func (c *AppConfig) setDefaults() {
+	if c.Server.Port == 0 {
+		c.Server.Port = 8080
+	}
+	// with tls the server must be reachable by Let's Encrypt, the host is not defaulted but required
+	if c.Server.Host == "" && len(c.Tls.Domains) == 0 {
+		c.Server.Host = "localhost"
+	}
+	c.Server.BasePath = strings.TrimRight(c.Server.BasePath, "/")
+	if c.Server.BasePath != "" && !strings.HasPrefix(c.Server.BasePath, "/") {
+		c.Server.BasePath = "/" + c.Server.BasePath
+	}
+	if c.Server.PublicUrl == "" {
+		c.Server.PublicUrl = "http://" + net.JoinHostPort(c.Server.Host, strconv.Itoa(c.Server.Port)) + c.Server.BasePath
+	}
+	c.Server.PublicUrl = strings.TrimRight(c.Server.PublicUrl, "/")
+	if c.Server.ReadHeaderTimeoutSeconds <= 0 {
+		c.Server.ReadHeaderTimeoutSeconds = 10
+	}
+	if c.Server.ReadTimeoutSeconds <= 0 {
+		c.Server.ReadTimeoutSeconds = 30
+	}
+	if c.Server.WriteTimeoutSeconds <= 0 {
+		c.Server.WriteTimeoutSeconds = 60
+	}
+	if c.Server.IdleTimeoutSeconds <= 0 {
+		c.Server.IdleTimeoutSeconds = 120
+	}
+	if c.Theme.SiteName == "" {
+		c.Theme.SiteName = "independ"
+	}
+	if c.Theme.AccentColor == "" {
+		c.Theme.AccentColor = "#36f"
+	}
+	if c.Theme.AccentTextColor == "" {
+		c.Theme.AccentTextColor = "white"
+	}
+	if c.Tls.CacheDir == "" {
+		c.Tls.CacheDir = "certs"
+	}
+	if c.Tls.HttpPort == 0 {
+		c.Tls.HttpPort = 80
+	}
+	if c.Tls.HttpsPort == 0 {
+		c.Tls.HttpsPort = 443
+	}
+	if c.Github.Url == "" {
+		c.Github.Url = "https://github.com"
+	}
+	c.Github.Url = strings.TrimRight(c.Github.Url, "/")
+	if c.Github.ApiUrl == "" {
+		c.Github.ApiUrl = "https://api.github.com"
+	}
+	c.Github.ApiUrl = strings.TrimRight(c.Github.ApiUrl, "/")
+	if c.Gitlab.Url == "" {
+		c.Gitlab.Url = "https://gitlab.com"
+	}
+	c.Gitlab.Url = strings.TrimRight(c.Gitlab.Url, "/")
+	if c.Gitlab.ApiUrl == "" {
+		c.Gitlab.ApiUrl = c.Gitlab.Url + "/api/v4"
+	}
+	c.Gitlab.ApiUrl = strings.TrimRight(c.Gitlab.ApiUrl, "/")
+	if c.Bitbucket.Url == "" {
+		c.Bitbucket.Url = "https://bitbucket.org"
+	}
+	c.Bitbucket.Url = strings.TrimRight(c.Bitbucket.Url, "/")
+	if c.Bitbucket.ApiUrl == "" {
+		c.Bitbucket.ApiUrl = "https://api.bitbucket.org/2.0"
+	}
+	c.Bitbucket.ApiUrl = strings.TrimRight(c.Bitbucket.ApiUrl, "/")
+	if c.Http.UserAgent == "" {
+		c.Http.UserAgent = DEFAULT_USER_AGENT
+	}
+	if c.Http.TimeoutSeconds <= 0 {
+		c.Http.TimeoutSeconds = 60
+	}
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+	if c.Log.Format == "" {
+		c.Log.Format = "text"
+	}
+	if c.Mail.Port == 0 {
+		c.Mail.Port = 587
+	}
+	if c.Mail.Encryption == "" {
+		c.Mail.Encryption = "starttls"
+	}
+	if c.Mail.Auth == "" {
+		c.Mail.Auth = "plain"
+	}
+	if c.Mail.From == "" {
+		c.Mail.From = "independ <info@independ.org>"
+	}
+	if c.Webhooks.Events == nil {
+		c.Webhooks.Events = alertKinds
+	}
+	if c.Pools.MaxQueued == 0 {
+		c.Pools.MaxQueued = 100
+	}
+	if c.Pools.WaitSeconds <= 0 {
+		c.Pools.WaitSeconds = 1
+	}
+	if c.Tarballs.MaxSizeMb <= 0 {
+		c.Tarballs.MaxSizeMb = 20
+	}
+	if c.Policy.FailSeverity == "" {
+		c.Policy.FailSeverity = string(High)
+	}
+	if c.Stale.Years <= 0 {
+		c.Stale.Years = 2
+	}
+	if c.Tables.PageSize <= 0 {
+		c.Tables.PageSize = 100
+	}
+	if c.Changes.Url != "" && !strings.HasSuffix(c.Changes.Url, "/") {
+		c.Changes.Url += "/"
+	}
+	if c.Changes.IntervalSeconds <= 0 {
+		c.Changes.IntervalSeconds = 60
+	}
+	if c.Jsr.ApiUrl == "" {
+		c.Jsr.ApiUrl = "https://api.jsr.io/"
+	}
+	if !strings.HasSuffix(c.Jsr.ApiUrl, "/") {
+		c.Jsr.ApiUrl += "/"
+	}
+	if c.Nuget.RegistrationUrl == "" {
+		c.Nuget.RegistrationUrl = "https://api.nuget.org/v3/registration5-gz-semver2/"
+	}
+	if !strings.HasSuffix(c.Nuget.RegistrationUrl, "/") {
+		c.Nuget.RegistrationUrl += "/"
+	}
+	if c.Nuget.TargetFramework == "" {
+		c.Nuget.TargetFramework = "net8.0"
+	}
+	if c.Refresh.Days <= 0 {
+		c.Refresh.Days = 7
+	}
+	if c.Refresh.MarginMinutes <= 0 {
+		c.Refresh.MarginMinutes = 30
+	}
+	if c.Refresh.IntervalMinutes <= 0 {
+		c.Refresh.IntervalMinutes = 10
+	}
+}
+
+// cssColorRE only allows hex colors, color names and rgb() or hsl(), the colors end up in a style element
+var cssColorRE = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|rgba|hsl|hsla)\([0-9.,%\s]+\))$`)
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// validate returns all the problems in the config, so they can be fixed at once
+func (c *AppConfig) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !validPort(c.Server.Port) {
+		add("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+
+	if c.Database.Source == "" {
+		add("database.source is required")
+	} else if dir := filepath.Dir(c.Database.Source); !dirExists(dir) {
+		add("database.source: directory %s does not exist", dir)
+	}
+
+	if len(c.Tls.Domains) > 0 {
+		if c.Server.Host == "" {
+			add("server.host is required with tls, e.g. \"0.0.0.0\" to listen on all interfaces")
+		}
+		if !validPort(c.Tls.HttpPort) {
+			add("tls.http_port must be between 1 and 65535, got %d", c.Tls.HttpPort)
+		}
+		if !validPort(c.Tls.HttpsPort) {
+			add("tls.https_port must be between 1 and 65535, got %d", c.Tls.HttpsPort)
+		}
+	}
+
+	if c.Github.ClientId != "" && c.Github.ClientSecret == "" {
+		add("github.client_secret is required with github.client_id")
+	}
+	for _, host := range [][2]string{{"github.url", c.Github.Url}, {"gitlab.url", c.Gitlab.Url},
+		{"bitbucket.url", c.Bitbucket.Url}} {
+		if u, err := url.Parse(host[1]); err != nil || u.Host == "" {
+			add("%s: %s is not a valid url", host[0], host[1])
+		}
+	}
+
+	if c.Policy.FailSeverity != "none" && severityRank(Severity(c.Policy.FailSeverity)) < 0 {
+		add("policy.fail_severity must be low, medium, high, critical or none, got %s", c.Policy.FailSeverity)
+	}
+	if c.Policy.MinScore < 0 || c.Policy.MinScore > 100 {
+		add("policy.min_score must be between 0 and 100, got %d", c.Policy.MinScore)
+	}
+
+	if c.GithubApp.AppId != 0 {
+		if _, err := ReadGithubAppKey(c.GithubApp.PrivateKey); err != nil {
+			add("github_app.private_key: %s", err)
+		}
+		if c.GithubApp.WebhookSecret == "" {
+			add("github_app.webhook_secret is required with github_app.app_id")
+		}
+	}
+
+	if _, err := ReadNpmrc(c.Npm.Npmrc); err != nil {
+		add("npm.npmrc: %s", err)
+	}
+	for _, mirror := range c.Npm.Mirrors {
+		if u, err := url.Parse(mirror); err != nil || u.Host == "" {
+			add("npm.mirrors: %s is not a valid url", mirror)
+		}
+	}
+
+	if c.Http.Proxy != "" {
+		if u, err := url.Parse(c.Http.Proxy); err != nil || u.Host == "" {
+			add("http.proxy is not a valid url: %s", c.Http.Proxy)
+		}
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		add("log.level must be debug, info, warn or error, got %s", c.Log.Level)
+	}
+	if format := strings.ToLower(c.Log.Format); format != "text" && format != "json" {
+		add("log.format must be text or json, got %s", c.Log.Format)
+	}
+
+	if c.Mail.ErrorTo != "" && c.Mail.Server == "" {
+		add("mail.server is required to send errors to %s", c.Mail.ErrorTo)
+	}
+
+	if c.Mail.ContactTo != "" {
+		if c.Mail.Server == "" {
+			add("mail.server is required to send contact messages to %s", c.Mail.ContactTo)
+		}
+		if _, err := netmail.ParseAddress(c.Mail.ContactTo); err != nil {
+			add("mail.contact_to is not a valid address: %s", c.Mail.ContactTo)
+		}
+	}
+
+	if !validPort(c.Mail.Port) {
+		add("mail.port must be between 1 and 65535, got %d", c.Mail.Port)
+	}
+	if _, ok := mailEncryptions[strings.ToLower(c.Mail.Encryption)]; !ok {
+		add("mail.encryption must be none, ssl, tls or starttls, got %s", c.Mail.Encryption)
+	}
+	if _, ok := mailAuths[strings.ToLower(c.Mail.Auth)]; !ok {
+		add("mail.auth must be none, plain, login or cram-md5, got %s", c.Mail.Auth)
+	}
+	if _, err := netmail.ParseAddress(c.Mail.From); err != nil {
+		add("mail.from is not a valid address: %s", c.Mail.From)
+	}
+
+	if c.Pages.Path != "" && !dirExists(c.Pages.Path) {
+		add("pages.path: directory %s does not exist", c.Pages.Path)
+	}
+
+	if c.Blob.Endpoint != "" {
+		if c.Blob.Bucket == "" || c.Blob.AccessKey == "" || c.Blob.SecretKey == "" {
+			add("blob.bucket, blob.access_key and blob.secret_key are required with blob.endpoint")
+		}
+	}
+
+	if c.Sentry.Dsn != "" {
+		if _, err := parseSentryDsn(c.Sentry.Dsn); err != nil {
+			add("sentry.dsn: %s", err)
+		}
+	}
+
+	for _, webhook := range append(append([]string{}, c.Webhooks.Slack...), c.Webhooks.Discord...) {
+		if u, err := url.Parse(webhook); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			add("webhooks: not a valid url: %s", webhook)
+		}
+	}
+	for _, event := range c.Webhooks.Events {
+		if !contains(alertKinds, event) {
+			add("webhooks.events: unknown event %s, must be one of %s", event, strings.Join(alertKinds, ", "))
+		}
+	}
+
+	if !cssColorRE.MatchString(c.Theme.AccentColor) {
+		add("theme.accent_color is not a valid color: %s", c.Theme.AccentColor)
+	}
+	if !cssColorRE.MatchString(c.Theme.AccentTextColor) {
+		add("theme.accent_text_color is not a valid color: %s", c.Theme.AccentTextColor)
+	}
+	for _, link := range c.Theme.Footer {
+		if link.Title == "" || link.Url == "" {
+			add("theme.footer: title and url are required, got %q %q", link.Title, link.Url)
+		}
+	}
+
+	if c.Files.RetentionDays < 0 {
+		add("files.retention_days must not be negative, got %d", c.Files.RetentionDays)
+	}
+	if c.Pools.WaitSeconds > MAX_WAIT_SECONDS {
+		add("pools.wait_seconds must be at most %d, got %d", MAX_WAIT_SECONDS, c.Pools.WaitSeconds)
+	}
+	if c.Refresh.Top < 0 {
+		add("refresh.top must not be negative, got %d", c.Refresh.Top)
+	}
+	return problems
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
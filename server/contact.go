@@ -0,0 +1,131 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+const CONTACT_MAX_LENGTH = 5000
+
+// a visitor can send a few messages per window, to limit spam
+const CONTACT_LIMIT = 3
+const CONTACT_WINDOW = time.Hour
+
+// CONTACT_HONEYPOT is a field that is hidden with css, only bots fill it in
+const CONTACT_HONEYPOT = "website"
+
+type ContactForm struct {
+	Name    string
+	Email   string
+	Message string
+}
+
+type ContactMailData struct {
+	ContactForm
+	RemoteIp string
+}
+
+// rateLimiter counts the events per key in a sliding window
+type rateLimiter struct {
+	sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: map[string][]time.Time{}}
+}
+
+// allow records an event for the key, it returns false when the key has reached the limit in the window
+func (r *rateLimiter) allow(key string, now time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+	var recent []time.Time
+	for _, t := range r.events[key] {
+		if now.Sub(t) < r.window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= r.limit {
+		r.events[key] = recent
+		return false
+	}
+	r.events[key] = append(recent, now)
+	// forget the keys without recent events now and then, so the map doesn't grow forever
+	if len(r.events) > 10000 {
+		for k, events := range r.events {
+			if now.Sub(events[len(events)-1]) >= r.window {
+				delete(r.events, k)
+			}
+		}
+	}
+	return true
+}
+
+var contactLimiter = newRateLimiter(CONTACT_LIMIT, CONTACT_WINDOW)
+
+func remoteIp(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+func contactHandler(writer http.ResponseWriter, request *http.Request) {
+	l := RequestLocale(request)
+	if request.Method != http.MethodPost {
+		WriteHtml(ContactView(l, "", ContactForm{}, CsrfToken(request)), writer)
+		return
+	}
+	form := ContactForm{
+		Name:    strings.TrimSpace(request.FormValue("name")),
+		Email:   strings.TrimSpace(request.FormValue("email")),
+		Message: strings.TrimSpace(strings.ReplaceAll(request.FormValue("message"), "\r\n", "\n")),
+	}
+	ip := remoteIp(request)
+	if request.FormValue(CONTACT_HONEYPOT) != "" {
+		// pretend it was sent, so the bot doesn't try again
+		slog.Info("ignored contact form with honeypot", "ip", ip)
+		WriteHtml(WatchMessageView(l, l.T("Message sent"), l.T("Thank you, we will get back to you soon.")), writer)
+		return
+	}
+	message := ""
+	if _, err := netmail.ParseAddress(form.Email); err != nil {
+		message = l.T("Invalid email address.")
+	} else if form.Message == "" {
+		message = l.T("The message is required.")
+	} else if len(form.Message) > CONTACT_MAX_LENGTH {
+		message = l.T("The message is longer than %d characters.", CONTACT_MAX_LENGTH)
+	}
+	if message != "" {
+		WriteHtmlWithStatus(ContactView(l, message, form, CsrfToken(request)), http.StatusBadRequest, writer)
+		return
+	}
+	if !contactLimiter.allow(ip, time.Now()) {
+		slog.Warn("contact form rate limited", "ip", ip)
+		WriteHtmlWithStatus(ContactView(l, l.T("You have sent too many messages, please try again later."), form,
+			CsrfToken(request)), http.StatusTooManyRequests, writer)
+		return
+	}
+	if err := SendMail("contact", Config.Mail.ContactTo, ContactMailData{ContactForm: form, RemoteIp: ip}); err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not send message", err)
+		return
+	}
+	WriteHtml(WatchMessageView(l, l.T("Message sent"), l.T("Thank you, we will get back to you soon.")), writer)
+}
+
+func init() {
+	RegisterMailTemplate("contact", MailTemplate{
+		Subject: "Contact form: {{.Email}}",
+		Body: func(data interface{}) Node {
+			return ContactMailView(data.(ContactMailData))
+		},
+	})
+}
@@ -1,48 +1,99 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"log"
-	"math/rand"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 )
 
-func returnError(title string, err string, trace string, code int, writer http.ResponseWriter) {
-	if Config.Mail.ErrorTo != "" && title != "Not found" {
-		log.Println("send error email...")
+// reportError sends the error to sentry and by email, when configured. It returns false if the error is not reported.
+func reportError(title string, err string, trace string, event *SentryEvent) bool {
+	reported := false
+	if sentry != nil {
+		slog.Info("send error to sentry")
+		go SendSentry(event)
+		reported = true
+	}
+	if Config.Mail.ErrorTo != "" {
+		slog.Info("send error email")
 		go SendError(title+": "+err, trace)
-		trace = "We have received the technical details of this error and will look into it."
+		reported = true
 	}
-	WriteHtmlWithStatus(ErrorView(title, err, trace), code, writer)
+	return reported
 }
 
-func httpError(writer http.ResponseWriter, code int, message string, error error) {
-	log.Println("HTTP ERROR", code, message, error)
-	title := "Error: " + message
-	if code == 404 {
-		title = "Not found"
+// returnError reports the error with the english title, the visitor sees the translated title
+func returnError(title string, shownTitle string, err string, trace string, code int, writer http.ResponseWriter,
+	request *http.Request, event *SentryEvent) {
+	l := RequestLocale(request)
+	if code != http.StatusNotFound && reportError(title, err, trace, event) {
+		trace = l.T("We have received the technical details of this error and will look into it.")
 	}
-	returnError(title, message, error.Error(), code, writer)
+	WriteHtmlWithStatus(ErrorView(l, shownTitle, err, trace), code, writer)
 }
 
-func redirectToLastVersion(writer http.ResponseWriter, packageName string) {
-	latestVersion, err := DbGetPackageLatestVersion(packageName)
-	if err != nil {
-		packageInfo, err := GetPackageInfo(packageName)
+func httpError(writer http.ResponseWriter, request *http.Request, code int, message string, error error) {
+	slog.Warn("http error", "code", code, "message", message, "err", error)
+	l := RequestLocale(request)
+	title, shownTitle := "Error: "+message, l.T("Error: %s", message)
+	if code == http.StatusNotFound {
+		title, shownTitle = "Not found", l.T("Not found")
+	}
+	event := NewSentryEvent(request, fmt.Sprintf("%T", errors.Cause(error)), message+": "+error.Error(), errorPcs(error))
+	event.Tags = map[string]string{"status": strconv.Itoa(code)}
+	returnError(title, shownTitle, message, error.Error(), code, writer, request, event)
+}
+
+const BUSY_RETRY_AFTER = 30
+
+func busyError(writer http.ResponseWriter, request *http.Request) {
+	slog.Warn("pools are busy")
+	writer.Header().Set("Retry-After", strconv.Itoa(BUSY_RETRY_AFTER))
+	WriteHtmlWithStatus(BusyView(RequestLocale(request), BUSY_RETRY_AFTER), http.StatusServiceUnavailable, writer)
+}
+
+// latestVersion returns the latest version of a package, from the db or else from the registry
+func latestVersion(ctx context.Context, packageName string) (string, error) {
+	latest, err := DbGetPackageLatestVersion(packageName)
+	// it is cleared when the package changed, see DbMarkPackagesStale
+	if err != nil || latest == "" {
+		packageInfo, err := RequestPackageInfo(ctx, packageName)
 		if err != nil {
-			httpError(writer, http.StatusNotFound, "could not get package "+packageName, err)
-			return
+			return "", err
 		}
-		latestVersion = packageInfo.DistTags.Latest
+		latest = packageInfo.DistTags.Latest
+	}
+	return latest, nil
+}
+
+func redirectToLastVersion(writer http.ResponseWriter, request *http.Request, packageName string) {
+	CountLookup(request, packageName)
+	latest, err := latestVersion(request.Context(), packageName)
+	if err == BusyError {
+		busyError(writer, request)
+		return
 	}
-	writer.Header().Set("Location", "/npm/"+packageName+"/"+latestVersion)
+	if err != nil {
+		httpError(writer, request, http.StatusNotFound, "could not get package "+packageName, err)
+		return
+	}
+	writer.Header().Set("Location", npmHref(packageName, latest))
 	writer.WriteHeader(http.StatusFound)
 }
 
@@ -53,9 +104,56 @@ func packageHandler(writer http.ResponseWriter, request *http.Request) {
 	if ns != "" {
 		name = ns + "/" + name
 	}
-	redirectToLastVersion(writer, name)
+	redirectToLastVersion(writer, request, name)
 }
 
+func packageVersionsHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	name := vars["name"]
+	if ns := vars["ns"]; ns != "" {
+		name = ns + "/" + name
+	}
+	CountLookup(request, name)
+	packageInfo, err := RequestPackageInfo(request.Context(), name)
+	if err == BusyError {
+		busyError(writer, request)
+		return
+	}
+	if err != nil {
+		httpError(writer, request, http.StatusNotFound, "could not get package "+name, err)
+		return
+	}
+	snapshots, err := DbGetStatSnapshots(name)
+	if err != nil {
+		slog.Error("could not get stat snapshots", "package", name, "err", err)
+	}
+	WriteHtmlCached(PackageVersionsView(RequestLocale(request), packageInfo, snapshots, ParseTableQuery(request)),
+		VERSION_CACHE_CONTROL, writer, request)
+}
+
+// MAX_WAIT_SECONDS stays below ANALYSIS_DEADLINE, so there is time left to render the page
+const MAX_WAIT_SECONDS = 25
+
+// waitDuration is the time to wait for an analysis before the wait view is shown. API clients can set ?wait=seconds
+// to block longer instead of polling.
+func waitDuration(request *http.Request) time.Duration {
+	seconds := Config.Pools.WaitSeconds
+	if raw := request.URL.Query().Get("wait"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			seconds = n
+		}
+	}
+	if seconds > MAX_WAIT_SECONDS {
+		seconds = MAX_WAIT_SECONDS
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// an analysis can change when new versions of dependencies are published, so only cache it for a while. Uploaded
+// files are private and always revalidated.
+const VERSION_CACHE_CONTROL = "public, max-age=600"
+const FILE_CACHE_CONTROL = "private, no-cache"
+
 func versionHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	ns := vars["ns"]
@@ -64,21 +162,122 @@ func versionHandler(writer http.ResponseWriter, request *http.Request) {
 	if ns != "" {
 		name = ns + "/" + name
 	}
-	version, err := GetVersion(name, versionRaw)
+	CountLookup(request, name)
+	version, err := GetVersion(request.Context(), name, versionRaw, waitDuration(request))
 	if err == TimeoutError {
-		WriteHtml(WaitView(name), writer)
+		WriteHtml(WaitView(RequestLocale(request), name, "/events/npm/"+name+"/"+versionRaw), writer)
+		return
+	}
+	if err == BusyError {
+		busyError(writer, request)
 		return
 	}
 	if err != nil {
-		httpError(writer, http.StatusNotFound, "could not get dependencies for package "+name+" "+versionRaw, err)
+		httpError(writer, request, http.StatusNotFound, "could not get dependencies for package "+name+" "+versionRaw, err)
+		return
+	}
+	WriteHtmlCached(VersionView(RequestLocale(request), version, ParseTableQuery(request)), VERSION_CACHE_CONTROL, writer, request)
+}
+
+// BADGE_WAIT is short, image proxies like the one of GitHub give up after a few seconds
+const BADGE_WAIT = 3 * time.Second
+const BADGE_CACHE_CONTROL = "public, max-age=3600"
+
+// badgeHandler shows the health grade of a version as an svg badge, of the latest version when no version is given.
+// While the analysis runs the grade is "?", and the badge is not cached.
+func badgeHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	name := vars["name"]
+	if ns := vars["ns"]; ns != "" {
+		name = ns + "/" + name
+	}
+	versionRaw := vars["version"]
+	var err error
+	if versionRaw == "" {
+		versionRaw, err = latestVersion(request.Context(), name)
+	}
+	grade := "?"
+	cacheControl := "no-cache"
+	if err == nil {
+		var version *Version
+		version, err = GetVersion(request.Context(), name, versionRaw, BADGE_WAIT)
+		if err == nil {
+			grade = version.Health(Config.Stale.Years, time.Now()).Grade
+			cacheControl = BADGE_CACHE_CONTROL
+		}
+	}
+	if err != nil && err != TimeoutError && err != BusyError {
+		httpError(writer, request, http.StatusNotFound, "could not get badge for package "+name+" "+versionRaw, err)
 		return
 	}
-	WriteHtml(VersionView(version), writer)
+	header := writer.Header()
+	header.Set("Content-Type", "image/svg+xml")
+	header.Set("Cache-Control", cacheControl)
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write([]byte(RenderNode(HealthBadge(grade))))
+}
+
+// resolverName joins the vars of the name patterns of a resolver
+func resolverName(request *http.Request) string {
+	vars := mux.Vars(request)
+	if ns := vars["ns"]; ns != "" {
+		return ns + "/" + vars["name"]
+	}
+	return vars["name"]
+}
+
+// resolverPackageHandler redirects to the latest version of a package of the resolver
+func resolverPackageHandler(resolver EcosystemResolver) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		name := resolverName(request)
+		data, err := resolver.RequestPackage(request.Context(), name)
+		if err == BusyError {
+			busyError(writer, request)
+			return
+		}
+		if err != nil {
+			httpError(writer, request, http.StatusNotFound, "could not get "+resolver.Prefix()+" package "+name, err)
+			return
+		}
+		latest, ok := resolver.LatestVersion(data)
+		if !ok {
+			httpError(writer, request, http.StatusNotFound, "could not get "+resolver.Prefix()+" package "+name,
+				errors.New("no versions"))
+			return
+		}
+		writer.Header().Set("Location", Href("/"+resolver.Prefix()+"/"+name+"/"+latest))
+		writer.WriteHeader(http.StatusFound)
+	}
+}
+
+func resolverVersionHandler(resolver EcosystemResolver) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		name := resolverName(request)
+		versionRaw := mux.Vars(request)["version"]
+		future := resolver.VersionFuture(name, versionRaw)
+		result := future.AwaitContext(request.Context(), waitDuration(request))
+		if result.Error == TimeoutError {
+			events := "/events/" + resolver.Prefix() + "/" + name + "/" + versionRaw
+			WriteHtml(WaitView(RequestLocale(request), name, events), writer)
+			return
+		}
+		if result.Error == BusyError {
+			busyError(writer, request)
+			return
+		}
+		if result.Error != nil {
+			httpError(writer, request, http.StatusNotFound,
+				"could not get dependencies for "+resolver.Prefix()+" package "+name+" "+versionRaw, result.Error)
+			return
+		}
+		WriteHtmlCached(VersionView(RequestLocale(request), result.Data.(*Version), ParseTableQuery(request)),
+			VERSION_CACHE_CONTROL, writer, request)
+	}
 }
 
 func goHandler(writer http.ResponseWriter, request *http.Request) {
 	name := request.URL.Query().Get("package")
-	redirectToLastVersion(writer, name)
+	redirectToLastVersion(writer, request, name)
 }
 
 func pageHandler(writer http.ResponseWriter, request *http.Request) {
@@ -86,23 +285,170 @@ func pageHandler(writer http.ResponseWriter, request *http.Request) {
 	path := vars["path"]
 	page, err := GetPage(path)
 	if err != nil {
-		httpError(writer, http.StatusNotFound, "could not get page "+path, err)
+		httpError(writer, request, http.StatusNotFound, "could not get page "+path, err)
 		return
 	}
-	WriteHtml(PageView(page), writer)
+	page.Content = RenderPlaceholders(page.Content)
+	WriteHtml(PageView(RequestLocale(request), page), writer)
+}
+
+func notFoundHandler(writer http.ResponseWriter, request *http.Request) {
+	slog.Info("not found", "path", request.URL.Path)
+	WriteHtmlWithStatus(NotFoundView(RequestLocale(request), request.URL.Path), http.StatusNotFound, writer)
+}
+
+// HOME_STATS_TTL is how long the counts of the home page are cached, counting the tables scans them
+const HOME_STATS_TTL = 10 * time.Minute
+
+var homeStatsCache = struct {
+	sync.Mutex
+	stats      HomeStats
+	expireTime time.Time
+}{}
+
+// homeStats returns the cached counts of the home page, they are counted again when they are expired or failed
+func homeStats() HomeStats {
+	homeStatsCache.Lock()
+	defer homeStatsCache.Unlock()
+	if time.Now().Before(homeStatsCache.expireTime) {
+		return homeStatsCache.stats
+	}
+	var stats HomeStats
+	var err error
+	counted := true
+	if stats.Packages, err = DbCountRows("packages"); err != nil {
+		slog.Error("could not count packages", "err", err)
+		counted = false
+	}
+	if stats.Versions, err = DbCountRows("versions"); err != nil {
+		slog.Error("could not count versions", "err", err)
+		counted = false
+	}
+	if counted {
+		homeStatsCache.stats = stats
+		homeStatsCache.expireTime = time.Now().Add(HOME_STATS_TTL)
+	}
+	return stats
 }
 
 func homeHandler(writer http.ResponseWriter, request *http.Request) {
-	WriteHtml(HomeView(), writer)
+	WriteHtml(HomeView(RequestLocale(request), homeStats(), CsrfToken(request)), writer)
+}
+
+const RECENT_LIMIT = 50
+const TRENDING_DAYS = 7
+
+// recentHandler shows the last analyzed versions and the packages that were looked up most in the last week
+func recentHandler(writer http.ResponseWriter, request *http.Request) {
+	versions, err := DbRecentVersions(RECENT_LIMIT)
+	if err != nil {
+		slog.Error("could not get recent versions", "err", err)
+	}
+	now := time.Now()
+	since := now.AddDate(0, 0, -TRENDING_DAYS+1).Format(LOOKUP_DAY_FORMAT)
+	previous := now.AddDate(0, 0, -2*TRENDING_DAYS+1).Format(LOOKUP_DAY_FORMAT)
+	trending, err := DbTrendingPackages(since, previous, RECENT_LIMIT)
+	if err != nil {
+		slog.Error("could not get trending packages", "err", err)
+	}
+	WriteHtml(RecentView(RequestLocale(request), versions, trending), writer)
+}
+
+const VULNERABILITIES_LIMIT = 20
+
+// vulnerabilitiesHandler summarizes the vulnerability database, and how up to date it is
+func vulnerabilitiesHandler(writer http.ResponseWriter, request *http.Request) {
+	var data VulnerabilitiesData
+	var err error
+	if data.Totals, err = DbCountVulnerabilitiesBySeverity(); err != nil {
+		slog.Error("could not count vulnerabilities", "err", err)
+	}
+	if data.Newest, err = DbNewestVulnerabilities(VULNERABILITIES_LIMIT); err != nil {
+		slog.Error("could not get newest vulnerabilities", "err", err)
+	}
+	if data.Packages, err = DbMostVulnerablePackages(VULNERABILITIES_LIMIT); err != nil {
+		slog.Error("could not get most vulnerable packages", "err", err)
+	}
+	if data.Sources, err = DbVulnerabilitySources(); err != nil {
+		slog.Error("could not get vulnerability sources", "err", err)
+	}
+	WriteHtml(VulnerabilitiesView(RequestLocale(request), data, time.Now()), writer)
+}
+
+const PUBLISHERS_LIMIT = 100
+
+// publishersHandler shows the publishers that are in the most dependency trees of the analyzed versions
+func publishersHandler(writer http.ResponseWriter, request *http.Request) {
+	publishers, err := DbTopPublishers(PUBLISHERS_LIMIT)
+	if err != nil {
+		slog.Error("could not get top publishers", "err", err)
+	}
+	trees, err := DbCountRows("versions")
+	if err != nil {
+		slog.Error("could not count versions", "err", err)
+	}
+	WriteHtml(PublishersView(RequestLocale(request), publishers, trees), writer)
+}
+
+// maintainerHandler lists the packages of an npm account, to vet an author before adopting their packages
+func maintainerHandler(writer http.ResponseWriter, request *http.Request) {
+	user := mux.Vars(request)["user"]
+	results, err := MaintainerPackages(request.Context(), user)
+	if err != nil {
+		httpError(writer, request, http.StatusBadGateway, "could not get packages of "+user, err)
+		return
+	}
+	names := make([]string, len(results))
+	for i, result := range results {
+		names[i] = result.Name
+	}
+	var downloads map[string]int64
+	if len(names) > 0 {
+		downloads = WeeklyDownloads(request.Context(), names)
+	}
+	WriteHtml(MaintainerView(RequestLocale(request), user, results, downloads), writer)
+}
+
+// semverHandler explains which published versions of a package match a constraint
+func semverHandler(writer http.ResponseWriter, request *http.Request) {
+	l := RequestLocale(request)
+	form := SemverForm{
+		Package:    strings.TrimSpace(request.URL.Query().Get("package")),
+		Constraint: strings.TrimSpace(request.URL.Query().Get("constraint")),
+	}
+	if form.Package == "" || form.Constraint == "" {
+		WriteHtml(SemverView(l, form, nil, "", ""), writer)
+		return
+	}
+	packageInfo, err := RequestPackageInfo(request.Context(), form.Package)
+	if err == BusyError {
+		busyError(writer, request)
+		return
+	}
+	if err != nil {
+		WriteHtml(SemverView(l, form, nil, "", l.T("Could not get package %s.", form.Package)), writer)
+		return
+	}
+	matches, picked, err := ExplainConstraint(packageInfo, form.Constraint)
+	if err != nil {
+		WriteHtml(SemverView(l, form, nil, "", l.T("Invalid constraint: %s", err.Error())), writer)
+		return
+	}
+	WriteHtml(SemverView(l, form, matches, picked, ""), writer)
 }
 
 const SAFE_CHARS = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
 
-// about 64 bits of entropy = about 11 chars
+// about 64 bits of entropy = about 11 chars, from crypto/rand so an id can't be guessed from an earlier one
 func randId(n int) string {
-	var id []byte
-	for i := 0; i < n; i++ {
-		id = append(id, SAFE_CHARS[rand.Intn(62)])
+	id := make([]byte, n)
+	max := big.NewInt(int64(len(SAFE_CHARS)))
+	for i := range id {
+		k, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		id[i] = SAFE_CHARS[k.Int64()]
 	}
 	return string(id)
 }
@@ -112,57 +458,178 @@ const MAX_UPLOAD_SIZE = 1000000
 func uploadHandler(writer http.ResponseWriter, request *http.Request) {
 	request.Body = http.MaxBytesReader(writer, request.Body, MAX_UPLOAD_SIZE)
 	if err := request.ParseMultipartForm(MAX_UPLOAD_SIZE); err != nil {
-		httpError(writer, http.StatusBadRequest, "the uploaded file is >1MB", err)
+		httpError(writer, request, http.StatusBadRequest, "the uploaded file is >1MB", err)
 		return
 	}
 	file, _, err := request.FormFile("file")
 	if err != nil {
-		httpError(writer, http.StatusBadRequest, "could not get uploaded file from form", err)
+		httpError(writer, request, http.StatusBadRequest, "could not get uploaded file from form", err)
 		return
 	}
 	defer file.Close()
 	bytes, err := ioutil.ReadAll(file)
 	if err != nil {
-		httpError(writer, http.StatusBadRequest, "could not read uploaded file", err)
+		httpError(writer, request, http.StatusBadRequest, "could not read uploaded file", err)
 		return
 	}
+	// the lockfile is optional, without it the ranges of the package.json are resolved
+	var lockBytes []byte
+	if lockFile, _, err := request.FormFile("lockfile"); err == nil {
+		defer lockFile.Close()
+		lockBytes, err = ioutil.ReadAll(lockFile)
+		if err != nil {
+			httpError(writer, request, http.StatusBadRequest, "could not read uploaded lockfile", err)
+			return
+		}
+	}
+	storeFile(writer, request, bytes, lockBytes)
+}
+
+// parseFile parses a package.json, or a package-lock.json of npm or a packages.lock.json of NuGet, which already
+// have the resolved tree
+func parseFile(bytes []byte) (*Version, error) {
+	if lock, ok := ParseNugetLock(bytes); ok {
+		return lock.LockedVersion(), nil
+	}
+	var versionInfo struct {
+		VersionInfo
+		LockfileVersion int `json:"lockfileVersion"`
+	}
+	if err := json.Unmarshal(bytes, &versionInfo); err != nil {
+		return nil, errors.Wrap(err,
+			"expected a package.json, a package-lock.json of npm 7 or later or a packages.lock.json of NuGet")
+	}
+	if versionInfo.LockfileVersion > 0 {
+		lock, err := ParseNpmLock(bytes)
+		if err != nil {
+			return nil, err
+		}
+		return lock.LockedVersion(lock.RootInfo()), nil
+	}
+	return NewVersion(versionInfo.VersionInfo, time.Now()), nil
+}
+
+// parseLockedFile parses a package.json with its package-lock.json, the tree is the locked one
+func parseLockedFile(bytes []byte, lockBytes []byte) (*Version, error) {
 	var versionInfo VersionInfo
 	if err := json.Unmarshal(bytes, &versionInfo); err != nil {
-		httpError(writer, http.StatusBadRequest, "could not parse uploaded file", err)
-		return
+		return nil, err
+	}
+	lock, err := ParseNpmLock(lockBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse lockfile")
 	}
+	return lock.LockedVersion(versionInfo), nil
+}
 
-	version := NewVersion(versionInfo, time.Now())
+// createFile stores a parsed file for analysis and returns its id and the token to delete it. When an identical file
+// was already analyzed, it returns the id of that file with a new token. The file is deleted when every uploader has
+// deleted it.
+func createFile(version *Version, bytes []byte, userId int) (string, string, error) {
+	contentHash := sha256Hex(bytes)
+	existingId, err := DbFindFileByContentHash(contentHash, userId)
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not search for existing file")
+	}
+	token := secureToken()
+	if existingId != "" {
+		if err := DbAddFileToken(existingId, sha256Hex([]byte(token))); err != nil {
+			return "", "", errors.Wrap(err, "could not store file token")
+		}
+		return existingId, token, nil
+	}
 	id := randId(11)
-	if err := DbPutFile(id, version); err != nil {
-		httpError(writer, http.StatusBadRequest, "could not store file", err)
-		return
+	if err := DbCreateFile(id, version, sha256Hex([]byte(token)), contentHash, userId); err != nil {
+		return "", "", errors.Wrap(err, "could not store file")
 	}
+	return id, token, nil
+}
+
+// fileHref is the page of a file, with the token to delete it when there is one
+func fileHref(id string, token string) string {
+	if token == "" {
+		return "/file/" + id
+	}
+	return "/file/" + id + "?token=" + token
+}
 
-	writer.Header().Set("Location", "/file/"+id)
+// storeFile stores a package.json or a packages.lock.json of NuGet for analysis, and redirects to its page. A
+// package.json can come with its package-lock.json, else lockBytes is nil.
+func storeFile(writer http.ResponseWriter, request *http.Request, bytes []byte, lockBytes []byte) {
+	var version *Version
+	var err error
+	if len(lockBytes) > 0 {
+		version, err = parseLockedFile(bytes, lockBytes)
+	} else {
+		version, err = parseFile(bytes)
+	}
+	if err != nil {
+		httpError(writer, request, http.StatusBadRequest, "could not parse file", err)
+		return
+	}
+	// the same package.json with another lockfile is another analysis
+	id, token, err := createFile(version, append(bytes, lockBytes...), currentUserId(request))
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not store file", err)
+		return
+	}
+	writer.Header().Set("Location", Href(fileHref(id, token)))
 	writer.WriteHeader(http.StatusMovedPermanently)
 }
 
 func fileHandler(writer http.ResponseWriter, request *http.Request) {
 	id := mux.Vars(request)["id"]
-	version, err := GetFile(id)
+	version, err := GetFile(request.Context(), id, waitDuration(request))
 	if err == TimeoutError {
-		WriteHtml(WaitView("your package.json"), writer)
+		l := RequestLocale(request)
+		WriteHtml(WaitView(l, l.T("your package.json"), "/events/file/"+id), writer)
+		return
+	}
+	if err == BusyError {
+		busyError(writer, request)
 		return
 	}
 	if err != nil {
-		httpError(writer, http.StatusNotFound, "could not get dependencies for file "+id, err)
+		httpError(writer, request, http.StatusNotFound, "could not get dependencies for file "+id, err)
+		return
+	}
+	WriteHtmlCached(FileView(RequestLocale(request), version, id, request.URL.Query().Get("token"), CsrfToken(request),
+		ParseTableQuery(request)), FILE_CACHE_CONTROL, writer, request)
+}
+
+func deleteFileHandler(writer http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+	exists, err := DbFileExists(id)
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not get file "+id, err)
+		return
+	}
+	if !exists {
+		httpError(writer, request, http.StatusNotFound, "could not find file "+id, errors.New("no file"))
+		return
+	}
+	// the token is looked up by its hash, like a session
+	deleted, err := DbDeleteFileToken(id, sha256Hex([]byte(request.FormValue("token"))))
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not delete file "+id, err)
+		return
+	}
+	if !deleted {
+		httpError(writer, request, http.StatusForbidden, "invalid token for file "+id, errors.New("token mismatch"))
 		return
 	}
-	WriteHtml(VersionView(version), writer)
+	ForgetFile(id)
+	WriteHtml(FileDeletedView(RequestLocale(request)), writer)
 }
 
-func writePanic(writer http.ResponseWriter, errObj interface{}, buf []byte) {
+func writePanic(writer http.ResponseWriter, request *http.Request, errObj interface{}, buf []byte, pcs []uintptr) {
 	err := fmt.Sprint(errObj)
 
-	log.Println(err, string(buf))
+	slog.Error("panic", "err", err, "stack", string(buf))
 
-	returnError("Internal Server Error", err, string(buf), http.StatusInternalServerError, writer)
+	event := NewSentryEvent(request, "panic", err, pcs)
+	event.Level = "fatal"
+	returnError("Internal Server Error", RequestLocale(request).T("Internal Server Error"), err, string(buf), http.StatusInternalServerError, writer, request, event)
 }
 
 func PanicRecovery(handler http.Handler) http.Handler {
@@ -173,7 +640,11 @@ func PanicRecovery(handler http.Handler) http.Handler {
 				n := runtime.Stack(buf, false)
 				buf = buf[:n]
 
-				writePanic(w, err, buf)
+				// skip runtime.Callers and this function, the stack still contains the panicking frames
+				pcs := make([]uintptr, 64)
+				pcs = pcs[:runtime.Callers(2, pcs)]
+
+				writePanic(w, r, err, buf, pcs)
 			}
 		}()
 
@@ -182,36 +653,121 @@ func PanicRecovery(handler http.Handler) http.Handler {
 }
 
 func Serve(publicFs fs.FS) {
+	LoadNavigation()
+	if Config.Pages.Watch && Config.Pages.Path != "" {
+		go watchPages()
+	}
+
 	r := mux.NewRouter()
-	r.HandleFunc("/npm/{name:[\\w\\-.]+}", packageHandler)
-	r.HandleFunc("/npm/{ns:@[\\w\\-]+}/{name:[\\w\\-.]+}", packageHandler)
-	r.HandleFunc("/npm/{name:[\\w\\-.]+}/{version:\\d.*}", versionHandler)
-	r.HandleFunc("/npm/{ns:@[\\w\\-]+}/{name:[\\w\\-.]+}/{version:\\d.*}", versionHandler)
+	r.HandleFunc("/npm/{name:[\\w\\-.]+}", Deadline(ANALYSIS_DEADLINE, packageHandler))
+	r.HandleFunc("/npm/{ns:@[\\w\\-]+}/{name:[\\w\\-.]+}", Deadline(ANALYSIS_DEADLINE, packageHandler))
+	r.HandleFunc("/npm/{name:[\\w\\-.]+}/versions", Deadline(ANALYSIS_DEADLINE, packageVersionsHandler))
+	r.HandleFunc("/npm/{ns:@[\\w\\-]+}/{name:[\\w\\-.]+}/versions", Deadline(ANALYSIS_DEADLINE, packageVersionsHandler))
+	r.HandleFunc("/npm/{name:[\\w\\-.]+}/{version:\\d.*}", Deadline(ANALYSIS_DEADLINE, versionHandler))
+	r.HandleFunc("/npm/{ns:@[\\w\\-]+}/{name:[\\w\\-.]+}/{version:\\d.*}", Deadline(ANALYSIS_DEADLINE, versionHandler))
+
+	r.HandleFunc("/badge/npm/{name:[\\w\\-.]+}", Deadline(ANALYSIS_DEADLINE, badgeHandler))
+	r.HandleFunc("/badge/npm/{ns:@[\\w\\-]+}/{name:[\\w\\-.]+}", Deadline(ANALYSIS_DEADLINE, badgeHandler))
+	r.HandleFunc("/badge/npm/{name:[\\w\\-.]+}/{version:\\d.*}", Deadline(ANALYSIS_DEADLINE, badgeHandler))
+	r.HandleFunc("/badge/npm/{ns:@[\\w\\-]+}/{name:[\\w\\-.]+}/{version:\\d.*}", Deadline(ANALYSIS_DEADLINE, badgeHandler))
+	for _, resolver := range sortedResolvers() {
+		for _, pattern := range resolver.NamePatterns() {
+			path := "/" + resolver.Prefix() + "/" + pattern
+			r.HandleFunc(path, Deadline(ANALYSIS_DEADLINE, resolverPackageHandler(resolver)))
+			r.HandleFunc(path+"/{version:\\d.*}", Deadline(ANALYSIS_DEADLINE, resolverVersionHandler(resolver)))
+			r.HandleFunc("/events"+path+"/{version:\\d.*}", Deadline(EVENTS_DEADLINE, resolverEventsHandler(resolver)))
+		}
+	}
+	r.HandleFunc("/upload", Deadline(UPLOAD_DEADLINE, uploadHandler))
+	r.HandleFunc("/repository", Deadline(UPLOAD_DEADLINE, repositoryHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/file/{id}", Deadline(ANALYSIS_DEADLINE, fileHandler))
+	r.HandleFunc("/file/{id}/delete", deleteFileHandler).Methods(http.MethodPost)
+	r.HandleFunc("/go", Deadline(ANALYSIS_DEADLINE, goHandler))
 
-	r.HandleFunc("/upload", uploadHandler)
-	r.HandleFunc("/file/{id}", fileHandler)
-	r.HandleFunc("/go", goHandler)
+	// the wait view listens to these, until the analysis is ready
+	r.HandleFunc("/events/npm/{name:[\\w\\-.]+}/{version:\\d.*}", Deadline(EVENTS_DEADLINE, versionEventsHandler))
+	r.HandleFunc("/events/npm/{ns:@[\\w\\-]+}/{name:[\\w\\-.]+}/{version:\\d.*}", Deadline(EVENTS_DEADLINE, versionEventsHandler))
+	r.HandleFunc("/events/file/{id}", Deadline(EVENTS_DEADLINE, fileEventsHandler))
+
+	if Config.Mail.Server != "" {
+		r.HandleFunc("/watch", watchHandler)
+		r.HandleFunc("/watch/confirm", watchConfirmHandler)
+		r.HandleFunc("/watch/unsubscribe", watchUnsubscribeHandler)
+		if Config.Mail.ContactTo != "" {
+			r.HandleFunc("/contact", contactHandler)
+		}
+	}
+
+	r.HandleFunc("/signup", signupHandler)
+	r.HandleFunc("/login", loginHandler)
+	r.HandleFunc("/logout", logoutHandler).Methods(http.MethodPost)
+	r.HandleFunc("/account", accountHandler)
+	if Config.Github.ClientId != "" {
+		r.HandleFunc("/login/github", githubLoginHandler)
+		r.HandleFunc("/login/github/callback", githubCallbackHandler)
+	}
+
+	r.HandleFunc("/api/search", searchHandler)
+	r.HandleFunc("/api/ci/check", Deadline(CI_DEADLINE, ciCheckHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/api/analyze", Deadline(UPLOAD_DEADLINE, analyzeHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/api/analyze/{id}", Deadline(ANALYSIS_DEADLINE, analyzeJobHandler))
+	if Config.GithubApp.AppId != 0 {
+		r.HandleFunc("/api/github/webhook", githubWebhookHandler).Methods(http.MethodPost)
+	}
+	r.HandleFunc("/api/admin/purge", AdminOnly(adminPurgeHandler))
+	r.HandleFunc("/api/admin/refresh", AdminOnly(adminRefreshHandler))
+	r.HandleFunc("/api/admin/dbstats", AdminOnly(adminDbStatsHandler))
+	if Config.Admin.Token != "" {
+		r.HandleFunc("/admin/login", adminLoginHandler)
+		r.HandleFunc("/admin/logout", adminLogoutHandler).Methods(http.MethodPost)
+		r.HandleFunc("/admin/pages", AdminPagesOnly(adminPagesHandler))
+		r.HandleFunc("/admin/pages/{id}", AdminPagesOnly(adminPageEditHandler))
+		r.HandleFunc("/admin/pages/{id}/delete", AdminPagesOnly(adminPageDeleteHandler)).Methods(http.MethodPost)
+	}
+
+	mountDebug(r)
 
 	r.HandleFunc("/pages/{path:.*}", pageHandler)
 	r.HandleFunc("/error", func(writer http.ResponseWriter, r *http.Request) { log.Panicln("test panic") })
+	r.HandleFunc("/recent", recentHandler)
+	r.HandleFunc("/vulnerabilities", vulnerabilitiesHandler)
+	r.HandleFunc("/publishers", publishersHandler)
+	r.HandleFunc("/maintainer/{user:[\\w\\-.]+}", Deadline(ANALYSIS_DEADLINE, maintainerHandler))
+	r.HandleFunc("/semver", Deadline(ANALYSIS_DEADLINE, semverHandler))
 	r.HandleFunc("/", homeHandler)
 
 	// for now, redirect unknown packages to npm. doesn't work with . in name, b/o main.css etc
-	r.HandleFunc("/{name:[\\w\\-]+}", packageHandler)
+	r.HandleFunc("/{name:[\\w\\-]+}", Deadline(ANALYSIS_DEADLINE, packageHandler))
 
-	r.PathPrefix("/").Handler(http.FileServer(http.FS(publicFs)))
+	if err := hashAssets(publicFs); err != nil {
+		log.Panicln("could not hash public files", err)
+	}
+	r.PathPrefix("/").Handler(Deadline(STATIC_DEADLINE, StaticHandler(publicFs, http.HandlerFunc(notFoundHandler)).ServeHTTP))
+	r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
 
+	r.Use(RequestLogger)
 	r.Use(PanicRecovery)
+	r.Use(Sessions)
+	r.Use(Csrf)
+
+	handler := withBasePath(r)
+	if Config.Server.TrustProxy {
+		handler = ProxyHeaders(handler)
+	}
 
-	listenAddr := fmt.Sprintf("localhost:%d", Config.Server.Port)
-	server := http.Server{Addr: listenAddr, Handler: r}
-	log.Println("start listening at http://" + listenAddr + "...")
-	err := server.ListenAndServe()
+	if tlsEnabled() {
+		serveTls(handler)
+		return
+	}
+
+	listenAddr := net.JoinHostPort(Config.Server.Host, strconv.Itoa(Config.Server.Port))
+	listener, err := listen(listenAddr, 0)
 	if err != nil {
+		log.Panicln("could not listen", err)
+	}
+	server := newServer(listenAddr, handler)
+	slog.Info("start listening at http://" + listener.Addr().String() + "...")
+	if err := server.Serve(listener); err != nil {
 		log.Panicln("could not start server", err)
 	}
 }
-
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// csrf protection with a double submit cookie: forms that post include the token of the cookie in a hidden field,
+// which other sites can't read
+
+const CSRF_COOKIE = "csrf"
+const CSRF_FIELD = "csrf_token"
+const CSRF_HEADER = "X-CSRF-Token"
+
+var CsrfError = errors.New("invalid or missing csrf token")
+
+type csrfContextKey struct{}
+
+// csrfState is the token of a request. A new token is only sent in a cookie by the pages that render a form, a page
+// that is cached publicly must not hand the same token to everyone.
+type csrfState struct {
+	token string
+	once  sync.Once
+	issue func() // sets the cookie of a new token, nil when the request had one
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Csrf checks the token of requests that change state, CsrfToken sets the cookie. The api is authenticated with a token
+// header instead of cookies, so it is not checked.
+func Csrf(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		state := &csrfState{}
+		if cookie, err := request.Cookie(CSRF_COOKIE); err == nil && len(cookie.Value) >= 32 {
+			state.token = cookie.Value
+		} else {
+			state.token = secureToken()
+			state.issue = func() {
+				http.SetCookie(writer, &http.Cookie{
+					Name:     CSRF_COOKIE,
+					Value:    state.token,
+					Path:     Href("/"),
+					HttpOnly: true,
+					Secure:   isHttps(request),
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+		}
+		token := state.token
+		request = request.WithContext(context.WithValue(request.Context(), csrfContextKey{}, state))
+
+		// the base path is already stripped from the path, see withBasePath
+		if !isSafeMethod(request.Method) && !strings.HasPrefix(request.URL.Path, "/api/") {
+			submitted := request.Header.Get(CSRF_HEADER)
+			if submitted == "" {
+				// the largest form is the upload, the handlers see the already parsed form
+				request.Body = http.MaxBytesReader(writer, request.Body, MAX_UPLOAD_SIZE)
+				if err := request.ParseMultipartForm(MAX_UPLOAD_SIZE); err != nil && err != http.ErrNotMultipart {
+					httpError(writer, request, http.StatusBadRequest, "the request is too large or invalid", err)
+					return
+				}
+				submitted = request.PostFormValue(CSRF_FIELD)
+			}
+			if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+				httpError(writer, request, http.StatusForbidden, "the form has expired, please reload the page and try again",
+					CsrfError)
+				return
+			}
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// CsrfToken returns the token that forms should post in the csrf_token field, and sets its cookie when it is new. It
+// must be called before the response is written.
+func CsrfToken(request *http.Request) string {
+	state, _ := request.Context().Value(csrfContextKey{}).(*csrfState)
+	if state == nil {
+		return ""
+	}
+	if state.issue != nil {
+		state.once.Do(state.issue)
+	}
+	return state.token
+}
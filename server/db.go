@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"log"
+	"log/slog"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -16,21 +17,56 @@ type PackageRow struct {
 	Name          string
 	Info          string
 	LatestVersion string `db:"latest_version"`
+	BlobKey       string `db:"blob_key"`
+	ETag          string `db:"etag"`
+	LastModified  string `db:"last_modified"`
 }
 
-func DbGetPackage(name string) (*PackageInfo, error) {
-	var row PackageRow
-	if err := db.Get(&row, "SELECT info FROM packages WHERE name = $1", name); err != nil {
+func (row PackageRow) packageInfo() (*PackageInfo, error) {
+	info, err := getContent(row.Info, row.BlobKey)
+	if err != nil {
 		return nil, err
 	}
 	var packageInfo PackageInfo
-	if err := json.Unmarshal([]byte(row.Info), &packageInfo); err != nil {
+	if err := json.Unmarshal(info, &packageInfo); err != nil {
 		return nil, err
 	}
+	packageInfo.ETag = row.ETag
+	packageInfo.LastModified = row.LastModified
 	return &packageInfo, nil
 }
 
+// DbGetPackage only returns the package if it is not expired
+func DbGetPackage(name string) (*PackageInfo, error) {
+	var row PackageRow
+	if err := db.Get(&row, "SELECT info, blob_key, etag, last_modified FROM packages WHERE name = $1 AND expire_time >= $2", name, time.Now()); err != nil {
+		return nil, err
+	}
+	return row.packageInfo()
+}
+
+// DbGetStalePackage also returns the package if it is expired
+func DbGetStalePackage(name string) (*PackageInfo, error) {
+	var row PackageRow
+	if err := db.Get(&row, "SELECT info, blob_key, etag, last_modified FROM packages WHERE name = $1", name); err != nil {
+		return nil, err
+	}
+	return row.packageInfo()
+}
+
+// DbGetPackageLatestVersion only returns the latest version if the package is not expired, it may have a newer one
 func DbGetPackageLatestVersion(name string) (string, error) {
+	var row PackageRow
+	if err := db.Get(&row, "SELECT latest_version FROM packages WHERE name = $1 AND expire_time >= $2", name,
+		time.Now()); err != nil {
+		return "", err
+	}
+	return row.LatestVersion, nil
+}
+
+// DbGetStalePackageLatestVersion also returns the latest version if the package is expired, for the cached data of
+// the digests
+func DbGetStalePackageLatestVersion(name string) (string, error) {
 	var row PackageRow
 	if err := db.Get(&row, "SELECT latest_version FROM packages WHERE name = $1", name); err != nil {
 		return "", err
@@ -38,29 +74,101 @@ func DbGetPackageLatestVersion(name string) (string, error) {
 	return row.LatestVersion, nil
 }
 
+// DbMarkPackagesStale expires the packages with the names that are not expired yet, and returns their names. Their
+// latest version is cleared too, it may have changed.
+func DbMarkPackagesStale(names []string, now time.Time) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In("SELECT name FROM packages WHERE name IN (?) AND expire_time >= ?", names, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create query for stale packages")
+	}
+	var stale []string
+	if err := db.Select(&stale, db.Rebind(query), args...); err != nil {
+		return nil, errors.Wrap(err, "could not get stale packages")
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	query, args, err = sqlx.In("UPDATE packages SET expire_time = ?, latest_version = '' WHERE name IN (?)", now, stale)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create query to mark packages stale")
+	}
+	if _, err := db.Exec(db.Rebind(query), args...); err != nil {
+		return nil, errors.Wrap(err, "could not mark packages stale")
+	}
+	return stale, nil
+}
+
+// DbGetChangesSeq returns the sequence of the changes feed that was handled last, found is false when it was not
+// followed before
+func DbGetChangesSeq(url string) (seq string, found bool, err error) {
+	err = db.Get(&seq, "SELECT seq FROM changes_feeds WHERE url = $1", url)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return seq, err == nil, err
+}
+
+func DbPutChangesSeq(url string, seq string) error {
+	_, err := db.Exec(`INSERT INTO changes_feeds (url, seq, update_time) VALUES ($1, $2, $3)
+		ON CONFLICT (url) DO UPDATE SET seq = excluded.seq, update_time = excluded.update_time`, url, seq, time.Now())
+	return err
+}
+
 func DbPutPackage(name string, packageInfo *PackageInfo, expireTime time.Time) error {
 	bytes, err := json.Marshal(packageInfo)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("INSERT INTO packages (name, info, latest_version, create_time, expire_time) VALUES ($1, $2, $3, $4, $5)",
-		name, bytes, packageInfo.DistTags.Latest, time.Now(), expireTime)
+	bytes, blobKey, err := putContent(packageBlobKey(name), bytes)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO packages (name, info, latest_version, blob_key, etag, last_modified, create_time, expire_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (name) DO UPDATE SET info = excluded.info, latest_version = excluded.latest_version,
+			blob_key = excluded.blob_key, etag = excluded.etag, last_modified = excluded.last_modified,
+			create_time = excluded.create_time, expire_time = excluded.expire_time`,
+		name, bytes, packageInfo.DistTags.Latest, blobKey, packageInfo.ETag, packageInfo.LastModified, time.Now(), expireTime)
 	return err
 }
 
+func DbDeletePackage(name string) (int64, error) {
+	var blobKeys []string
+	if err := db.Select(&blobKeys, "SELECT blob_key FROM packages WHERE name = $1 AND blob_key != ''", name); err != nil {
+		return 0, err
+	}
+	result, err := db.Exec("DELETE FROM packages WHERE name = $1", name)
+	if err != nil {
+		return 0, err
+	}
+	deleteBlobs(blobKeys)
+	return result.RowsAffected()
+}
+
 type VersionRow struct {
 	Name    string
 	Version string
 	Content string
+	BlobKey string `db:"blob_key"`
 }
 
+// DbGetVersion only returns the version if it is not expired
 func DbGetVersion(name string, versionRaw string) (*Version, error) {
 	var row VersionRow
-	if err := db.Get(&row, "SELECT content FROM versions WHERE name = $1 AND version = $2", name, versionRaw); err != nil {
+	if err := db.Get(&row, "SELECT content, blob_key FROM versions WHERE name = $1 AND version = $2 AND expire_time >= $3",
+		name, versionRaw, time.Now()); err != nil {
+		return nil, err
+	}
+	content, err := getContent(row.Content, row.BlobKey)
+	if err != nil {
 		return nil, err
 	}
 	var version Version
-	if err := json.Unmarshal([]byte(row.Content), &version); err != nil {
+	if err := json.Unmarshal(content, &version); err != nil {
 		return nil, err
 	}
 	return &version, nil
@@ -71,11 +179,119 @@ func DbPutVersion(name string, versionRaw string, version *Version, expireTime t
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("INSERT INTO versions (name, version, content, create_time, expire_time) VALUES ($1, $2, $3, $4, $5)",
-		name, versionRaw, bytes, time.Now(), expireTime)
+	bytes, blobKey, err := putContent(versionBlobKey(name, versionRaw), bytes)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO versions (name, version, content, blob_key, create_time, expire_time) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (name, version) DO UPDATE SET content = excluded.content, blob_key = excluded.blob_key,
+			create_time = excluded.create_time, expire_time = excluded.expire_time`,
+		name, versionRaw, bytes, blobKey, time.Now(), expireTime)
+	if err != nil {
+		return err
+	}
+	if err := dbPutVersionPublishers(name, versionRaw, version.Publishers); err != nil {
+		return err
+	}
+	return dbPutStatSnapshot(name, versionRaw, version)
+}
+
+// StatSnapshotRow are the stats of an analysis of a version on a day. They are kept when the version expires, so the
+// package page can show how the stats changed, until they are older than STAT_SNAPSHOT_RETENTION.
+type StatSnapshotRow struct {
+	Name            string
+	Version         string
+	Day             string
+	Packages        int
+	Versions        int
+	DiskSpace       int64 `db:"disk_space"`
+	Vulnerabilities int
+}
+
+// STAT_SNAPSHOT_RETENTION is how long the snapshots are kept, one row per version per day would grow forever
+const STAT_SNAPSHOT_RETENTION = 365 * 24 * time.Hour
+
+// dbPutStatSnapshot keeps one snapshot per version per day, the last analysis of the day wins
+func dbPutStatSnapshot(name string, versionRaw string, version *Version) error {
+	_, err := db.Exec(`
+		INSERT INTO stat_snapshots (name, version, day, packages, versions, disk_space, vulnerabilities)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (name, version, day) DO UPDATE SET packages = excluded.packages, versions = excluded.versions,
+			disk_space = excluded.disk_space, vulnerabilities = excluded.vulnerabilities`,
+		name, versionRaw, time.Now().Format(LOOKUP_DAY_FORMAT), version.Stats.Packages, version.Stats.Versions,
+		version.Stats.DiskSpace, len(version.Vulnerabilities))
 	return err
 }
 
+// DbGetStatSnapshots returns the snapshots of all versions of a package, the oldest first
+func DbGetStatSnapshots(name string) ([]StatSnapshotRow, error) {
+	var rows []StatSnapshotRow
+	err := db.Select(&rows, `SELECT name, version, day, packages, versions, disk_space, vulnerabilities FROM stat_snapshots
+		WHERE name = $1 ORDER BY day, version`, name)
+	return rows, err
+}
+
+// dbPutVersionPublishers replaces the publishers in the tree of an analyzed version, for the statistics across all
+// analyses. Rows of versions that are deleted are ignored by the queries, and removed by expire.
+func dbPutVersionPublishers(name string, versionRaw string, publishers map[string]int) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM version_publishers WHERE name = $1 AND version = $2", name, versionRaw); err != nil {
+		return err
+	}
+	for publisher, count := range publishers {
+		if _, err := tx.Exec("INSERT INTO version_publishers (name, version, publisher, count) VALUES ($1, $2, $3, $4)",
+			name, versionRaw, publisher, count); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type PublisherStatsRow struct {
+	Publisher string
+	Trees     int // analyzed versions with the publisher in their tree
+	Versions  int // published versions in these trees, a version in several trees is counted several times
+}
+
+// DbTopPublishers returns the publishers that are in the most trees of the analyzed versions
+func DbTopPublishers(limit int) ([]PublisherStatsRow, error) {
+	var rows []PublisherStatsRow
+	err := db.Select(&rows, `
+		SELECT p.publisher, COUNT(*) AS trees, SUM(p.count) AS versions FROM version_publishers p
+		JOIN versions v ON v.name = p.name AND v.version = p.version
+		GROUP BY p.publisher ORDER BY trees DESC, versions DESC, p.publisher LIMIT $1`, limit)
+	return rows, err
+}
+
+// DbDeleteVersions deletes the given version of a package, or all versions if versionRaw is empty
+func DbDeleteVersions(name string, versionRaw string) (int64, error) {
+	where := "name = $1"
+	args := []interface{}{name}
+	if versionRaw != "" {
+		where += " AND version = $2"
+		args = append(args, versionRaw)
+	}
+	var blobKeys []string
+	if err := db.Select(&blobKeys, "SELECT blob_key FROM versions WHERE "+where+" AND blob_key != ''", args...); err != nil {
+		return 0, err
+	}
+	result, err := db.Exec("DELETE FROM versions WHERE "+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	// a purged version is analyzed again, its history goes too
+	if _, err := db.Exec("DELETE FROM stat_snapshots WHERE "+where, args...); err != nil {
+		return 0, err
+	}
+	deleteBlobs(blobKeys)
+	return result.RowsAffected()
+}
+
 type FileRow struct {
 	Id      string
 	Content string
@@ -98,14 +314,85 @@ func DbPutFile(id string, version *Version) error {
 	if err != nil {
 		return err
 	}
-	// TODO transaction
-	if _, err = DbGetFile(id); err != nil {
-		if err == sql.ErrNoRows {
-			_, err = db.Exec("INSERT INTO files (id, content, create_time) VALUES ($1, $2, $3)", id, bytes, time.Now())
-		}
-	} else {
-		_, err = db.Exec("UPDATE files SET content = $2 WHERE id = $1", id, bytes)
+	_, err = db.Exec(`
+		INSERT INTO files (id, content, create_time) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET content = excluded.content`,
+		id, bytes, time.Now())
+	return err
+}
+
+// DbCreateFile stores a new upload, together with the hash of its delete token and the hash of the uploaded content. The
+// user id is 0 for anonymous uploads.
+func DbCreateFile(id string, version *Version, tokenHash string, contentHash string, userId int) error {
+	bytes, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO files (id, content, content_hash, user_id, create_time) VALUES ($1, $2, $3, $4, $5)",
+		id, bytes, contentHash, userId, time.Now()); err != nil {
+		return err
 	}
+	if _, err := tx.Exec("INSERT INTO file_tokens (file_id, token_hash) VALUES ($1, $2)", id, tokenHash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DbAddFileToken adds a delete token to a file, for another upload of the same content
+func DbAddFileToken(id string, tokenHash string) error {
+	_, err := db.Exec("INSERT INTO file_tokens (file_id, token_hash) VALUES ($1, $2)", id, tokenHash)
+	return err
+}
+
+// DbFindFileByContentHash returns the id of a file with the same content hash of the same user, or an empty string
+func DbFindFileByContentHash(contentHash string, userId int) (string, error) {
+	var id string
+	err := db.Get(&id, "SELECT id FROM files WHERE content_hash = $1 AND user_id = $2 LIMIT 1", contentHash, userId)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// DbFileExists returns whether the file is stored, without loading it
+func DbFileExists(id string) (bool, error) {
+	var count int
+	err := db.Get(&count, "SELECT COUNT(*) FROM files WHERE id = $1", id)
+	return count > 0, err
+}
+
+// DbDeleteFileToken deletes a delete token of the file, and the file itself when it was the last token. It returns false
+// when the token is not one of the file.
+func DbDeleteFileToken(id string, tokenHash string) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("DELETE FROM file_tokens WHERE file_id = $1 AND token_hash = $2", id, tokenHash)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM files WHERE id = $1
+		AND NOT EXISTS (SELECT 1 FROM file_tokens WHERE file_id = $1)`, id); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func DbDeleteFile(id string) error {
+	if _, err := db.Exec("DELETE FROM file_tokens WHERE file_id = $1", id); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM files WHERE id = $1", id)
 	return err
 }
 
@@ -156,21 +443,703 @@ func DbGetVulnerabilitiesForPackages(packages []string) ([]Vulnerability, error)
 	if err := db.Select(&rows, query, args...); err != nil {
 		return nil, errors.Wrap(err, "could not get vulnerabilities for a list of packages")
 	}
+	return vulnerabilitiesFromRows(rows), nil
+}
+
+func vulnerabilitiesFromRows(rows []VulnerabilityRow) []Vulnerability {
 	var vulnerabilities []Vulnerability
 	for _, row := range rows {
 		v := Vulnerability{Id: row.Id, PackageName: row.Name, Title: row.Title, Severity: Severity(row.Severity)}
+		var err error
 		v.PublicationTime, err = time.Parse(time.RFC3339, row.PublicationTime)
 		if err != nil {
-			log.Println("could not parse time", row.PublicationTime, err)
+			slog.Warn("could not parse time", "time", row.PublicationTime, "err", err)
 			continue
 		}
 		if err := json.Unmarshal(row.Semver, &v.Semver); err != nil {
-			log.Println("could not unmarschal semver", row.Semver, err)
+			slog.Warn("could not unmarshal semver", "semver", string(row.Semver), "err", err)
 			continue
 		}
 		vulnerabilities = append(vulnerabilities, v)
 	}
-	return vulnerabilities, nil
+	return vulnerabilities
+}
+
+// DbNewestVulnerabilities returns the vulnerabilities that were published last
+func DbNewestVulnerabilities(limit int) ([]Vulnerability, error) {
+	var rows []VulnerabilityRow
+	if err := db.Select(&rows, `SELECT id, name, title, publication_time, semver, severity FROM vulnerabilities
+		ORDER BY publication_time DESC LIMIT $1`, limit); err != nil {
+		return nil, errors.Wrap(err, "could not get newest vulnerabilities")
+	}
+	return vulnerabilitiesFromRows(rows), nil
+}
+
+// DbCountVulnerabilitiesBySeverity returns the number of vulnerabilities per severity
+func DbCountVulnerabilitiesBySeverity() (VulnerabilityStats, error) {
+	var rows []struct {
+		Severity string
+		Count    int
+	}
+	var stats VulnerabilityStats
+	if err := db.Select(&rows, "SELECT severity, COUNT(*) AS count FROM vulnerabilities GROUP BY severity"); err != nil {
+		return stats, errors.Wrap(err, "could not count vulnerabilities")
+	}
+	for _, row := range rows {
+		switch Severity(row.Severity) {
+		case Low:
+			stats.LowCount = row.Count
+		case Medium:
+			stats.MediumCount = row.Count
+		case High:
+			stats.HighCount = row.Count
+		case Critical:
+			stats.CriticalCount = row.Count
+		}
+	}
+	return stats, nil
+}
+
+// DbMostVulnerablePackages returns the packages with the most vulnerabilities, with their number of vulnerabilities
+func DbMostVulnerablePackages(limit int) (IntEntries, error) {
+	var entries IntEntries
+	err := db.Select(&entries, `SELECT name AS key, COUNT(*) AS value FROM vulnerabilities GROUP BY name
+		ORDER BY value DESC, name LIMIT $1`, limit)
+	return entries, err
+}
+
+type VulnerabilitySourceRow struct {
+	Source    string
+	SyncTime  string `db:"sync_time"` // of the last successful update
+	LastError string `db:"last_error"`
+	ErrorTime string `db:"error_time"`
+}
+
+// DbPutVulnerabilitySync records an update of the vulnerabilities from a source, err is nil when it succeeded
+func DbPutVulnerabilitySync(source string, now time.Time, err error) error {
+	var dbErr error
+	if err == nil {
+		_, dbErr = db.Exec(`INSERT INTO vulnerability_sources (source, sync_time, last_error, error_time) VALUES ($1, $2, '', '')
+			ON CONFLICT (source) DO UPDATE SET sync_time = excluded.sync_time`, source, now.Format(time.RFC3339))
+	} else {
+		_, dbErr = db.Exec(`INSERT INTO vulnerability_sources (source, sync_time, last_error, error_time) VALUES ($1, '', $2, $3)
+			ON CONFLICT (source) DO UPDATE SET last_error = excluded.last_error, error_time = excluded.error_time`,
+			source, err.Error(), now.Format(time.RFC3339))
+	}
+	return dbErr
+}
+
+func DbVulnerabilitySources() ([]VulnerabilitySourceRow, error) {
+	var rows []VulnerabilitySourceRow
+	err := db.Select(&rows, "SELECT source, sync_time, last_error, error_time FROM vulnerability_sources ORDER BY source")
+	return rows, err
+}
+
+func DbCountLookup(name string, day string) error {
+	_, err := db.Exec("INSERT INTO lookups (name, day, count) VALUES ($1, $2, 1) ON CONFLICT (name, day) DO UPDATE SET count = count + 1",
+		name, day)
+	return err
+}
+
+type DownloadsRow struct {
+	Name  string
+	Count int64
+}
+
+// DbGetDownloads returns the weekly downloads of the packages that are not expired
+func DbGetDownloads(packages []string, now time.Time) (map[string]int64, error) {
+	query, args, err := sqlx.In("SELECT name, count FROM downloads WHERE name IN (?) AND expire_time >= ?", packages, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create query for downloads")
+	}
+	var rows []DownloadsRow
+	if err := db.Select(&rows, db.Rebind(query), args...); err != nil {
+		return nil, errors.Wrap(err, "could not get downloads")
+	}
+	downloads := map[string]int64{}
+	for _, row := range rows {
+		downloads[row.Name] = row.Count
+	}
+	return downloads, nil
+}
+
+func DbPutDownloads(name string, count int64, expireTime time.Time) error {
+	_, err := db.Exec(`INSERT INTO downloads (name, count, expire_time) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET count = excluded.count, expire_time = excluded.expire_time`, name, count, expireTime)
+	return err
+}
+
+// DbGetSearch returns the cached results of a search as json, or "" if they are not cached or expired
+func DbGetSearch(query string, now time.Time) (string, error) {
+	var content string
+	err := db.Get(&content, "SELECT content FROM searches WHERE query = $1 AND expire_time >= $2", query, now)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return content, err
+}
+
+func DbPutSearch(query string, content string, expireTime time.Time) error {
+	_, err := db.Exec(`INSERT INTO searches (query, content, expire_time) VALUES ($1, $2, $3)
+		ON CONFLICT (query) DO UPDATE SET content = excluded.content, expire_time = excluded.expire_time`,
+		query, content, expireTime)
+	return err
+}
+
+// DbGetTarballSize returns the size of a tarball, or 0 if it is not known. Published tarballs don't change, so the
+// sizes don't expire.
+func DbGetTarballSize(url string) (int64, error) {
+	var size int64
+	err := db.Get(&size, "SELECT size FROM tarballs WHERE url = $1", url)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return size, err
+}
+
+func DbPutTarballSize(url string, size int64) error {
+	_, err := db.Exec("INSERT INTO tarballs (url, size) VALUES ($1, $2) ON CONFLICT (url) DO UPDATE SET size = excluded.size",
+		url, size)
+	return err
+}
+
+// DbGetProvenance returns the repository of the provenance at the attestations url, found is false when it is unknown
+func DbGetProvenance(url string) (repository string, found bool, err error) {
+	err = db.Get(&repository, "SELECT repository FROM provenances WHERE url = $1", url)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return repository, err == nil, err
+}
+
+func DbPutProvenance(url string, repository string) error {
+	_, err := db.Exec(`INSERT INTO provenances (url, repository) VALUES ($1, $2)
+		ON CONFLICT (url) DO UPDATE SET repository = excluded.repository`, url, repository)
+	return err
+}
+
+// DbGetNugetPackage returns a NuGet package that is not expired, by its lower case id
+func DbGetNugetPackage(id string) (*NugetPackage, error) {
+	var info string
+	if err := db.Get(&info, "SELECT info FROM nuget_packages WHERE id = $1 AND expire_time >= $2", id, time.Now()); err != nil {
+		return nil, err
+	}
+	var nugetPackage NugetPackage
+	if err := json.Unmarshal([]byte(info), &nugetPackage); err != nil {
+		return nil, err
+	}
+	return &nugetPackage, nil
+}
+
+func DbPutNugetPackage(id string, nugetPackage *NugetPackage, expireTime time.Time) error {
+	bytes, err := json.Marshal(nugetPackage)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO nuget_packages (id, info, expire_time) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET info = excluded.info, expire_time = excluded.expire_time`, id, bytes, expireTime)
+	return err
+}
+
+// DbGetNugetVersion returns an analysis of a NuGet version, by the lower case id of the package
+func DbGetNugetVersion(id string, versionRaw string) (*Version, error) {
+	var content string
+	if err := db.Get(&content, "SELECT content FROM nuget_versions WHERE id = $1 AND version = $2", id, versionRaw); err != nil {
+		return nil, err
+	}
+	var version Version
+	if err := json.Unmarshal([]byte(content), &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+func DbPutNugetVersion(id string, versionRaw string, version *Version, expireTime time.Time) error {
+	bytes, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO nuget_versions (id, version, content, create_time, expire_time) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id, version) DO UPDATE SET content = excluded.content, create_time = excluded.create_time,
+			expire_time = excluded.expire_time`, id, versionRaw, bytes, time.Now(), expireTime)
+	return err
+}
+
+// DbGetJsrPackage returns a JSR package that is not expired, by its @scope/name
+func DbGetJsrPackage(name string) (*JsrPackage, error) {
+	var info string
+	if err := db.Get(&info, "SELECT info FROM jsr_packages WHERE name = $1 AND expire_time >= $2", name, time.Now()); err != nil {
+		return nil, err
+	}
+	var jsrPackage JsrPackage
+	if err := json.Unmarshal([]byte(info), &jsrPackage); err != nil {
+		return nil, err
+	}
+	return &jsrPackage, nil
+}
+
+func DbPutJsrPackage(name string, jsrPackage *JsrPackage, expireTime time.Time) error {
+	bytes, err := json.Marshal(jsrPackage)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO jsr_packages (name, info, expire_time) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET info = excluded.info, expire_time = excluded.expire_time`, name, bytes, expireTime)
+	return err
+}
+
+// DbGetJsrDependencies returns the dependencies of a JSR version, found is false when they are unknown
+func DbGetJsrDependencies(name string, versionRaw string) (dependencies []JsrDependency, found bool, err error) {
+	var content string
+	err = db.Get(&content, "SELECT content FROM jsr_dependencies WHERE name = $1 AND version = $2", name, versionRaw)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if err := json.Unmarshal([]byte(content), &dependencies); err != nil {
+		return nil, false, err
+	}
+	return dependencies, true, nil
+}
+
+func DbPutJsrDependencies(name string, versionRaw string, dependencies []JsrDependency) error {
+	bytes, err := json.Marshal(dependencies)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO jsr_dependencies (name, version, content) VALUES ($1, $2, $3)
+		ON CONFLICT (name, version) DO UPDATE SET content = excluded.content`, name, versionRaw, bytes)
+	return err
+}
+
+// DbGetJsrVersion returns an analysis of a JSR version
+func DbGetJsrVersion(name string, versionRaw string) (*Version, error) {
+	var content string
+	if err := db.Get(&content, "SELECT content FROM jsr_versions WHERE name = $1 AND version = $2", name, versionRaw); err != nil {
+		return nil, err
+	}
+	var version Version
+	if err := json.Unmarshal([]byte(content), &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+func DbPutJsrVersion(name string, versionRaw string, version *Version, expireTime time.Time) error {
+	bytes, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO jsr_versions (name, version, content, create_time, expire_time) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name, version) DO UPDATE SET content = excluded.content, create_time = excluded.create_time,
+			expire_time = excluded.expire_time`, name, versionRaw, bytes, time.Now(), expireTime)
+	return err
+}
+
+type TarballContentsRow struct {
+	FileCount    int   `db:"file_count"`
+	UnpackedSize int64 `db:"unpacked_size"`
+}
+
+// DbGetTarballContents returns the counted files and size of an inspected tarball, or nil
+func DbGetTarballContents(url string) (*TarballContentsRow, error) {
+	var row TarballContentsRow
+	err := db.Get(&row, "SELECT file_count, unpacked_size FROM tarball_contents WHERE url = $1", url)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func DbPutTarballContents(url string, row TarballContentsRow) error {
+	_, err := db.Exec(`INSERT INTO tarball_contents (url, file_count, unpacked_size) VALUES ($1, $2, $3)
+		ON CONFLICT (url) DO UPDATE SET file_count = excluded.file_count, unpacked_size = excluded.unpacked_size`,
+		url, row.FileCount, row.UnpackedSize)
+	return err
+}
+
+type MailRow struct {
+	Id        int
+	Recipient string
+	Subject   string
+	Body      string
+	TextBody  string `db:"text_body"`
+	Attempts  int
+}
+
+func DbQueueMail(recipient string, subject string, body string, textBody string) error {
+	now := time.Now()
+	_, err := db.Exec(`INSERT INTO mails (recipient, subject, body, text_body, attempts, next_attempt, create_time)
+		VALUES ($1, $2, $3, $4, 0, $5, $6)`, recipient, subject, body, textBody, now, now)
+	return err
+}
+
+// DbDueMails returns the queued mails that should be sent now, the oldest first
+func DbDueMails(now time.Time, limit int) ([]MailRow, error) {
+	var rows []MailRow
+	err := db.Select(&rows, "SELECT id, recipient, subject, body, text_body, attempts FROM mails WHERE next_attempt <= $1 ORDER BY id LIMIT $2",
+		now, limit)
+	return rows, err
+}
+
+func DbRetryMail(id int, attempts int, nextAttempt time.Time, lastError string) error {
+	_, err := db.Exec("UPDATE mails SET attempts = $1, next_attempt = $2, last_error = $3 WHERE id = $4",
+		attempts, nextAttempt, lastError, id)
+	return err
+}
+
+func DbDeleteMail(id int) error {
+	_, err := db.Exec("DELETE FROM mails WHERE id = $1", id)
+	return err
+}
+
+type WatchRow struct {
+	Id        int
+	Email     string
+	Packages  string // separated by spaces
+	Token     string // for the confirm and unsubscribe links in the emails
+	State     string // json of the last digest
+	Confirmed bool
+	Alerts    bool // email new vulnerabilities right away
+}
+
+func DbCreateWatch(email string, packages string, token string, alerts bool, userId int) error {
+	now := time.Now()
+	_, err := db.Exec(`INSERT INTO watches (email, packages, token, state, confirmed, alerts, user_id, last_digest, create_time)
+		VALUES ($1, $2, $3, '', 0, $4, $5, $6, $7)`, email, packages, token, alerts, userId, now, now)
+	return err
+}
+
+func DbGetWatchByToken(token string) (*WatchRow, error) {
+	var row WatchRow
+	if err := db.Get(&row, "SELECT id, email, packages, token, state, confirmed, alerts FROM watches WHERE token = $1", token); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// DbSetWatchDigest confirms the watch, and stores the state of its last digest
+func DbSetWatchDigest(id int, state string, lastDigest time.Time) error {
+	_, err := db.Exec("UPDATE watches SET confirmed = 1, state = $1, last_digest = $2 WHERE id = $3", state, lastDigest, id)
+	return err
+}
+
+// DbDueWatches returns the confirmed watches that did not get a digest since the given time
+func DbDueWatches(before time.Time) ([]WatchRow, error) {
+	var rows []WatchRow
+	err := db.Select(&rows, "SELECT id, email, packages, token, state, confirmed, alerts FROM watches WHERE confirmed = 1 AND last_digest < $1",
+		before)
+	return rows, err
+}
+
+// DbConfirmedWatches returns all the confirmed watches
+func DbConfirmedWatches() ([]WatchRow, error) {
+	var rows []WatchRow
+	err := db.Select(&rows, "SELECT id, email, packages, token, state, confirmed, alerts FROM watches WHERE confirmed = 1")
+	return rows, err
+}
+
+func DbDeleteWatch(id int) error {
+	_, err := db.Exec("DELETE FROM watches WHERE id = $1", id)
+	return err
+}
+
+type UserRow struct {
+	Id           int
+	Email        string
+	PasswordHash string `db:"password_hash"`
+	GithubId     int64  `db:"github_id"`
+	GithubLogin  string `db:"github_login"`
+	GithubToken  string `db:"github_token"` // encrypted, for github api requests on behalf of the user, see openGithubToken
+}
+
+const userColumns = "users.id, users.email, users.password_hash, users.github_id, users.github_login, users.github_token"
+
+var EmailTakenError = errors.New("email address is already registered")
+
+func DbCreateUser(email string, passwordHash string) (int, error) {
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM users WHERE email = $1", email); err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, EmailTakenError
+	}
+	result, err := db.Exec("INSERT INTO users (email, password_hash, create_time) VALUES ($1, $2, $3)",
+		email, passwordHash, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func DbGetUserByEmail(email string) (*UserRow, error) {
+	var row UserRow
+	if err := db.Get(&row, "SELECT "+userColumns+" FROM users WHERE email = $1", email); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func DbGetUserByGithubId(githubId int64) (*UserRow, error) {
+	var row UserRow
+	if err := db.Get(&row, "SELECT "+userColumns+" FROM users WHERE github_id = $1", githubId); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// DbSetUserGithub links the github account to the user, and stores its latest access token
+func DbSetUserGithub(userId int, githubId int64, login string, token string) error {
+	_, err := db.Exec("UPDATE users SET github_id = $1, github_login = $2, github_token = $3 WHERE id = $4",
+		githubId, login, token, userId)
+	return err
+}
+
+func DbCreateSession(tokenHash string, userId int, expireTime time.Time) error {
+	_, err := db.Exec("INSERT INTO sessions (token_hash, user_id, expire_time, create_time) VALUES ($1, $2, $3, $4)",
+		tokenHash, userId, expireTime, time.Now())
+	return err
+}
+
+// DbGetSessionUser returns the user of an unexpired session
+func DbGetSessionUser(tokenHash string) (*UserRow, error) {
+	var row UserRow
+	err := db.Get(&row, `SELECT `+userColumns+` FROM sessions
+		JOIN users ON users.id = sessions.user_id WHERE sessions.token_hash = $1 AND sessions.expire_time >= $2`,
+		tokenHash, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func DbDeleteSession(tokenHash string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE token_hash = $1", tokenHash)
+	return err
+}
+
+// DbCreateAdminSession stores a session of the admin pages, with the hash of the admin token it was started with
+func DbCreateAdminSession(tokenHash string, adminTokenHash string, expireTime time.Time) error {
+	_, err := db.Exec(`INSERT INTO admin_sessions (token_hash, admin_token_hash, expire_time, create_time)
+		VALUES ($1, $2, $3, $4)`, tokenHash, adminTokenHash, expireTime, time.Now())
+	return err
+}
+
+// DbAdminSessionExists returns whether the session is unexpired and was started with the current admin token
+func DbAdminSessionExists(tokenHash string, adminTokenHash string) (bool, error) {
+	var count int
+	err := db.Get(&count, `SELECT COUNT(*) FROM admin_sessions
+		WHERE token_hash = $1 AND admin_token_hash = $2 AND expire_time >= $3`, tokenHash, adminTokenHash, time.Now())
+	return count > 0, err
+}
+
+func DbDeleteAdminSession(tokenHash string) error {
+	_, err := db.Exec("DELETE FROM admin_sessions WHERE token_hash = $1", tokenHash)
+	return err
+}
+
+type UserFileRow struct {
+	Id         string
+	CreateTime string `db:"create_time"`
+}
+
+func DbUserFiles(userId int) ([]UserFileRow, error) {
+	var rows []UserFileRow
+	err := db.Select(&rows, "SELECT id, create_time FROM files WHERE user_id = $1 ORDER BY create_time DESC", userId)
+	return rows, err
+}
+
+func DbUserWatches(userId int) ([]WatchRow, error) {
+	var rows []WatchRow
+	err := db.Select(&rows, "SELECT id, email, packages, token, state, confirmed, alerts FROM watches WHERE user_id = $1 ORDER BY id",
+		userId)
+	return rows, err
+}
+
+type PageRow struct {
+	Id        int
+	Path      string
+	Title     string
+	Content   string // markdown
+	Published bool
+}
+
+var PagePathTakenError = errors.New("there is already a page with this path")
+
+func DbListPages() ([]PageRow, error) {
+	var rows []PageRow
+	err := db.Select(&rows, "SELECT id, path, title, content, published FROM pages ORDER BY path")
+	return rows, err
+}
+
+func DbGetPage(id int) (*PageRow, error) {
+	var row PageRow
+	if err := db.Get(&row, "SELECT id, path, title, content, published FROM pages WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func DbGetPublishedPage(path string) (*PageRow, error) {
+	var row PageRow
+	err := db.Get(&row, "SELECT id, path, title, content, published FROM pages WHERE path = $1 AND published = 1", path)
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// DbSavePage creates the page when the id is 0, and updates it otherwise. It returns the id of the page.
+func DbSavePage(page PageRow) (int, error) {
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM pages WHERE path = $1 AND id != $2", page.Path, page.Id); err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, PagePathTakenError
+	}
+	now := time.Now()
+	if page.Id != 0 {
+		_, err := db.Exec("UPDATE pages SET path = $1, title = $2, content = $3, published = $4, update_time = $5 WHERE id = $6",
+			page.Path, page.Title, page.Content, page.Published, now, page.Id)
+		return page.Id, err
+	}
+	result, err := db.Exec(`INSERT INTO pages (path, title, content, published, update_time, create_time)
+		VALUES ($1, $2, $3, $4, $5, $6)`, page.Path, page.Title, page.Content, page.Published, now, now)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func DbDeletePage(id int) error {
+	_, err := db.Exec("DELETE FROM pages WHERE id = $1", id)
+	return err
+}
+
+// DbPopularExpiringPackages returns the most looked up packages since the given day, that expire before the given time
+func DbPopularExpiringPackages(since string, top int, expireBefore time.Time) ([]string, error) {
+	var names []string
+	err := db.Select(&names, `
+		SELECT name FROM (
+			SELECT name, SUM(count) AS total FROM lookups WHERE day >= $1 GROUP BY name ORDER BY total DESC LIMIT $2
+		) AS popular
+		WHERE name IN (SELECT name FROM packages WHERE expire_time < $3)`,
+		since, top, expireBefore)
+	return names, err
+}
+
+type RecentVersionRow struct {
+	Name       string
+	Version    string
+	CreateTime string `db:"create_time"`
+}
+
+// DbRecentVersions returns the last analyzed versions, the newest first. Uploaded files are private, so they are not
+// included.
+func DbRecentVersions(limit int) ([]RecentVersionRow, error) {
+	var rows []RecentVersionRow
+	err := db.Select(&rows, "SELECT name, version, create_time FROM versions ORDER BY create_time DESC LIMIT $1", limit)
+	return rows, err
+}
+
+type TrendingRow struct {
+	Name     string
+	Recent   int64 // lookups since the day
+	Previous int64 // lookups in the days before that
+}
+
+// DbTrendingPackages returns the most looked up packages since a day, with their lookups between the previous day and
+// that day to compare
+func DbTrendingPackages(since string, previous string, limit int) ([]TrendingRow, error) {
+	var rows []TrendingRow
+	err := db.Select(&rows, `
+		SELECT name, SUM(CASE WHEN day >= $1 THEN count ELSE 0 END) AS recent,
+			SUM(CASE WHEN day < $1 THEN count ELSE 0 END) AS previous
+		FROM lookups WHERE day >= $2 GROUP BY name HAVING recent > 0 ORDER BY recent DESC, name LIMIT $3`,
+		since, previous, limit)
+	return rows, err
+}
+
+type TableStats struct {
+	Rows    int64      `json:"rows"`
+	Size    int64      `json:"size"`
+	Expired int64      `json:"expired"`
+	Cache   *PoolStats `json:"cache,omitempty"`
+}
+
+// dbTableStats counts the rows and the content size of a table, and the rows that are expired but not yet deleted
+func dbTableStats(table string, contentColumn string, expireColumn string, now time.Time) (TableStats, error) {
+	var stats TableStats
+	query := "SELECT COUNT(*) AS count, COALESCE(SUM(LENGTH(" + contentColumn + ")), 0) AS size FROM " + table
+	var row struct {
+		Count int64
+		Size  int64
+	}
+	if err := db.Get(&row, query); err != nil {
+		return stats, errors.Wrap(err, "could not get stats for "+table)
+	}
+	stats.Rows = row.Count
+	stats.Size = row.Size
+	if expireColumn != "" {
+		if err := db.Get(&stats.Expired, "SELECT COUNT(*) FROM "+table+" WHERE "+expireColumn+" < $1", now); err != nil {
+			return stats, errors.Wrap(err, "could not get expired count for "+table)
+		}
+	}
+	return stats, nil
+}
+
+func DbStats() (map[string]TableStats, error) {
+	now := time.Now()
+	var fileExpireColumn string
+	fileNow := now
+	if days := Config.Files.RetentionDays; days > 0 {
+		fileExpireColumn = "create_time"
+		fileNow = now.Add(-time.Duration(days) * 24 * time.Hour)
+	}
+	tables := []struct {
+		name          string
+		contentColumn string
+		expireColumn  string
+		now           time.Time
+		pool          *SmartWorkPool
+	}{
+		{"packages", "info", "expire_time", now, packagePool},
+		{"versions", "content", "expire_time", now, versionPool},
+		{"files", "content", fileExpireColumn, fileNow, filePool},
+		{"vulnerabilities", "semver", "", now, nil},
+	}
+	result := map[string]TableStats{}
+	for _, table := range tables {
+		stats, err := dbTableStats(table.name, table.contentColumn, table.expireColumn, table.now)
+		if err != nil {
+			return nil, err
+		}
+		if table.pool != nil {
+			poolStats := table.pool.Stats()
+			stats.Cache = &poolStats
+		}
+		result[table.name] = stats
+	}
+	return result, nil
+}
+
+// DbCountRows counts the rows of one of the tables, for the statistics on the pages
+func DbCountRows(table string) (int64, error) {
+	if !contains([]string{"packages", "versions", "files", "vulnerabilities", "users", "watches"}, table) {
+		return 0, errors.New("unknown table " + table)
+	}
+	var count int64
+	err := db.Get(&count, "SELECT COUNT(*) FROM "+table)
+	return count, err
 }
 
 func connect() {
@@ -180,20 +1149,74 @@ func connect() {
 	if err != nil {
 		log.Panicln("could not open", source, err)
 	}
+	setupBlobStore()
 }
 
+const STALE_PACKAGE_KEEP = 7 * 24 * time.Hour
+
 func expire() {
 	now := time.Now()
-	log.Println("run expire")
+	slog.Debug("run expire")
+
+	// expired packages are kept for a while, so they can be revalidated with the registry
+	staleBefore := now.Add(-STALE_PACKAGE_KEEP)
+
+	var blobKeys []string
+	db.Select(&blobKeys, "SELECT blob_key FROM packages WHERE expire_time < $1 AND blob_key != ''", staleBefore)
+	var versionBlobKeys []string
+	db.Select(&versionBlobKeys, "SELECT blob_key FROM versions WHERE expire_time < $1 AND blob_key != ''", now)
+	blobKeys = append(blobKeys, versionBlobKeys...)
 
-	result := db.MustExec("DELETE FROM packages WHERE expire_time < $1", now)
+	result := db.MustExec("DELETE FROM packages WHERE expire_time < $1", staleBefore)
 	if n, err := result.RowsAffected(); n > 0 && err == nil {
-		log.Printf("expired %d packages\n", n)
+		slog.Info("expired packages", "count", n)
 	}
 
 	result = db.MustExec("DELETE FROM versions WHERE expire_time < $1", now)
 	if n, err := result.RowsAffected(); n > 0 && err == nil {
-		log.Printf("expired %d versions\n", n)
+		slog.Info("expired versions", "count", n)
+	}
+	db.MustExec("DELETE FROM nuget_packages WHERE expire_time < $1", now)
+	db.MustExec("DELETE FROM nuget_versions WHERE expire_time < $1", now)
+	db.MustExec("DELETE FROM jsr_packages WHERE expire_time < $1", now)
+	db.MustExec("DELETE FROM jsr_versions WHERE expire_time < $1", now)
+	db.MustExec("DELETE FROM stat_snapshots WHERE day < $1", now.Add(-STAT_SNAPSHOT_RETENTION).Format(LOOKUP_DAY_FORMAT))
+	db.MustExec(`DELETE FROM version_publishers WHERE NOT EXISTS
+		(SELECT 1 FROM versions v WHERE v.name = version_publishers.name AND v.version = version_publishers.version)`)
+
+	deleteBlobs(blobKeys)
+
+	result = db.MustExec("DELETE FROM downloads WHERE expire_time < $1", now)
+	if n, err := result.RowsAffected(); n > 0 && err == nil {
+		slog.Info("expired downloads", "count", n)
+	}
+
+	db.MustExec("DELETE FROM searches WHERE expire_time < $1", now)
+
+	result = db.MustExec("DELETE FROM sessions WHERE expire_time < $1", now)
+	if n, err := result.RowsAffected(); n > 0 && err == nil {
+		slog.Info("expired sessions", "count", n)
+	}
+	db.MustExec("DELETE FROM admin_sessions WHERE expire_time < $1", now)
+
+	if days := Config.Files.RetentionDays; days > 0 {
+		createdBefore := now.Add(-time.Duration(days) * 24 * time.Hour)
+		var ids []string
+		// the files of users are kept until they delete them
+		if err := db.Select(&ids, "SELECT id FROM files WHERE create_time < $1 AND user_id = 0", createdBefore); err != nil {
+			slog.Error("could not select expired files", "err", err)
+			return
+		}
+		for _, id := range ids {
+			if err := DbDeleteFile(id); err != nil {
+				slog.Error("could not delete expired file", "id", id, "err", err)
+				continue
+			}
+			ForgetFile(id)
+		}
+		if len(ids) > 0 {
+			slog.Info("expired files", "count", len(ids))
+		}
 	}
 }
 
@@ -204,40 +1227,367 @@ func scheduleExpire() {
 	}
 }
 
-func runMigrations() {
-	Migrate([]Migration{
-		{
-			Name: "create tables",
-			Sql: `
-				CREATE TABLE packages (name TEXT, info TEXT, create_time TEXT, expire_time TEXT);
-				CREATE UNIQUE INDEX packages_name ON packages (name);
-
-				CREATE TABLE versions (name TEXT, version TEXT, content TEXT, create_time TEXT, expire_time TEXT);
-				CREATE UNIQUE INDEX versions_name_version ON versions (name, version);
-
-				CREATE TABLE files (id TEXT, content TEXT, create_time TEXT);
-				CREATE UNIQUE INDEX files_id ON files (id);
-			`,
-		},
-		{
-			Name: "add latest_version",
-			Sql: `
-				ALTER TABLE packages ADD COLUMN latest_version TEXT;
-			`,
-		},
-		{
-			Name: "create vulnerabilities table",
-			Sql: `
-				CREATE TABLE vulnerabilities (id TEXT, name TEXT, title TEXT, publication_time TEXT, semver TEXT, severity TEXT);
-				CREATE UNIQUE INDEX vulnerabilities_id ON vulnerabilities (id);
-				CREATE INDEX vulnerabilities_name ON vulnerabilities (name);
-			`,
-		},
-	})
+var migrations = []Migration{
+	{
+		Name: "create tables",
+		Sql: `
+			CREATE TABLE packages (name TEXT, info TEXT, create_time TEXT, expire_time TEXT);
+			CREATE UNIQUE INDEX packages_name ON packages (name);
+
+			CREATE TABLE versions (name TEXT, version TEXT, content TEXT, create_time TEXT, expire_time TEXT);
+			CREATE UNIQUE INDEX versions_name_version ON versions (name, version);
+
+			CREATE TABLE files (id TEXT, content TEXT, create_time TEXT);
+			CREATE UNIQUE INDEX files_id ON files (id);
+		`,
+		Down: `
+			DROP TABLE files;
+			DROP TABLE versions;
+			DROP TABLE packages;
+		`,
+	},
+	{
+		Name: "add latest_version",
+		Sql: `
+			ALTER TABLE packages ADD COLUMN latest_version TEXT;
+		`,
+		Down: `
+			ALTER TABLE packages DROP COLUMN latest_version;
+		`,
+	},
+	{
+		Name: "create vulnerabilities table",
+		Sql: `
+			CREATE TABLE vulnerabilities (id TEXT, name TEXT, title TEXT, publication_time TEXT, semver TEXT, severity TEXT);
+			CREATE UNIQUE INDEX vulnerabilities_id ON vulnerabilities (id);
+			CREATE INDEX vulnerabilities_name ON vulnerabilities (name);
+		`,
+		Down: `
+			DROP TABLE vulnerabilities;
+		`,
+	},
+	{
+		Name: "add blob_key",
+		Sql: `
+			ALTER TABLE packages ADD COLUMN blob_key TEXT NOT NULL DEFAULT '';
+			ALTER TABLE versions ADD COLUMN blob_key TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+			ALTER TABLE versions DROP COLUMN blob_key;
+			ALTER TABLE packages DROP COLUMN blob_key;
+		`,
+	},
+	{
+		Name: "add files delete_token",
+		Sql: `
+			ALTER TABLE files ADD COLUMN delete_token TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+			ALTER TABLE files DROP COLUMN delete_token;
+		`,
+	},
+	{
+		Name: "add files content_hash",
+		Sql: `
+			ALTER TABLE files ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';
+			CREATE INDEX files_content_hash ON files (content_hash);
+		`,
+		Down: `
+			DROP INDEX files_content_hash;
+			ALTER TABLE files DROP COLUMN content_hash;
+		`,
+	},
+	{
+		Name: "create lookups table",
+		Sql: `
+			CREATE TABLE lookups (name TEXT, day TEXT, count INTEGER);
+			CREATE UNIQUE INDEX lookups_name_day ON lookups (name, day);
+			CREATE INDEX lookups_day ON lookups (day);
+		`,
+		Down: `
+			DROP TABLE lookups;
+		`,
+	},
+	{
+		Name: "add packages etag and last_modified",
+		Sql: `
+			ALTER TABLE packages ADD COLUMN etag TEXT NOT NULL DEFAULT '';
+			ALTER TABLE packages ADD COLUMN last_modified TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+			ALTER TABLE packages DROP COLUMN last_modified;
+			ALTER TABLE packages DROP COLUMN etag;
+		`,
+	},
+	{
+		Name: "create mails table",
+		Sql: `
+			CREATE TABLE mails (id INTEGER PRIMARY KEY AUTOINCREMENT, recipient TEXT, subject TEXT, body TEXT,
+				attempts INTEGER NOT NULL DEFAULT 0, next_attempt TEXT, last_error TEXT NOT NULL DEFAULT '', create_time TEXT);
+			CREATE INDEX mails_next_attempt ON mails (next_attempt);
+		`,
+		Down: `
+			DROP TABLE mails;
+		`,
+	},
+	{
+		Name: "add mails text_body",
+		Sql: `
+			ALTER TABLE mails ADD COLUMN text_body TEXT NOT NULL DEFAULT '';
+		`,
+		Down: `
+			ALTER TABLE mails DROP COLUMN text_body;
+		`,
+	},
+	{
+		Name: "create watches table",
+		Sql: `
+			CREATE TABLE watches (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT, packages TEXT, token TEXT,
+				state TEXT, confirmed INTEGER NOT NULL DEFAULT 0, last_digest TEXT, create_time TEXT);
+			CREATE UNIQUE INDEX watches_token ON watches (token);
+		`,
+		Down: `
+			DROP TABLE watches;
+		`,
+	},
+	{
+		Name: "add watches alerts",
+		Sql: `
+			ALTER TABLE watches ADD COLUMN alerts INTEGER NOT NULL DEFAULT 0;
+		`,
+		Down: `
+			ALTER TABLE watches DROP COLUMN alerts;
+		`,
+	},
+	{
+		Name: "create users and sessions tables",
+		Sql: `
+			CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT, password_hash TEXT, create_time TEXT);
+			CREATE UNIQUE INDEX users_email ON users (email);
+			CREATE TABLE sessions (token_hash TEXT, user_id INTEGER, expire_time TEXT, create_time TEXT);
+			CREATE UNIQUE INDEX sessions_token_hash ON sessions (token_hash);
+			ALTER TABLE files ADD COLUMN user_id INTEGER NOT NULL DEFAULT 0;
+			CREATE INDEX files_user_id ON files (user_id);
+			ALTER TABLE watches ADD COLUMN user_id INTEGER NOT NULL DEFAULT 0;
+		`,
+		Down: `
+			ALTER TABLE watches DROP COLUMN user_id;
+			DROP INDEX files_user_id;
+			ALTER TABLE files DROP COLUMN user_id;
+			DROP TABLE sessions;
+			DROP TABLE users;
+		`,
+	},
+	{
+		Name: "add users github",
+		Sql: `
+			ALTER TABLE users ADD COLUMN github_id INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE users ADD COLUMN github_login TEXT NOT NULL DEFAULT '';
+			ALTER TABLE users ADD COLUMN github_token TEXT NOT NULL DEFAULT '';
+			CREATE INDEX users_github_id ON users (github_id);
+		`,
+		Down: `
+			DROP INDEX users_github_id;
+			ALTER TABLE users DROP COLUMN github_token;
+			ALTER TABLE users DROP COLUMN github_login;
+			ALTER TABLE users DROP COLUMN github_id;
+		`,
+	},
+	{
+		Name: "create pages table",
+		Sql: `
+			CREATE TABLE pages (id INTEGER PRIMARY KEY AUTOINCREMENT, path TEXT, title TEXT, content TEXT,
+				published INTEGER NOT NULL DEFAULT 0, update_time TEXT, create_time TEXT);
+			CREATE UNIQUE INDEX pages_path ON pages (path);
+		`,
+		Down: `
+			DROP TABLE pages;
+		`,
+	},
+	{
+		Name: "create downloads table",
+		Sql: `
+			CREATE TABLE downloads (name TEXT, count INTEGER, expire_time TEXT);
+			CREATE UNIQUE INDEX downloads_name ON downloads (name);
+		`,
+		Down: `
+			DROP TABLE downloads;
+		`,
+	},
+	{
+		Name: "create tarballs table",
+		Sql: `
+			CREATE TABLE tarballs (url TEXT, size INTEGER);
+			CREATE UNIQUE INDEX tarballs_url ON tarballs (url);
+		`,
+		Down: `
+			DROP TABLE tarballs;
+		`,
+	},
+	{
+		Name: "create tarball_contents table",
+		Sql: `
+			CREATE TABLE tarball_contents (url TEXT, file_count INTEGER, unpacked_size INTEGER);
+			CREATE UNIQUE INDEX tarball_contents_url ON tarball_contents (url);
+		`,
+		Down: `
+			DROP TABLE tarball_contents;
+		`,
+	},
+	{
+		Name: "add versions create_time index",
+		Sql: `
+			CREATE INDEX versions_create_time ON versions (create_time);
+		`,
+		Down: `
+			DROP INDEX versions_create_time;
+		`,
+	},
+	{
+		Name: "create vulnerability_sources table",
+		Sql: `
+			CREATE TABLE vulnerability_sources (source TEXT, sync_time TEXT, last_error TEXT, error_time TEXT);
+			CREATE UNIQUE INDEX vulnerability_sources_source ON vulnerability_sources (source);
+		`,
+		Down: `
+			DROP TABLE vulnerability_sources;
+		`,
+	},
+	{
+		Name: "create version_publishers table",
+		Sql: `
+			CREATE TABLE version_publishers (name TEXT, version TEXT, publisher TEXT, count INTEGER);
+			CREATE INDEX version_publishers_name_version ON version_publishers (name, version);
+			CREATE INDEX version_publishers_publisher ON version_publishers (publisher);
+		`,
+		Down: `
+			DROP TABLE version_publishers;
+		`,
+	},
+	{
+		Name: "create stat_snapshots table",
+		Sql: `
+			CREATE TABLE stat_snapshots (name TEXT, version TEXT, day TEXT, packages INTEGER, versions INTEGER,
+				disk_space INTEGER, vulnerabilities INTEGER);
+			CREATE UNIQUE INDEX stat_snapshots_name_version_day ON stat_snapshots (name, version, day);
+		`,
+		Down: `
+			DROP TABLE stat_snapshots;
+		`,
+	},
+	{
+		Name: "create provenances table",
+		Sql: `
+			CREATE TABLE provenances (url TEXT, repository TEXT);
+			CREATE UNIQUE INDEX provenances_url ON provenances (url);
+		`,
+		Down: `
+			DROP TABLE provenances;
+		`,
+	},
+	{
+		Name: "create nuget tables",
+		Sql: `
+			CREATE TABLE nuget_packages (id TEXT, info TEXT, expire_time TEXT);
+			CREATE UNIQUE INDEX nuget_packages_id ON nuget_packages (id);
+			CREATE TABLE nuget_versions (id TEXT, version TEXT, content TEXT, create_time TEXT, expire_time TEXT);
+			CREATE UNIQUE INDEX nuget_versions_id_version ON nuget_versions (id, version);
+		`,
+		Down: `
+			DROP TABLE nuget_versions;
+			DROP TABLE nuget_packages;
+		`,
+	},
+	{
+		Name: "create jsr tables",
+		Sql: `
+			CREATE TABLE jsr_packages (name TEXT, info TEXT, expire_time TEXT);
+			CREATE UNIQUE INDEX jsr_packages_name ON jsr_packages (name);
+			CREATE TABLE jsr_dependencies (name TEXT, version TEXT, content TEXT);
+			CREATE UNIQUE INDEX jsr_dependencies_name_version ON jsr_dependencies (name, version);
+			CREATE TABLE jsr_versions (name TEXT, version TEXT, content TEXT, create_time TEXT, expire_time TEXT);
+			CREATE UNIQUE INDEX jsr_versions_name_version ON jsr_versions (name, version);
+		`,
+		Down: `
+			DROP TABLE jsr_versions;
+			DROP TABLE jsr_dependencies;
+			DROP TABLE jsr_packages;
+		`,
+	},
+	{
+		Name: "create changes_feeds table",
+		Sql: `
+			CREATE TABLE changes_feeds (url TEXT, seq TEXT, update_time TEXT);
+			CREATE UNIQUE INDEX changes_feeds_url ON changes_feeds (url);
+		`,
+		Down: `
+			DROP TABLE changes_feeds;
+		`,
+	},
+	{
+		Name: "create searches table",
+		Sql: `
+			CREATE TABLE searches (query TEXT, content TEXT, expire_time TEXT);
+			CREATE UNIQUE INDEX searches_query ON searches (query);
+		`,
+		Down: `
+			DROP TABLE searches;
+		`,
+	},
+	{
+		// the tokens are encrypted now, the plain ones are dropped and the users log in with github again
+		Name: "clear plain github tokens",
+		Sql: `
+			UPDATE users SET github_token = '';
+		`,
+		Down: `
+			UPDATE users SET github_token = '';
+		`,
+	},
+	{
+		Name: "create admin_sessions table",
+		Sql: `
+			CREATE TABLE admin_sessions (token_hash TEXT, admin_token_hash TEXT, expire_time TEXT, create_time TEXT);
+			CREATE UNIQUE INDEX admin_sessions_token_hash ON admin_sessions (token_hash);
+		`,
+		Down: `
+			DROP TABLE admin_sessions;
+		`,
+	},
+	{
+		// every upload of the same content gets its own token, the file is deleted with the last one
+		Name: "create file_tokens table",
+		Sql: `
+			CREATE TABLE file_tokens (file_id TEXT, token_hash TEXT);
+			CREATE INDEX file_tokens_file_id ON file_tokens (file_id);
+			INSERT INTO file_tokens (file_id, token_hash) SELECT id, delete_token FROM files WHERE delete_token != '';
+			ALTER TABLE files DROP COLUMN delete_token;
+		`,
+		Down: `
+			ALTER TABLE files ADD COLUMN delete_token TEXT NOT NULL DEFAULT '';
+			UPDATE files SET delete_token = COALESCE(
+				(SELECT token_hash FROM file_tokens WHERE file_id = files.id LIMIT 1), '');
+			DROP TABLE file_tokens;
+		`,
+	},
 }
 
 func SetupDb() {
 	connect()
-	runMigrations()
+	Migrate(migrations)
 	go scheduleExpire()
+	go scheduleRefresh()
+	go scheduleChanges()
+	go scheduleMails()
+	go scheduleDigests()
+}
+
+func DryRunMigrations() {
+	connect()
+	MigrateDryRun(migrations)
+}
+
+func RollbackMigration() {
+	connect()
+	Rollback(migrations)
 }
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+)
+
+func publishPoolStats() {
+	expvar.Publish("pools", expvar.Func(func() interface{} {
+		return map[string]PoolStats{
+			"packages": packagePool.Stats(),
+			"versions": versionPool.Stats(),
+			"files":    filePool.Stats(),
+		}
+	}))
+}
+
+// debugAuth requires the admin token for the debug endpoints, if it is configured
+func debugAuth(handler http.HandlerFunc) http.HandlerFunc {
+	if Config.Admin.Token == "" {
+		return handler
+	}
+	return AdminOnly(handler)
+}
+
+// mountDebug adds the pprof and expvar endpoints, if they are enabled in the config
+func mountDebug(r *mux.Router) {
+	if !Config.Debug.Enabled {
+		return
+	}
+	publishPoolStats()
+	r.HandleFunc("/debug/pprof/cmdline", debugAuth(pprof.Cmdline))
+	r.HandleFunc("/debug/pprof/profile", debugAuth(pprof.Profile))
+	r.HandleFunc("/debug/pprof/symbol", debugAuth(pprof.Symbol))
+	r.HandleFunc("/debug/pprof/trace", debugAuth(pprof.Trace))
+	r.PathPrefix("/debug/pprof/").HandlerFunc(debugAuth(pprof.Index))
+	r.Handle("/debug/vars", debugAuth(expvar.Handler().ServeHTTP))
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	netmail "net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DIGEST_INTERVAL = 7 * 24 * time.Hour
+const MAX_WATCHED_PACKAGES = 50
+
+// confirmation emails are limited per visitor and per address, so the form can't be used to send mail to anyone
+const WATCH_IP_LIMIT = 5
+const WATCH_ADDRESS_LIMIT = 3
+const WATCH_WINDOW = 24 * time.Hour
+
+var watchIpLimiter = newRateLimiter(WATCH_IP_LIMIT, WATCH_WINDOW)
+var watchAddressLimiter = newRateLimiter(WATCH_ADDRESS_LIMIT, WATCH_WINDOW)
+
+var packageNameRE = regexp.MustCompile(`^(@[\w\-.]+/)?[\w\-.]+$`)
+
+// PackageSnapshot is the state of a watched package in a digest, the next digest shows the changes
+type PackageSnapshot struct {
+	Latest          string `json:"latest"`
+	Packages        int    `json:"packages"`
+	Vulnerabilities int    `json:"vulnerabilities"`
+}
+
+type DigestEntry struct {
+	Name     string
+	Analyzed bool
+	Current  PackageSnapshot
+	Previous *PackageSnapshot
+}
+
+type DigestMailData struct {
+	Entries        []DigestEntry
+	UnsubscribeUrl string
+}
+
+// VulnerabilityMatch is a new vulnerability in the resolved dependencies of a watched package
+type VulnerabilityMatch struct {
+	Package       string
+	Version       string
+	Vulnerability Vulnerability
+}
+
+type VulnerabilityAlertMailData struct {
+	Matches        []VulnerabilityMatch
+	UnsubscribeUrl string
+}
+
+type WatchConfirmMailData struct {
+	Packages   []string
+	ConfirmUrl string
+}
+
+// absoluteUrl is used for links in emails
+func absoluteUrl(path string) string {
+	return Config.Server.PublicUrl + path
+}
+
+func parsePackageNames(raw string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' }) {
+		if !packageNameRE.MatchString(name) {
+			return nil, errors.New("invalid package name: " + name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no packages")
+	}
+	if len(names) > MAX_WATCHED_PACKAGES {
+		return nil, errors.Errorf("more than %d packages", MAX_WATCHED_PACKAGES)
+	}
+	return names, nil
+}
+
+// snapshotPackage returns the state of the latest version of the package, only from the cached data
+func snapshotPackage(name string) (PackageSnapshot, bool) {
+	latest, err := DbGetStalePackageLatestVersion(name)
+	if err != nil || latest == "" {
+		return PackageSnapshot{}, false
+	}
+	snapshot := PackageSnapshot{Latest: latest}
+	version, err := DbGetVersion(name, latest)
+	if err != nil {
+		return snapshot, false
+	}
+	snapshot.Packages = version.Stats.Packages
+	snapshot.Vulnerabilities = len(version.Vulnerabilities)
+	return snapshot, true
+}
+
+func snapshotPackages(names []string) (map[string]PackageSnapshot, []DigestEntry) {
+	state := map[string]PackageSnapshot{}
+	var entries []DigestEntry
+	for _, name := range names {
+		snapshot, analyzed := snapshotPackage(name)
+		state[name] = snapshot
+		entries = append(entries, DigestEntry{Name: name, Analyzed: analyzed, Current: snapshot})
+	}
+	return state, entries
+}
+
+// sendDigest sends the changes since the last digest, and stores the current state
+func sendDigest(watch WatchRow) error {
+	var previous map[string]PackageSnapshot
+	if watch.State != "" {
+		if err := json.Unmarshal([]byte(watch.State), &previous); err != nil {
+			slog.Warn("could not parse watch state", "id", watch.Id, "err", err)
+		}
+	}
+	state, entries := snapshotPackages(strings.Fields(watch.Packages))
+	for i := range entries {
+		if snapshot, ok := previous[entries[i].Name]; ok {
+			entries[i].Previous = &snapshot
+		}
+	}
+	stateJson, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	data := DigestMailData{Entries: entries, UnsubscribeUrl: absoluteUrl("/watch/unsubscribe?token=" + watch.Token)}
+	if err := SendMail("digest", watch.Email, data); err != nil {
+		return err
+	}
+	return DbSetWatchDigest(watch.Id, string(stateJson), time.Now())
+}
+
+func sendDigests() {
+	watches, err := DbDueWatches(time.Now().Add(-DIGEST_INTERVAL))
+	if err != nil {
+		slog.Error("could not get due watches", "err", err)
+		return
+	}
+	for _, watch := range watches {
+		if err := sendDigest(watch); err != nil {
+			slog.Error("could not send digest", "id", watch.Id, "err", err)
+		}
+	}
+}
+
+func scheduleDigests() {
+	if Config.Mail.Server == "" {
+		return
+	}
+	for {
+		sendDigests()
+		time.Sleep(time.Hour)
+	}
+}
+
+func vulnerabilityUrl(vulnerability Vulnerability) string {
+	if vulnerability.Url != "" {
+		return vulnerability.Url
+	}
+	return "https://security.snyk.io/vuln/" + vulnerability.Id
+}
+
+// matchWatch returns the vulnerabilities that affect the cached latest version of the watched packages
+func matchWatch(watch WatchRow, vulnerabilities []Vulnerability, versions map[string]*Version) []VulnerabilityMatch {
+	var matches []VulnerabilityMatch
+	for _, name := range strings.Fields(watch.Packages) {
+		version, ok := versions[name]
+		if !ok {
+			if latest, err := DbGetStalePackageLatestVersion(name); err == nil && latest != "" {
+				version, _ = DbGetVersion(name, latest)
+			}
+			versions[name] = version // also remember nil, when the package is not analyzed
+		}
+		if version == nil {
+			continue
+		}
+		for _, vulnerability := range vulnerabilities {
+			if version.Affected(vulnerability) {
+				matches = append(matches, VulnerabilityMatch{Package: name, Version: version.Info.Version, Vulnerability: vulnerability})
+			}
+		}
+	}
+	return matches
+}
+
+// AlertWatchers notifies the watchers of packages whose resolved dependencies are affected by the new vulnerabilities,
+// by email when they asked for alerts, and once per vulnerability to the webhooks
+func AlertWatchers(vulnerabilities []Vulnerability) {
+	watches, err := DbConfirmedWatches()
+	if err != nil {
+		slog.Error("could not get watches", "err", err)
+		return
+	}
+	versions := map[string]*Version{}
+	affected := map[string][]string{} // vulnerability id -> watched packages
+	for _, watch := range watches {
+		matches := matchWatch(watch, vulnerabilities, versions)
+		if len(matches) == 0 {
+			continue
+		}
+		for _, match := range matches {
+			id := match.Vulnerability.Id
+			if !contains(affected[id], match.Package) {
+				affected[id] = append(affected[id], match.Package)
+			}
+		}
+		if watch.Alerts && Config.Mail.Server != "" {
+			data := VulnerabilityAlertMailData{Matches: matches, UnsubscribeUrl: absoluteUrl("/watch/unsubscribe?token=" + watch.Token)}
+			if err := SendMail("vulnerability-alert", watch.Email, data); err != nil {
+				slog.Error("could not send vulnerability alert", "id", watch.Id, "err", err)
+			}
+		}
+	}
+	for _, vulnerability := range vulnerabilities {
+		if packages, ok := affected[vulnerability.Id]; ok {
+			SendAlert(Alert{
+				Kind:  ALERT_VULNERABILITY,
+				Title: "New " + string(vulnerability.Severity) + " vulnerability in " + vulnerability.PackageName,
+				Text:  vulnerability.Title + "\naffects " + strings.Join(packages, ", "),
+				Url:   vulnerabilityUrl(vulnerability),
+			})
+		}
+	}
+}
+
+func watchHandler(writer http.ResponseWriter, request *http.Request) {
+	l := RequestLocale(request)
+	if request.Method != http.MethodPost {
+		WriteHtml(WatchView(l, "", CsrfToken(request)), writer)
+		return
+	}
+	address, err := netmail.ParseAddress(request.FormValue("email"))
+	if err != nil {
+		WriteHtmlWithStatus(WatchView(l, l.T("Invalid email address."), CsrfToken(request)), http.StatusBadRequest, writer)
+		return
+	}
+	names, err := parsePackageNames(request.FormValue("packages"))
+	if err != nil {
+		WriteHtmlWithStatus(WatchView(l, l.T("Invalid packages: %s.", err.Error()), CsrfToken(request)), http.StatusBadRequest, writer)
+		return
+	}
+
+	ip := remoteIp(request)
+	now := time.Now()
+	if !watchIpLimiter.allow(ip, now) || !watchAddressLimiter.allow(strings.ToLower(address.Address), now) {
+		slog.Warn("watch form rate limited", "ip", ip)
+		WriteHtmlWithStatus(WatchView(l, l.T("Too many confirmation emails were requested, please try again later."),
+			CsrfToken(request)), http.StatusTooManyRequests, writer)
+		return
+	}
+
+	token := secureToken()
+	alerts := request.FormValue("alerts") != ""
+	if err := DbCreateWatch(address.Address, strings.Join(names, " "), token, alerts, currentUserId(request)); err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not store watch", err)
+		return
+	}
+	data := WatchConfirmMailData{Packages: names, ConfirmUrl: absoluteUrl("/watch/confirm?token=" + token)}
+	if err := SendMail("watch-confirm", address.Address, data); err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not send confirmation email", err)
+		return
+	}
+	WriteHtml(WatchMessageView(l, l.T("Check your email"), l.T("We have sent you an email to confirm the weekly digest.")), writer)
+}
+
+func watchFromToken(writer http.ResponseWriter, request *http.Request) *WatchRow {
+	token := request.FormValue("token")
+	if token == "" {
+		httpError(writer, request, http.StatusNotFound, "unknown or expired link", errors.New("no token"))
+		return nil
+	}
+	watch, err := DbGetWatchByToken(token)
+	if err == sql.ErrNoRows {
+		httpError(writer, request, http.StatusNotFound, "unknown or expired link", err)
+		return nil
+	}
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not get watch", err)
+		return nil
+	}
+	return watch
+}
+
+func watchConfirmHandler(writer http.ResponseWriter, request *http.Request) {
+	watch := watchFromToken(writer, request)
+	if watch == nil {
+		return
+	}
+	if !watch.Confirmed {
+		// the first digest is sent after a week, with the changes since now
+		state, _ := snapshotPackages(strings.Fields(watch.Packages))
+		stateJson, _ := json.Marshal(state)
+		if err := DbSetWatchDigest(watch.Id, string(stateJson), time.Now()); err != nil {
+			httpError(writer, request, http.StatusInternalServerError, "could not confirm watch", err)
+			return
+		}
+	}
+	l := RequestLocale(request)
+	WriteHtml(WatchMessageView(l, l.T("Confirmed"), l.T("You will receive a weekly digest for %s.",
+		strings.ReplaceAll(watch.Packages, " ", ", "))), writer)
+}
+
+func watchUnsubscribeHandler(writer http.ResponseWriter, request *http.Request) {
+	watch := watchFromToken(writer, request)
+	if watch == nil {
+		return
+	}
+	l := RequestLocale(request)
+	// only delete with a post, so link checkers in mail clients can't unsubscribe
+	if request.Method != http.MethodPost {
+		WriteHtml(WatchUnsubscribeView(l, watch.Token, watch.Packages, CsrfToken(request)), writer)
+		return
+	}
+	if err := DbDeleteWatch(watch.Id); err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not unsubscribe", err)
+		return
+	}
+	WriteHtml(WatchMessageView(l, l.T("Unsubscribed"), l.T("You will not receive the weekly digest anymore.")), writer)
+}
+
+func init() {
+	RegisterMailTemplate("watch-confirm", MailTemplate{
+		Subject: "Confirm your weekly independ digest",
+		Body: func(data interface{}) Node {
+			return WatchConfirmMailView(data.(WatchConfirmMailData))
+		},
+	})
+	RegisterMailTemplate("vulnerability-alert", MailTemplate{
+		Subject: "New vulnerabilities in your packages",
+		Body: func(data interface{}) Node {
+			return VulnerabilityAlertMailView(data.(VulnerabilityAlertMailData))
+		},
+	})
+	RegisterMailTemplate("digest", MailTemplate{
+		Subject: "Your weekly independ digest",
+		Body: func(data interface{}) Node {
+			return DigestMailView(data.(DigestMailData))
+		},
+	})
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DOWNLOADS_URL = "https://api.npmjs.org/downloads/point/last-week/"
+
+// DOWNLOADS_EXPIRE is how long the weekly downloads are cached, the api updates them once a day
+const DOWNLOADS_EXPIRE = 24 * time.Hour
+
+// DOWNLOADS_BULK is the maximum number of packages in one request, the api doesn't allow scoped packages in bulk
+const DOWNLOADS_BULK = 128
+
+type downloadsPoint struct {
+	Downloads int64  `json:"downloads"`
+	Package   string `json:"package"`
+}
+
+// getDownloadsApi returns the weekly downloads of unscoped packages in bulk, or of one scoped package
+func getDownloadsApi(ctx context.Context, names []string) (map[string]int64, error) {
+	var escaped []string
+	for _, name := range names {
+		escaped = append(escaped, url.PathEscape(name))
+	}
+	response, err := getBodyConditional(ctx, DOWNLOADS_URL+strings.Join(escaped, ","), "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get downloads")
+	}
+	downloads := map[string]int64{}
+	if len(names) == 1 {
+		var point downloadsPoint
+		if err := json.Unmarshal(response.Body, &point); err != nil {
+			return nil, errors.Wrap(err, "could not parse downloads")
+		}
+		downloads[names[0]] = point.Downloads
+		return downloads, nil
+	}
+	// a bulk response has a point per package, or null for an unknown package
+	var points map[string]*downloadsPoint
+	if err := json.Unmarshal(response.Body, &points); err != nil {
+		return nil, errors.Wrap(err, "could not parse downloads")
+	}
+	for name, point := range points {
+		if point != nil {
+			downloads[name] = point.Downloads
+		}
+	}
+	return downloads, nil
+}
+
+// WeeklyDownloads returns the downloads of last week of the packages, from the db or else from the npm api. Packages
+// whose downloads could not be fetched are missing, the downloads are only informative.
+func WeeklyDownloads(ctx context.Context, names []string) map[string]int64 {
+	now := time.Now()
+	downloads, err := DbGetDownloads(names, now)
+	if err != nil {
+		slog.Error("could not get downloads from db", "err", err)
+		downloads = map[string]int64{}
+	}
+	var batches [][]string
+	var bulk []string
+	for _, name := range names {
+		if _, ok := downloads[name]; ok {
+			continue
+		}
+		if strings.HasPrefix(name, "@") {
+			batches = append(batches, []string{name})
+			continue
+		}
+		bulk = append(bulk, name)
+		if len(bulk) == DOWNLOADS_BULK {
+			batches = append(batches, bulk)
+			bulk = nil
+		}
+	}
+	if len(bulk) > 0 {
+		batches = append(batches, bulk)
+	}
+
+	for _, batch := range batches {
+		fetched, err := getDownloadsApi(ctx, batch)
+		if err != nil {
+			slog.Warn("could not get downloads", "packages", len(batch), "err", err)
+			continue
+		}
+		for name, count := range fetched {
+			downloads[name] = count
+			if err := DbPutDownloads(name, count, now.Add(DOWNLOADS_EXPIRE)); err != nil {
+				slog.Error("could not put downloads in db", "name", name, "err", err)
+			}
+		}
+	}
+	return downloads
+}
+
+// GatherDownloads adds the weekly downloads of the package and its direct dependencies. For an uploaded file the dev
+// dependencies are included, and the package itself is not, it may be private.
+func (v *Version) GatherDownloads(ctx context.Context, file bool) {
+	var names []string
+	if !file {
+		names = append(names, v.Info.Name)
+	}
+	for name := range v.Info.Dependencies {
+		names = append(names, name)
+	}
+	if file {
+		for name := range v.Info.DevDependencies {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		v.Downloads = WeeklyDownloads(ctx, names)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"log/slog"
+
+	"github.com/pkg/errors"
+)
+
+const DUMP_FORMAT = 1
+
+type VersionDumpRow struct {
+	Name       string          `json:"name"`
+	Version    string          `json:"version"`
+	Content    json.RawMessage `json:"content"`
+	CreateTime string          `json:"createTime"`
+	ExpireTime string          `json:"expireTime"`
+}
+
+type FileDumpRow struct {
+	Id         string          `json:"id"`
+	Content    json.RawMessage `json:"content"`
+	CreateTime string          `json:"createTime"`
+}
+
+// Dump is the export format of the cached analyses, it does not depend on the database backend
+type Dump struct {
+	Format   int              `json:"format"`
+	Versions []VersionDumpRow `json:"versions"`
+	Files    []FileDumpRow    `json:"files"`
+}
+
+func DbExport() (*Dump, error) {
+	dump := Dump{Format: DUMP_FORMAT}
+
+	var versionRows []struct {
+		VersionRow
+		CreateTime string `db:"create_time"`
+		ExpireTime string `db:"expire_time"`
+	}
+	if err := db.Select(&versionRows, "SELECT name, version, content, blob_key, create_time, expire_time FROM versions ORDER BY name, version"); err != nil {
+		return nil, errors.Wrap(err, "could not export versions")
+	}
+	for _, row := range versionRows {
+		content, err := getContent(row.Content, row.BlobKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not export version %s %s", row.Name, row.Version)
+		}
+		dump.Versions = append(dump.Versions, VersionDumpRow{row.Name, row.Version, json.RawMessage(content), row.CreateTime, row.ExpireTime})
+	}
+
+	var fileRows []struct {
+		FileRow
+		CreateTime string `db:"create_time"`
+	}
+	if err := db.Select(&fileRows, "SELECT id, content, create_time FROM files ORDER BY id"); err != nil {
+		return nil, errors.Wrap(err, "could not export files")
+	}
+	for _, row := range fileRows {
+		dump.Files = append(dump.Files, FileDumpRow{row.Id, json.RawMessage(row.Content), row.CreateTime})
+	}
+	return &dump, nil
+}
+
+func DbImport(dump *Dump) error {
+	if dump.Format != DUMP_FORMAT {
+		return errors.Errorf("unsupported dump format %d", dump.Format)
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, row := range dump.Versions {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO versions (name, version, content, create_time, expire_time) VALUES ($1, $2, $3, $4, $5)",
+			row.Name, row.Version, string(row.Content), row.CreateTime, row.ExpireTime); err != nil {
+			return errors.Wrapf(err, "could not import version %s %s", row.Name, row.Version)
+		}
+	}
+	for _, row := range dump.Files {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO files (id, content, create_time) VALUES ($1, $2, $3)",
+			row.Id, string(row.Content), row.CreateTime); err != nil {
+			return errors.Wrapf(err, "could not import file %s", row.Id)
+		}
+	}
+	return tx.Commit()
+}
+
+func ExportDb(path string) {
+	connect()
+	Migrate(migrations)
+	dump, err := DbExport()
+	if err != nil {
+		log.Fatalln("could not export", err)
+	}
+	bytes, err := json.Marshal(dump)
+	if err != nil {
+		log.Fatalln("could not marshal export", err)
+	}
+	if err := ioutil.WriteFile(path, bytes, 0644); err != nil {
+		log.Fatalln("could not write export", path, err)
+	}
+	slog.Info("exported", "versions", len(dump.Versions), "files", len(dump.Files), "path", path)
+}
+
+func ImportDb(path string) {
+	connect()
+	Migrate(migrations)
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalln("could not read import", path, err)
+	}
+	var dump Dump
+	if err := json.Unmarshal(bytes, &dump); err != nil {
+		log.Fatalln("could not parse import", path, err)
+	}
+	if err := DbImport(&dump); err != nil {
+		log.Fatalln("could not import", path, err)
+	}
+	slog.Info("imported", "versions", len(dump.Versions), "files", len(dump.Files), "path", path)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// EcosystemDependency is a dependency as a version declares it, before a version of it is selected. The resolver is
+// that of the dependency, a JSR package can depend on npm packages.
+type EcosystemDependency struct {
+	Resolver   EcosystemResolver
+	Name       string // as it is shown in the tree
+	Constraint string // in the syntax of the ecosystem
+}
+
+// Selection is the version that a resolver selected for a dependency
+type Selection struct {
+	Name       string // as it is shown in the tree, a registry can correct the casing
+	Version    string
+	Constraint *semver.Constraints // parsed, to find a version that was already resolved for another package
+}
+
+// EcosystemResolver hides the registry of an ecosystem from the analysis, see GatherResolved. The packages it returns
+// are only passed back to it.
+type EcosystemResolver interface {
+	// Prefix is the first part of the urls of the packages, like nuget for /nuget/<id>/<version>
+	Prefix() string
+	// NamePatterns are the mux patterns of the package names in the urls, with the vars ns and name. They are nil for
+	// npm, which has its own handlers with the versions page, badges and lookup counts.
+	NamePatterns() []string
+	// Package gets a package from the registry, through the pool of the ecosystem
+	Package(name string) *Future
+	// RequestPackage is for user requests, it returns BusyError when the pool is saturated
+	RequestPackage(ctx context.Context, name string) (Data, error)
+	// LatestVersion selects the version that is shown when no version is asked for
+	LatestVersion(data Data) (string, bool)
+	// SelectVersion selects a version in the constraint of a dependency. An empty version without an error skips the
+	// dependency, like a package for another platform.
+	SelectVersion(data Data, dependency EcosystemDependency) (Selection, error)
+	// Dependencies extracts the dependencies of a version, sorted by name
+	Dependencies(ctx context.Context, data Data, version string) ([]EcosystemDependency, error)
+	// AddDetails adds a selected version to the stats, publishers and details of the parent
+	AddDetails(parent *Version, data Data, name string, version string)
+	// VersionFuture starts the analysis of a version, unless it is already stored or in progress
+	VersionFuture(name string, version string) *Future
+}
+
+// resolvers are the registered resolvers, by prefix
+var resolvers = map[string]EcosystemResolver{}
+
+func RegisterResolver(resolver EcosystemResolver) {
+	resolvers[resolver.Prefix()] = resolver
+}
+
+// sortedResolvers returns the registered resolvers by prefix, so the routes are registered in a stable order
+func sortedResolvers() []EcosystemResolver {
+	var list []EcosystemResolver
+	for _, resolver := range resolvers {
+		list = append(list, resolver)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Prefix() < list[j].Prefix() })
+	return list
+}
+
+// GatherResolved adds the dependencies of the version with the key to the parent recursively, each with the resolver
+// of its ecosystem. Like npm, a package can be resolved at more than one version when the constraints don't overlap.
+// Every package to fetch is a step of the progress in the context. It stops early when the context is done, the caller
+// should check the context afterwards.
+func (parent *Version) GatherResolved(ctx context.Context, key string, dependencies []EcosystemDependency) {
+	if ctx.Err() != nil {
+		return
+	}
+	progress := ProgressFromContext(ctx)
+	futures := make([]*Future, len(dependencies))
+	for i, dependency := range dependencies {
+		futures[i] = dependency.Resolver.Package(dependency.Name)
+	}
+	progress.Add(len(futures))
+	for i, dependency := range dependencies {
+		result := futures[i].AwaitContext(ctx, 0)
+		if ctx.Err() != nil {
+			return
+		}
+		progress.Step()
+		if result.Error != nil {
+			parent.Errors = append(parent.Errors, "could not get "+dependency.Name+": "+result.Error.Error())
+			continue
+		}
+		resolver := dependency.Resolver
+		selection, err := resolver.SelectVersion(result.Data, dependency)
+		if err != nil {
+			parent.Errors = append(parent.Errors, "no matching version for "+dependency.Name+" constraint "+
+				dependency.Constraint+": "+err.Error())
+			continue
+		}
+		if selection.Version == "" {
+			continue
+		}
+		resolved := selection.Version
+		gather := false
+		if versions, ok := parent.Dependencies[selection.Name]; ok {
+			if matching := MatchingVersion(versions, selection.Constraint); matching != "" {
+				resolved = matching
+			} else {
+				parent.Dependencies[selection.Name] = append(versions, selection.Version)
+				gather = true
+			}
+		} else {
+			parent.Dependencies[selection.Name] = []string{selection.Version}
+			parent.Stats.Packages++
+			gather = true
+		}
+		parent.Edges[key] = append(parent.Edges[key], DependencyKey(selection.Name, resolved))
+		if !gather {
+			continue
+		}
+		resolver.AddDetails(parent, result.Data, selection.Name, selection.Version)
+		children, err := resolver.Dependencies(ctx, result.Data, selection.Version)
+		if err != nil {
+			parent.Errors = append(parent.Errors, err.Error())
+			continue
+		}
+		parent.GatherResolved(ctx, DependencyKey(selection.Name, selection.Version), children)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// EVENTS_DEADLINE limits how long an event stream stays open, the browser reconnects when it is closed
+const EVENTS_DEADLINE = 5 * time.Minute
+
+// EVENTS_INTERVAL is the interval at which the progress is sent, when it changed
+const EVENTS_INTERVAL = time.Second
+
+// EVENTS_PING is the interval of the comments that keep a quiet stream open through proxies. An open stream keeps the
+// work alive, because it waits for the future.
+const EVENTS_PING = 15 * time.Second
+
+// EVENTS_RETRY is the time in milliseconds that the browser waits before it reconnects
+const EVENTS_RETRY = 2000
+
+// streamReady sends server-sent events to the wait view: the progress of the work, a ping when there is nothing to
+// tell, and a "ready" event when the future is resolved. An error also counts as ready, the reloaded page shows it.
+func streamReady(writer http.ResponseWriter, request *http.Request, future *Future) {
+	l := RequestLocale(request)
+	controller := http.NewResponseController(writer)
+	header := writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // don't let nginx buffer the stream
+	writer.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(writer, "retry: %d\n\n", EVENTS_RETRY)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+	var lastDone, lastRemaining int64
+	lastWrite := time.Now()
+	for {
+		result := future.AwaitContext(request.Context(), EVENTS_INTERVAL)
+		if request.Context().Err() != nil {
+			return // the browser left, or the deadline passed and it will reconnect
+		}
+		if result.Error != TimeoutError {
+			fmt.Fprint(writer, "event: ready\ndata: \n\n")
+			controller.Flush()
+			return
+		}
+		done, remaining := future.Progress().Get()
+		if done != lastDone || remaining != lastRemaining {
+			lastDone, lastRemaining = done, remaining
+			fmt.Fprintf(writer, "event: progress\ndata: %s\n\n", l.T("%d packages fetched, %d to go", done, remaining))
+		} else if time.Since(lastWrite) >= EVENTS_PING {
+			fmt.Fprint(writer, ": ping\n\n")
+		} else {
+			continue
+		}
+		lastWrite = time.Now()
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func versionEventsHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	name := vars["name"]
+	if ns := vars["ns"]; ns != "" {
+		name = ns + "/" + name
+	}
+	streamReady(writer, request, VersionFuture(name, vars["version"]))
+}
+
+func resolverEventsHandler(resolver EcosystemResolver) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		streamReady(writer, request, resolver.VersionFuture(resolverName(request), mux.Vars(request)["version"]))
+	}
+}
+
+func fileEventsHandler(writer http.ResponseWriter, request *http.Request) {
+	streamReady(writer, request, FileFuture(mux.Vars(request)["id"]))
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// login with github, see https://docs.github.com/en/apps/oauth-apps/building-oauth-apps/authorizing-oauth-apps
+
+const GITHUB_STATE_COOKIE = "github_state"
+
+var GithubTakenError = errors.New("github account is linked to another user")
+
+// the email address of an account is not verified, so a github login is not linked to it by the address. Otherwise
+// whoever registered the address first could still log in with the password.
+var GithubEmailTakenError = errors.New("the email address of the github account is registered by another user")
+
+type GithubUser struct {
+	Id    int64  `json:"id"`
+	Login string `json:"login"`
+}
+
+type GithubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+func githubCallbackUrl() string {
+	return absoluteUrl("/login/github/callback")
+}
+
+func readGithubResponse(response *http.Response, u string, v interface{}) error {
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return &StatusError{Code: response.StatusCode, Status: response.Status, Url: u}
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return errors.Wrap(err, "could not read github response")
+	}
+	return errors.Wrap(json.Unmarshal(body, v), "could not parse github response")
+}
+
+// githubGet gets a path of the github api with the access token of a user, so it counts against the quota of the user
+func githubGet(ctx context.Context, token string, path string, v interface{}) error {
+	return githubApi(ctx, http.MethodGet, token, path, nil, v)
+}
+
+// githubApi sends a request to the github api, the body is sent as json and the response is parsed into v
+func githubApi(ctx context.Context, method string, token string, path string, body interface{}, v interface{}) error {
+	u := Config.Github.ApiUrl + path
+	response, err := githubDo(ctx, method, token, u, body)
+	if err != nil {
+		return err
+	}
+	return readGithubResponse(response, u, v)
+}
+
+// githubDo sends a request to a url of the github api, for when the headers of the response are needed
+func githubDo(ctx context.Context, method string, token string, u string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(content)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not "+strings.ToLower(method)+" "+u)
+	}
+	return response, nil
+}
+
+func githubAccessToken(ctx context.Context, code string) (string, error) {
+	u := Config.Github.Url + "/login/oauth/access_token"
+	form := url.Values{
+		"client_id":     {Config.Github.ClientId},
+		"client_secret": {Config.Github.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {githubCallbackUrl()},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get github access token")
+	}
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := readGithubResponse(response, u, &result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", errors.Errorf("github did not give an access token: %s %s", result.Error, result.ErrorDescription)
+	}
+	return result.AccessToken, nil
+}
+
+// githubPrimaryEmail returns the verified primary email address, or an empty string
+func githubPrimaryEmail(ctx context.Context, token string) string {
+	var emails []GithubEmail
+	if err := githubGet(ctx, token, "/user/emails", &emails); err != nil {
+		slog.Warn("could not get github emails", "err", err)
+		return ""
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			return email.Email
+		}
+	}
+	return ""
+}
+
+// githubAccountUser returns the user to link the github account to: the user that already linked it, the logged in
+// user or else a new user. An existing user connects github while logged in.
+func githubAccountUser(request *http.Request, githubUser GithubUser, token string) (int, error) {
+	current := CurrentUser(request)
+	user, err := DbGetUserByGithubId(githubUser.Id)
+	if err == nil {
+		if current != nil && current.Id != user.Id {
+			return 0, GithubTakenError
+		}
+		return user.Id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+	if current != nil {
+		return current.Id, nil
+	}
+	email := githubPrimaryEmail(request.Context(), token)
+	if email != "" {
+		_, err := DbGetUserByEmail(email)
+		if err == nil {
+			return 0, GithubEmailTakenError
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	} else {
+		email = strconv.FormatInt(githubUser.Id, 10) + "+" + githubUser.Login + "@users.noreply.github.com"
+	}
+	// without a password hash, the user can only login with github
+	return DbCreateUser(email, "")
+}
+
+// githubTokenCipher is aes-gcm with a key derived from the client secret. When the secret changes, the stored tokens
+// can't be opened anymore, and the users log in with github again.
+func githubTokenCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("github token\x00" + Config.Github.ClientSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealGithubToken encrypts the access token of a user for the db
+func sealGithubToken(token string) (string, error) {
+	aead, err := githubTokenCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(token), nil)), nil
+}
+
+// openGithubToken decrypts the access token of a user, it returns "" when there is none or it can't be opened
+func openGithubToken(sealed string) string {
+	aead, err := githubTokenCipher()
+	if sealed == "" || err != nil {
+		return ""
+	}
+	bytes, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(bytes) < aead.NonceSize() {
+		return ""
+	}
+	token, err := aead.Open(nil, bytes[:aead.NonceSize()], bytes[aead.NonceSize():], nil)
+	if err != nil {
+		slog.Warn("could not open github token", "err", err)
+		return ""
+	}
+	return string(token)
+}
+
+func githubLoginHandler(writer http.ResponseWriter, request *http.Request) {
+	state := secureToken()
+	http.SetCookie(writer, &http.Cookie{
+		Name:     GITHUB_STATE_COOKIE,
+		Value:    state,
+		Path:     Href("/login/github"),
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   isHttps(request),
+		SameSite: http.SameSiteLaxMode,
+	})
+	query := url.Values{
+		"client_id":    {Config.Github.ClientId},
+		"redirect_uri": {githubCallbackUrl()},
+		"scope":        {"user:email"},
+		"state":        {state},
+	}
+	http.Redirect(writer, request, Config.Github.Url+"/login/oauth/authorize?"+query.Encode(), http.StatusSeeOther)
+}
+
+func githubCallbackHandler(writer http.ResponseWriter, request *http.Request) {
+	cookie, err := request.Cookie(GITHUB_STATE_COOKIE)
+	if err != nil || cookie.Value == "" || cookie.Value != request.FormValue("state") {
+		httpError(writer, request, http.StatusBadRequest, "invalid github login, please try again", errors.New("state mismatch"))
+		return
+	}
+	http.SetCookie(writer, &http.Cookie{Name: GITHUB_STATE_COOKIE, Path: Href("/login/github"), MaxAge: -1})
+	if request.FormValue("error") != "" {
+		l := RequestLocale(request)
+		WriteHtmlWithStatus(LoginView(l, l.T("GitHub login was cancelled."), CsrfToken(request)), http.StatusUnauthorized, writer)
+		return
+	}
+
+	token, err := githubAccessToken(request.Context(), request.FormValue("code"))
+	if err != nil {
+		httpError(writer, request, http.StatusBadGateway, "could not login with github", err)
+		return
+	}
+	var githubUser GithubUser
+	if err := githubGet(request.Context(), token, "/user", &githubUser); err != nil {
+		httpError(writer, request, http.StatusBadGateway, "could not get github user", err)
+		return
+	}
+	userId, err := githubAccountUser(request, githubUser, token)
+	if err == GithubTakenError {
+		httpError(writer, request, http.StatusConflict, "this github account is linked to another account", err)
+		return
+	}
+	if err == GithubEmailTakenError {
+		httpError(writer, request, http.StatusConflict,
+			"this email address already has an account, log in and connect github on the account page", err)
+		return
+	}
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not get account", err)
+		return
+	}
+	sealed, err := sealGithubToken(token)
+	if err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not encrypt github token", err)
+		return
+	}
+	if err := DbSetUserGithub(userId, githubUser.Id, githubUser.Login, sealed); err != nil {
+		httpError(writer, request, http.StatusInternalServerError, "could not link github account", err)
+		return
+	}
+	if CurrentUser(request) == nil {
+		if err := startSession(writer, request, userId); err != nil {
+			httpError(writer, request, http.StatusInternalServerError, "could not start session", err)
+			return
+		}
+	}
+	http.Redirect(writer, request, Href("/account"), http.StatusSeeOther)
+}
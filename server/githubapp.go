@@ -0,0 +1,492 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// a github app checks the package.json, package-lock.json and packages.lock.json files that a pull request changes, see
+// https://docs.github.com/en/apps/creating-github-apps/writing-code-for-a-github-app/building-ci-checks-with-a-github-app
+
+// GITHUB_WEBHOOK_MAX_SIZE is the largest webhook payload that github sends
+const GITHUB_WEBHOOK_MAX_SIZE = 25 * 1000 * 1000
+
+// GITHUB_CHECK_MAX_FILES is the most changed files of a pull request that are analyzed, for monorepos
+const GITHUB_CHECK_MAX_FILES = 10
+
+// GITHUB_CHECK_FILE_DEADLINE is the time to analyze the head and base of one changed file, the files are analyzed at the
+// same time
+const GITHUB_CHECK_FILE_DEADLINE = 5 * time.Minute
+
+// GITHUB_CHECK_DEADLINE leaves time for the api requests around the analyses
+const GITHUB_CHECK_DEADLINE = GITHUB_CHECK_FILE_DEADLINE + 2*time.Minute
+
+// GITHUB_API_TIMEOUT is the time to complete the check run, also when the analyses took all of their time
+const GITHUB_API_TIMEOUT = 30 * time.Second
+
+// GITHUB_CHECK_FILES are the changed files that are checked. A package.json is analyzed with the package-lock.json
+// next to it, a change of either checks them together.
+var GITHUB_CHECK_FILES = []string{"package.json", "package-lock.json", "packages.lock.json"}
+
+// GITHUB_CHECK_ACTIONS are the actions of a pull request that change its head
+var GITHUB_CHECK_ACTIONS = []string{"opened", "synchronize", "reopened"}
+
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		HtmlUrl string `json:"html_url"`
+		Head    struct {
+			Sha string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Sha string `json:"sha"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		Private  bool   `json:"private"`
+	} `json:"repository"`
+	Installation struct {
+		Id int64 `json:"id"`
+	} `json:"installation"`
+}
+
+type githubPullRequestFile struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"` // added, removed, modified, renamed, ...
+}
+
+type githubCheckRunOutput struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+// ReadGithubAppKey reads the private key of the app, github generates it in pkcs1, a converted one is in pkcs8
+func ReadGithubAppKey(path string) (*rsa.PrivateKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no pem block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an rsa key")
+	}
+	return key, nil
+}
+
+// githubAppJwt returns the jwt that the app authenticates with, the clock of github may be a bit behind
+func githubAppJwt(now time.Time) (string, error) {
+	encode := func(v interface{}) string {
+		content, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(content)
+	}
+	unsigned := encode(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encode(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(Config.GithubApp.AppId, 10),
+	})
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, Config.GithubAppKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// githubInstallationToken returns a token for the repositories of an installation of the app, it is valid for an hour
+func githubInstallationToken(ctx context.Context, installationId int64) (string, error) {
+	jwt, err := githubAppJwt(time.Now())
+	if err != nil {
+		return "", errors.Wrap(err, "could not sign github app jwt")
+	}
+	var result struct {
+		Token string `json:"token"`
+	}
+	path := "/app/installations/" + strconv.FormatInt(installationId, 10) + "/access_tokens"
+	if err := githubApi(ctx, http.MethodPost, jwt, path, map[string]string{}, &result); err != nil {
+		return "", err
+	}
+	return result.Token, nil
+}
+
+// githubFileAt gets a file of a repository at a commit, or nil when it does not exist there
+func githubFileAt(ctx context.Context, token string, repository string, name string, ref string) ([]byte, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github.raw")
+	header.Set("Authorization", "Bearer "+token)
+	escaped := strings.Split(name, "/")
+	for i, part := range escaped {
+		escaped[i] = url.PathEscape(part)
+	}
+	content, err := repositoryGet(ctx, Config.Github.ApiUrl+"/repos/"+repository+"/contents/"+
+		strings.Join(escaped, "/")+"?ref="+url.QueryEscape(ref), header)
+	var statusError *StatusError
+	if errors.As(err, &statusError) && statusError.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	return content, err
+}
+
+// analyzeContent analyzes a file like the ci check, and returns the url of its analysis. A package.json can come with
+// its package-lock.json, else lock is nil. A file of a private repository is not stored, anyone with its id could see
+// it, so it has no url.
+func analyzeContent(ctx context.Context, content []byte, lock []byte, private bool) (*Version, string, error) {
+	var version *Version
+	var err error
+	if lock != nil {
+		version, err = parseLockedFile(content, lock)
+	} else {
+		version, err = parseFile(content)
+	}
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not parse file")
+	}
+	var analyzed *Version
+	report := ""
+	if private {
+		analyzed, err = AnalyzeFile(ctx, version)
+	} else {
+		// the check run is public to the readers of the repository, so the url has no token to delete the file
+		var id string
+		id, _, err = createFile(version, append(content, lock...), 0)
+		if err != nil {
+			return nil, "", err
+		}
+		report = absoluteUrl(fileHref(id, ""))
+		analyzed, err = GetFile(ctx, id, 0)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, report, errors.New("the analysis is not finished yet")
+	}
+	return analyzed, report, err
+}
+
+// analyzeFileAt analyzes a file of a repository at a commit, the version is nil when the file does not exist there. A
+// package.json is analyzed with the package-lock.json next to it, or the lockfile alone when there is no package.json.
+func analyzeFileAt(ctx context.Context, token string, repository string, private bool, name string, ref string) (
+	*Version, string, error) {
+	content, err := githubFileAt(ctx, token, repository, name, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	var lock []byte
+	if path.Base(name) == "package.json" {
+		lock, err = githubFileAt(ctx, token, repository, path.Join(path.Dir(name), "package-lock.json"), ref)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if content == nil {
+		content, lock = lock, nil
+	}
+	if content == nil {
+		return nil, "", nil
+	}
+	return analyzeContent(ctx, content, lock, private)
+}
+
+// githubNextPage returns the url of the next page in a Link header of the github api, or ""
+func githubNextPage(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		segments := strings.Split(part, ";")
+		for _, segment := range segments[1:] {
+			if strings.TrimSpace(segment) == `rel="next"` {
+				next := strings.Trim(strings.TrimSpace(segments[0]), "<>")
+				// the token is sent along, so only to the api
+				if strings.HasPrefix(next, Config.Github.ApiUrl+"/") {
+					return next
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// githubPullRequestFiles lists the files of a pull request, all pages of them
+func githubPullRequestFiles(ctx context.Context, token string, repository string, number int) (
+	[]githubPullRequestFile, error) {
+	var files []githubPullRequestFile
+	u := Config.Github.ApiUrl + "/repos/" + repository + "/pulls/" + strconv.Itoa(number) + "/files?per_page=100"
+	for u != "" {
+		response, err := githubDo(ctx, http.MethodGet, token, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		next := githubNextPage(response.Header.Get("Link"))
+		var page []githubPullRequestFile
+		if err := readGithubResponse(response, u, &page); err != nil {
+			return nil, err
+		}
+		files = append(files, page...)
+		u = next
+	}
+	return files, nil
+}
+
+func signedDelta(head int, base int) string {
+	if head == base {
+		return ""
+	}
+	return fmt.Sprintf("%+d", head-base)
+}
+
+func vulnerabilityCount(vs VulnerabilityStats) int {
+	return vs.LowCount + vs.MediumCount + vs.HighCount + vs.CriticalCount
+}
+
+// dependencyChanges returns the names of the packages that head has and base not, and the other way around
+func dependencyChanges(base *Version, head *Version) ([]string, []string) {
+	var added, removed []string
+	for name := range head.Dependencies {
+		if _, ok := base.Dependencies[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for name := range base.Dependencies {
+		if _, ok := head.Dependencies[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
+
+// fileCheckSummary is the markdown of the changes of one file in a pull request. A file that is new in the pull request
+// is compared with an empty tree.
+func fileCheckSummary(name string, base *Version, head *Version, verdict Verdict, report string) string {
+	if base == nil {
+		base = &Version{Dependencies: map[string][]string{}}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "### %s\n\n", name)
+	fmt.Fprintf(&b, "| | base | head | change |\n| --- | ---: | ---: | ---: |\n")
+	row := func(label string, base int, head int) {
+		fmt.Fprintf(&b, "| %s | %d | %d | %s |\n", label, base, head, signedDelta(head, base))
+	}
+	baseVs, headVs := base.Stats.VulnerabilityStats, head.Stats.VulnerabilityStats
+	row("packages", base.Stats.Packages, head.Stats.Packages)
+	row("versions", base.Stats.Versions, head.Stats.Versions)
+	row("vulnerabilities", vulnerabilityCount(baseVs), vulnerabilityCount(headVs))
+	row("critical and high", baseVs.CriticalCount+baseVs.HighCount, headVs.CriticalCount+headVs.HighCount)
+	fmt.Fprintf(&b, "| disk space (MB) | %.2f | %.2f | |\n\n", float64(base.Stats.DiskSpace)/1e6,
+		float64(head.Stats.DiskSpace)/1e6)
+	added, removed := dependencyChanges(base, head)
+	if len(added) > 0 {
+		fmt.Fprintf(&b, "Added: %s\n\n", namesList(added))
+	}
+	if len(removed) > 0 {
+		fmt.Fprintf(&b, "Removed: %s\n\n", namesList(removed))
+	}
+	for _, violation := range verdict.Violations {
+		fmt.Fprintf(&b, "- :x: %s\n", violation.Message)
+	}
+	if len(verdict.Violations) > 0 {
+		b.WriteString("\n")
+	}
+	if report != "" {
+		fmt.Fprintf(&b, "[Full analysis](%s)\n\n", report)
+	}
+	return b.String()
+}
+
+// checkFile analyzes the head and base of a changed file of a pull request in its own time, and returns the markdown of
+// its changes, its number of policy violations and whether the head could be analyzed
+func checkFile(ctx context.Context, token string, event githubPullRequestEvent, file githubPullRequestFile) (string,
+	int, bool) {
+	if file.Status == "removed" {
+		return fmt.Sprintf("### %s\n\nRemoved.\n\n", file.Filename), 0, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, GITHUB_CHECK_FILE_DEADLINE)
+	defer cancel()
+	repository, private := event.Repository.FullName, event.Repository.Private
+
+	var base *Version
+	var baseErr error
+	baseDone := make(chan struct{})
+	go func() {
+		defer close(baseDone)
+		base, _, baseErr = analyzeFileAt(ctx, token, repository, private, file.Filename, event.PullRequest.Base.Sha)
+	}()
+	head, report, err := analyzeFileAt(ctx, token, repository, private, file.Filename, event.PullRequest.Head.Sha)
+	<-baseDone
+	if err == nil && head == nil {
+		err = errors.New("not found in the head of the pull request")
+	}
+	if err != nil {
+		return fmt.Sprintf("### %s\n\nCould not analyze: %s\n\n", file.Filename, err), 0, false
+	}
+	verdict := head.CheckPolicy(Config.Policy, time.Now())
+	sendPolicyAlert(fmt.Sprintf("%s in %s#%d", file.Filename, repository, event.Number), verdict,
+		event.PullRequest.HtmlUrl)
+	summary := fileCheckSummary(file.Filename, base, head, verdict, report)
+	if baseErr != nil {
+		// the changes are then against an empty tree
+		summary += fmt.Sprintf("Could not analyze the base: %s\n\n", baseErr)
+	}
+	return summary, len(verdict.Violations), true
+}
+
+// checkPullRequest posts a check run on the head of a pull request with the changes of its package files, it fails
+// when the head of a file breaks the policy. A pull request without changed package files gets no check run.
+func checkPullRequest(ctx context.Context, event githubPullRequestEvent) error {
+	repository := event.Repository.FullName
+	token, err := githubInstallationToken(ctx, event.Installation.Id)
+	if err != nil {
+		return errors.Wrap(err, "could not get installation token")
+	}
+	files, err := githubPullRequestFiles(ctx, token, repository, event.Number)
+	if err != nil {
+		return errors.Wrap(err, "could not get files of pull request")
+	}
+	var changed []githubPullRequestFile
+	indexes := map[string]int{}
+	for _, file := range files {
+		name := path.Base(file.Filename)
+		if !contains(GITHUB_CHECK_FILES, name) {
+			continue
+		}
+		changedFile := file
+		if name == "package-lock.json" {
+			// the lockfile is checked with its package.json, which may not have changed itself
+			changedFile = githubPullRequestFile{Filename: path.Join(path.Dir(file.Filename), "package.json"),
+				Status: "modified"}
+		}
+		if i, ok := indexes[changedFile.Filename]; ok {
+			if name == "package.json" {
+				changed[i] = changedFile
+			}
+			continue
+		}
+		if len(changed) < GITHUB_CHECK_MAX_FILES {
+			indexes[changedFile.Filename] = len(changed)
+			changed = append(changed, changedFile)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	var checkRun struct {
+		Id int64 `json:"id"`
+	}
+	if err := githubApi(ctx, http.MethodPost, token, "/repos/"+repository+"/check-runs", map[string]interface{}{
+		"name":     Config.Theme.SiteName,
+		"head_sha": event.PullRequest.Head.Sha,
+		"status":   "in_progress",
+	}, &checkRun); err != nil {
+		return errors.Wrap(err, "could not create check run")
+	}
+
+	summaries := make([]string, len(changed))
+	violations := make([]int, len(changed))
+	analyzed := make([]bool, len(changed))
+	var wg sync.WaitGroup
+	for i, file := range changed {
+		wg.Add(1)
+		go func(i int, file githubPullRequestFile) {
+			defer wg.Done()
+			summaries[i], violations[i], analyzed[i] = checkFile(ctx, token, event, file)
+		}(i, file)
+	}
+	wg.Wait()
+
+	totalViolations, totalAnalyzed := 0, 0
+	for i := range changed {
+		totalViolations += violations[i]
+		if analyzed[i] {
+			totalAnalyzed++
+		}
+	}
+	conclusion, title := "success", "No policy violations"
+	if totalViolations > 0 {
+		conclusion, title = "failure", fmt.Sprintf("Policy violations: %d", totalViolations)
+	} else if totalAnalyzed == 0 {
+		conclusion, title = "neutral", "Nothing to analyze"
+	}
+	// the context may have run out during the analyses, the check run must not stay in progress
+	completeCtx, cancel := context.WithTimeout(context.Background(), GITHUB_API_TIMEOUT)
+	defer cancel()
+	return githubApi(completeCtx, http.MethodPatch, token, "/repos/"+repository+"/check-runs/"+
+		strconv.FormatInt(checkRun.Id, 10), map[string]interface{}{
+		"status":     "completed",
+		"conclusion": conclusion,
+		"output":     githubCheckRunOutput{Title: title, Summary: strings.Join(summaries, "")},
+	}, &checkRun)
+}
+
+// validGithubSignature checks the signature of a webhook, which github makes with the secret of the app
+func validGithubSignature(body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(Config.GithubApp.WebhookSecret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// githubWebhookHandler receives the events of the app. Github waits only 10 seconds for an answer, so pull requests
+// are checked in the background.
+func githubWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, GITHUB_WEBHOOK_MAX_SIZE))
+	if err != nil {
+		jsonError(writer, http.StatusBadRequest, "could not read webhook", err)
+		return
+	}
+	if !validGithubSignature(body, request.Header.Get("X-Hub-Signature-256")) {
+		jsonError(writer, http.StatusUnauthorized, "invalid signature", nil)
+		return
+	}
+	if request.Header.Get("X-GitHub-Event") != "pull_request" {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var event githubPullRequestEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		jsonError(writer, http.StatusBadRequest, "could not parse webhook", err)
+		return
+	}
+	if !contains(GITHUB_CHECK_ACTIONS, event.Action) {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), GITHUB_CHECK_DEADLINE)
+		defer cancel()
+		if err := checkPullRequest(ctx, event); err != nil {
+			slog.Error("could not check pull request", "repository", event.Repository.FullName,
+				"number", event.Number, "err", err)
+		}
+	}()
+	writer.WriteHeader(http.StatusAccepted)
+}
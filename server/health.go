@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// HealthFactor is a part of the health score, with the points it costs
+type HealthFactor struct {
+	Name    string // vulnerabilities, freshness, duplication, deprecated or bus factor
+	Penalty int
+}
+
+// Health is the combined health of a version and its dependencies, 100 points is perfectly healthy
+type Health struct {
+	Score   int
+	Grade   string
+	Factors []HealthFactor
+}
+
+// HEALTH_GRADES are the lowest scores of the grades, the last grade is for everything lower
+var HEALTH_GRADES = []struct {
+	Grade string
+	Min   int
+}{{"A", 90}, {"B", 80}, {"C", 70}, {"D", 60}, {"E", 50}, {"F", 0}}
+
+func penalty(points float64, max int) int {
+	if points > float64(max) {
+		return max
+	}
+	return int(points + 0.5)
+}
+
+// share is the part of the packages with a property, 0 without packages
+func share(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total)
+}
+
+// Health scores a version on the vulnerabilities, the stale, duplicated and deprecated dependencies and the packages
+// with a single maintainer, who can abandon it or lose their account. The weights are chosen so that a critical
+// vulnerability alone drops the grade to C.
+func (v *Version) Health(staleYears int, now time.Time) Health {
+	vs := v.Stats.VulnerabilityStats
+	packages := map[string]bool{}
+	deprecated := 0
+	singleMaintainer := map[string]bool{}
+	for key, details := range v.Details {
+		name, _ := SplitDependencyKey(key)
+		packages[name] = true
+		if details.Deprecated {
+			deprecated++
+		}
+		if details.Maintainers == 1 {
+			singleMaintainer[name] = true
+		}
+	}
+	factors := []HealthFactor{
+		{"vulnerabilities", penalty(float64(25*vs.CriticalCount+10*vs.HighCount+3*vs.MediumCount+vs.LowCount), 40)},
+		{"freshness", penalty(20*share(len(v.StaleDependencies(staleYears, now)), len(packages)), 20)},
+		{"duplication", penalty(float64(3*len(v.MajorConflicts())), 15)},
+		{"deprecated", penalty(float64(5*deprecated), 15)},
+		{"bus factor", penalty(10*share(len(singleMaintainer), len(packages)), 10)},
+	}
+	health := Health{Score: 100, Factors: factors}
+	for _, factor := range factors {
+		health.Score -= factor.Penalty
+	}
+	for _, grade := range HEALTH_GRADES {
+		if health.Score >= grade.Min {
+			health.Grade = grade.Grade
+			break
+		}
+	}
+	return health
+}
+
+// HEALTH_COLORS are the colors of the grades on the badge, from green to red
+var HEALTH_COLORS = map[string]string{"A": "#393", "B": "#7a3", "C": "#bb2", "D": "#e90", "E": "#d40", "F": "#a00"}
+
+const BADGE_LABEL_WIDTH = 50
+const BADGE_VALUE_WIDTH = 30
+
+// HealthBadge renders the grade as an svg badge for a readme, like the badges of shields.io. The grade is "?" while
+// the analysis is not finished.
+func HealthBadge(grade string) Node {
+	color, ok := HEALTH_COLORS[grade]
+	if !ok {
+		color = "#888"
+	}
+	width := BADGE_LABEL_WIDTH + BADGE_VALUE_WIDTH
+	text := func(x int, s string) Node {
+		return H("text", s).Attr("x", fmt.Sprint(x)).Attr("y", "14")
+	}
+	return H("svg", H("title", "health: "+grade),
+		H("rect").Attr("width", fmt.Sprint(BADGE_LABEL_WIDTH)).Attr("height", "20").Attr("fill", "#555"),
+		H("rect").Attr("x", fmt.Sprint(BADGE_LABEL_WIDTH)).Attr("width", fmt.Sprint(BADGE_VALUE_WIDTH)).
+			Attr("height", "20").Attr("fill", color),
+		H("g",
+			text(BADGE_LABEL_WIDTH/2, "health"),
+			text(BADGE_LABEL_WIDTH+BADGE_VALUE_WIDTH/2, grade),
+		).Attr("fill", "#fff").Attr("text-anchor", "middle").Attr("font-family", "Verdana,sans-serif").
+			Attr("font-size", "11"),
+	).Attr("xmlns", "http://www.w3.org/2000/svg").Attr("width", fmt.Sprint(width)).Attr("height", "20")
+}
@@ -1,13 +1,20 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	gohtml "html"
 	"log"
+	"log/slog"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
 )
 
 const space = "                                                                                                    "
@@ -19,9 +26,18 @@ type Node interface {
 	WriteTextTo(b *strings.Builder)
 }
 
+// COMPACT is the indent for rendering without pretty printing, the children of a block are separated by a space
+// instead of a newline and indentation, which renders the same
+const COMPACT = -1
+
+// RenderNode renders compact html, unless pretty html is enabled in the debug config
 func RenderNode(node Node) string {
 	var b strings.Builder
-	node.WriteTo(&b, 0)
+	indent := COMPACT
+	if Config.Debug.PrettyHtml {
+		indent = 0
+	}
+	node.WriteTo(&b, indent)
 	return b.String()
 }
 
@@ -37,6 +53,37 @@ func WriteHtmlWithStatus(node Node, status int, writer http.ResponseWriter) {
 	_, _ = writer.Write([]byte(RenderNode(node)))
 }
 
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteHtmlCached writes the node with an etag of its content and the given cache control. It only writes a 304 Not
+// Modified, if the client already has the same content.
+func WriteHtmlCached(node Node, cacheControl string, writer http.ResponseWriter, request *http.Request) {
+	content := []byte(RenderNode(node))
+	sum := sha256.Sum256(content)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	header := writer.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", cacheControl)
+	// the content is in the language of the request, see RequestLocale
+	header.Set("Vary", "Accept-Language")
+	if etagMatches(request.Header.Get("If-None-Match"), etag) {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", "text/html")
+	writer.WriteHeader(200)
+	_, _ = writer.Write(content)
+}
+
 var multiLine = regexp.MustCompile(`\n{3,}`)
 
 func RenderText(node Node) string {
@@ -46,10 +93,11 @@ func RenderText(node Node) string {
 	return multiLine.ReplaceAllString(s, "\n\n")
 }
 
-type elementType int
+// ElementType is how an element is rendered
+type ElementType int
 
 const (
-	Standalone elementType = iota
+	Standalone ElementType = iota
 	Block
 	Inline
 )
@@ -65,30 +113,161 @@ func Attr(key, value string) ElementAttr {
 
 type Element struct {
 	name     string
-	typ      elementType
+	typ      ElementType
 	block    bool
 	attrs    []ElementAttr
 	children []Node
 }
 
-func newElement(name string) *Element {
-	typ, ok := tagToType[name]
-	if !ok {
-		log.Panicln("unknown tag: " + name)
+// child adds the children, the nodes of a fragment are added as separate children
+func (t *Element) child(children ...Node) *Element {
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		if t.typ == Standalone {
+			builderError(errors.Errorf("<%s> can't have children", t.name))
+			continue
+		}
+		if f, ok := child.(fragment); ok {
+			t.child(f...)
+		} else {
+			t.children = append(t.children, child)
+		}
 	}
-	return &Element{name: name, typ: typ}
+	return t
 }
 
-func (t *Element) child(children ...Node) *Element {
-	t.children = append(t.children, children...)
-	return t
+// urlAttrs are the attributes with a url, the values are checked with SafeUrl
+var urlAttrs = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"poster":     true,
+	"cite":       true,
 }
 
+var safeSchemes = []string{"http", "https", "mailto"}
+
+// SafeUrl returns the url without the whitespace and control characters that browsers ignore, and false for a url with
+// a scheme other than http, https or mailto, like javascript: or data:. Urls without a scheme are relative and safe.
+func SafeUrl(raw string) (string, bool) {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, raw)
+	cleaned = strings.TrimFunc(cleaned, func(r rune) bool { return r <= ' ' })
+	end := strings.IndexAny(cleaned, "/?#")
+	if end < 0 {
+		end = len(cleaned)
+	}
+	if colon := strings.IndexByte(cleaned[:end], ':'); colon >= 0 {
+		if !contains(safeSchemes, strings.ToLower(cleaned[:colon])) {
+			return "", false
+		}
+	}
+	return cleaned, true
+}
+
+// Attr adds the attribute. A url attribute with an unsafe url is skipped, see SafeUrl, because the urls often come
+// from registry metadata.
 func (t *Element) Attr(key, value string) *Element {
+	if urlAttrs[key] {
+		safe, ok := SafeUrl(value)
+		if !ok {
+			slog.Warn("skipped unsafe url", "attr", key, "url", value)
+			return t
+		}
+		value = safe
+	}
 	t.attrs = append(t.attrs, ElementAttr{key, value})
 	return t
 }
 
+// Href sets the link of an a element, it is skipped when the url is unsafe
+func (t *Element) Href(url string) *Element {
+	return t.Attr("href", url)
+}
+
+// Src sets the source of an img, script or iframe element, it is skipped when the url is unsafe
+func (t *Element) Src(url string) *Element {
+	return t.Attr("src", url)
+}
+
+// BoolAttr adds a boolean attribute like checked or disabled, only when it is on
+func (t *Element) BoolAttr(key string, on bool) *Element {
+	if on {
+		t.Attr(key, key)
+	}
+	return t
+}
+
+func (t *Element) IntAttr(key string, value int) *Element {
+	return t.Attr(key, strconv.Itoa(value))
+}
+
+// Class adds the classes to the class attribute, empty classes are skipped, so a class can be added conditionally
+func (t *Element) Class(classes ...string) *Element {
+	var names []string
+	for _, class := range classes {
+		if class != "" {
+			names = append(names, class)
+		}
+	}
+	if len(names) == 0 {
+		return t
+	}
+	for i, attr := range t.attrs {
+		if attr.key == "class" {
+			t.attrs[i].value += " " + strings.Join(names, " ")
+			return t
+		}
+	}
+	return t.Attr("class", strings.Join(names, " "))
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Style sets the style attribute to the properties, sorted by name so the html is stable
+func (t *Element) Style(properties map[string]string) *Element {
+	var declarations []string
+	for _, name := range sortedKeys(properties) {
+		declarations = append(declarations, name+": "+properties[name])
+	}
+	return t.Attr("style", strings.Join(declarations, "; "))
+}
+
+func (t *Element) prefixedAttrs(prefix string, values map[string]string) *Element {
+	for _, key := range sortedKeys(values) {
+		if !validName(key) {
+			builderError(errors.Errorf("invalid attribute name: %s%s", prefix, key))
+			continue
+		}
+		t.Attr(prefix+key, values[key])
+	}
+	return t
+}
+
+// Data adds data-* attributes, the keys are without the data- prefix
+func (t *Element) Data(values map[string]string) *Element {
+	return t.prefixedAttrs("data-", values)
+}
+
+// Aria adds aria-* attributes, the keys are without the aria- prefix
+func (t *Element) Aria(values map[string]string) *Element {
+	return t.prefixedAttrs("aria-", values)
+}
+
 func (t *Element) Add(params ...interface{}) *Element {
 	for _, param := range params {
 		if param == nil {
@@ -108,7 +287,7 @@ func (t *Element) Add(params ...interface{}) *Element {
 				t.Attr(attr.key, attr.value)
 			}
 		} else {
-			log.Panicln("cannot handle param", param)
+			builderError(errors.Errorf("cannot handle param %#v", param))
 		}
 	}
 	return t
@@ -134,7 +313,14 @@ func (t *Element) WriteTo(b *strings.Builder, indent int) {
 		b.WriteString(" />")
 	} else if t.typ == Block {
 		b.WriteRune('>')
-		if len(t.children) > 0 {
+		if indent == COMPACT {
+			for i, child := range t.children {
+				if i > 0 {
+					b.WriteRune(' ')
+				}
+				child.WriteTo(b, COMPACT)
+			}
+		} else if len(t.children) > 0 {
 			for _, child := range t.children {
 				if child != nil {
 					b.WriteRune('\n')
@@ -183,6 +369,68 @@ func (t *Element) WriteTextTo(b *strings.Builder) {
 				}
 			}
 		}
+		if t.name == "a" {
+			// keep the link in plain text
+			if href := t.getAttr("href"); href != "" {
+				b.WriteString(" (" + href + ")")
+			}
+		}
+	}
+}
+
+func (t *Element) getAttr(key string) string {
+	for _, attr := range t.attrs {
+		if attr.key == key {
+			return attr.value
+		}
+	}
+	return ""
+}
+
+// If returns the node when the condition is true, else nil, which is skipped by Add. The node is always built, so it
+// must not depend on the condition to be safe to build.
+func If(condition bool, node Node) Node {
+	if condition {
+		return node
+	}
+	return nil
+}
+
+// ForEach returns a node for every item, the list can be added to an element
+func ForEach[T any](items []T, fn func(T) Node) []Node {
+	nodes := make([]Node, 0, len(items))
+	for _, item := range items {
+		nodes = append(nodes, fn(item))
+	}
+	return nodes
+}
+
+// fragment is a list of nodes without a wrapping element, when it is added to an element its nodes become children
+type fragment []Node
+
+// Fragment groups the params of Add, so a helper can return several nodes
+func Fragment(params ...interface{}) Node {
+	el := &Element{typ: Block}
+	el.Add(params...)
+	if len(el.attrs) > 0 {
+		builderError(errors.New("attributes in a fragment are ignored"))
+	}
+	return fragment(el.children)
+}
+
+func (f fragment) WriteTo(b *strings.Builder, indent int) {
+	for _, node := range f {
+		if node != nil {
+			node.WriteTo(b, indent)
+		}
+	}
+}
+
+func (f fragment) WriteTextTo(b *strings.Builder) {
+	for _, node := range f {
+		if node != nil {
+			node.WriteTextTo(b)
+		}
 	}
 }
 
@@ -206,46 +454,165 @@ func (t UnsafeRawContent) WriteTextTo(b *strings.Builder) {
 	// not supported in plain text, just ignore
 }
 
-var tagToType = map[string]elementType{
-	"div":      Block,
-	"span":     Inline,
+// tagToType has the html5 and svg elements. Standalone are the void elements, they can't have children. Inline
+// elements are rendered on one line, because whitespace matters in them.
+var tagToType = map[string]ElementType{
+	// document
 	"html":     Block,
 	"head":     Block,
 	"body":     Block,
-	"meta":     Standalone,
 	"title":    Inline,
+	"meta":     Standalone,
 	"link":     Standalone,
+	"base":     Standalone,
+	"style":    Block,
 	"script":   Block,
-	"h1":       Block,
-	"h2":       Block,
-	"h3":       Block,
-	"h4":       Block,
-	"p":        Block,
-	"a":        Inline,
-	"br":       Standalone,
-	"hr":       Standalone,
-	"ul":       Block,
-	"li":       Block,
-	"b":        Inline,
-	"pre":      Inline,
-	"img":      Standalone,
+	"noscript": Block,
+	"template": Block,
+
+	// sections
+	"header":  Block,
+	"footer":  Block,
+	"main":    Block,
+	"nav":     Block,
+	"section": Block,
+	"article": Block,
+	"aside":   Block,
+	"address": Block,
+	"h1":      Block,
+	"h2":      Block,
+	"h3":      Block,
+	"h4":      Block,
+	"h5":      Block,
+	"h6":      Block,
+	"hgroup":  Block,
+	"search":  Block,
+
+	// grouping
+	"div":        Block,
+	"p":          Block,
+	"hr":         Standalone,
+	"pre":        Inline,
+	"blockquote": Block,
+	"ol":         Block,
+	"ul":         Block,
+	"menu":       Block,
+	"li":         Block,
+	"dl":         Block,
+	"dt":         Block,
+	"dd":         Block,
+	"figure":     Block,
+	"figcaption": Block,
+	"details":    Block,
+	"summary":    Inline,
+	"dialog":     Block,
+
+	// text
+	"a":      Inline,
+	"span":   Inline,
+	"b":      Inline,
+	"i":      Inline,
+	"u":      Inline,
+	"s":      Inline,
+	"em":     Inline,
+	"strong": Inline,
+	"small":  Inline,
+	"mark":   Inline,
+	"abbr":   Inline,
+	"cite":   Inline,
+	"q":      Inline,
+	"dfn":    Inline,
+	"code":   Inline,
+	"kbd":    Inline,
+	"samp":   Inline,
+	"var":    Inline,
+	"sub":    Inline,
+	"sup":    Inline,
+	"time":   Inline,
+	"data":   Inline,
+	"bdi":    Inline,
+	"bdo":    Inline,
+	"ins":    Inline,
+	"del":    Inline,
+	"br":     Standalone,
+	"wbr":    Standalone,
+
+	// embedded
+	"img":     Standalone,
+	"picture": Block,
+	"source":  Standalone,
+	"track":   Standalone,
+	"embed":   Standalone,
+	"area":    Standalone,
+	"map":     Block,
+	"iframe":  Inline,
+	"object":  Block,
+	"video":   Block,
+	"audio":   Block,
+	"canvas":  Inline,
+
+	// tables
 	"table":    Block,
+	"caption":  Block,
+	"colgroup": Block,
+	"col":      Standalone,
 	"thead":    Block,
 	"tbody":    Block,
+	"tfoot":    Block,
 	"tr":       Block,
 	"th":       Block,
 	"td":       Block,
+
+	// forms
 	"form":     Block,
+	"fieldset": Block,
+	"legend":   Inline,
+	"label":    Inline,
 	"input":    Standalone,
 	"button":   Inline,
+	"select":   Block,
+	"optgroup": Block,
+	"option":   Inline,
+	"datalist": Block,
 	"textarea": Inline,
+	"output":   Inline,
+	"progress": Inline,
+	"meter":    Inline,
+
+	// svg, its empty elements are also rendered as <path />, which is allowed in svg
+	"svg":            Block,
+	"g":              Block,
+	"defs":           Block,
+	"symbol":         Block,
+	"use":            Standalone,
+	"path":           Standalone,
+	"rect":           Standalone,
+	"circle":         Standalone,
+	"ellipse":        Standalone,
+	"line":           Standalone,
+	"polyline":       Standalone,
+	"polygon":        Standalone,
+	"stop":           Standalone,
+	"text":           Inline,
+	"tspan":          Inline,
+	"linearGradient": Block,
+	"radialGradient": Block,
+	"clipPath":       Block,
+}
+
+// RegisterTag adds an element that H() doesn't know yet, e.g. a custom element like "my-chart" or a less common svg
+// element. Call it from an init function, before the first spec with the tag is parsed.
+func RegisterTag(name string, typ ElementType) {
+	if !validName(name) {
+		log.Panicln("invalid tag name: " + name)
+	}
+	tagToType[name] = typ
 }
 
 type specParser struct {
-	h      string
-	i      int
-	n      int
-	params []interface{}
+	h string
+	i int
+	n int
 }
 
 func (sp *specParser) more() bool {
@@ -263,8 +630,23 @@ func (sp *specParser) next() {
 	sp.i++
 }
 
+// SpecError is a malformed spec, or params that don't match the spec
+type SpecError struct {
+	Spec    string
+	Message string
+}
+
+func (e *SpecError) Error() string {
+	return fmt.Sprintf("invalid spec \"%s\": %s", e.Spec, e.Message)
+}
+
+// fail stops the parsing, parseSpecTemplate recovers and returns the error
+func (sp *specParser) fail(format string, args ...interface{}) {
+	panic(&SpecError{Spec: sp.h, Message: fmt.Sprintf(format, args...)})
+}
+
 func (sp *specParser) panicExpected(s string) {
-	log.Panicf("expected %s in \"%v\" @ %v (...%v)", s, sp.h, sp.i, sp.h[sp.i:])
+	sp.fail("expected %s @ %v (...%v)", s, sp.i, sp.h[sp.i:])
 }
 
 func (sp *specParser) skip(ch uint8) {
@@ -278,6 +660,15 @@ func isLetter(ch uint8) bool {
 	return (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '-' || ch == '_'
 }
 
+func validName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if !isLetter(name[i]) {
+			return false
+		}
+	}
+	return name != ""
+}
+
 func (sp *specParser) parseName() string {
 	start := sp.i
 	if !isLetter(sp.cur()) {
@@ -289,7 +680,23 @@ func (sp *specParser) parseName() string {
 	return sp.h[start:sp.i]
 }
 
-func (sp *specParser) parseSpec() *Element {
+// attrTemplate is an attribute of a parsed spec, the value is a literal or a %s or %d param
+type attrTemplate struct {
+	key   string
+	value string
+	param uint8
+}
+
+type elementTemplate struct {
+	name  string
+	typ   ElementType
+	attrs []attrTemplate
+}
+
+// specTemplate is a parsed spec, a chain of elements where each element is the parent of the next
+type specTemplate []elementTemplate
+
+func (sp *specParser) parseSpec() elementTemplate {
 	first := sp.cur()
 	var tag string
 	if first == '.' || first == '#' {
@@ -297,7 +704,11 @@ func (sp *specParser) parseSpec() *Element {
 	} else {
 		tag = sp.parseName()
 	}
-	el := newElement(tag)
+	typ, ok := tagToType[tag]
+	if !ok {
+		sp.fail("unknown tag: %s", tag)
+	}
+	el := elementTemplate{name: tag, typ: typ}
 
 	var classes []string
 	for sp.more() && sp.cur() != ' ' {
@@ -308,41 +719,36 @@ func (sp *specParser) parseSpec() *Element {
 		} else if first == '#' {
 			sp.next()
 			id := sp.parseName()
-			el.Attr("id", id)
+			el.attrs = append(el.attrs, attrTemplate{key: "id", value: id})
 		} else {
 			sp.panicExpected("' ', '.' or '#'")
 		}
 	}
 	if len(classes) > 0 {
-		el.Attr("class", strings.Join(classes, " "))
+		el.attrs = append(el.attrs, attrTemplate{key: "class", value: strings.Join(classes, " ")})
 	}
 
 	return el
 }
 
-func (sp *specParser) parseAttr() ElementAttr {
+func (sp *specParser) parseAttr() attrTemplate {
 	key := sp.parseName()
 	sp.skip('=')
-	var value string
+	attr := attrTemplate{key: key}
 	if sp.cur() == '\'' {
 		sp.next()
 		start := sp.i
-		for sp.cur() != '\'' {
+		for sp.more() && sp.cur() != '\'' {
 			sp.next()
 		}
-		value = sp.h[start:sp.i]
+		attr.value = sp.h[start:sp.i]
 		sp.skip('\'')
 	} else if sp.cur() == '%' {
 		sp.next()
 		spec := sp.cur()
-		if spec == 's' {
-			sp.next()
-			value = sp.params[0].(string)
-			sp.params = sp.params[1:]
-		} else if spec == 'd' {
+		if spec == 's' || spec == 'd' {
 			sp.next()
-			value = strconv.Itoa(sp.params[0].(int))
-			sp.params = sp.params[1:]
+			attr.param = spec
 		} else {
 			sp.panicExpected("%s or %d")
 		}
@@ -351,45 +757,150 @@ func (sp *specParser) parseAttr() ElementAttr {
 		for sp.more() && sp.cur() != ' ' {
 			sp.next()
 		}
-		value = sp.h[start:sp.i]
+		attr.value = sp.h[start:sp.i]
 	}
-	return ElementAttr{key, value}
+	return attr
 }
 
-func H(h string, p ...interface{}) *Element {
-	var top, cur *Element
-	n := len(h)
-	sp := specParser{h, 0, n, p}
-	if n == 0 {
-		top = newElement("div")
-		cur = top
-	} else {
-		for sp.more() {
-			el := sp.parseSpec()
-			if cur == nil {
-				top = el
-			} else {
-				cur.child(el)
+func parseSpecTemplate(h string) (elements specTemplate, err error) {
+	if h == "" {
+		return specTemplate{{name: "div", typ: Block}}, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			specErr, ok := r.(*SpecError)
+			if !ok {
+				panic(r)
 			}
-			cur = el
+			elements, err = nil, specErr
+		}
+	}()
+	sp := specParser{h, 0, len(h)}
+	for sp.more() {
+		el := sp.parseSpec()
+		if sp.more() {
+			sp.skip(' ')
+		}
+		for sp.more() && sp.cur() != '>' {
+			el.attrs = append(el.attrs, sp.parseAttr())
 			if sp.more() {
 				sp.skip(' ')
 			}
-			for sp.more() && sp.cur() != '>' {
-				attr := sp.parseAttr()
-				cur.Attr(attr.key, attr.value)
-				if sp.more() {
-					sp.skip(' ')
+		}
+		if sp.more() {
+			sp.skip('>')
+			sp.skip(' ')
+		}
+		elements = append(elements, el)
+	}
+	return elements, nil
+}
+
+type cachedSpec struct {
+	template specTemplate
+	err      error
+}
+
+// specCache has the parsed specs. The specs are constants in the views, so it doesn't grow after the first requests.
+var specCache sync.Map
+
+func getSpecTemplate(h string) (specTemplate, error) {
+	if cached, ok := specCache.Load(h); ok {
+		c := cached.(cachedSpec)
+		return c.template, c.err
+	}
+	template, err := parseSpecTemplate(h)
+	specCache.Store(h, cachedSpec{template, err})
+	return template, err
+}
+
+// ValidateSpec returns the error of a malformed spec, e.g. to check specs that are built at runtime
+func ValidateSpec(h string) error {
+	_, err := getSpecTemplate(h)
+	return err
+}
+
+func validParam(param interface{}) bool {
+	switch param.(type) {
+	case nil, string, int, Node, []Node, ElementAttr, []ElementAttr:
+		return true
+	}
+	return false
+}
+
+// instantiate creates the elements of the template, the params are used for the %s and %d attributes first, the rest
+// are added to the last element
+func (st specTemplate) instantiate(spec string, params []interface{}) (*Element, error) {
+	var top, cur *Element
+	for _, template := range st {
+		el := &Element{name: template.name, typ: template.typ}
+		if len(template.attrs) > 0 {
+			el.attrs = make([]ElementAttr, 0, len(template.attrs))
+			for _, attr := range template.attrs {
+				value := attr.value
+				if attr.param != 0 {
+					if len(params) == 0 {
+						return nil, &SpecError{Spec: spec, Message: "missing param for " + attr.key}
+					}
+					var ok bool
+					if attr.param == 's' {
+						value, ok = params[0].(string)
+					} else if n, isInt := params[0].(int); isInt {
+						value, ok = strconv.Itoa(n), true
+					}
+					if !ok {
+						return nil, &SpecError{Spec: spec, Message: fmt.Sprintf("wrong param for %s=%%%c: %#v",
+							attr.key, attr.param, params[0])}
+					}
+					params = params[1:]
+					// only the params can be unsafe, the literal values are part of the view
+					el.Attr(attr.key, value)
+					continue
 				}
+				el.attrs = append(el.attrs, ElementAttr{attr.key, value})
 			}
-			if sp.more() {
-				sp.skip('>')
-				sp.skip(' ')
-			}
+		}
+		if cur == nil {
+			top = el
+		} else {
+			cur.child(el)
+		}
+		cur = el
+	}
+	for _, param := range params {
+		if !validParam(param) {
+			return nil, &SpecError{Spec: spec, Message: fmt.Sprintf("cannot handle param %#v", param)}
 		}
 	}
+	cur.Add(params...)
+	return top, nil
+}
 
-	cur.Add(sp.params...)
+// HE is H that returns an error for a malformed spec or params that don't match it
+func HE(h string, p ...interface{}) (*Element, error) {
+	template, err := getSpecTemplate(h)
+	if err != nil {
+		return nil, err
+	}
+	return template.instantiate(h, p)
+}
 
-	return top
+// builderError panics in debug mode, so mistakes are found during development. In production the error is logged, and
+// the page is rendered without the broken element instead of failing the request.
+func builderError(err error) {
+	if Config.Debug.Enabled {
+		panic(err)
+	}
+	slog.Error("html builder error", "err", err)
+}
+
+// H creates an element from a spec like "form.upload method=POST action=%s > p", the spec is parsed once and cached.
+// A malformed spec returns an empty span, see builderError.
+func H(h string, p ...interface{}) *Element {
+	el, err := HE(h, p...)
+	if err != nil {
+		builderError(err)
+		return &Element{name: "span", typ: Inline}
+	}
+	return el
 }
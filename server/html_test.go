@@ -0,0 +1,35 @@
+package server
+
+import "testing"
+
+func TestSafeUrl(t *testing.T) {
+	tests := []struct {
+		raw  string
+		url  string
+		safe bool
+	}{
+		{"https://example.com/a?b#c", "https://example.com/a?b#c", true},
+		{"http://example.com", "http://example.com", true},
+		{"HTTPS://example.com", "HTTPS://example.com", true},
+		{"mailto:someone@example.com", "mailto:someone@example.com", true},
+		{"/package/react", "/package/react", true},
+		{"?sort=name#tree", "?sort=name#tree", true},
+		{"docs/a:b", "docs/a:b", true},
+		{"javascript:alert(1)", "", false},
+		{"JavaScript:alert(1)", "", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", "", false},
+		{"vbscript:msgbox(1)", "", false},
+		{" javascript:alert(1)", "", false},
+		{"\x00\x1fjavascript:alert(1)", "", false},
+		{"java\tscript:alert(1)", "", false},
+		{"java\nscript:alert(1)", "", false},
+		{"jav\r\nascript:alert(1)", "", false},
+		{"\n https://example.com \t", "https://example.com", true},
+	}
+	for _, test := range tests {
+		url, safe := SafeUrl(test.raw)
+		if url != test.url || safe != test.safe {
+			t.Errorf("SafeUrl(%q) = %q, %v, want %q, %v", test.raw, url, safe, test.url, test.safe)
+		}
+	}
+}
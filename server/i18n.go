@@ -0,0 +1,369 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Locale is a language of the site. The messages in the views are written in english, and are also the keys of the
+// catalogs of the other languages, like gettext.
+type Locale string
+
+const DEFAULT_LOCALE Locale = "en"
+
+// T translates the message, and formats it with the args. Messages without a translation are shown in english.
+func (l Locale) T(message string, args ...interface{}) string {
+	if translated, ok := catalogs[l][message]; ok {
+		message = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+func knownLocale(tag string) (Locale, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	// only the language is used, so en-US is en
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	locale := Locale(tag)
+	if _, ok := catalogs[locale]; ok || locale == DEFAULT_LOCALE {
+		return locale, true
+	}
+	return "", false
+}
+
+// negotiateLocale returns the known locale with the highest quality in an Accept-Language header
+func negotiateLocale(acceptLanguage string) Locale {
+	best, bestQuality := DEFAULT_LOCALE, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		if locale, ok := knownLocale(tag); ok && quality > bestQuality {
+			best, bestQuality = locale, quality
+		}
+	}
+	return best
+}
+
+// RequestLocale returns the language of the Accept-Language header of the request
+func RequestLocale(request *http.Request) Locale {
+	return negotiateLocale(request.Header.Get("Accept-Language"))
+}
+
+var catalogs = map[Locale]map[string]string{
+	"nl": {
+		// layout
+		"Account": "Account",
+		"%s | %s": "%s | %s",
+
+		// home
+		"%s: know your dependencies": "%s: ken je afhankelijkheden",
+		"Check out some examples:":   "Bekijk enkele voorbeelden:",
+		"Go to another package:":     "Ga naar een ander pakket:",
+		"Package name":               "Pakketnaam",
+		"Go":                         "Ga",
+		"Upload package.json or packages.lock.json of NuGet:": "Upload package.json of packages.lock.json van NuGet:",
+		"Upload":                        "Uploaden",
+		"package-lock.json (optional) ": "package-lock.json (optioneel) ",
+		"Or analyze a repository on GitHub, GitLab or Bitbucket:": "Of analyseer een repository op GitHub, GitLab of Bitbucket:",
+		"Repository url": "Repository-url",
+		"Analyze":        "Analyseren",
+		"Get a weekly digest of your packages by email": "Ontvang een wekelijks overzicht van je pakketten per e-mail",
+
+		// version
+		"%s %s dependencies":                "afhankelijkheden van %s %s",
+		"description:":                      "beschrijving:",
+		"homepage:":                         "homepage:",
+		"license:":                          "licentie:",
+		"published by:":                     "gepubliceerd door:",
+		"published at:":                     "gepubliceerd op:",
+		"%s versions":                       "versies van %s",
+		"version":                           "versie",
+		"published at":                      "gepubliceerd op",
+		"tags":                              "tags",
+		"all versions":                      "alle versies",
+		"Readme":                            "Leesmij",
+		"Errors":                            "Fouten",
+		"packages":                          "pakketten",
+		"publishers":                        "publicisten",
+		"files":                             "bestanden",
+		"disk space":                        "schijfruimte",
+		"vulnerabilities":                   "kwetsbaarheden",
+		"download size":                     "downloadgrootte",
+		"with types":                        "met types",
+		"%d bundled, %d from @types":        "%d meegeleverd, %d van @types",
+		"types:":                            "types:",
+		"bundled":                           "meegeleverd",
+		"none":                              "geen",
+		"ESM and CJS":                       "ESM en CJS",
+		"with ESM":                          "met ESM",
+		"%d ESM only, %d dual, %d CJS only": "%d alleen ESM, %d beide, %d alleen CJS",
+		"format":                            "formaat",
+		"module format:":                    "moduleformaat:",
+		"gzipped tarballs":                  "gzip-tarballs",
+		"low":                               "laag",
+		"medium":                            "gemiddeld",
+		"high":                              "hoog",
+		"critical":                          "kritiek",
+		"packages analyzed":                 "pakketten geanalyseerd",
+		"versions analyzed":                 "versies geanalyseerd",
+		"previous":                          "vorige",
+		"next":                              "volgende",
+		"low %d \u00a0 medium %d \u00a0 high %d \u00a0 critical %d": "laag %d \u00a0 gemiddeld %d \u00a0 hoog %d \u00a0 kritiek %d",
+		"Dependencies":     "Afhankelijkheden",
+		"Tree":             "Boom",
+		"(expanded above)": "(hierboven uitgeklapt)",
+		"Publishers":       "Publicisten",
+		"Vulnerabilities":  "Kwetsbaarheden",
+		"name":             "naam",
+		"versions":         "versies",
+		"publisher":        "publicist",
+		"count":            "aantal",
+		"package":          "pakket",
+		"title":            "titel",
+		"severity":         "ernst",
+		"date":             "datum",
+		"affected":         "getroffen",
+		"%d packages, %d versions, %d publishers, %.2f MB disk space, %d vulnerabilities": "%d pakketten, %d versies, %d publicisten, %.2f MB schijfruimte, %d kwetsbaarheden",
+		" (%d high, %d critical)": " (%d hoog, %d kritiek)",
+		"Licenses":                "Licenties",
+		"license":                 "licentie",
+		"kind":                    "soort",
+		"permissive":              "permissief",
+		"weak copyleft":           "zwakke copyleft",
+		"strong copyleft":         "sterke copyleft",
+		"network copyleft":        "netwerk-copyleft",
+		"proprietary":             "niet vrij",
+		"unknown":                 "onbekend",
+		"ok":                      "ok",
+		"review":                  "nakijken",
+		"conflict":                "conflict",
+		"not for others":          "niet voor anderen",
+		"%s (this package)":       "%s (dit pakket)",
+		"Which licenses of the dependencies can be used by a package with a license of each kind. This is a rough guide, not legal advice.": "Welke licenties van de afhankelijkheden een pakket met een licentie van elke soort kan gebruiken. Dit is een ruwe richtlijn, geen juridisch advies.",
+		"%s is licensed as %s (%s), which a package licensed as %s may not use.":                                                            "%s heeft de licentie %s (%s), die een pakket met de licentie %s niet mag gebruiken.",
+		"Disk space":                         "Schijfruimte",
+		"%d other packages":                  "%d andere pakketten",
+		"Filter by name":                     "Filteren op naam",
+		"Filter":                             "Filteren",
+		"Show all":                           "Alles tonen",
+		"No dependencies match %s.":          "Geen afhankelijkheden komen overeen met %s.",
+		"size":                               "grootte",
+		"Find a package in the dependencies": "Zoek een pakket in de afhankelijkheden",
+		"Search":                             "Zoeken",
+		"Releases per month":                 "Releases per maand",
+		"%s: %d versions":                    "%s: %d versies",
+		"direct":                             "direct",
+		"transitive":                         "transitief",
+		"Heaviest":                           "Zwaarste",
+		"What every direct dependency adds by itself, the packages that no other direct dependency needs.": "Wat elke directe afhankelijkheid op zichzelf toevoegt, de pakketten die geen andere directe afhankelijkheid nodig heeft.",
+		"Unmaintained": "Niet onderhouden",
+		"The dependencies without a release in the last %d years.": "De afhankelijkheden zonder release in de laatste %d jaar.",
+		"last release":                  "laatste release",
+		"age":                           "leeftijd",
+		"median age":                    "mediane leeftijd",
+		"%d unmaintained for %d+ years": "%d niet onderhouden sinds %d+ jaar",
+		"%d months":                     "%d maanden",
+		"%.1f years":                    "%.1f jaar",
+		"Maintainers":                   "Onderhouders",
+		"Every npm account that can publish a new version of a package in the tree, with the number of versions.": "Elk npm-account dat een nieuwe versie van een pakket in de boom kan publiceren, met het aantal versies.",
+		"maintainer":                           "onderhouder",
+		"maintainers":                          "onderhouders",
+		"npm accounts that can publish":        "npm-accounts die kunnen publiceren",
+		"Dependencies over time":               "Afhankelijkheden door de tijd",
+		"Disk space over time":                 "Schijfruimte door de tijd",
+		"Vulnerabilities over time":            "Kwetsbaarheden door de tijd",
+		"Warnings":                             "Waarschuwingen",
+		"%s is resolved at %d major versions:": "%s wordt opgelost naar %d major-versies:",
+		"Integrity":                            "Integriteit",
+		"The checksums of the analyzed tarballs, to pin and verify exactly these versions. The signatures of the registry are shown with their key id, they are not verified here.": "De checksums van de geanalyseerde tarballs, om precies deze versies vast te leggen en te controleren. De handtekeningen van de registry worden getoond met hun sleutel-id, ze worden hier niet gecontroleerd.",
+		"integrity":          "integriteit",
+		"integrity:":         "integriteit:",
+		"shasum":             "shasum",
+		"registry signature": "registry-handtekening",
+		"declares repository %s, but its provenance says it was built from %s. It was published by %s.": "declareert de repository %s, maar volgens de provenance is het gebouwd vanuit %s. Het is gepubliceerd door %s.",
+		"most files":           "meeste bestanden",
+		"most disk space":      "meeste schijfruimte",
+		"most vulnerabilities": "meeste kwetsbaarheden",
+		"health":               "gezondheid",
+		"%d of 100 points":     "%d van 100 punten",
+		"badge:":               "badge:",
+
+		// file
+		"Uploaded files are deleted automatically after %d days.":           "Geüploade bestanden worden na %d dagen automatisch verwijderd.",
+		"Bookmark this page if you want to delete the file yourself later.": "Bewaar deze pagina als je het bestand later zelf wilt verwijderen.",
+		"Delete file":                          "Bestand verwijderen",
+		"your package.json":                    "je package.json",
+		"File deleted":                         "Bestand verwijderd",
+		"Your uploaded file has been deleted.": "Je geüploade bestand is verwijderd.",
+
+		// wait and busy
+		"Waiting for %s...": "Wachten op %s...",
+		"Please wait while the dependencies of %s are being fetched. This may take a minute or so, depending on the number of dependencies. This page will automatically refresh when it is ready.": "Even geduld, de afhankelijkheden van %s worden opgehaald. Dit kan een minuut duren, afhankelijk van het aantal afhankelijkheden. Deze pagina ververst automatisch zodra het klaar is.",
+		"%d packages fetched, %d to go": "%d pakketten opgehaald, nog %d te gaan",
+		"Too busy":                      "Te druk",
+		"%s is fetching the dependencies of a lot of packages right now. Please try again in a minute. This page will automatically refresh.": "%s haalt op dit moment de afhankelijkheden van veel pakketten op. Probeer het over een minuut opnieuw. Deze pagina ververst automatisch.",
+
+		// errors
+		"Not found":               "Niet gevonden",
+		"Page not found":          "Pagina niet gevonden",
+		"There is no page at %s.": "Er is geen pagina op %s.",
+		"Look up a package:":      "Zoek een pakket op:",
+		"Go to the home page":     "Ga naar de homepage",
+		"Error: %s":               "Fout: %s",
+		"Internal Server Error":   "Interne serverfout",
+		"Technical Information":   "Technische informatie",
+		"We have received the technical details of this error and will look into it.": "We hebben de technische details van deze fout ontvangen en gaan ernaar kijken.",
+
+		// watch
+		"Weekly digest": "Wekelijks overzicht",
+		"Get a weekly email with the new versions, vulnerabilities and number of dependencies of your packages.": "Ontvang wekelijks een e-mail met de nieuwe versies, kwetsbaarheden en het aantal afhankelijkheden van je pakketten.",
+		"Email address": "E-mailadres",
+		"Package names": "Pakketnamen",
+		" Also email me right away about new vulnerabilities in the dependencies": " Mail me ook meteen over nieuwe kwetsbaarheden in de afhankelijkheden",
+		"Subscribe":              "Aanmelden",
+		"Invalid email address.": "Ongeldig e-mailadres.",
+		"Invalid packages: %s.":  "Ongeldige pakketten: %s.",
+		"Too many confirmation emails were requested, please try again later.": "Er zijn te veel bevestigingsmails aangevraagd, probeer het later opnieuw.",
+		"Check your email": "Controleer je e-mail",
+		"We have sent you an email to confirm the weekly digest.": "We hebben je een e-mail gestuurd om het wekelijkse overzicht te bevestigen.",
+		"Confirmed": "Bevestigd",
+		"You will receive a weekly digest for %s.": "Je ontvangt een wekelijks overzicht van %s.",
+		"Unsubscribe":                    "Afmelden",
+		"Stop the weekly digest for %s?": "Het wekelijkse overzicht van %s stoppen?",
+		"Unsubscribed":                   "Afgemeld",
+		"You will not receive the weekly digest anymore.": "Je ontvangt het wekelijkse overzicht niet meer.",
+
+		// contact
+		"Contact": "Contact",
+		"Questions, ideas or problems? Send us a message.": "Vragen, ideeën of problemen? Stuur ons een bericht.",
+		"Name":         "Naam",
+		"Message":      "Bericht",
+		"Send":         "Versturen",
+		"Message sent": "Bericht verstuurd",
+		"Thank you, we will get back to you soon.":                 "Bedankt, we nemen snel contact met je op.",
+		"The message is required.":                                 "Het bericht is verplicht.",
+		"The message is longer than %d characters.":                "Het bericht is langer dan %d tekens.",
+		"You have sent too many messages, please try again later.": "Je hebt te veel berichten verstuurd, probeer het later opnieuw.",
+
+		// accounts
+		"Sign up":  "Registreren",
+		"Log in":   "Inloggen",
+		"Log out":  "Uitloggen",
+		"Password": "Wachtwoord",
+		"The password needs at least %d characters. Already have an account? ": "Het wachtwoord moet minstens %d tekens hebben. Heb je al een account? ",
+		"No account yet? ":                          "Nog geen account? ",
+		"Sign in with GitHub":                       "Inloggen met GitHub",
+		"GitHub account: ":                          "GitHub-account: ",
+		"Connect your GitHub account":               "Koppel je GitHub-account",
+		"Logged in as %s":                           "Ingelogd als %s",
+		"Uploaded files":                            "Geüploade bestanden",
+		"Weekly digests":                            "Wekelijkse overzichten",
+		"No uploaded files.":                        "Geen geüploade bestanden.",
+		"No weekly digests.":                        "Geen wekelijkse overzichten.",
+		" uploaded %s":                              " geüpload op %s",
+		"%s to %s":                                  "%s naar %s",
+		" (not confirmed)":                          " (niet bevestigd)",
+		"unsubscribe":                               "afmelden",
+		"The password is too short.":                "Het wachtwoord is te kort.",
+		"This email address is already registered.": "Dit e-mailadres is al geregistreerd.",
+		"Wrong email address or password.":          "Verkeerd e-mailadres of wachtwoord.",
+		"GitHub login was cancelled.":               "Het inloggen met GitHub is geannuleerd.",
+
+		// drift
+		"Drift": "Afwijkingen",
+		"Where the versions in the package-lock.json do not follow the ranges in the package.json.": "Waar de versies in de package-lock.json niet de bereiken in de package.json volgen.",
+		"declared":                               "gedeclareerd",
+		"locked":                                 "vastgelegd",
+		"newest":                                 "nieuwste",
+		"missing":                                "ontbreekt",
+		"mismatch":                               "buiten bereik",
+		"undeclared":                             "niet gedeclareerd",
+		"outdated":                               "verouderd",
+		"The lockfile follows the package.json.": "Het lockbestand volgt de package.json.",
+
+		// maintainer
+		"Packages of %s":                        "Pakketten van %s",
+		"weekly downloads":                      "wekelijkse downloads",
+		"last published":                        "laatst gepubliceerd",
+		"description":                           "beschrijving",
+		"Only the first %d packages are shown.": "Alleen de eerste %d pakketten worden getoond.",
+		"No packages were found for the npm account %s.": "Er zijn geen pakketten gevonden voor het npm-account %s.",
+
+		// admin
+		"Admin":              "Beheer",
+		"Admin token":        "Beheertoken",
+		"Wrong admin token.": "Verkeerd beheertoken.",
+		"Pages":              "Pagina's",
+		"draft":              "concept",
+		"published":          "gepubliceerd",
+		"No pages yet, the pages directory is used.": "Nog geen pagina's, de pagina's in de map worden gebruikt.",
+		"New page":    "Nieuwe pagina",
+		"Edit page":   "Pagina bewerken",
+		"Delete page": "Pagina verwijderen",
+		"Title":       "Titel",
+		"Markdown":    "Markdown",
+		" Published":  " Gepubliceerd",
+		"Save":        "Opslaan",
+		"There is already a page with this path.":                       "Er is al een pagina met dit pad.",
+		"The path may only contain lowercase letters, digits, - and /.": "Het pad mag alleen kleine letters, cijfers, - en / bevatten.",
+		"The title is required.":                                        "De titel is verplicht.",
+
+		// recent
+		"Recently analyzed and trending packages": "Recent geanalyseerde en populaire pakketten",
+		"Recently analyzed and trending":          "Recent geanalyseerd en populair",
+		"Trending in the last %d days":            "Populair in de laatste %d dagen",
+		"lookups":                                 "opzoekingen",
+		"change":                                  "verandering",
+		"new":                                     "nieuw",
+		"No packages were looked up in the last %d days.": "Er zijn de laatste %d dagen geen pakketten opgezocht.",
+		"Recently analyzed":              "Recent geanalyseerd",
+		"analyzed at":                    "geanalyseerd op",
+		"No packages were analyzed yet.": "Er zijn nog geen pakketten geanalyseerd.",
+		"Uploaded files are private, they are never listed here.": "Geüploade bestanden zijn privé, ze worden hier nooit getoond.",
+
+		// vulnerability statistics
+		"Vulnerability statistics": "Kwetsbaarheidsstatistieken",
+		"Sources":                  "Bronnen",
+		"source":                   "bron",
+		"last update":              "laatste update",
+		"last error":               "laatste fout",
+		"The vulnerabilities were not updated yet.": "De kwetsbaarheden zijn nog niet bijgewerkt.",
+		"%d hours ago":           "%d uur geleden",
+		"%d days ago":            "%d dagen geleden",
+		"Newest vulnerabilities": "Nieuwste kwetsbaarheden",
+		"Most affected packages": "Meest getroffen pakketten",
+
+		// publishers
+		"Publishers in the most dependency trees": "Publicisten in de meeste afhankelijkheidsbomen",
+		"The npm accounts that published a package in the dependency trees of the %d analyzed versions. When one of these accounts is compromised, all these trees are at risk.": "De npm-accounts die een pakket hebben gepubliceerd in de afhankelijkheidsbomen van de %d geanalyseerde versies. Als een van deze accounts wordt gekaapt, lopen al deze bomen risico.",
+		"trees":                          "bomen",
+		"share":                          "aandeel",
+		"No versions were analyzed yet.": "Er zijn nog geen versies geanalyseerd.",
+
+		// explain
+		"Semver constraint explainer": "Semver-voorwaarden uitgelegd",
+		"See which published versions of a package match a version constraint, like in package.json.": "Bekijk welke gepubliceerde versies van een pakket overeenkomen met een versievoorwaarde, zoals in package.json.",
+		"Explain":                   "Uitleggen",
+		"Could not get package %s.": "Kon het pakket %s niet ophalen.",
+		"Invalid constraint: %s":    "Ongeldige voorwaarde: %s",
+		"No version matches, so %s can not be installed with this constraint.": "Geen enkele versie komt overeen, dus %s kan met deze voorwaarde niet worden geïnstalleerd.",
+		"%d of %d versions match, npm installs the highest one:":               "%d van %d versies komen overeen, npm installeert de hoogste:",
+		"matches":     "komt overeen",
+		"reason":      "reden",
+		"no":          "nee",
+		"yes":         "ja",
+		"yes, picked": "ja, gekozen",
+		"Prereleases, like 2.0.0-beta.1, only match a constraint with a prerelease of the same major, minor and patch version, like ^2.0.0-beta.0. So a prerelease is never installed by accident.": "Prereleases, zoals 2.0.0-beta.1, komen alleen overeen met een voorwaarde met een prerelease van dezelfde major-, minor- en patchversie, zoals ^2.0.0-beta.0. Een prerelease wordt dus nooit per ongeluk geïnstalleerd.",
+	},
+}
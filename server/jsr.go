@@ -0,0 +1,370 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+)
+
+// ECOSYSTEM_JSR marks the versions of JSR packages. Their trees can contain npm packages, see JSR_PREFIX.
+const ECOSYSTEM_JSR = "jsr"
+
+// JSR_PREFIX is in front of the names of JSR packages in the dependencies, like Deno writes them, so they don't mix
+// with the npm packages in the same tree
+const JSR_PREFIX = "jsr:"
+
+// isNpmPackage returns whether a package in a tree of the ecosystem is from the npm registry, only the names with
+// JSR_PREFIX in a JSR tree are not
+func isNpmPackage(ecosystem string, name string) bool {
+	return ecosystem == "" || ecosystem == ECOSYSTEM_JSR && !strings.HasPrefix(name, JSR_PREFIX)
+}
+
+type JsrVersion struct {
+	Version   string    `json:"version"`
+	Yanked    bool      `json:"yanked"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// JsrDependency is an import of a version, of a JSR package or of an npm package with an npm: specifier
+type JsrDependency struct {
+	Kind       string `json:"kind"` // jsr or npm
+	Name       string `json:"name"` // @scope/name for jsr
+	Constraint string `json:"constraint"`
+	Path       string `json:"path"` // the imported subpath, a package can be imported at more than one
+}
+
+type JsrGithubRepository struct {
+	Owner string `json:"owner"`
+	Name  string `json:"name"`
+}
+
+type JsrPackage struct {
+	Scope            string                `json:"scope"`
+	Name             string                `json:"name"`
+	Description      string                `json:"description"`
+	GithubRepository *JsrGithubRepository  `json:"githubRepository"`
+	Versions         map[string]JsrVersion `json:"versions"`
+}
+
+// FullName returns the name as it is imported, like @std/path
+func (p *JsrPackage) FullName() string {
+	return "@" + p.Scope + "/" + p.Name
+}
+
+// Repository returns the url of the linked github repository, or "" when there is none
+func (p *JsrPackage) Repository() string {
+	if p.GithubRepository == nil || p.GithubRepository.Owner == "" {
+		return ""
+	}
+	return "https://github.com/" + p.GithubRepository.Owner + "/" + p.GithubRepository.Name
+}
+
+// VersionInfo converts a version for the views, the scope is shown as the publisher
+func (p *JsrPackage) VersionInfo(version string) VersionInfo {
+	info := VersionInfo{Name: JSR_PREFIX + p.FullName(), Version: version, Description: p.Description,
+		NpmUser: NpmUser{Name: "@" + p.Scope}}
+	if repository := p.Repository(); repository != "" {
+		info.Homepage = repository
+		info.Repository = repository
+	}
+	return info
+}
+
+// MaxVersion returns the highest version that is not yanked in the constraint, like Deno does
+func (p *JsrPackage) MaxVersion(constraint *semver.Constraints) (string, error) {
+	var maxVersion *semver.Version
+	for versionRaw, info := range p.Versions {
+		version, err := semver.NewVersion(versionRaw)
+		if err != nil || info.Yanked {
+			continue
+		}
+		if constraint.Check(version) && (maxVersion == nil || version.GreaterThan(maxVersion)) {
+			maxVersion = version
+		}
+	}
+	if maxVersion == nil {
+		return "", errors.Errorf("no matching version found in %s constraint %s", p.FullName(), constraint)
+	}
+	return maxVersion.Original(), nil
+}
+
+// LatestVersion returns the highest stable version that is not yanked, or the highest prerelease if there is none
+func (p *JsrPackage) LatestVersion() (string, bool) {
+	stable, _ := semver.NewConstraint("*")
+	if latest, err := p.MaxVersion(stable); err == nil {
+		return latest, true
+	}
+	prerelease, _ := semver.NewConstraint(">= 0.0.0-0")
+	latest, err := p.MaxVersion(prerelease)
+	return latest, err == nil
+}
+
+// LastReleaseTime returns the time of the newest version that is not yanked
+func (p *JsrPackage) LastReleaseTime() time.Time {
+	var last time.Time
+	for _, info := range p.Versions {
+		if !info.Yanked && info.CreatedAt.After(last) {
+			last = info.CreatedAt
+		}
+	}
+	return last
+}
+
+// splitJsrName splits @scope/name, ok is false for other names
+func splitJsrName(name string) (scope string, pkg string, ok bool) {
+	scope, pkg, ok = strings.Cut(strings.TrimPrefix(name, "@"), "/")
+	return scope, pkg, ok && strings.HasPrefix(name, "@") && scope != "" && pkg != ""
+}
+
+func jsrPackageUrl(name string) (string, error) {
+	scope, pkg, ok := splitJsrName(name)
+	if !ok {
+		return "", errors.Errorf("invalid jsr package name %s", name)
+	}
+	return Config.Jsr.ApiUrl + "scopes/" + scope + "/packages/" + pkg, nil
+}
+
+// GetJsrPackageRegistry gets a package and its versions from the api of JSR
+func GetJsrPackageRegistry(ctx context.Context, name string) (*JsrPackage, error) {
+	slog.Debug("get from jsr", "package", name)
+	url, err := jsrPackageUrl(name)
+	if err != nil {
+		return nil, err
+	}
+	var jsrPackage JsrPackage
+	if err := getJson(ctx, url, &jsrPackage); err != nil {
+		return nil, errors.Wrap(err, "could not get jsr package "+name)
+	}
+	var versions []JsrVersion
+	if err := getJson(ctx, url+"/versions", &versions); err != nil {
+		return nil, errors.Wrap(err, "could not get versions of jsr package "+name)
+	}
+	jsrPackage.Versions = map[string]JsrVersion{}
+	for _, version := range versions {
+		jsrPackage.Versions[version.Version] = version
+	}
+	return &jsrPackage, nil
+}
+
+// JsrDependencies returns the dependencies of a version, from the db or else from the api. Published versions can't be
+// changed, so they are cached forever.
+func JsrDependencies(ctx context.Context, name string, version string) ([]JsrDependency, error) {
+	dependencies, found, err := DbGetJsrDependencies(name, version)
+	if err != nil {
+		slog.Error("could not get jsr dependencies from db", "package", name, "version", version, "err", err)
+	}
+	if found {
+		return dependencies, nil
+	}
+	url, err := jsrPackageUrl(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := getJson(ctx, url+"/versions/"+version+"/dependencies", &dependencies); err != nil {
+		return nil, errors.Wrapf(err, "could not get dependencies of jsr package %s %s", name, version)
+	}
+	if err := DbPutJsrDependencies(name, version, dependencies); err != nil {
+		slog.Error("could not put jsr dependencies in db", "package", name, "version", version, "err", err)
+	}
+	return dependencies, nil
+}
+
+type JsrPackagePerformer struct{}
+
+func (p JsrPackagePerformer) Get(name string) Data {
+	jsrPackage, err := DbGetJsrPackage(name)
+	if err != nil {
+		return nil
+	}
+	return jsrPackage
+}
+
+func (p JsrPackagePerformer) Put(name string, data Data) {
+	jsrPackage := data.(*JsrPackage)
+	if err := DbPutJsrPackage(name, jsrPackage, calcExpire(jsrPackage.LastReleaseTime())); err != nil {
+		slog.Error("could not put jsr package in db", "package", name, "err", err)
+	}
+}
+
+func (p JsrPackagePerformer) Perform(ctx context.Context, name string) Result {
+	jsrPackage, err := GetJsrPackageRegistry(ctx, name)
+	if err != nil {
+		return Result{Error: err}
+	}
+	return Result{Data: jsrPackage}
+}
+
+var jsrPackagePool *SmartWorkPool
+
+// RequestJsrPackage is for user requests, it returns BusyError when the pool is saturated
+func RequestJsrPackage(ctx context.Context, name string) (*JsrPackage, error) {
+	result := jsrPackagePool.TryProcessKey(name, Config.Pools.MaxQueued).AwaitContext(ctx, 0)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return result.Data.(*JsrPackage), nil
+}
+
+// jsrResolver resolves JSR packages to the highest version that is not yanked, like Deno. Their npm: imports are
+// resolved by npmResolver, so the npm part of the tree is analyzed like any other.
+type jsrResolver struct{}
+
+func (r jsrResolver) Prefix() string {
+	return ECOSYSTEM_JSR
+}
+
+func (r jsrResolver) NamePatterns() []string {
+	return []string{"{ns:@[\\w\\-]+}/{name:[\\w\\-]+}"}
+}
+
+// Package accepts the names with and without JSR_PREFIX
+func (r jsrResolver) Package(name string) *Future {
+	return jsrPackagePool.ProcessKey(strings.TrimPrefix(name, JSR_PREFIX))
+}
+
+func (r jsrResolver) RequestPackage(ctx context.Context, name string) (Data, error) {
+	return RequestJsrPackage(ctx, strings.TrimPrefix(name, JSR_PREFIX))
+}
+
+func (r jsrResolver) LatestVersion(data Data) (string, bool) {
+	return data.(*JsrPackage).LatestVersion()
+}
+
+func (r jsrResolver) SelectVersion(data Data, dependency EcosystemDependency) (Selection, error) {
+	constraintRaw := dependency.Constraint
+	if constraintRaw == "" {
+		constraintRaw = "*"
+	}
+	constraint, err := semver.NewConstraint(constraintRaw)
+	if err != nil {
+		return Selection{}, errors.Wrap(err, "invalid constraint")
+	}
+	jsrPackage := data.(*JsrPackage)
+	version, err := jsrPackage.MaxVersion(constraint)
+	if err != nil {
+		return Selection{}, err
+	}
+	return Selection{Name: JSR_PREFIX + jsrPackage.FullName(), Version: version, Constraint: constraint}, nil
+}
+
+// Dependencies lists a package once, the api lists it once per imported subpath
+func (r jsrResolver) Dependencies(ctx context.Context, data Data, version string) ([]EcosystemDependency, error) {
+	imports, err := JsrDependencies(ctx, data.(*JsrPackage).FullName(), version)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var dependencies []EcosystemDependency
+	for _, dependency := range imports {
+		var resolved EcosystemDependency
+		switch dependency.Kind {
+		case "jsr":
+			resolved = EcosystemDependency{jsrResolver{}, JSR_PREFIX + dependency.Name, dependency.Constraint}
+		case "npm":
+			resolved = EcosystemDependency{npmResolver{}, dependency.Name, dependency.Constraint}
+		default:
+			continue
+		}
+		if !seen[resolved.Name] {
+			seen[resolved.Name] = true
+			dependencies = append(dependencies, resolved)
+		}
+	}
+	sort.Slice(dependencies, func(i, j int) bool { return dependencies[i].Name < dependencies[j].Name })
+	return dependencies, nil
+}
+
+func (r jsrResolver) AddDetails(parent *Version, data Data, name string, version string) {
+	jsrPackage := data.(*JsrPackage)
+	publisher := "@" + jsrPackage.Scope
+	parent.Publishers[publisher]++
+	parent.Stats.Versions++
+	parent.Details[DependencyKey(name, version)] = DependencyDetails{
+		Publisher:   publisher,
+		Published:   jsrPackage.Versions[version].CreatedAt,
+		LastRelease: jsrPackage.LastReleaseTime(),
+		Repository:  NormalizeRepository(jsrPackage.Repository()),
+	}
+}
+
+func (r jsrResolver) VersionFuture(name string, version string) *Future {
+	return JsrVersionFuture(strings.TrimPrefix(name, JSR_PREFIX), version)
+}
+
+// GatherJsrVersion analyzes a version of a package, the latest when versionRaw is empty
+func (p *JsrPackage) GatherJsrVersion(ctx context.Context, versionRaw string) (*Version, error) {
+	if versionRaw == "" {
+		versionRaw, _ = p.LatestVersion()
+	}
+	info, ok := p.Versions[versionRaw]
+	if !ok {
+		return nil, errors.Errorf("could not find version %s in %s", versionRaw, p.FullName())
+	}
+	parent := NewVersion(p.VersionInfo(versionRaw), info.CreatedAt)
+	parent.Ecosystem = ECOSYSTEM_JSR
+	dependencies, err := jsrResolver{}.Dependencies(ctx, p, versionRaw)
+	if err != nil {
+		return nil, err
+	}
+	parent.GatherResolved(ctx, DependencyKey(parent.Info.Name, versionRaw), dependencies)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	// only the npm packages in the tree have tarballs, provenance and known vulnerabilities
+	parent.GatherMissingDistStats(ctx)
+	parent.GatherTarballSizes(ctx)
+	parent.GatherProvenance(ctx)
+	if err := parent.GatherVulnerabilities(false); err != nil {
+		return nil, errors.Wrapf(err, "could not gather vulns for %s version %s", p.FullName(), versionRaw)
+	}
+	sort.Slice(parent.Vulnerabilities, func(i, j int) bool {
+		return parent.Vulnerabilities[i].PackageName < parent.Vulnerabilities[j].PackageName
+	})
+	parent.GatherBreakdown()
+	parent.GatherOffenders()
+	return parent, nil
+}
+
+type JsrVersionPerformer struct{}
+
+func (p JsrVersionPerformer) Get(key string) Data {
+	name, versionRaw := parseVersionKey(key)
+	version, err := DbGetJsrVersion(name, versionRaw)
+	if err != nil || version.IsStale() {
+		return nil
+	}
+	return version
+}
+
+func (p JsrVersionPerformer) Put(key string, data Data) {
+	name, versionRaw := parseVersionKey(key)
+	version := data.(*Version)
+	if err := DbPutJsrVersion(name, versionRaw, version, calcExpire(version.Time)); err != nil {
+		slog.Error("could not put jsr version in db", "key", key, "err", err)
+	}
+}
+
+func (p JsrVersionPerformer) Perform(ctx context.Context, key string) Result {
+	name, versionRaw := parseVersionKey(key)
+	result := jsrPackagePool.ProcessKey(name).AwaitContext(ctx, 0)
+	if result.Error != nil {
+		return Result{Error: result.Error}
+	}
+	version, err := result.Data.(*JsrPackage).GatherJsrVersion(ctx, versionRaw)
+	if err != nil {
+		return Result{Error: err}
+	}
+	return Result{Data: version}
+}
+
+var jsrVersionPool *SmartWorkPool
+
+// JsrVersionFuture starts gathering the dependencies of the JSR version, unless it is already stored or in progress
+func JsrVersionFuture(name string, version string) *Future {
+	return jsrVersionPool.TryProcessKey(name+"\t"+version, Config.Pools.MaxQueued)
+}
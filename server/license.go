@@ -0,0 +1,197 @@
+package server
+
+import (
+	"sort"
+	"strings"
+)
+
+// LicenseKind groups licenses by what they ask of the packages that use them
+type LicenseKind string
+
+const (
+	LicensePermissive      LicenseKind = "permissive"
+	LicenseWeakCopyleft    LicenseKind = "weak copyleft"    // changes to the library itself must be shared
+	LicenseStrongCopyleft  LicenseKind = "strong copyleft"  // the whole program must be shared under the license
+	LicenseNetworkCopyleft LicenseKind = "network copyleft" // also when it is only used over a network
+	LicenseProprietary     LicenseKind = "proprietary"      // UNLICENSED, not to be used by others
+	LicenseUnknown         LicenseKind = "unknown"
+)
+
+// LicenseKinds are the kinds from the most permissive to the most restrictive, the columns of the matrix
+var LicenseKinds = []LicenseKind{LicensePermissive, LicenseWeakCopyleft, LicenseStrongCopyleft, LicenseNetworkCopyleft,
+	LicenseProprietary}
+
+var licenseKindPrefixes = []struct {
+	prefix string
+	kind   LicenseKind
+}{
+	{"AGPL", LicenseNetworkCopyleft},
+	{"LGPL", LicenseWeakCopyleft},
+	{"GPL", LicenseStrongCopyleft},
+	{"MPL", LicenseWeakCopyleft},
+	{"EPL", LicenseWeakCopyleft},
+	{"CDDL", LicenseWeakCopyleft},
+	{"EUPL", LicenseStrongCopyleft},
+	{"OSL", LicenseStrongCopyleft},
+	{"MIT", LicensePermissive},
+	{"ISC", LicensePermissive},
+	{"BSD", LicensePermissive},
+	{"0BSD", LicensePermissive},
+	{"Apache", LicensePermissive},
+	{"Unlicense", LicensePermissive},
+	{"CC0", LicensePermissive},
+	{"CC-BY-3", LicensePermissive},
+	{"CC-BY-4", LicensePermissive},
+	{"BlueOak", LicensePermissive},
+	{"Zlib", LicensePermissive},
+	{"Python", LicensePermissive},
+	{"Artistic", LicensePermissive},
+	{"WTFPL", LicensePermissive},
+	{"UNLICENSED", LicenseProprietary},
+}
+
+// LicenseName returns the license field of a package.json as a string. Old packages have an object with a type.
+func LicenseName(license interface{}) string {
+	switch l := license.(type) {
+	case string:
+		return strings.TrimSpace(l)
+	case map[string]interface{}:
+		if t, ok := l["type"].(string); ok {
+			return strings.TrimSpace(t)
+		}
+	}
+	return ""
+}
+
+func singleLicenseKind(license string) LicenseKind {
+	license = strings.Trim(strings.TrimSpace(license), "()")
+	license = strings.TrimSuffix(license, "+")
+	for _, p := range licenseKindPrefixes {
+		if strings.HasPrefix(strings.ToUpper(license), strings.ToUpper(p.prefix)) {
+			return p.kind
+		}
+	}
+	return LicenseUnknown
+}
+
+func kindIndex(kind LicenseKind) int {
+	for i, k := range LicenseKinds {
+		if k == kind {
+			return i
+		}
+	}
+	return len(LicenseKinds)
+}
+
+// LicenseKindOf classifies an SPDX expression. With OR the user can choose, so the most permissive option counts, with
+// AND all apply, so the most restrictive one counts. Parentheses are not nested deeper than that.
+func LicenseKindOf(license string) LicenseKind {
+	if license == "" {
+		return LicenseUnknown
+	}
+	best := LicenseUnknown
+	for _, option := range strings.Split(license, " OR ") {
+		kind := LicensePermissive
+		for _, part := range strings.Split(option, " AND ") {
+			if partKind := singleLicenseKind(part); kindIndex(partKind) > kindIndex(kind) {
+				kind = partKind
+			}
+		}
+		if kindIndex(kind) < kindIndex(best) {
+			best = kind
+		}
+	}
+	return best
+}
+
+// LicenseCompatibility tells if a dependency license can be used by a package with another license
+type LicenseCompatibility string
+
+const (
+	LicenseCompatible   LicenseCompatibility = "ok"
+	LicenseReview       LicenseCompatibility = "review" // allowed, with conditions on the dependency itself
+	LicenseConflict     LicenseCompatibility = "conflict"
+	LicenseNotKnown     LicenseCompatibility = "unknown"
+	LicenseNotForOthers LicenseCompatibility = "not for others" // a proprietary dependency of another package
+)
+
+// CheckLicense tells if a package with a license of the root kind can depend on one with the dependency kind. This is
+// a rough guide for the common cases, not legal advice.
+func CheckLicense(root LicenseKind, dependency LicenseKind) LicenseCompatibility {
+	switch dependency {
+	case LicensePermissive:
+		return LicenseCompatible
+	case LicenseWeakCopyleft:
+		return LicenseReview
+	case LicenseStrongCopyleft:
+		if root == LicenseStrongCopyleft || root == LicenseNetworkCopyleft {
+			return LicenseCompatible
+		}
+		if root == LicenseUnknown {
+			return LicenseNotKnown
+		}
+		return LicenseConflict
+	case LicenseNetworkCopyleft:
+		if root == LicenseNetworkCopyleft {
+			return LicenseCompatible
+		}
+		if root == LicenseUnknown {
+			return LicenseNotKnown
+		}
+		return LicenseConflict
+	case LicenseProprietary:
+		return LicenseNotForOthers
+	}
+	return LicenseNotKnown
+}
+
+// LicenseUsage is a license in the tree, with the packages that have it
+type LicenseUsage struct {
+	License  string
+	Kind     LicenseKind
+	Packages []string // names, sorted
+}
+
+// LicenseUsages aggregates the licenses of the dependencies, the most used first
+func (v *Version) LicenseUsages() []LicenseUsage {
+	names := map[string]map[string]bool{}
+	for key, details := range v.Details {
+		name, _ := SplitDependencyKey(key)
+		if names[details.License] == nil {
+			names[details.License] = map[string]bool{}
+		}
+		names[details.License][name] = true
+	}
+	var usages []LicenseUsage
+	for license, packages := range names {
+		usage := LicenseUsage{License: license, Kind: LicenseKindOf(license)}
+		for name := range packages {
+			usage.Packages = append(usage.Packages, name)
+		}
+		sort.Strings(usage.Packages)
+		usages = append(usages, usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if len(usages[i].Packages) != len(usages[j].Packages) {
+			return len(usages[i].Packages) > len(usages[j].Packages)
+		}
+		return usages[i].License < usages[j].License
+	})
+	return usages
+}
+
+// LicenseConflicts returns the licenses in the tree that the license of the package itself does not allow. Without a
+// known license of the package there is nothing to compare.
+func (v *Version) LicenseConflicts() []LicenseUsage {
+	root := LicenseKindOf(LicenseName(v.Info.License))
+	if root == LicenseUnknown {
+		return nil
+	}
+	var conflicts []LicenseUsage
+	for _, usage := range v.LicenseUsages() {
+		if check := CheckLicense(root, usage.Kind); check == LicenseConflict || check == LicenseNotForOthers {
+			conflicts = append(conflicts, usage)
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// SetupLogging installs the default slog logger, with the level and format (text or json) from the config. Messages
+// of the standard log package are also written to this logger.
+func SetupLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(Config.Log.Level)); err != nil {
+		log.Fatalln("invalid log level", Config.Log.Level, err)
+	}
+	options := &slog.HandlerOptions{
+		Level: level,
+		// log errors with their message only, not the stack trace of pkg/errors
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if err, ok := attr.Value.Any().(error); ok {
+				return slog.String(attr.Key, err.Error())
+			}
+			return attr
+		},
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(Config.Log.Format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	default:
+		log.Fatalln("invalid log format", Config.Log.Format)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(bytes []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(bytes)
+	r.size += n
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RequestLogger logs the method, path, status and duration of every request
+func RequestLogger(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		handler.ServeHTTP(recorder, r)
+
+		slog.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote", r.RemoteAddr,
+			"status", recorder.status,
+			"size", recorder.size,
+			"duration", time.Since(start),
+		)
+	})
+}
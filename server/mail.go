@@ -1,43 +1,152 @@
 package server
 
 import (
-	"log"
+	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/xhit/go-simple-mail/v2"
 )
 
+var mailEncryptions = map[string]mail.Encryption{
+	"none":     mail.EncryptionNone,
+	"ssl":      mail.EncryptionSSLTLS,
+	"tls":      mail.EncryptionSSLTLS,
+	"starttls": mail.EncryptionSTARTTLS,
+}
+
+var mailAuths = map[string]mail.AuthType{
+	"none":     mail.AuthNone,
+	"plain":    mail.AuthPlain,
+	"login":    mail.AuthLogin,
+	"cram-md5": mail.AuthCRAMMD5,
+}
+
 func smtpConnect() (*mail.SMTPClient, error) {
 	config := Config.Mail
 
 	server := mail.NewSMTPClient()
 	server.Host = config.Server
-	server.Port = 587
+	server.Port = config.Port
 	server.Username = config.Username
 	server.Password = config.Password
-	server.Encryption = mail.EncryptionSTARTTLS
+	server.Encryption = mailEncryptions[strings.ToLower(config.Encryption)]
+	server.Authentication = mailAuths[strings.ToLower(config.Auth)]
+	server.KeepAlive = true // send several queued mails over one connection
 	return server.Connect()
 }
 
+// SendError queues an email with the error to the error_to address
 func SendError(subj string, body string) {
-	from := "independ <info@independ.org>"
-	to := Config.Mail.ErrorTo
-	email := mail.NewMSG()
-	email.SetFrom(from).AddTo(to).SetSubject(subj)
-	email.SetBody(mail.TextHTML, "<pre>"+body+"</pre>")
+	if err := SendMail("error", Config.Mail.ErrorTo, ErrorMailData{Title: subj, Trace: body}); err != nil {
+		slog.Error("could not send error email", "err", err)
+	}
+}
+
+const MAIL_MAX_ATTEMPTS = 10
+const MAIL_RETRY_BASE_DELAY = time.Minute
+const MAIL_POLL_INTERVAL = time.Minute
+const MAIL_BATCH_SIZE = 20
 
+// wakes the mail worker, when a mail is queued
+var mailWake = make(chan struct{}, 1)
+
+// without a mail server, nothing sends the queued mails, so they are refused
+var MailDisabledError = errors.New("no mail server is configured")
+
+// QueueMail stores the mail in the db, a background worker sends it and retries when the mail server is unavailable.
+// The body is sent as html with a plain text alternative.
+func QueueMail(to string, subject string, body Node) error {
+	if Config.Mail.Server == "" {
+		return MailDisabledError
+	}
+	if err := DbQueueMail(to, subject, RenderNode(body), RenderText(body)); err != nil {
+		return errors.Wrap(err, "could not queue email")
+	}
+	select {
+	case mailWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func sendMail(client *mail.SMTPClient, row MailRow) error {
+	email := mail.NewMSG()
+	email.SetFrom(Config.Mail.From).AddTo(row.Recipient).SetSubject(row.Subject)
+	if row.TextBody != "" {
+		email.SetBody(mail.TextPlain, row.TextBody)
+		email.AddAlternative(mail.TextHTML, row.Body)
+	} else {
+		email.SetBody(mail.TextHTML, row.Body)
+	}
 	if email.Error != nil {
-		log.Println("error creating error email:", email.Error)
+		return email.Error
+	}
+	return email.Send(client)
+}
+
+func mailFailed(row MailRow, err error) {
+	attempts := row.Attempts + 1
+	if attempts >= MAIL_MAX_ATTEMPTS {
+		slog.Error("giving up on email", "subject", row.Subject, "attempts", attempts, "err", err)
+		if err := DbDeleteMail(row.Id); err != nil {
+			slog.Error("could not delete email", "err", err)
+		}
 		return
 	}
+	delay := backoff(attempts, MAIL_RETRY_BASE_DELAY)
+	slog.Warn("could not send email, will retry", "subject", row.Subject, "attempts", attempts, "delay", delay, "err", err)
+	if err := DbRetryMail(row.Id, attempts, time.Now().Add(delay), err.Error()); err != nil {
+		slog.Error("could not update email", "err", err)
+	}
+}
+
+// deliverMails sends the mails that are due, using one connection to the mail server. It returns the number of
+// handled mails.
+func deliverMails() int {
+	rows, err := DbDueMails(time.Now(), MAIL_BATCH_SIZE)
+	if err != nil {
+		slog.Error("could not get queued emails", "err", err)
+		return 0
+	}
+	if len(rows) == 0 {
+		return 0
+	}
 
 	client, err := smtpConnect()
 	if err != nil {
-		log.Println("error connecting to server:", err)
+		for _, row := range rows {
+			mailFailed(row, err)
+		}
+		return len(rows)
 	}
 	defer client.Close()
-	if err = email.Send(client); err != nil {
-		log.Println("error sending error email:", err)
+
+	for _, row := range rows {
+		if err := sendMail(client, row); err != nil {
+			mailFailed(row, err)
+			continue
+		}
+		if err := DbDeleteMail(row.Id); err != nil {
+			slog.Error("could not delete sent email", "err", err)
+		}
+		slog.Info("email sent", "subject", row.Subject)
 	}
+	return len(rows)
+}
 
-	log.Println("error email send:", subj)
+func scheduleMails() {
+	if Config.Mail.Server == "" {
+		return
+	}
+	for {
+		for deliverMails() == MAIL_BATCH_SIZE {
+			// more mails are due
+		}
+		select {
+		case <-mailWake:
+		case <-time.After(MAIL_POLL_INTERVAL):
+		}
+	}
 }
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// MailTemplate renders a kind of email. The subject is a text/template, the body is built with H(), both get the data
+// passed to SendMail.
+type MailTemplate struct {
+	Subject string
+	Body    func(data interface{}) Node
+
+	subject *template.Template
+}
+
+var mailTemplates = map[string]*MailTemplate{}
+
+// RegisterMailTemplate adds a kind of email, call it from an init function
+func RegisterMailTemplate(kind string, t MailTemplate) {
+	t.subject = template.Must(template.New(kind).Option("missingkey=error").Parse(t.Subject))
+	mailTemplates[kind] = &t
+}
+
+func (t *MailTemplate) render(data interface{}) (string, Node, error) {
+	var subject strings.Builder
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return "", nil, errors.Wrap(err, "could not render mail subject")
+	}
+	return subject.String(), MailLayout(t.Body(data)), nil
+}
+
+// SendMail renders the template of the kind with the data, and queues the email to the recipient
+func SendMail(kind string, to string, data interface{}) error {
+	t, ok := mailTemplates[kind]
+	if !ok {
+		return errors.New("unknown mail kind: " + kind)
+	}
+	if to == "" {
+		return errors.New("no recipient for mail kind: " + kind)
+	}
+	subject, body, err := t.render(data)
+	if err != nil {
+		return err
+	}
+	return QueueMail(to, subject, body)
+}
+
+type ErrorMailData struct {
+	Title string
+	Trace string
+}
+
+func init() {
+	RegisterMailTemplate("error", MailTemplate{
+		Subject: "{{.Title}}",
+		Body: func(data interface{}) Node {
+			d := data.(ErrorMailData)
+			return ErrorMailView(d.Title, d.Trace)
+		},
+	})
+}
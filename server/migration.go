@@ -1,7 +1,9 @@
 package server
 
 import (
+	"fmt"
 	"log"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -11,22 +13,41 @@ import (
 type Migration struct {
 	Name string
 	Sql  string
+	Down string
 }
 
-func (m Migration) apply() error {
-	lines := strings.Split(m.Sql, ";\n")
-	for _, line := range lines {
+func splitStatements(sql string) []string {
+	var statements []string
+	for _, line := range strings.Split(sql, ";\n") {
 		line = strings.TrimSpace(line)
 		if line != "" {
-			_, err := db.Exec(line)
-			if err != nil {
-				return errors.Wrap(err, "error in line: '"+line+"'")
-			}
+			statements = append(statements, line)
+		}
+	}
+	return statements
+}
+
+func execStatements(sql string) error {
+	for _, line := range splitStatements(sql) {
+		_, err := db.Exec(line)
+		if err != nil {
+			return errors.Wrap(err, "error in line: '"+line+"'")
 		}
 	}
 	return nil
 }
 
+func (m Migration) apply() error {
+	return execStatements(m.Sql)
+}
+
+func (m Migration) revert() error {
+	if strings.TrimSpace(m.Down) == "" {
+		return errors.New("migration has no down sql: '" + m.Name + "'")
+	}
+	return execStatements(m.Down)
+}
+
 type MigrationRow struct {
 	Name string
 	Time time.Time
@@ -54,6 +75,10 @@ func saveMigration(migration Migration) {
 	db.MustExec("INSERT INTO migrations (name, time) VALUES ($1, $2)", migration.Name, time.Now())
 }
 
+func deleteMigration(migration Migration) {
+	db.MustExec("DELETE FROM migrations WHERE name = $1", migration.Name)
+}
+
 func Migrate(migrations []Migration) {
 	finished, err := getFinishedMigrations()
 	if err != nil {
@@ -64,10 +89,55 @@ func Migrate(migrations []Migration) {
 		if containsMigration(finished, migration) {
 			continue
 		}
-		log.Println("apply migration", migration.Name)
+		slog.Info("apply migration", "name", migration.Name)
 		if err := migration.apply(); err != nil {
 			log.Fatalln("could not apply migration: '"+migration.Name+"'", err)
 		}
 		saveMigration(migration)
 	}
 }
+
+// MigrateDryRun prints the statements of the pending migrations without executing them
+func MigrateDryRun(migrations []Migration) {
+	finished, err := getFinishedMigrations()
+	if err != nil {
+		log.Fatalln("could not read existing migrations", err)
+	}
+
+	pending := 0
+	for _, migration := range migrations {
+		if containsMigration(finished, migration) {
+			continue
+		}
+		pending++
+		fmt.Printf("-- migration: %s\n", migration.Name)
+		for _, statement := range splitStatements(migration.Sql) {
+			fmt.Printf("%s;\n", statement)
+		}
+	}
+	if pending == 0 {
+		fmt.Println("-- no pending migrations")
+	}
+}
+
+// Rollback reverts the last applied migration, using the order of the given list
+func Rollback(migrations []Migration) {
+	finished, err := getFinishedMigrations()
+	if err != nil {
+		log.Fatalln("could not read existing migrations", err)
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if !containsMigration(finished, migration) {
+			continue
+		}
+		slog.Info("rollback migration", "name", migration.Name)
+		if err := migration.revert(); err != nil {
+			log.Fatalln("could not rollback migration: '"+migration.Name+"'", err)
+		}
+		deleteMigration(migration)
+		return
+	}
+	slog.Info("no migration to rollback")
+}
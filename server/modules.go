@@ -0,0 +1,88 @@
+package server
+
+import "strings"
+
+// ModuleFormat is the kind of javascript modules a package ships
+type ModuleFormat string
+
+const (
+	ModuleESM  ModuleFormat = "esm"
+	ModuleCJS  ModuleFormat = "cjs"
+	ModuleDual ModuleFormat = "dual" // both, one for import and one for require
+)
+
+// exportsFormats walks the exports field of a package.json and tells if it has entries for import and for require.
+// The import and require conditions decide, else the extension of the path, else the type of the package.
+func exportsFormats(exports interface{}, typeModule bool) (esm bool, cjs bool) {
+	switch e := exports.(type) {
+	case string:
+		switch {
+		case strings.HasSuffix(e, ".mjs"):
+			return true, false
+		case strings.HasSuffix(e, ".cjs"):
+			return false, true
+		default:
+			return typeModule, !typeModule
+		}
+	case []interface{}: // fallbacks
+		for _, item := range e {
+			itemEsm, itemCjs := exportsFormats(item, typeModule)
+			esm, cjs = esm || itemEsm, cjs || itemCjs
+		}
+	case map[string]interface{}: // subpaths or conditions
+		for key, value := range e {
+			switch key {
+			case "import", "module":
+				esm = true
+			case "require":
+				cjs = true
+			case "types":
+			default:
+				itemEsm, itemCjs := exportsFormats(value, typeModule)
+				esm, cjs = esm || itemEsm, cjs || itemCjs
+			}
+		}
+	}
+	return
+}
+
+// ModuleFormat detects the module format from the type, exports and module fields. The module field is not used by
+// node, but points bundlers to an esm build.
+func (v VersionInfo) ModuleFormat() ModuleFormat {
+	typeModule := v.Type == "module"
+	esm, cjs := exportsFormats(v.Exports, typeModule)
+	if !esm && !cjs {
+		esm, cjs = typeModule, !typeModule
+	}
+	if v.Module != nil && v.Module != "" {
+		esm = true
+	}
+	switch {
+	case esm && cjs:
+		return ModuleDual
+	case esm:
+		return ModuleESM
+	default:
+		return ModuleCJS
+	}
+}
+
+// ModuleFormatCounts counts the resolved versions of the dependencies per module format
+func (v *Version) ModuleFormatCounts() map[ModuleFormat]int {
+	counts := map[ModuleFormat]int{}
+	for _, details := range v.Details {
+		if details.Format != "" {
+			counts[details.Format]++
+		}
+	}
+	return counts
+}
+
+func containsFormat(list []ModuleFormat, format ModuleFormat) bool {
+	for _, item := range list {
+		if item == format {
+			return true
+		}
+	}
+	return false
+}
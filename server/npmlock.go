@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+)
+
+// kinds of drift between the ranges of a package.json and the versions of its lockfile
+const (
+	DRIFT_MISSING    = "missing"    // declared, but not in the lockfile
+	DRIFT_MISMATCH   = "mismatch"   // the locked version is outside the declared range
+	DRIFT_UNDECLARED = "undeclared" // in the lockfile, but not declared
+	DRIFT_OUTDATED   = "outdated"   // the range allows a newer version than the locked one
+)
+
+// Drift is a direct dependency whose locked version does not follow its declared range
+type Drift struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Declared string `json:"declared"`         // the range in the package.json
+	Locked   string `json:"locked"`           // the version in the lockfile
+	Newest   string `json:"newest,omitempty"` // the newest version in the range, when outdated
+}
+
+// NpmLockEntry is a package in a package-lock.json, by its path in node_modules
+type NpmLockEntry struct {
+	Name                 string            `json:"name"` // of the package, when it is installed under an alias
+	Version              string            `json:"version"`
+	Link                 bool              `json:"link"` // a workspace, not from the registry
+	Dependencies         map[string]string `json:"dependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+}
+
+// NpmLock is a package-lock.json or npm-shrinkwrap.json of npm 7 or later, the packages by their path
+type NpmLock struct {
+	Name            string                  `json:"name"`
+	Version         string                  `json:"version"`
+	LockfileVersion int                     `json:"lockfileVersion"`
+	Packages        map[string]NpmLockEntry `json:"packages"`
+}
+
+// ParseNpmLock parses a package-lock.json. Version 1 of npm 6 has only a nested tree of dependencies, it is not
+// supported.
+func ParseNpmLock(bytes []byte) (*NpmLock, error) {
+	var lock NpmLock
+	if err := json.Unmarshal(bytes, &lock); err != nil {
+		return nil, err
+	}
+	if lock.LockfileVersion == 0 {
+		return nil, errors.New("not a package-lock.json")
+	}
+	if lock.Packages == nil {
+		return nil, errors.Errorf("lockfileVersion %d is not supported, install with npm 7 or later",
+			lock.LockfileVersion)
+	}
+	return &lock, nil
+}
+
+// RootInfo returns the package.json as recorded in the lock, for a lock that is uploaded without its package.json
+func (l *NpmLock) RootInfo() VersionInfo {
+	root := l.Packages[""]
+	info := VersionInfo{Name: root.Name, Version: root.Version, Dependencies: root.Dependencies,
+		DevDependencies: root.DevDependencies}
+	if info.Name == "" {
+		info.Name = l.Name
+	}
+	if info.Version == "" {
+		info.Version = l.Version
+	}
+	return info
+}
+
+// packageName returns the name of the package at the path, the last part after node_modules unless it is an alias
+func (l *NpmLock) packageName(path string) string {
+	if name := l.Packages[path].Name; name != "" {
+		return name
+	}
+	return path[strings.LastIndex(path, "node_modules/")+len("node_modules/"):]
+}
+
+// resolve finds the path of a dependency like node does: in the node_modules of the package, else in those of the
+// packages it is in
+func (l *NpmLock) resolve(from string, name string) (string, bool) {
+	dir := from
+	for {
+		path := "node_modules/" + name
+		if dir != "" {
+			path = dir + "/" + path
+		}
+		if entry, ok := l.Packages[path]; ok && !entry.Link && entry.Version != "" {
+			return path, true
+		}
+		if dir == "" {
+			return "", false
+		}
+		if i := strings.LastIndex(dir, "/node_modules/"); i >= 0 {
+			dir = dir[:i]
+		} else {
+			dir = ""
+		}
+	}
+}
+
+// addEdges adds the edges from the key to the dependencies that resolve from the path
+func (l *NpmLock) addEdges(version *Version, key string, from string, dependencies ...map[string]string) {
+	seen := map[string]bool{}
+	for _, names := range dependencies {
+		for name := range names {
+			path, ok := l.resolve(from, name)
+			if !ok {
+				continue
+			}
+			child := DependencyKey(l.packageName(path), l.Packages[path].Version)
+			if !seen[child] {
+				seen[child] = true
+				version.Edges[key] = append(version.Edges[key], child)
+			}
+		}
+	}
+	sort.Strings(version.Edges[key])
+}
+
+// LockedVersion turns the lock in a version with the locked tree of the package.json, the details are gathered by
+// GatherNpmLock. Workspaces are not in the registry, they are left out.
+func (l *NpmLock) LockedVersion(info VersionInfo) *Version {
+	version := NewVersion(info, time.Now())
+	version.Locked = true
+	for path, entry := range l.Packages {
+		if entry.Link || entry.Version == "" || !strings.Contains(path, "node_modules/") {
+			continue
+		}
+		name := l.packageName(path)
+		if !strArrContain(version.Dependencies[name], entry.Version) {
+			version.Dependencies[name] = append(version.Dependencies[name], entry.Version)
+		}
+		l.addEdges(version, DependencyKey(name, entry.Version), path, entry.Dependencies,
+			entry.OptionalDependencies, entry.PeerDependencies)
+	}
+	for _, versions := range version.Dependencies {
+		sort.Strings(versions)
+	}
+	l.addEdges(version, DependencyKey(info.Name, info.Version), "", info.Dependencies, info.DevDependencies)
+	version.Drift = l.drift(info)
+	return version
+}
+
+// drift compares the direct dependencies of the package.json with those of the lock, without the registry
+func (l *NpmLock) drift(info VersionInfo) []Drift {
+	declared := map[string]string{}
+	for _, dependencies := range []map[string]string{info.Dependencies, info.DevDependencies} {
+		for name, constraintRaw := range dependencies {
+			declared[name] = constraintRaw
+		}
+	}
+	var drift []Drift
+	for name, constraintRaw := range declared {
+		entry, ok := l.Packages["node_modules/"+name]
+		if !ok || entry.Version == "" {
+			drift = append(drift, Drift{Name: name, Kind: DRIFT_MISSING, Declared: constraintRaw})
+			continue
+		}
+		// ranges like a git url or an alias are not semver, they can't be compared
+		constraint, err := semver.NewConstraint(constraintRaw)
+		if err != nil {
+			continue
+		}
+		if locked, err := semver.NewVersion(entry.Version); err == nil && !constraint.Check(locked) {
+			drift = append(drift, Drift{Name: name, Kind: DRIFT_MISMATCH, Declared: constraintRaw,
+				Locked: entry.Version})
+		}
+	}
+	root := l.Packages[""]
+	for _, dependencies := range []map[string]string{root.Dependencies, root.DevDependencies,
+		root.OptionalDependencies} {
+		for name := range dependencies {
+			if _, ok := declared[name]; !ok {
+				drift = append(drift, Drift{Name: name, Kind: DRIFT_UNDECLARED,
+					Locked: l.Packages["node_modules/"+name].Version})
+			}
+		}
+	}
+	sortDrift(drift)
+	return drift
+}
+
+func sortDrift(drift []Drift) {
+	sort.Slice(drift, func(i, j int) bool {
+		if drift[i].Name != drift[j].Name {
+			return drift[i].Name < drift[j].Name
+		}
+		return drift[i].Kind < drift[j].Kind
+	})
+}
+
+// GatherNpmLock gets the details of the locked versions from the registry, and adds the direct dependencies with a
+// newer version in their range to the drift
+func (v *Version) GatherNpmLock(ctx context.Context) {
+	progress := ProgressFromContext(ctx)
+	names := sortedDependencyNames(v.Dependencies)
+	futures := make([]*Future, len(names))
+	for i, name := range names {
+		futures[i] = packagePool.ProcessKey(name)
+	}
+	progress.Add(len(futures))
+	root := DependencyKey(v.Info.Name, v.Info.Version)
+	direct := map[string]bool{}
+	for _, key := range v.Edges[root] {
+		direct[key] = true
+	}
+	drifted := map[string]bool{}
+	for _, drift := range v.Drift {
+		drifted[drift.Name] = true
+	}
+	for i, name := range names {
+		result := futures[i].AwaitContext(ctx, 0)
+		if ctx.Err() != nil {
+			return
+		}
+		progress.Step()
+		v.Stats.Packages++
+		if result.Error != nil {
+			v.Errors = append(v.Errors, "could not get "+name+": "+result.Error.Error())
+			continue
+		}
+		packageInfo := result.Data.(*PackageInfo)
+		for _, versionRaw := range v.Dependencies[name] {
+			if _, ok := packageInfo.Versions[versionRaw]; !ok {
+				v.Errors = append(v.Errors, "could not find version "+versionRaw+" of "+name)
+				continue
+			}
+			npmResolver{}.AddDetails(v, packageInfo, name, versionRaw)
+			if direct[DependencyKey(name, versionRaw)] && !drifted[name] {
+				v.addOutdated(packageInfo, name, versionRaw)
+			}
+		}
+	}
+	sortDrift(v.Drift)
+}
+
+// addOutdated adds the drift when the declared range of a direct dependency allows a newer version than the locked one
+func (v *Version) addOutdated(packageInfo *PackageInfo, name string, locked string) {
+	constraintRaw, ok := v.Info.Dependencies[name]
+	if !ok {
+		constraintRaw, ok = v.Info.DevDependencies[name]
+	}
+	if !ok {
+		return
+	}
+	newest, err := packageInfo.MaxVersion(constraintRaw)
+	if err != nil {
+		return
+	}
+	newestVersion, err := semver.NewVersion(newest.Version)
+	if err != nil {
+		return
+	}
+	if lockedVersion, err := semver.NewVersion(locked); err == nil && newestVersion.GreaterThan(lockedVersion) {
+		v.Drift = append(v.Drift, Drift{Name: name, Kind: DRIFT_OUTDATED, Declared: constraintRaw, Locked: locked,
+			Newest: newest.Version})
+	}
+}
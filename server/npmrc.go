@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const DEFAULT_NPM_REGISTRY = "https://registry.npmjs.org/"
+
+// Npmrc are the registries of an .npmrc, like npm uses them: a scoped package comes from the registry of its scope,
+// other packages from the default registry. The credentials are per registry, by its url without the protocol, like
+// //npm.pkg.github.com/.
+type Npmrc struct {
+	Registry    string            // with a trailing /
+	Scopes      map[string]string // @scope -> registry, with a trailing /
+	Credentials map[string]string // //host/path/ -> the value of the Authorization header
+	Mirrors     []string          // of the default registry, from the config, with a trailing /
+}
+
+var npmrcEnv = regexp.MustCompile(`\$\{([^${}?]+)(\??)\}`)
+
+// expandNpmrcEnv replaces ${NAME} by the environment variable, like npm it fails when the variable is not set. With
+// ${NAME?} it is replaced by "" instead.
+func expandNpmrcEnv(value string) (string, error) {
+	var missing []string
+	expanded := npmrcEnv.ReplaceAllStringFunc(value, func(match string) string {
+		groups := npmrcEnv.FindStringSubmatch(match)
+		env, ok := os.LookupEnv(groups[1])
+		if !ok && groups[2] == "" {
+			missing = append(missing, groups[1])
+		}
+		return env
+	})
+	if len(missing) > 0 {
+		return "", errors.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+func withSlash(registry string) string {
+	if !strings.HasSuffix(registry, "/") {
+		return registry + "/"
+	}
+	return registry
+}
+
+// ParseNpmrc parses the ini format of an .npmrc. Only the registries and their credentials are used, the other
+// settings are ignored.
+func ParseNpmrc(content string) (Npmrc, error) {
+	npmrc := Npmrc{Registry: DEFAULT_NPM_REGISTRY, Scopes: map[string]string{}, Credentials: map[string]string{}}
+	usernames := map[string]string{}
+	passwords := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return npmrc, errors.Errorf("line %d: expected key=value", n)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		value, err := expandNpmrcEnv(value)
+		if err != nil {
+			return npmrc, errors.Wrapf(err, "line %d", n)
+		}
+		switch {
+		case key == "registry":
+			npmrc.Registry = withSlash(value)
+		case strings.HasPrefix(key, "@") && strings.HasSuffix(key, ":registry"):
+			npmrc.Scopes[strings.TrimSuffix(key, ":registry")] = withSlash(value)
+		case strings.HasPrefix(key, "//"):
+			// the part after the last : is the setting, the host can have a port
+			i := strings.LastIndex(key, ":")
+			if i < 0 {
+				continue
+			}
+			prefix, setting := withSlash(key[:i]), key[i+1:]
+			if value == "" {
+				continue
+			}
+			switch setting {
+			case "_authToken":
+				npmrc.Credentials[prefix] = "Bearer " + value
+			case "_auth":
+				npmrc.Credentials[prefix] = "Basic " + value
+			case "username":
+				usernames[prefix] = value
+			case "_password":
+				passwords[prefix] = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return npmrc, err
+	}
+	// the password is base64 encoded in an .npmrc, basic auth encodes it together with the username
+	for prefix, username := range usernames {
+		if _, ok := npmrc.Credentials[prefix]; ok || passwords[prefix] == "" {
+			continue
+		}
+		password, err := base64.StdEncoding.DecodeString(passwords[prefix])
+		if err != nil {
+			return npmrc, errors.Wrapf(err, "invalid _password for %s", prefix)
+		}
+		npmrc.Credentials[prefix] = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+string(password)))
+	}
+	return npmrc, nil
+}
+
+// ReadNpmrc reads and parses an .npmrc, without a path it is the default registry without credentials
+func ReadNpmrc(path string) (Npmrc, error) {
+	if path == "" {
+		return ParseNpmrc("")
+	}
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return Npmrc{}, err
+	}
+	return ParseNpmrc(string(bytes))
+}
+
+// RegistryOf returns the registry of a package, the one of its scope if it has one. Without an .npmrc read it is the
+// default registry.
+func (n Npmrc) RegistryOf(name string) string {
+	if strings.HasPrefix(name, "@") {
+		scope, _, _ := strings.Cut(name, "/")
+		if registry, ok := n.Scopes[scope]; ok {
+			return registry
+		}
+	}
+	if n.Registry == "" {
+		return DEFAULT_NPM_REGISTRY
+	}
+	return n.Registry
+}
+
+// PackageUrl returns the url of the packument of a package, the / of a scoped name is escaped like npm does
+func (n Npmrc) PackageUrl(name string) string {
+	return n.RegistryOf(name) + strings.Replace(name, "/", "%2f", 1)
+}
+
+// PackageUrls returns the urls of the packument in order of priority: of the registry and then of the mirrors. The
+// mirrors are only used for the default registry, the packages of a scoped registry are private.
+func (n Npmrc) PackageUrls(name string) []string {
+	registry := n.RegistryOf(name)
+	urls := []string{n.PackageUrl(name)}
+	if registry == n.RegistryOf("") {
+		for _, mirror := range n.Mirrors {
+			urls = append(urls, mirror+strings.Replace(name, "/", "%2f", 1))
+		}
+	}
+	return urls
+}
+
+// Authorization returns the Authorization header for a url, or "". Like npm, the credentials of the longest
+// matching path on the same host are used, so the tarballs of a registry get the credentials of the registry too.
+func (n Npmrc) Authorization(u *url.URL) string {
+	if len(n.Credentials) == 0 {
+		return ""
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	for {
+		path = path[:strings.LastIndex(path, "/")+1]
+		if credentials, ok := n.Credentials["//"+u.Host+path]; ok {
+			return credentials
+		}
+		if path == "/" || path == "" {
+			return ""
+		}
+		path = path[:len(path)-1]
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNpmrcAuthorization(t *testing.T) {
+	t.Setenv("NPM_TOKEN", "env-token")
+	npmrc, err := ParseNpmrc(`
+; comment
+registry=https://registry.example.com/npm
+@acme:registry=https://npm.pkg.github.com
+//registry.example.com/npm/:_authToken=registry-token
+//registry.example.com/npm/private/:_authToken=private-token
+//npm.pkg.github.com/:_authToken=${NPM_TOKEN}
+//localhost:4873/:_auth="dXNlcjpwYXNz"
+//basic.example.com/:username=user
+//basic.example.com/:_password=cGFzcw==
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url           string
+		authorization string
+	}{
+		{"https://registry.example.com/npm/react", "Bearer registry-token"},
+		{"https://registry.example.com/npm/react/-/react-18.2.0.tgz", "Bearer registry-token"},
+		{"https://registry.example.com/npm/private/left-pad", "Bearer private-token"},
+		{"https://registry.example.com/npm", ""},
+		{"https://registry.example.com/other/react", ""},
+		{"https://registry.example.com/npmx/react", ""},
+		{"https://registry.example.com.evil.com/npm/react", ""},
+		{"https://npm.pkg.github.com/@acme%2fwidgets", "Bearer env-token"},
+		{"https://localhost:4873/react", "Basic dXNlcjpwYXNz"},
+		{"https://localhost/react", ""},
+		{"https://basic.example.com/react", "Basic dXNlcjpwYXNz"},
+		{"https://registry.npmjs.org/react", ""},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authorization := npmrc.Authorization(u); authorization != test.authorization {
+			t.Errorf("Authorization(%s) = %q, want %q", test.url, authorization, test.authorization)
+		}
+	}
+	if registry := npmrc.RegistryOf("@acme/widgets"); registry != "https://npm.pkg.github.com/" {
+		t.Errorf("RegistryOf(@acme/widgets) = %s", registry)
+	}
+	if registry := npmrc.RegistryOf("react"); registry != "https://registry.example.com/npm/" {
+		t.Errorf("RegistryOf(react) = %s", registry)
+	}
+}
+
+func TestParseNpmrcErrors(t *testing.T) {
+	tests := []string{
+		"registry",
+		"//registry.example.com/:_authToken=${INDEPEND_TEST_UNSET}",
+		"//registry.example.com/:username=user\n//registry.example.com/:_password=not base64",
+	}
+	for _, content := range tests {
+		if _, err := ParseNpmrc(content); err == nil {
+			t.Errorf("ParseNpmrc(%q) did not fail", content)
+		}
+	}
+	npmrc, err := ParseNpmrc("//registry.example.com/:_authToken=${INDEPEND_TEST_UNSET?}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(npmrc.Credentials) != 0 {
+		t.Errorf("an empty token was used as credentials: %v", npmrc.Credentials)
+	}
+}
@@ -0,0 +1,664 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+)
+
+// ECOSYSTEM_NUGET marks the versions of .NET packages, the versions of npm packages have no ecosystem
+const ECOSYSTEM_NUGET = "nuget"
+
+// NUGET_LOCK_NAME is the name of the root of an uploaded packages.lock.json, the version is its target framework
+const NUGET_LOCK_NAME = "packages.lock.json"
+
+type NugetDependency struct {
+	Id    string `json:"id"`
+	Range string `json:"range"` // like [1.0.0, ), see ParseNugetRange
+}
+
+// NugetDependencyGroup are the dependencies of a package for a target framework, an empty framework is for any
+type NugetDependencyGroup struct {
+	TargetFramework string            `json:"targetFramework"`
+	Dependencies    []NugetDependency `json:"dependencies"`
+}
+
+type NugetVulnerability struct {
+	AdvisoryUrl string `json:"advisoryUrl"`
+	Severity    string `json:"severity"` // 0 low, 1 moderate, 2 high, 3 critical
+}
+
+type NugetDeprecation struct {
+	Reasons []string `json:"reasons"`
+	Message string   `json:"message"`
+}
+
+// NugetVersion is the catalog entry of a version in the registration of a package
+type NugetVersion struct {
+	Id                string                 `json:"id"`
+	Version           string                 `json:"version"`
+	Description       string                 `json:"description"`
+	Authors           interface{}            `json:"authors"` // a string, or a list in some feeds
+	LicenseExpression string                 `json:"licenseExpression"`
+	ProjectUrl        string                 `json:"projectUrl"`
+	Published         time.Time              `json:"published"`
+	Listed            *bool                  `json:"listed"`
+	Deprecation       *NugetDeprecation      `json:"deprecation"`
+	DependencyGroups  []NugetDependencyGroup `json:"dependencyGroups"`
+	Vulnerabilities   []NugetVulnerability   `json:"vulnerabilities"`
+	PackageContent    string                 `json:"packageContent"` // url of the nupkg, from the registration leaf
+}
+
+// IsListed returns false for versions that were unlisted, they are not picked for a range
+func (v NugetVersion) IsListed() bool {
+	return v.Listed == nil || *v.Listed
+}
+
+// GetAuthors returns the authors as one string
+func (v NugetVersion) GetAuthors() string {
+	switch authors := v.Authors.(type) {
+	case string:
+		return strings.TrimSpace(authors)
+	case []interface{}:
+		var names []string
+		for _, author := range authors {
+			if name, ok := author.(string); ok {
+				names = append(names, strings.TrimSpace(name))
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
+
+// VersionInfo converts the version for the views, the authors are shown as the publisher
+func (v NugetVersion) VersionInfo() VersionInfo {
+	info := VersionInfo{Name: v.Id, Version: v.Version, Description: v.Description, NpmUser: NpmUser{Name: v.GetAuthors()}}
+	if v.LicenseExpression != "" {
+		info.License = v.LicenseExpression
+	}
+	if v.ProjectUrl != "" {
+		info.Homepage = v.ProjectUrl
+	}
+	return info
+}
+
+type NugetPackage struct {
+	Id       string                  `json:"id"` // with the casing of the registry
+	Versions map[string]NugetVersion `json:"versions"`
+}
+
+// LatestVersion returns the highest listed stable version, or the highest listed version if there is no stable one
+func (p *NugetPackage) LatestVersion() (NugetVersion, bool) {
+	var latest *semver.Version
+	var latestPrerelease *semver.Version
+	for versionRaw, info := range p.Versions {
+		version, err := semver.NewVersion(versionRaw)
+		if err != nil || !info.IsListed() {
+			continue
+		}
+		if version.Prerelease() == "" {
+			if latest == nil || version.GreaterThan(latest) {
+				latest = version
+			}
+		} else if latestPrerelease == nil || version.GreaterThan(latestPrerelease) {
+			latestPrerelease = version
+		}
+	}
+	if latest == nil {
+		latest = latestPrerelease
+	}
+	if latest == nil {
+		return NugetVersion{}, false
+	}
+	return p.Versions[latest.Original()], true
+}
+
+// LastReleaseTime returns the time of the newest listed version
+func (p *NugetPackage) LastReleaseTime() time.Time {
+	var last time.Time
+	for _, info := range p.Versions {
+		if info.IsListed() && info.Published.After(last) {
+			last = info.Published
+		}
+	}
+	return last
+}
+
+// MinVersion returns the lowest listed version in the range, like NuGet itself it picks the lowest applicable version
+// instead of the highest
+func (p *NugetPackage) MinVersion(constraint *semver.Constraints) (NugetVersion, error) {
+	var minVersion *semver.Version
+	for versionRaw, info := range p.Versions {
+		version, err := semver.NewVersion(versionRaw)
+		if err != nil || !info.IsListed() {
+			continue
+		}
+		if constraint.Check(version) && (minVersion == nil || version.LessThan(minVersion)) {
+			minVersion = version
+		}
+	}
+	if minVersion == nil {
+		return NugetVersion{}, errors.Errorf("no matching version found in %s constraint %s", p.Id, constraint)
+	}
+	return p.Versions[minVersion.Original()], nil
+}
+
+// nugetBound returns a version of a range as a full semver version. In a constraint 1.0 would mean 1.0.x, in NuGet it
+// is 1.0.0.
+func nugetBound(raw string) (string, error) {
+	version, err := semver.NewVersion(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	return version.String(), nil
+}
+
+// ParseNugetRange converts a NuGet version range to a semver constraint. A bare version is a minimum, [1.0] is exactly
+// that version, and in intervals like [1.0, 2.0) brackets are inclusive and parentheses exclusive.
+func ParseNugetRange(raw string) (*semver.Constraints, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return semver.NewConstraint("*")
+	}
+	if !strings.HasPrefix(raw, "[") && !strings.HasPrefix(raw, "(") {
+		lower, err := nugetBound(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid range %s", raw)
+		}
+		return semver.NewConstraint(">= " + lower)
+	}
+	if len(raw) < 2 || !strings.ContainsAny(raw[len(raw)-1:], "])") {
+		return nil, errors.Errorf("invalid range %s", raw)
+	}
+	lowerInclusive := raw[0] == '['
+	upperInclusive := raw[len(raw)-1] == ']'
+	parts := strings.Split(raw[1:len(raw)-1], ",")
+	if len(parts) == 1 {
+		if !lowerInclusive || !upperInclusive {
+			return nil, errors.Errorf("invalid range %s", raw)
+		}
+		exact, err := nugetBound(parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid range %s", raw)
+		}
+		return semver.NewConstraint("= " + exact)
+	}
+	if len(parts) != 2 {
+		return nil, errors.Errorf("invalid range %s", raw)
+	}
+	var constraints []string
+	if strings.TrimSpace(parts[0]) != "" {
+		lower, err := nugetBound(parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid range %s", raw)
+		}
+		if lowerInclusive {
+			constraints = append(constraints, ">= "+lower)
+		} else {
+			constraints = append(constraints, "> "+lower)
+		}
+	}
+	if strings.TrimSpace(parts[1]) != "" {
+		upper, err := nugetBound(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid range %s", raw)
+		}
+		if upperInclusive {
+			constraints = append(constraints, "<= "+upper)
+		} else {
+			constraints = append(constraints, "< "+upper)
+		}
+	}
+	if len(constraints) == 0 {
+		return semver.NewConstraint("*")
+	}
+	return semver.NewConstraint(strings.Join(constraints, ", "))
+}
+
+var nugetFrameworkPattern = regexp.MustCompile(`^(net|netcoreapp|netstandard)(\d+(?:\.\d+)*)`)
+
+// NugetFramework shortens a target framework moniker of the registry, .NETStandard2.0 becomes netstandard2.0 and
+// .NETFramework4.6.2 becomes net462
+func NugetFramework(tfm string) string {
+	tfm = strings.ToLower(strings.TrimSpace(tfm))
+	switch {
+	case strings.HasPrefix(tfm, ".netframework"):
+		return "net" + strings.ReplaceAll(strings.TrimPrefix(tfm, ".netframework"), ".", "")
+	case strings.HasPrefix(tfm, ".net"):
+		return "net" + strings.TrimPrefix(tfm, ".net")
+	}
+	return tfm
+}
+
+// nugetFrameworkVersion splits a short framework in its family and version. The .NET Framework versions like net462
+// have no dot, they are in the family netfx with version 4.62.
+func nugetFrameworkVersion(framework string) (family string, version float64, ok bool) {
+	match := nugetFrameworkPattern.FindStringSubmatch(framework)
+	if match == nil {
+		return "", 0, false
+	}
+	family, versionRaw := match[1], match[2]
+	if family == "net" && !strings.Contains(versionRaw, ".") {
+		family = "netfx"
+		versionRaw = versionRaw[:1] + "." + versionRaw[1:]
+	}
+	parts := strings.SplitN(versionRaw, ".", 3)
+	version, err := strconv.ParseFloat(strings.Join(parts[:min(len(parts), 2)], "."), 64)
+	return family, version, err == nil
+}
+
+// nugetFrameworkRank tells how well a dependency group fits the target framework, lower is better and -1 is not
+// compatible. The same family is preferred over .NET Core, over .NET Standard, over a group for any framework.
+func nugetFrameworkRank(target string, group string) int {
+	if group == "" || group == "any" {
+		return 1000
+	}
+	targetFamily, targetVersion, ok := nugetFrameworkVersion(target)
+	groupFamily, groupVersion, ok2 := nugetFrameworkVersion(group)
+	if !ok || !ok2 {
+		if target == group {
+			return 0
+		}
+		return -1
+	}
+	distance := int((targetVersion - groupVersion) * 10)
+	if targetFamily == groupFamily {
+		if groupVersion > targetVersion {
+			return -1
+		}
+		return distance
+	}
+	switch {
+	case targetFamily == "net" && groupFamily == "netcoreapp":
+		return 100
+	case groupFamily == "netstandard" && groupVersion <= 2.0 && (targetFamily == "net" || targetFamily == "netcoreapp" ||
+		targetFamily == "netfx" && targetVersion >= 4.61):
+		return 200 + int((2.1-groupVersion)*10)
+	case groupFamily == "netstandard" && groupVersion <= 2.1 && (targetFamily == "net" ||
+		targetFamily == "netcoreapp" && targetVersion >= 3.0):
+		return 200
+	}
+	return -1
+}
+
+// Dependencies returns the dependencies of the group that fits the target framework best, nil when none fits
+func (v NugetVersion) Dependencies(target string) []NugetDependency {
+	best := -1
+	var dependencies []NugetDependency
+	for _, group := range v.DependencyGroups {
+		rank := nugetFrameworkRank(target, NugetFramework(group.TargetFramework))
+		if rank >= 0 && (best < 0 || rank < best) {
+			best = rank
+			dependencies = group.Dependencies
+		}
+	}
+	return dependencies
+}
+
+var nugetSeverities = map[string]Severity{"0": Low, "1": Medium, "2": High, "3": Critical}
+
+// nugetVulnerabilities converts the vulnerabilities in a catalog entry, the registry lists them per version
+func (v NugetVersion) nugetVulnerabilities() []Vulnerability {
+	var vulnerabilities []Vulnerability
+	for _, vulnerability := range v.Vulnerabilities {
+		id := vulnerability.AdvisoryUrl[strings.LastIndex(vulnerability.AdvisoryUrl, "/")+1:]
+		vulnerabilities = append(vulnerabilities, Vulnerability{
+			Id:             id,
+			Url:            vulnerability.AdvisoryUrl,
+			PackageManager: ECOSYSTEM_NUGET,
+			PackageName:    v.Id,
+			Title:          id,
+			Semver:         SemverSpec{Vulnerable: []string{"= " + v.Version}},
+			Severity:       nugetSeverities[vulnerability.Severity],
+		})
+	}
+	return vulnerabilities
+}
+
+type nugetRegistrationLeaf struct {
+	CatalogEntry   NugetVersion `json:"catalogEntry"`
+	PackageContent string       `json:"packageContent"`
+}
+
+type nugetRegistrationPage struct {
+	Url   string                  `json:"@id"`
+	Items []nugetRegistrationLeaf `json:"items"` // missing when the page has to be fetched separately
+}
+
+type nugetRegistrationIndex struct {
+	Items []nugetRegistrationPage `json:"items"`
+}
+
+// GetNugetPackageRegistry gets all versions of a package from the registration of the NuGet feed. Packages with many
+// versions have pages that are fetched separately.
+func GetNugetPackageRegistry(ctx context.Context, id string) (*NugetPackage, error) {
+	slog.Debug("get from nuget", "package", id)
+	var index nugetRegistrationIndex
+	if err := getJson(ctx, Config.Nuget.RegistrationUrl+strings.ToLower(id)+"/index.json", &index); err != nil {
+		return nil, errors.Wrap(err, "could not get nuget package "+id)
+	}
+	nugetPackage := NugetPackage{Id: id, Versions: map[string]NugetVersion{}}
+	for _, page := range index.Items {
+		if page.Items == nil {
+			if err := getJson(ctx, page.Url, &page); err != nil {
+				return nil, errors.Wrap(err, "could not get nuget package "+id)
+			}
+		}
+		for _, leaf := range page.Items {
+			entry := leaf.CatalogEntry
+			entry.PackageContent = leaf.PackageContent
+			nugetPackage.Versions[entry.Version] = entry
+			nugetPackage.Id = entry.Id
+		}
+	}
+	return &nugetPackage, nil
+}
+
+type NugetPackagePerformer struct{}
+
+func (p NugetPackagePerformer) Get(id string) Data {
+	nugetPackage, err := DbGetNugetPackage(id)
+	if err != nil {
+		return nil
+	}
+	return nugetPackage
+}
+
+func (p NugetPackagePerformer) Put(id string, data Data) {
+	nugetPackage := data.(*NugetPackage)
+	if err := DbPutNugetPackage(id, nugetPackage, calcExpire(nugetPackage.LastReleaseTime())); err != nil {
+		slog.Error("could not put nuget package in db", "package", id, "err", err)
+	}
+}
+
+func (p NugetPackagePerformer) Perform(ctx context.Context, id string) Result {
+	nugetPackage, err := GetNugetPackageRegistry(ctx, id)
+	if err != nil {
+		return Result{Error: err}
+	}
+	return Result{Data: nugetPackage}
+}
+
+var nugetPackagePool *SmartWorkPool
+
+// nugetPackageFuture gets a package by its lower case id, the ids of NuGet are case insensitive
+func nugetPackageFuture(id string) *Future {
+	return nugetPackagePool.ProcessKey(strings.ToLower(id))
+}
+
+// RequestNugetPackage is for user requests, it returns BusyError when the pool is saturated
+func RequestNugetPackage(ctx context.Context, id string) (*NugetPackage, error) {
+	result := nugetPackagePool.TryProcessKey(strings.ToLower(id), Config.Pools.MaxQueued).AwaitContext(ctx, 0)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return result.Data.(*NugetPackage), nil
+}
+
+// nugetResolver resolves NuGet packages for the configured target framework, the packages are looked up case
+// insensitively and shown with the casing of the registry
+type nugetResolver struct{}
+
+func (r nugetResolver) Prefix() string {
+	return ECOSYSTEM_NUGET
+}
+
+func (r nugetResolver) NamePatterns() []string {
+	return []string{"{name:[\\w\\-.]+}"}
+}
+
+func (r nugetResolver) Package(name string) *Future {
+	return nugetPackageFuture(name)
+}
+
+func (r nugetResolver) RequestPackage(ctx context.Context, name string) (Data, error) {
+	return RequestNugetPackage(ctx, name)
+}
+
+func (r nugetResolver) LatestVersion(data Data) (string, bool) {
+	latest, ok := data.(*NugetPackage).LatestVersion()
+	return latest.Version, ok
+}
+
+func (r nugetResolver) SelectVersion(data Data, dependency EcosystemDependency) (Selection, error) {
+	constraint, err := ParseNugetRange(dependency.Constraint)
+	if err != nil {
+		return Selection{}, errors.Wrap(err, "invalid range")
+	}
+	child, err := data.(*NugetPackage).MinVersion(constraint)
+	if err != nil {
+		return Selection{}, err
+	}
+	return Selection{Name: child.Id, Version: child.Version, Constraint: constraint}, nil
+}
+
+func (r nugetResolver) Dependencies(ctx context.Context, data Data, version string) ([]EcosystemDependency, error) {
+	return data.(*NugetPackage).Versions[version].nugetDependencies(), nil
+}
+
+// AddDetails also adds the vulnerabilities, the registry lists them per version
+func (r nugetResolver) AddDetails(parent *Version, data Data, name string, version string) {
+	nugetPackage := data.(*NugetPackage)
+	child := nugetPackage.Versions[version]
+	publisher := child.GetAuthors()
+	parent.Publishers[publisher]++
+	parent.Stats.Versions++
+	parent.Details[DependencyKey(name, child.Version)] = DependencyDetails{
+		Publisher:   publisher,
+		Tarball:     child.PackageContent,
+		Published:   child.Published,
+		LastRelease: nugetPackage.LastReleaseTime(),
+		License:     child.LicenseExpression,
+		Deprecated:  child.Deprecation != nil,
+	}
+	parent.Vulnerabilities = append(parent.Vulnerabilities, child.nugetVulnerabilities()...)
+}
+
+func (r nugetResolver) VersionFuture(name string, version string) *Future {
+	return NugetVersionFuture(name, version)
+}
+
+// nugetDependencies returns the dependencies for the configured target framework
+func (v NugetVersion) nugetDependencies() []EcosystemDependency {
+	var dependencies []EcosystemDependency
+	for _, dependency := range v.Dependencies(Config.Nuget.TargetFramework) {
+		dependencies = append(dependencies, EcosystemDependency{nugetResolver{}, dependency.Id, dependency.Range})
+	}
+	sort.SliceStable(dependencies, func(i, j int) bool { return dependencies[i].Name < dependencies[j].Name })
+	return dependencies
+}
+
+// finishNuget does what is left after the dependencies are resolved
+func (parent *Version) finishNuget() {
+	sort.Slice(parent.Vulnerabilities, func(i, j int) bool {
+		return parent.Vulnerabilities[i].PackageName < parent.Vulnerabilities[j].PackageName
+	})
+	parent.Stats.VulnerabilityStats = GetVulnerabilityStats(parent.Vulnerabilities)
+	parent.GatherBreakdown()
+	parent.GatherOffenders()
+}
+
+// GatherNugetVersion analyzes a version of a package for the configured target framework, the latest when versionRaw
+// is empty
+func (p *NugetPackage) GatherNugetVersion(ctx context.Context, versionRaw string) (*Version, error) {
+	var root NugetVersion
+	var ok bool
+	if versionRaw != "" {
+		root, ok = p.Versions[versionRaw]
+	} else {
+		root, ok = p.LatestVersion()
+	}
+	if !ok {
+		return nil, errors.Errorf("could not find version %s in %s", versionRaw, p.Id)
+	}
+	parent := NewVersion(root.VersionInfo(), root.Published)
+	parent.Ecosystem = ECOSYSTEM_NUGET
+	parent.Vulnerabilities = root.nugetVulnerabilities()
+	parent.GatherResolved(ctx, DependencyKey(root.Id, root.Version), root.nugetDependencies())
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	parent.finishNuget()
+	return parent, nil
+}
+
+type NugetVersionPerformer struct{}
+
+func (p NugetVersionPerformer) Get(key string) Data {
+	id, versionRaw := parseVersionKey(key)
+	version, err := DbGetNugetVersion(id, versionRaw)
+	if err != nil || version.IsStale() {
+		return nil
+	}
+	return version
+}
+
+func (p NugetVersionPerformer) Put(key string, data Data) {
+	id, versionRaw := parseVersionKey(key)
+	version := data.(*Version)
+	if err := DbPutNugetVersion(id, versionRaw, version, calcExpire(version.Time)); err != nil {
+		slog.Error("could not put nuget version in db", "key", key, "err", err)
+	}
+}
+
+func (p NugetVersionPerformer) Perform(ctx context.Context, key string) Result {
+	id, versionRaw := parseVersionKey(key)
+	result := nugetPackageFuture(id).AwaitContext(ctx, 0)
+	if result.Error != nil {
+		return Result{Error: result.Error}
+	}
+	version, err := result.Data.(*NugetPackage).GatherNugetVersion(ctx, versionRaw)
+	if err != nil {
+		return Result{Error: err}
+	}
+	return Result{Data: version}
+}
+
+var nugetVersionPool *SmartWorkPool
+
+// NugetVersionFuture starts gathering the dependencies of the NuGet version, unless it is already stored or in progress
+func NugetVersionFuture(id string, version string) *Future {
+	return nugetVersionPool.TryProcessKey(strings.ToLower(id)+"\t"+version, Config.Pools.MaxQueued)
+}
+
+// NugetLockEntry is a package in a packages.lock.json, with its resolved version and the ranges of its dependencies
+type NugetLockEntry struct {
+	Type         string            `json:"type"` // Direct, Transitive, CentralTransitive or Project
+	Requested    string            `json:"requested"`
+	Resolved     string            `json:"resolved"`
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+// NugetLock is a packages.lock.json, the resolved packages per target framework. Frameworks for a runtime, like
+// net8.0/linux-x64, are skipped.
+type NugetLock struct {
+	Version      int                                  `json:"version"`
+	Dependencies map[string]map[string]NugetLockEntry `json:"dependencies"`
+}
+
+// ParseNugetLock returns the lock when the bytes are a packages.lock.json, a package.json doesn't parse as one
+func ParseNugetLock(bytes []byte) (*NugetLock, bool) {
+	var lock NugetLock
+	if err := json.Unmarshal(bytes, &lock); err != nil || lock.Version == 0 {
+		return nil, false
+	}
+	for _, entries := range lock.Dependencies {
+		for _, entry := range entries {
+			if entry.Resolved != "" {
+				return &lock, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Framework picks the configured target framework if the lock has it, else the first one
+func (l *NugetLock) Framework() string {
+	var frameworks []string
+	for framework := range l.Dependencies {
+		if !strings.Contains(framework, "/") {
+			frameworks = append(frameworks, framework)
+		}
+	}
+	sort.Strings(frameworks)
+	for _, framework := range frameworks {
+		if framework == Config.Nuget.TargetFramework {
+			return framework
+		}
+	}
+	if len(frameworks) == 0 {
+		return ""
+	}
+	return frameworks[0]
+}
+
+// LockedVersion turns the lock in a version with the locked tree, the details are gathered by GatherNugetLock. Project
+// references have no version in the registry, they are left out.
+func (l *NugetLock) LockedVersion() *Version {
+	framework := l.Framework()
+	version := NewVersion(VersionInfo{Name: NUGET_LOCK_NAME, Version: framework}, time.Now())
+	version.Ecosystem = ECOSYSTEM_NUGET
+	entries := l.Dependencies[framework]
+	root := DependencyKey(NUGET_LOCK_NAME, framework)
+	for name, entry := range entries {
+		if entry.Resolved == "" {
+			continue
+		}
+		version.Dependencies[name] = []string{entry.Resolved}
+		key := DependencyKey(name, entry.Resolved)
+		if entry.Type == "Direct" {
+			version.Edges[root] = append(version.Edges[root], key)
+		}
+		for dependency := range entry.Dependencies {
+			if resolved := entries[dependency].Resolved; resolved != "" {
+				version.Edges[key] = append(version.Edges[key], DependencyKey(dependency, resolved))
+			}
+		}
+	}
+	for key := range version.Edges {
+		sort.Strings(version.Edges[key])
+	}
+	return version
+}
+
+// GatherNugetLock gets the details of the locked versions from the registry
+func (v *Version) GatherNugetLock(ctx context.Context) {
+	progress := ProgressFromContext(ctx)
+	names := sortedDependencyNames(v.Dependencies)
+	futures := make([]*Future, len(names))
+	for i, name := range names {
+		futures[i] = nugetPackageFuture(name)
+	}
+	progress.Add(len(futures))
+	for i, name := range names {
+		result := futures[i].AwaitContext(ctx, 0)
+		if ctx.Err() != nil {
+			return
+		}
+		progress.Step()
+		v.Stats.Packages++
+		if result.Error != nil {
+			v.Errors = append(v.Errors, "could not get "+name+": "+result.Error.Error())
+			continue
+		}
+		nugetPackage := result.Data.(*NugetPackage)
+		for _, versionRaw := range v.Dependencies[name] {
+			child, ok := nugetPackage.Versions[versionRaw]
+			if !ok {
+				v.Errors = append(v.Errors, "could not find version "+versionRaw+" of "+name)
+				continue
+			}
+			nugetResolver{}.AddDetails(v, nugetPackage, name, child.Version)
+		}
+	}
+	v.finishNuget()
+}
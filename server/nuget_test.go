@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func TestParseNugetRange(t *testing.T) {
+	tests := []struct {
+		raw        string
+		matches    []string
+		notMatches []string
+	}{
+		{"", []string{"0.1.0", "1.0.0", "9.0.0"}, nil},
+		{"1.0", []string{"1.0.0", "1.5.0", "2.0.0"}, []string{"0.9.0"}},
+		{"[1.0]", []string{"1.0.0"}, []string{"0.9.0", "1.0.1", "2.0.0"}},
+		{"[1.0, 2.0]", []string{"1.0.0", "1.5.0", "2.0.0"}, []string{"0.9.0", "2.0.1"}},
+		{"[1.0, 2.0)", []string{"1.0.0", "1.9.9"}, []string{"0.9.0", "2.0.0"}},
+		{"(1.0, 2.0]", []string{"1.0.1", "2.0.0"}, []string{"1.0.0", "2.0.1"}},
+		{"(1.0,)", []string{"1.0.1", "9.0.0"}, []string{"1.0.0"}},
+		{"[1.0,)", []string{"1.0.0", "9.0.0"}, []string{"0.9.0"}},
+		{"(,2.0)", []string{"0.1.0", "1.9.9"}, []string{"2.0.0", "3.0.0"}},
+		{"(,2.0]", []string{"0.1.0", "2.0.0"}, []string{"2.0.1"}},
+		{" [1.0 , 2.0) ", []string{"1.0.0"}, []string{"2.0.0"}},
+	}
+	for _, test := range tests {
+		constraint, err := ParseNugetRange(test.raw)
+		if err != nil {
+			t.Errorf("ParseNugetRange(%q) failed: %v", test.raw, err)
+			continue
+		}
+		for _, v := range test.matches {
+			if !constraint.Check(semver.MustParse(v)) {
+				t.Errorf("ParseNugetRange(%q) does not match %s", test.raw, v)
+			}
+		}
+		for _, v := range test.notMatches {
+			if constraint.Check(semver.MustParse(v)) {
+				t.Errorf("ParseNugetRange(%q) matches %s", test.raw, v)
+			}
+		}
+	}
+}
+
+func TestParseNugetRangeErrors(t *testing.T) {
+	for _, raw := range []string{"[]", "(1.0)", "[1.0", "(1.0]x", "[1.0, 2.0, 3.0]", "[a, b]"} {
+		if _, err := ParseNugetRange(raw); err == nil {
+			t.Errorf("ParseNugetRange(%q) did not fail", raw)
+		}
+	}
+}
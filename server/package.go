@@ -1,41 +1,141 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Masterminds/semver/v3"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 )
 
-func getBody(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+type registryResponse struct {
+	Body         []byte
+	ETag         string
+	LastModified string
+	NotModified  bool
+}
+
+// getBodyConditional only gets the body if it was modified since the given etag or last modified time
+func getBodyConditional(ctx context.Context, url string, etag string, lastModified string) (*registryResponse, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		request.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		request.Header.Set("If-Modified-Since", lastModified)
+	}
+	resp, err := httpClient.Do(request)
 	if err != nil {
 		return nil, err // wrap?
 	}
 	defer resp.Body.Close()
+	response := registryResponse{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		response.NotModified = true
+		return &response, nil
+	}
 	if resp.StatusCode >= 400 {
-		return nil, errors.New(resp.Status + " in " + url)
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status, Url: url}
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+	response.Body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err // wrap?
 	}
-	return body, nil
+	return &response, nil
+}
+
+func getBody(url string) ([]byte, error) {
+	response, err := getBodyConditional(context.Background(), url, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// getJson gets and parses a json document, with retries
+func getJson(ctx context.Context, url string, target interface{}) error {
+	var response *registryResponse
+	err := retry(ctx, "get "+url, RETRY_ATTEMPTS, RETRY_BASE_DELAY, func() (err error) {
+		response, err = getBodyConditional(ctx, url, "", "")
+		return
+	})
+	if err != nil {
+		return errors.Wrapf(err, "could not get %s", url)
+	}
+	if err := json.Unmarshal(response.Body, target); err != nil {
+		return errors.Wrapf(err, "could not parse json of %s", url)
+	}
+	return nil
 }
 
 type Dist struct {
-	FileCount    int   `json:"fileCount"`
-	UnpackedSize int64 `json:"unpackedSize"`
+	FileCount    int               `json:"fileCount"`
+	UnpackedSize int64             `json:"unpackedSize"`
+	Tarball      string            `json:"tarball"` // url
+	Shasum       string            `json:"shasum"`  // sha1 of the tarball, in hex
+	Integrity    string            `json:"integrity"`
+	Signatures   []DistSignature   `json:"signatures"`
+	Attestations *DistAttestations `json:"attestations"` // only when published with provenance
 }
 
+// DistSignature is a signature of the registry over the name, version and integrity of a package. It can be verified
+// with the public key with this key id, see https://registry.npmjs.org/-/npm/v1/keys
+type DistSignature struct {
+	Keyid string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// DistTags point to versions, like next or beta. Latest is always there, it is what npm installs by default.
 type DistTags struct {
-	Latest string `json:"latest"`
+	Latest string
+	Others map[string]string // the tags besides latest
+}
+
+func (d *DistTags) UnmarshalJSON(bytes []byte) error {
+	var tags map[string]string
+	if err := json.Unmarshal(bytes, &tags); err != nil {
+		return err
+	}
+	d.Latest = tags["latest"]
+	delete(tags, "latest")
+	d.Others = tags
+	return nil
+}
+
+func (d DistTags) MarshalJSON() ([]byte, error) {
+	tags := map[string]string{"latest": d.Latest}
+	for tag, version := range d.Others {
+		tags[tag] = version
+	}
+	return json.Marshal(tags)
+}
+
+// VersionTags returns the tags per version
+func (d DistTags) VersionTags() map[string][]string {
+	tags := map[string][]string{d.Latest: {"latest"}}
+	for tag, version := range d.Others {
+		tags[version] = append(tags[version], tag)
+	}
+	for _, list := range tags {
+		sort.Strings(list)
+	}
+	return tags
 }
 
 type NpmUser struct {
@@ -43,6 +143,31 @@ type NpmUser struct {
 	Email string `json:"email"`
 }
 
+// Maintainers are the npm accounts that can publish a package. Some old versions list them as "name <email>" strings.
+type Maintainers []NpmUser
+
+func (m *Maintainers) UnmarshalJSON(bytes []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(bytes, &items); err != nil {
+		// not a list, ignore it instead of failing the whole package
+		*m = nil
+		return nil
+	}
+	var maintainers Maintainers
+	for _, item := range items {
+		var user NpmUser
+		var raw string
+		if err := json.Unmarshal(item, &user); err == nil {
+			maintainers = append(maintainers, user)
+		} else if err := json.Unmarshal(item, &raw); err == nil {
+			name, email, _ := strings.Cut(raw, " <")
+			maintainers = append(maintainers, NpmUser{Name: strings.TrimSpace(name), Email: strings.TrimSuffix(email, ">")})
+		}
+	}
+	*m = maintainers
+	return nil
+}
+
 type VersionInfo struct {
 	Name            string            `json:"name"`
 	Version         string            `json:"version"`
@@ -52,11 +177,46 @@ type VersionInfo struct {
 	Dependencies    map[string]string `json:"dependencies"`
 	DevDependencies map[string]string `json:"devDependencies"`
 	NpmUser         NpmUser           `json:"_npmUser"`
+	Maintainers     Maintainers       `json:"maintainers"`
 	Dist            Dist              `json:"dist"`
+	Types           interface{}       `json:"types"`   // declarations of typescript types in the package, usually a path
+	Typings         interface{}       `json:"typings"` // older name of types
+	Type            interface{}       `json:"type"`    // module or commonjs, the default
+	Exports         interface{}       `json:"exports"` // entry points, per subpath and condition
+	Module          interface{}       `json:"module"`  // esm entry point for bundlers
+	Repository      interface{}       `json:"repository"`
+	Deprecated      interface{}       `json:"deprecated"` // the message, some old versions have true
 	Os              []string          `json:"os"`
 	Cpu             []string          `json:"cpu"`
 }
 
+// HasTypes returns true when the package bundles its typescript types
+func (v VersionInfo) HasTypes() bool {
+	return (v.Types != nil && v.Types != "") || (v.Typings != nil && v.Typings != "") || strings.HasPrefix(v.Name, "@types/")
+}
+
+// IsDeprecated returns true when the version is deprecated on npm
+func (v VersionInfo) IsDeprecated() bool {
+	return v.Deprecated != nil && v.Deprecated != "" && v.Deprecated != false
+}
+
+// CountMaintainers adds the maintainers of the version to the counts, by account name
+func (v VersionInfo) CountMaintainers(counts map[string]int) {
+	for _, maintainer := range v.Maintainers {
+		if maintainer.Name != "" {
+			counts[maintainer.Name]++
+		}
+	}
+}
+
+// ProvenanceUrl returns the url of the attestations, when the version was published with provenance
+func (v VersionInfo) ProvenanceUrl() string {
+	if attestations := v.Dist.Attestations; attestations != nil && attestations.Provenance.PredicateType == SLSA_PROVENANCE {
+		return attestations.Url
+	}
+	return ""
+}
+
 func (v VersionInfo) GetPublisher() string {
 	var res string
 	if v.NpmUser.Name != "" {
@@ -75,18 +235,77 @@ type PackageInfo struct {
 	DistTags DistTags               `json:"dist-tags"`
 	Versions map[string]VersionInfo `json:"versions"`
 	Time     map[string]time.Time   `json:"time"`
+	Readme   string                 `json:"readme"` // of the latest version, in markdown
+
+	// validators of the registry response, stored in separate columns
+	ETag         string `json:"-"`
+	LastModified string `json:"-"`
 }
 
-func GetPackageInfoRegistry(name string) (*PackageInfo, error) {
-	log.Println("get", name, "from registry")
-	var packageInfo PackageInfo
-	body, err := getBody("https://registry.npmjs.org/" + name)
+func GetPackageInfoRegistry(ctx context.Context, name string) (*PackageInfo, error) {
+	return RevalidatePackageInfoRegistry(ctx, name, nil)
+}
+
+// getFromRegistries tries the urls in order of priority, until one of them does not fail with a transient error or an
+// open breaker. A package that is not found is not looked up further, the first registry is leading.
+func getFromRegistries(ctx context.Context, urls []string, etag string, lastModified string) (*registryResponse, error) {
+	var err error
+	for i, url := range urls {
+		var response *registryResponse
+		err = registryBreaker(url).Call(func() (err error) {
+			response, err = getBodyConditional(ctx, url, etag, lastModified)
+			return
+		})
+		if err == nil {
+			if i > 0 {
+				slog.Info("got package from mirror", "url", url)
+			}
+			return response, nil
+		}
+		if err != RegistryUnavailableError && !isTransient(err) {
+			return nil, err
+		}
+		if i < len(urls)-1 {
+			slog.Warn("registry failed, try the next one", "url", url, "err", err)
+		}
+	}
+	return nil, err
+}
+
+// RevalidatePackageInfoRegistry gets the package from the registry, unless it was not modified since the stale
+// package info was fetched. In that case, the stale package info is returned.
+func RevalidatePackageInfoRegistry(ctx context.Context, name string, stale *PackageInfo) (*PackageInfo, error) {
+	var etag, lastModified string
+	if stale != nil {
+		slog.Debug("revalidate with registry", "package", name)
+		etag, lastModified = stale.ETag, stale.LastModified
+	} else {
+		slog.Debug("get from registry", "package", name)
+	}
+	urls := Config.Registries.PackageUrls(name)
+	var response *registryResponse
+	err := retry(ctx, "get package "+name, RETRY_ATTEMPTS, RETRY_BASE_DELAY, func() (err error) {
+		response, err = getFromRegistries(ctx, urls, etag, lastModified)
+		return
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "could not get package "+name)
 	}
-	if err = json.Unmarshal(body, &packageInfo); err != nil {
+	if response.NotModified && stale != nil {
+		if response.ETag != "" {
+			stale.ETag = response.ETag
+		}
+		if response.LastModified != "" {
+			stale.LastModified = response.LastModified
+		}
+		return stale, nil
+	}
+	var packageInfo PackageInfo
+	if err = json.Unmarshal(response.Body, &packageInfo); err != nil {
 		return nil, errors.Wrap(err, "could not parse json for package "+name)
 	}
+	packageInfo.ETag = response.ETag
+	packageInfo.LastModified = response.LastModified
 	return &packageInfo, nil
 }
 
@@ -116,6 +335,60 @@ func (p *PackageInfo) MaxVersion(constraintRaw string) (VersionInfo, error) {
 	}
 }
 
+// SortedVersions returns the versions from new to old, by semver. Versions that are not valid semver come last.
+func (p *PackageInfo) SortedVersions() []string {
+	var valid []*semver.Version
+	var invalid []string
+	for versionRaw := range p.Versions {
+		if version, err := semver.NewVersion(versionRaw); err == nil {
+			valid = append(valid, version)
+		} else {
+			invalid = append(invalid, versionRaw)
+		}
+	}
+	sort.Sort(sort.Reverse(semver.Collection(valid)))
+	sort.Strings(invalid)
+	var versions []string
+	for _, version := range valid {
+		versions = append(versions, version.Original())
+	}
+	return append(versions, invalid...)
+}
+
+// ReleasesPerMonth counts the published versions per month, from the month of the first version to the month of the
+// last one. The time map of the registry also has created and modified, those are skipped.
+func (p *PackageInfo) ReleasesPerMonth() (first time.Time, counts []int) {
+	var times []time.Time
+	for version, t := range p.Time {
+		if _, ok := p.Versions[version]; ok {
+			times = append(times, t.UTC())
+		}
+	}
+	if len(times) == 0 {
+		return first, nil
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	month := func(t time.Time) int { return t.Year()*12 + int(t.Month()) - 1 }
+	start := month(times[0])
+	counts = make([]int, month(times[len(times)-1])-start+1)
+	for _, t := range times {
+		counts[month(t)-start]++
+	}
+	return time.Date(times[0].Year(), times[0].Month(), 1, 0, 0, 0, 0, time.UTC), counts
+}
+
+// LastReleaseTime is when the last version was published, which is not always the latest one, like a fix of an old
+// major version
+func (p *PackageInfo) LastReleaseTime() time.Time {
+	var last time.Time
+	for version, t := range p.Time {
+		if _, ok := p.Versions[version]; ok && t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
 func (p *PackageInfo) LatestVersion() VersionInfo {
 	return p.Versions[p.DistTags.Latest]
 }
@@ -129,17 +402,67 @@ type Stats struct {
 	Versions           int                `json:"versions"`
 	Files              int                `json:"files"`
 	DiskSpace          int64              `json:"diskSpace"`
+	DownloadSize       int64              `json:"downloadSize"` // of the tarballs whose size is known
 	VulnerabilityStats VulnerabilityStats `json:"vulnerabilityStats"`
+
+	// the dependencies split in the direct ones and the rest, the package itself is in neither
+	Direct     BreakdownStats `json:"direct"`
+	Transitive BreakdownStats `json:"transitive"`
+}
+
+// BreakdownStats count a part of the dependencies
+type BreakdownStats struct {
+	Packages        int   `json:"packages"`
+	Versions        int   `json:"versions"`
+	DiskSpace       int64 `json:"diskSpace"`
+	Vulnerabilities int   `json:"vulnerabilities"`
+}
+
+// ANALYSIS_VERSION is the version of the gathering logic, increment it when the logic changes. Stored versions with an
+// older analysis version are stale and will be gathered again.
+const ANALYSIS_VERSION = 17
+
+// DependencyDetails are the facts of a resolved version of a dependency, for the tables and the treemap
+type DependencyDetails struct {
+	Size         int64           `json:"size"` // unpacked, in bytes
+	Files        int             `json:"files"`
+	Publisher    string          `json:"publisher"`
+	Tarball      string          `json:"tarball"`
+	TarballSize  int64           `json:"tarballSize"` // gzipped, what is downloaded
+	Types        bool            `json:"types"`       // bundles typescript types
+	Format       ModuleFormat    `json:"format"`
+	Published    time.Time       `json:"published"`
+	LastRelease  time.Time       `json:"lastRelease"` // of any version of the package
+	Shasum       string          `json:"shasum"`
+	Integrity    string          `json:"integrity"`
+	Signatures   []DistSignature `json:"signatures"`
+	Repository   string          `json:"repository"`   // normalized, see NormalizeRepository
+	Attestations string          `json:"attestations"` // url, when published with provenance
+	Provenance   string          `json:"provenance"`   // normalized repository that the provenance says it was built from
+	License      string          `json:"license"`      // spdx expression, see LicenseName
+	Deprecated   bool            `json:"deprecated"`
+	Maintainers  int             `json:"maintainers"` // of the version, for the bus factor
 }
 
 type Version struct {
-	Info            VersionInfo         `json:"info"`
-	Time            time.Time           `json:"time"`
-	Dependencies    map[string][]string `json:"dependencies"`
-	Publishers      map[string]int      `json:"publishers"`
-	Vulnerabilities []Vulnerability     `json:"vulnerabilities"`
-	Stats           Stats               `json:"stats"`
-	Errors          []string            `json:"error"`
+	AnalysisVersion int                          `json:"analysisVersion"`
+	Ecosystem       string                       `json:"ecosystem,omitempty"` // empty for npm, see ECOSYSTEM_NUGET and ECOSYSTEM_JSR
+	Info            VersionInfo                  `json:"info"`
+	Time            time.Time                    `json:"time"`
+	Dependencies    map[string][]string          `json:"dependencies"`
+	Edges           map[string][]string          `json:"edges"`     // dependency key -> the dependency keys it resolved to
+	Details         map[string]DependencyDetails `json:"details"`   // dependency key -> details
+	Readme          string                       `json:"readme"`    // of the latest version of the package
+	Downloads       map[string]int64             `json:"downloads"` // weekly, of the package and its direct dependencies
+	Typings         map[string]Typings           `json:"typings"`   // of the package and its direct dependencies
+	Publishers      map[string]int               `json:"publishers"`
+	Maintainers     map[string]int               `json:"maintainers"` // npm account -> the number of versions it can publish
+	Vulnerabilities []Vulnerability              `json:"vulnerabilities"`
+	Stats           Stats                        `json:"stats"`
+	Offenders       Offenders                    `json:"offenders"`
+	Errors          []string                     `json:"error"`
+	Locked          bool                         `json:"locked,omitempty"` // the tree of an upload is from its lockfile
+	Drift           []Drift                      `json:"drift,omitempty"`  // of the lockfile from the package.json
 }
 
 func NewVersion(versionInfo VersionInfo, time time.Time) *Version {
@@ -154,17 +477,152 @@ func NewVersion(versionInfo VersionInfo, time time.Time) *Version {
 	if publisher != "" {
 		publishers[publisher] = 1
 	}
+	maintainers := map[string]int{}
+	versionInfo.CountMaintainers(maintainers)
 	return &Version{
-		Info:         versionInfo,
-		Time:         time,
-		Dependencies: map[string][]string{},
-		Publishers:   publishers,
-		Stats:        stats,
+		AnalysisVersion: ANALYSIS_VERSION,
+		Info:            versionInfo,
+		Time:            time,
+		Dependencies:    map[string][]string{},
+		Edges:           map[string][]string{},
+		Details:         map[string]DependencyDetails{},
+		Publishers:      publishers,
+		Maintainers:     maintainers,
+		Stats:           stats,
 	}
 }
 
+func (v *Version) IsStale() bool {
+	return v.AnalysisVersion < ANALYSIS_VERSION
+}
+
+// DependencyKey identifies a version of a package in the edges
+func DependencyKey(name string, version string) string {
+	return name + "@" + version
+}
+
+// SplitDependencyKey is the reverse of DependencyKey, scoped names also start with @
+func SplitDependencyKey(key string) (name string, version string) {
+	i := strings.LastIndex(key, "@")
+	if i < 0 {
+		return key, ""
+	}
+	return key[:i], key[i+1:]
+}
+
+// SEARCH_MAX is the maximum number of dependencies that FindDependencies returns
+const SEARCH_MAX = 100
+
+// FindDependencies returns the resolved versions whose package name contains the search, each with the shortest path of
+// dependency keys from the root to it. The nearest dependencies come first.
+func (v *Version) FindDependencies(search string) [][]string {
+	search = strings.ToLower(search)
+	var paths [][]string
+	v.walkBreadthFirst(func(key string, path func() []string) bool {
+		if name, _ := SplitDependencyKey(key); strings.Contains(strings.ToLower(name), search) {
+			paths = append(paths, path())
+		}
+		return len(paths) < SEARCH_MAX
+	})
+	return paths
+}
+
+// walkBreadthFirst visits the resolved versions from the root, the nearest first, until visit returns false. The path
+// function returns the shortest path of dependency keys from the root to the visited key.
+func (v *Version) walkBreadthFirst(visit func(key string, path func() []string) bool) {
+	root := DependencyKey(v.Info.Name, v.Info.Version)
+	from := map[string]string{root: ""}
+	queue := []string{root}
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		if key != root {
+			path := func() []string {
+				var path []string
+				for k := key; k != root; k = from[k] {
+					path = append([]string{k}, path...)
+				}
+				return path
+			}
+			if !visit(key, path) {
+				return
+			}
+		}
+		children := append([]string{}, v.Edges[key]...)
+		sort.Strings(children)
+		for _, child := range children {
+			if _, seen := from[child]; !seen {
+				from[child] = key
+				queue = append(queue, child)
+			}
+		}
+	}
+}
+
+// MajorConflict is a package that is resolved at more than one major version, with the shortest path to each of them.
+// These duplicates are often left behind by an incomplete upgrade, and make bundles larger.
+type MajorConflict struct {
+	Name  string
+	Paths [][]string // per major version, the lowest major first
+}
+
+// MajorConflicts returns the packages with more than one major version in the tree, by name
+func (v *Version) MajorConflicts() []MajorConflict {
+	majors := map[string]map[uint64]bool{}
+	for name, versions := range v.Dependencies {
+		for _, versionRaw := range versions {
+			if version, err := semver.NewVersion(versionRaw); err == nil {
+				if majors[name] == nil {
+					majors[name] = map[uint64]bool{}
+				}
+				majors[name][version.Major()] = true
+			}
+		}
+	}
+	paths := map[string]map[uint64][]string{}
+	v.walkBreadthFirst(func(key string, path func() []string) bool {
+		name, versionRaw := SplitDependencyKey(key)
+		if len(majors[name]) < 2 {
+			return true
+		}
+		version, err := semver.NewVersion(versionRaw)
+		if err != nil {
+			return true
+		}
+		if paths[name] == nil {
+			paths[name] = map[uint64][]string{}
+		}
+		if _, ok := paths[name][version.Major()]; !ok {
+			paths[name][version.Major()] = path()
+		}
+		return true
+	})
+	var conflicts []MajorConflict
+	for name, perMajor := range paths {
+		if len(perMajor) < 2 {
+			continue
+		}
+		var keys []uint64
+		for major := range perMajor {
+			keys = append(keys, major)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		conflict := MajorConflict{Name: name}
+		for _, major := range keys {
+			conflict.Paths = append(conflict.Paths, perMajor[major])
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Name < conflicts[j].Name })
+	return conflicts
+}
+
 func HasMatchingVersion(versions []string, constraint *semver.Constraints) bool {
-	ok := false
+	return MatchingVersion(versions, constraint) != ""
+}
+
+// MatchingVersion returns the first of the versions that matches the constraint, or ""
+func MatchingVersion(versions []string, constraint *semver.Constraints) string {
 	for _, vRaw := range versions {
 		v, err := semver.NewVersion(vRaw)
 		if err != nil {
@@ -172,17 +630,93 @@ func HasMatchingVersion(versions []string, constraint *semver.Constraints) bool
 		}
 		valid, _ := constraint.Validate(v)
 		if valid {
-			ok = true
-			break
+			return vRaw
+		}
+	}
+	return ""
+}
+
+// Affected returns true if the package or one of its resolved dependencies has a vulnerable version
+func (v *Version) Affected(vulnerability Vulnerability) bool {
+	name := vulnerability.PackageName
+	var depVersions []string
+	if name == v.Info.Name {
+		depVersions = []string{v.Info.Version}
+	} else {
+		depVersions = v.Dependencies[name]
+	}
+	for _, depVersion := range depVersions {
+		depV, err := semver.NewVersion(depVersion)
+		if err != nil {
+			slog.Warn("invalid version", "version", depVersion, "err", err)
+			continue
+		}
+		for _, expr := range vulnerability.Semver.Vulnerable {
+			c, err := semver.NewConstraint(expr)
+			if err != nil {
+				slog.Warn("invalid constraint", "constraint", expr, "err", err)
+				continue
+			}
+			if c.Check(depV) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// detailUrls returns the urls of the details by dependency key, like the tarballs, for the details that have one
+func (v *Version) detailUrls(url func(details DependencyDetails) string) map[string]string {
+	urls := map[string]string{}
+	for key, details := range v.Details {
+		if u := url(details); u != "" {
+			urls[key] = u
 		}
 	}
-	return ok
+	return urls
 }
 
-func (v *Version) GatherVulnerabilities() error {
-	packageNames := []string{v.Info.Name}
+// fetchConcurrently fetches the urls by key, at most concurrency at a time. The results are passed to found one at a
+// time, so it can write to the version. It returns the number of urls that could not be fetched.
+func fetchConcurrently[T any](urls map[string]string, concurrency int, fetch func(url string) (T, error),
+	found func(key string, result T)) int {
+	var m sync.Mutex // protects failed and the calls of found
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	failed := 0
+	for key, url := range urls {
+		key, url := key, url
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			result, err := fetch(url)
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				failed++
+				return
+			}
+			found(key, result)
+		}()
+	}
+	wg.Wait()
+	return failed
+}
+
+// GatherVulnerabilities finds the vulnerabilities of the package and its dependencies. An uploaded file is not
+// published, so it is skipped itself.
+func (v *Version) GatherVulnerabilities(file bool) error {
+	// the advisories are of npm packages, the JSR packages in a tree are left out
+	var packageNames []string
+	if !file && isNpmPackage(v.Ecosystem, v.Info.Name) {
+		packageNames = append(packageNames, v.Info.Name)
+	}
 	for name := range v.Dependencies {
-		packageNames = append(packageNames, name)
+		if isNpmPackage(v.Ecosystem, name) {
+			packageNames = append(packageNames, name)
+		}
 	}
 	allVulnerabilities, err := DbGetVulnerabilitiesForPackages(packageNames)
 	if err != nil {
@@ -190,32 +724,7 @@ func (v *Version) GatherVulnerabilities() error {
 	}
 	var vulnerabilities []Vulnerability
 	for _, vulnerability := range allVulnerabilities {
-		match := false
-		name := vulnerability.PackageName
-		var depVersions []string
-		if name == v.Info.Name {
-			depVersions = []string{v.Info.Version}
-		} else {
-			depVersions = v.Dependencies[name]
-		}
-		for _, depVersion := range depVersions {
-			depV, err := semver.NewVersion(depVersion)
-			if err != nil {
-				log.Println("err in version", depVersion, err)
-				continue
-			}
-			for _, expr := range vulnerability.Semver.Vulnerable {
-				c, err := semver.NewConstraint(expr)
-				if err != nil {
-					log.Println("err in constraint", expr, err)
-					continue
-				}
-				if c.Check(depV) {
-					match = true
-				}
-			}
-		}
-		if match {
+		if v.Affected(vulnerability) {
 			vulnerabilities = append(vulnerabilities, vulnerability)
 		}
 	}
@@ -225,68 +734,256 @@ func (v *Version) GatherVulnerabilities() error {
 	return nil
 }
 
-func (p VersionInfo) GatherDependencies(parent *Version, alsoDev bool) {
-	if len(p.Dependencies) > 0 || (alsoDev && len(p.DevDependencies) > 0) {
-		var names []string
-		var constraints []string
-		var futures []*Future
-		for name, constraintRaw := range p.Dependencies {
-			names = append(names, name)
-			constraints = append(constraints, constraintRaw)
-			futures = append(futures, packagePool.ProcessKey(name))
-		}
-		if alsoDev {
-			for name, constraintRaw := range p.DevDependencies {
-				names = append(names, name)
-				constraints = append(constraints, constraintRaw)
-				futures = append(futures, packagePool.ProcessKey(name))
+// GatherBreakdown splits the dependencies in direct and transitive ones, after the dependencies and vulnerabilities
+// are gathered. A package of which one version is a direct dependency counts as direct.
+func (v *Version) GatherBreakdown() {
+	root := DependencyKey(v.Info.Name, v.Info.Version)
+	directKeys := map[string]bool{}
+	directNames := map[string]bool{}
+	for _, key := range v.Edges[root] {
+		name, _ := SplitDependencyKey(key)
+		directKeys[key] = true
+		directNames[name] = true
+	}
+	var direct, transitive BreakdownStats
+	transitiveNames := map[string]bool{}
+	for key, details := range v.Details {
+		name, _ := SplitDependencyKey(key)
+		if directKeys[key] {
+			direct.Versions++
+			direct.DiskSpace += details.Size
+		} else {
+			transitive.Versions++
+			transitive.DiskSpace += details.Size
+			if !directNames[name] {
+				transitiveNames[name] = true
 			}
 		}
-		for i, future := range futures {
-			name := names[i]
-			constraintRaw := constraints[i]
-			result := future.Await()
-			if result.Error != nil {
-				parent.Errors = append(parent.Errors, "could not get "+name+": "+result.Error.Error())
-				continue
-			}
-			packageInfo := result.Data.(*PackageInfo)
-			constraint, err := semver.NewConstraint(constraintRaw)
-			if err != nil {
-				parent.Errors = append(parent.Errors, "invalid constraint for "+name+" constraint "+constraintRaw+": "+err.Error())
-				continue
-			}
-			childVersion, err := packageInfo.MaxVersion(constraintRaw)
-			if err != nil {
-				parent.Errors = append(parent.Errors, "no matching version for "+name+" constraint "+constraintRaw+": "+err.Error())
-				continue
-			}
-			if !childVersion.MatchPlatform("linux", "x64") {
-				continue
-			}
-			gather := false
-			dependencies := parent.Dependencies
-			stats := &parent.Stats
-			if versions, hasDepend := dependencies[name]; hasDepend {
-				if !HasMatchingVersion(versions, constraint) {
-					dependencies[name] = append(dependencies[name], childVersion.Version)
-					gather = true
+	}
+	direct.Packages = len(directNames)
+	transitive.Packages = len(transitiveNames)
+	for _, vulnerability := range v.Vulnerabilities {
+		if directNames[vulnerability.PackageName] {
+			direct.Vulnerabilities++
+		} else if vulnerability.PackageName != v.Info.Name {
+			transitive.Vulnerabilities++
+		}
+	}
+	v.Stats.Direct = direct
+	v.Stats.Transitive = transitive
+}
+
+// OFFENDERS_TOP is the number of packages in each list of the offenders
+const OFFENDERS_TOP = 5
+
+// Offender is a package with what it contributes to a stat, all its versions added up
+type Offender struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+// Offenders are the packages that contribute most to the stats, the largest first
+type Offenders struct {
+	Files           []Offender `json:"files"`
+	DiskSpace       []Offender `json:"diskSpace"`
+	Vulnerabilities []Offender `json:"vulnerabilities"`
+}
+
+// topOffenders returns the packages with the highest values, by name when they are equal. Packages without a value
+// are left out.
+func topOffenders(values map[string]int64) []Offender {
+	var offenders []Offender
+	for name, value := range values {
+		if value > 0 {
+			offenders = append(offenders, Offender{name, value})
+		}
+	}
+	sort.Slice(offenders, func(i, j int) bool {
+		if offenders[i].Value != offenders[j].Value {
+			return offenders[i].Value > offenders[j].Value
+		}
+		return offenders[i].Name < offenders[j].Name
+	})
+	if len(offenders) > OFFENDERS_TOP {
+		offenders = offenders[:OFFENDERS_TOP]
+	}
+	return offenders
+}
+
+// GatherOffenders finds the dependencies that add the most files, disk space and vulnerabilities, after the
+// dependencies and vulnerabilities are gathered
+func (v *Version) GatherOffenders() {
+	files := map[string]int64{}
+	diskSpace := map[string]int64{}
+	vulnerabilities := map[string]int64{}
+	for key, details := range v.Details {
+		name, _ := SplitDependencyKey(key)
+		files[name] += int64(details.Files)
+		diskSpace[name] += details.Size
+	}
+	for _, vulnerability := range v.Vulnerabilities {
+		if vulnerability.PackageName != v.Info.Name {
+			vulnerabilities[vulnerability.PackageName]++
+		}
+	}
+	v.Offenders = Offenders{
+		Files:           topOffenders(files),
+		DiskSpace:       topOffenders(diskSpace),
+		Vulnerabilities: topOffenders(vulnerabilities),
+	}
+}
+
+// Footprint is what a direct dependency adds by itself: the versions that no other direct dependency brings in
+type Footprint struct {
+	Key       string // dependency key of the direct dependency
+	Packages  int    // including the direct dependency itself
+	Files     int
+	DiskSpace int64
+}
+
+// Footprints returns the footprint of every direct dependency, the heaviest first
+func (v *Version) Footprints() []Footprint {
+	root := DependencyKey(v.Info.Name, v.Info.Version)
+	reachable := func(start string) map[string]bool {
+		seen := map[string]bool{start: true}
+		stack := []string{start}
+		for len(stack) > 0 {
+			key := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, child := range v.Edges[key] {
+				if !seen[child] && child != root {
+					seen[child] = true
+					stack = append(stack, child)
 				}
-			} else {
-				dependencies[name] = []string{childVersion.Version}
-				gather = true
-				stats.Packages++
 			}
-			if gather {
-				publisher := childVersion.GetPublisher()
-				parent.Publishers[publisher]++
-				stats.Versions++
-				stats.Files += childVersion.Dist.FileCount
-				stats.DiskSpace += childVersion.Dist.UnpackedSize
-				childVersion.GatherDependencies(parent, false)
+		}
+		return seen
+	}
+
+	direct := v.Edges[root]
+	perDirect := make([]map[string]bool, len(direct))
+	reachedBy := map[string]int{}
+	for i, key := range direct {
+		perDirect[i] = reachable(key)
+		for reached := range perDirect[i] {
+			reachedBy[reached]++
+		}
+	}
+	var footprints []Footprint
+	for i, key := range direct {
+		footprint := Footprint{Key: key}
+		names := map[string]bool{}
+		for reached := range perDirect[i] {
+			if reachedBy[reached] > 1 {
+				continue
 			}
+			name, _ := SplitDependencyKey(reached)
+			names[name] = true
+			details := v.Details[reached]
+			footprint.Files += details.Files
+			footprint.DiskSpace += details.Size
 		}
+		footprint.Packages = len(names)
+		footprints = append(footprints, footprint)
 	}
+	sort.SliceStable(footprints, func(i, j int) bool { return footprints[i].DiskSpace > footprints[j].DiskSpace })
+	return footprints
+}
+
+// npmResolver resolves npm packages from the registry, only the versions for linux on x64 are installed
+type npmResolver struct{}
+
+func (r npmResolver) Prefix() string {
+	return "npm"
+}
+
+func (r npmResolver) NamePatterns() []string {
+	return nil
+}
+
+func (r npmResolver) Package(name string) *Future {
+	return packagePool.ProcessKey(name)
+}
+
+func (r npmResolver) RequestPackage(ctx context.Context, name string) (Data, error) {
+	return RequestPackageInfo(ctx, name)
+}
+
+func (r npmResolver) LatestVersion(data Data) (string, bool) {
+	latest := data.(*PackageInfo).DistTags.Latest
+	return latest, latest != ""
+}
+
+func (r npmResolver) SelectVersion(data Data, dependency EcosystemDependency) (Selection, error) {
+	constraint, err := semver.NewConstraint(dependency.Constraint)
+	if err != nil {
+		return Selection{}, errors.Wrap(err, "invalid constraint")
+	}
+	childVersion, err := data.(*PackageInfo).MaxVersion(dependency.Constraint)
+	if err != nil {
+		return Selection{}, err
+	}
+	if !childVersion.MatchPlatform("linux", "x64") {
+		return Selection{}, nil
+	}
+	return Selection{Name: dependency.Name, Version: childVersion.Version, Constraint: constraint}, nil
+}
+
+func (r npmResolver) Dependencies(ctx context.Context, data Data, version string) ([]EcosystemDependency, error) {
+	return data.(*PackageInfo).Versions[version].npmDependencies(false), nil
+}
+
+func (r npmResolver) AddDetails(parent *Version, data Data, name string, version string) {
+	packageInfo := data.(*PackageInfo)
+	childVersion := packageInfo.Versions[version]
+	publisher := childVersion.GetPublisher()
+	parent.Publishers[publisher]++
+	childVersion.CountMaintainers(parent.Maintainers)
+	parent.Stats.Versions++
+	parent.Stats.Files += childVersion.Dist.FileCount
+	parent.Stats.DiskSpace += childVersion.Dist.UnpackedSize
+	parent.Details[DependencyKey(name, version)] = DependencyDetails{
+		Size:         childVersion.Dist.UnpackedSize,
+		Files:        childVersion.Dist.FileCount,
+		Publisher:    publisher,
+		Tarball:      childVersion.Dist.Tarball,
+		Types:        childVersion.HasTypes(),
+		Format:       childVersion.ModuleFormat(),
+		Published:    packageInfo.Time[version],
+		LastRelease:  packageInfo.LastReleaseTime(),
+		Shasum:       childVersion.Dist.Shasum,
+		Integrity:    childVersion.Dist.Integrity,
+		Signatures:   childVersion.Dist.Signatures,
+		Repository:   NormalizeRepository(childVersion.Repository),
+		Attestations: childVersion.ProvenanceUrl(),
+		License:      LicenseName(childVersion.License),
+		Deprecated:   childVersion.IsDeprecated(),
+		Maintainers:  len(childVersion.Maintainers),
+	}
+}
+
+func (r npmResolver) VersionFuture(name string, version string) *Future {
+	return VersionFuture(name, version)
+}
+
+// npmDependencies returns the dependencies of a package.json, the dev dependencies only for the root of an upload
+func (p VersionInfo) npmDependencies(alsoDev bool) []EcosystemDependency {
+	var dependencies []EcosystemDependency
+	for name, constraintRaw := range p.Dependencies {
+		dependencies = append(dependencies, EcosystemDependency{npmResolver{}, name, constraintRaw})
+	}
+	if alsoDev {
+		for name, constraintRaw := range p.DevDependencies {
+			dependencies = append(dependencies, EcosystemDependency{npmResolver{}, name, constraintRaw})
+		}
+	}
+	sort.SliceStable(dependencies, func(i, j int) bool { return dependencies[i].Name < dependencies[j].Name })
+	return dependencies
+}
+
+// GatherDependencies adds the dependencies of the package.json to the parent recursively, see GatherResolved
+func (p VersionInfo) GatherDependencies(ctx context.Context, parent *Version, alsoDev bool) {
+	parent.GatherResolved(ctx, DependencyKey(p.Name, p.Version), p.npmDependencies(alsoDev))
 }
 
 func strArrContain(array []string, s string) bool {
@@ -312,7 +1009,21 @@ func (p VersionInfo) MatchPlatform(os string, cpu string) bool {
 	return true
 }
 
-func (p *PackageInfo) GatherDependencies(versionRaw string) (*Version, error) {
+// README_MAX_LENGTH limits the readme that is stored with a version, some packages have their whole manual in it
+const README_MAX_LENGTH = 100000
+
+// truncate cuts s to at most n bytes, without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func (p *PackageInfo) GatherDependencies(ctx context.Context, versionRaw string) (*Version, error) {
 	var versionInfo VersionInfo
 	if versionRaw != "" {
 		var ok bool
@@ -324,10 +1035,21 @@ func (p *PackageInfo) GatherDependencies(versionRaw string) (*Version, error) {
 		versionInfo = p.LatestVersion()
 	}
 	parent := NewVersion(versionInfo, p.Time[versionInfo.Version])
-	versionInfo.GatherDependencies(parent, false)
-	if err := parent.GatherVulnerabilities(); err != nil {
+	parent.Readme = truncate(p.Readme, README_MAX_LENGTH)
+	versionInfo.GatherDependencies(ctx, parent, false)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	parent.GatherMissingDistStats(ctx)
+	parent.GatherTarballSizes(ctx)
+	parent.GatherProvenance(ctx)
+	parent.GatherDownloads(ctx, false)
+	parent.GatherTypings(ctx, false)
+	if err := parent.GatherVulnerabilities(false); err != nil {
 		return nil, errors.Wrapf(err, "could not gather vulns for %s version %s", p.Name, versionRaw)
 	}
+	parent.GatherBreakdown()
+	parent.GatherOffenders()
 	return parent, nil
 }
 
@@ -357,12 +1079,14 @@ func (p PackageInfoPerformer) Put(name string, data Data) {
 	packageInfo := data.(*PackageInfo)
 	err := DbPutPackage(name, packageInfo, calcExpire(packageInfo.LatestTime()))
 	if err != nil {
-		log.Println("could not put package "+name+" in db", err)
+		slog.Error("could not put package in db", "package", name, "err", err)
 	}
 }
 
-func (p PackageInfoPerformer) Perform(name string) Result {
-	packageInfo, err := GetPackageInfoRegistry(name)
+func (p PackageInfoPerformer) Perform(ctx context.Context, name string) Result {
+	// an expired package is kept for a while, so it can be revalidated instead of downloaded again
+	stale, _ := DbGetStalePackage(name)
+	packageInfo, err := RevalidatePackageInfoRegistry(ctx, name, stale)
 	if err != nil {
 		return Result{Error: err}
 	}
@@ -371,8 +1095,17 @@ func (p PackageInfoPerformer) Perform(name string) Result {
 
 var packagePool *SmartWorkPool
 
-func GetPackageInfo(name string) (*PackageInfo, error) {
-	result := packagePool.ProcessKey(name).Await()
+func GetPackageInfo(ctx context.Context, name string) (*PackageInfo, error) {
+	return awaitPackageInfo(ctx, packagePool.ProcessKey(name))
+}
+
+// RequestPackageInfo is GetPackageInfo for user requests, it returns BusyError when the pool is saturated
+func RequestPackageInfo(ctx context.Context, name string) (*PackageInfo, error) {
+	return awaitPackageInfo(ctx, packagePool.TryProcessKey(name, Config.Pools.MaxQueued))
+}
+
+func awaitPackageInfo(ctx context.Context, future *Future) (*PackageInfo, error) {
+	result := future.AwaitContext(ctx, 0)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -391,28 +1124,36 @@ func parseVersionKey(key string) (string, string) {
 func (p VersionPerformer) Get(key string) Data {
 	name, versionRaw := parseVersionKey(key)
 	version, err := DbGetVersion(name, versionRaw)
-	if err != nil {
+	if err != nil || version.IsStale() {
 		return nil
 	}
 	return version
 }
 
+// ERROR_VERSION_EXPIRE is the expiration of a version with errors, they are often transient like a registry that is
+// down, so it is gathered again soon
+const ERROR_VERSION_EXPIRE = 10 * time.Minute
+
 func (p VersionPerformer) Put(key string, data Data) {
 	name, versionRaw := parseVersionKey(key)
 	version := data.(*Version)
-	err := DbPutVersion(name, versionRaw, version, calcExpire(version.Time))
+	expireTime := calcExpire(version.Time)
+	if len(version.Errors) > 0 {
+		expireTime = time.Now().Add(ERROR_VERSION_EXPIRE)
+	}
+	err := DbPutVersion(name, versionRaw, version, expireTime)
 	if err != nil {
-		log.Println("could not put version "+key+" in db", err)
+		slog.Error("could not put version in db", "key", key, "err", err)
 	}
 }
 
-func (p VersionPerformer) Perform(key string) Result {
+func (p VersionPerformer) Perform(ctx context.Context, key string) Result {
 	name, versionRaw := parseVersionKey(key)
-	packageInfo, err := GetPackageInfo(name)
+	packageInfo, err := GetPackageInfo(ctx, name)
 	if err != nil {
 		return Result{Error: err}
 	}
-	version, err := packageInfo.GatherDependencies(versionRaw)
+	version, err := packageInfo.GatherDependencies(ctx, versionRaw)
 	if err != nil {
 		return Result{Error: err}
 	}
@@ -421,8 +1162,14 @@ func (p VersionPerformer) Perform(key string) Result {
 
 var versionPool *SmartWorkPool
 
-func GetVersion(name string, version string) (*Version, error) {
-	result := versionPool.ProcessKey(name + "\t" + version).AwaitTimeout(time.Second * 1)
+// VersionFuture starts gathering the dependencies of the version, unless it is already stored or in progress
+func VersionFuture(name string, version string) *Future {
+	return versionPool.TryProcessKey(name+"\t"+version, Config.Pools.MaxQueued)
+}
+
+// GetVersion returns TimeoutError if the version is not ready after the wait duration
+func GetVersion(ctx context.Context, name string, version string, wait time.Duration) (*Version, error) {
+	result := VersionFuture(name, version).AwaitContext(ctx, wait)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -432,7 +1179,11 @@ func GetVersion(name string, version string) (*Version, error) {
 type FilePerformer struct{}
 
 func fileIsReady(version *Version) bool {
-	return len(version.Dependencies) > 0 || len(version.Info.Dependencies) == 0
+	if version.Ecosystem == ECOSYSTEM_NUGET || version.Locked {
+		// the locked tree is there from the upload, the details are gathered
+		return !version.IsStale() && (len(version.Details) > 0 || len(version.Dependencies) == 0)
+	}
+	return !version.IsStale() && (len(version.Dependencies) > 0 || len(version.Info.Dependencies) == 0)
 }
 
 func (p FilePerformer) Get(id string) Data {
@@ -447,36 +1198,123 @@ func (p FilePerformer) Put(id string, data Data) {
 	version := data.(*Version)
 	err := DbPutFile(id, version)
 	if err != nil {
-		log.Println("could not put file "+id+" in db", err)
+		slog.Error("could not put file in db", "id", id, "err", err)
 	}
 }
 
-func (p FilePerformer) Perform(id string) Result {
-	version, err := DbGetFile(id)
+func (p FilePerformer) Perform(ctx context.Context, id string) Result {
+	stored, err := DbGetFile(id)
+	if err != nil {
+		return Result{Error: err}
+	}
+	version, err := AnalyzeFile(ctx, stored)
 	if err != nil {
 		return Result{Error: err}
 	}
-	version.Info.GatherDependencies(version, true)
+	SendAlert(Alert{
+		Kind:  ALERT_ANALYSIS,
+		Title: "Analysis of uploaded " + version.Info.Name + " complete",
+		Text:  fmt.Sprintf("%d packages, %d vulnerabilities", version.Stats.Packages, len(version.Vulnerabilities)),
+		Url:   absoluteUrl("/file/" + id),
+	})
 	return Result{Data: version}
 }
 
+// AnalyzeFile analyzes a parsed file, without storing it
+func AnalyzeFile(ctx context.Context, stored *Version) (*Version, error) {
+	// start from the uploaded info, the stored version may contain the results of an older analysis
+	version := NewVersion(stored.Info, stored.Time)
+	if stored.Ecosystem == ECOSYSTEM_NUGET {
+		// the tree is locked, only the details are gathered
+		version.Ecosystem = ECOSYSTEM_NUGET
+		version.Dependencies = stored.Dependencies
+		version.Edges = stored.Edges
+		version.GatherNugetLock(ctx)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	} else {
+		if stored.Locked {
+			// the tree is locked, the registry only adds the details
+			version.Locked = true
+			version.Dependencies = stored.Dependencies
+			version.Edges = stored.Edges
+			// outdated drift depends on the registry, it is found again
+			for _, drift := range stored.Drift {
+				if drift.Kind != DRIFT_OUTDATED {
+					version.Drift = append(version.Drift, drift)
+				}
+			}
+			version.GatherNpmLock(ctx)
+		} else {
+			version.Info.GatherDependencies(ctx, version, true)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		version.GatherMissingDistStats(ctx)
+		version.GatherTarballSizes(ctx)
+		version.GatherProvenance(ctx)
+		version.GatherDownloads(ctx, true)
+		version.GatherTypings(ctx, true)
+		if err := version.GatherVulnerabilities(true); err != nil {
+			return nil, err
+		}
+		version.GatherBreakdown()
+		version.GatherOffenders()
+	}
+	return version, nil
+}
+
 var filePool *SmartWorkPool
 
-func GetFile(id string) (*Version, error) {
-	result := filePool.ProcessKey(id).AwaitTimeout(time.Second * 1)
+func FileFuture(id string) *Future {
+	return filePool.TryProcessKey(id, Config.Pools.MaxQueued)
+}
+
+func GetFile(ctx context.Context, id string, wait time.Duration) (*Version, error) {
+	result := FileFuture(id).AwaitContext(ctx, wait)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return result.Data.(*Version), nil
 }
 
+// ABANDON_GRACE is the time after which the gathering of a version or file is cancelled, when nobody waits for it.
+// The wait view keeps an event stream open until it is ready, so this only happens when the user left.
+const ABANDON_GRACE = 10 * time.Second
+
+func ForgetFile(id string) {
+	filePool.Forget(func(key string) bool { return key == id })
+}
+
 func init() {
 	packagePool = NewSmartWorkPool(PackageInfoPerformer{})
 	packagePool.Start(8)
 
+	nugetPackagePool = NewSmartWorkPool(NugetPackagePerformer{})
+	nugetPackagePool.Start(8)
+
+	nugetVersionPool = NewSmartWorkPool(NugetVersionPerformer{})
+	nugetVersionPool.Start(2)
+	nugetVersionPool.CancelAbandoned("nuget versions", ABANDON_GRACE)
+
+	RegisterResolver(npmResolver{})
+	RegisterResolver(nugetResolver{})
+	RegisterResolver(jsrResolver{})
+
+	jsrPackagePool = NewSmartWorkPool(JsrPackagePerformer{})
+	jsrPackagePool.Start(8)
+
+	jsrVersionPool = NewSmartWorkPool(JsrVersionPerformer{})
+	jsrVersionPool.Start(2)
+	jsrVersionPool.CancelAbandoned("jsr versions", ABANDON_GRACE)
+
 	versionPool = NewSmartWorkPool(VersionPerformer{})
 	versionPool.Start(4)
+	versionPool.CancelAbandoned("versions", ABANDON_GRACE)
 
 	filePool = NewSmartWorkPool(FilePerformer{})
 	filePool.Start(4)
+	filePool.CancelAbandoned("files", ABANDON_GRACE)
 }
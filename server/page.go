@@ -1,33 +1,407 @@
 package server
 
 import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	gohtml "html"
+	"io/fs"
 	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/html"
+	toml "github.com/pelletier/go-toml"
 	"github.com/pkg/errors"
 )
 
 type Page struct {
-	Title   string
-	Content string
+	Path        string
+	Title       string
+	Description string
+	Image       string // for link previews
+	Order       int    // only pages with an order are in the navigation
+	Parent      string
+	Content     string
+}
+
+// FrontMatter is the metadata at the start of a markdown page, as toml between +++ lines or as yaml between --- lines
+type FrontMatter struct {
+	Title       string `toml:"title"`
+	Description string `toml:"description"`
+	Image       string `toml:"image"`
+	Order       int    `toml:"order"`
+	Parent      string `toml:"parent"`
 }
 
 var H1RE = regexp.MustCompile(`^\s*# (.*)\n`)
 
+var pagePathRE = regexp.MustCompile(`^[a-z0-9\-]+(/[a-z0-9\-]+)*$`)
+
+// splitFrontMatter returns the front matter, its delimiter and the rest of the markdown
+func splitFrontMatter(md []byte) (string, string, []byte) {
+	md = bytes.ReplaceAll(md, []byte("\r\n"), []byte("\n"))
+	for _, delimiter := range []string{"+++", "---"} {
+		if !bytes.HasPrefix(md, []byte(delimiter+"\n")) {
+			continue
+		}
+		rest := md[len(delimiter)+1:]
+		end := bytes.Index(rest, []byte("\n"+delimiter+"\n"))
+		if end < 0 {
+			continue
+		}
+		return string(rest[:end+1]), delimiter, rest[end+len(delimiter)+2:]
+	}
+	return "", "", md
+}
+
+// parseYamlFrontMatter only supports the flat "key: value" lines that are needed for the front matter
+func parseYamlFrontMatter(raw string, frontMatter *FrontMatter) error {
+	for i, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return errors.Errorf("line %d: expected key: value", i+1)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		switch strings.TrimSpace(key) {
+		case "title":
+			frontMatter.Title = value
+		case "description":
+			frontMatter.Description = value
+		case "image":
+			frontMatter.Image = value
+		case "parent":
+			frontMatter.Parent = value
+		case "order":
+			order, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.Errorf("line %d: order is not a number: %s", i+1, value)
+			}
+			frontMatter.Order = order
+		}
+	}
+	return nil
+}
+
+func parseFrontMatter(md []byte) (FrontMatter, []byte, error) {
+	var frontMatter FrontMatter
+	raw, delimiter, rest := splitFrontMatter(md)
+	var err error
+	if delimiter == "+++" {
+		err = toml.Unmarshal([]byte(raw), &frontMatter)
+	} else if delimiter == "---" {
+		err = parseYamlFrontMatter(raw, &frontMatter)
+	}
+	return frontMatter, rest, errors.Wrap(err, "invalid front matter")
+}
+
+// readmeFlags render a readme from the registry safely: raw html is skipped and links with unsafe protocols too
+const readmeFlags = html.CommonFlags | html.SkipHTML | html.Safelink | html.NofollowLinks | html.NoreferrerLinks |
+	html.HrefTargetBlank | html.LazyLoadImages
+
+// RenderReadme returns the html of the markdown of a package readme
+func RenderReadme(md string) string {
+	renderer := html.NewRenderer(html.RendererOptions{Flags: readmeFlags})
+	return string(markdown.ToHTML([]byte(md), nil, renderer))
+}
+
+// newPage renders the markdown, the title is the one of the front matter, else the first heading, else the path
+func newPage(path string, md []byte) (Page, error) {
+	frontMatter, rest, err := parseFrontMatter(md)
+	if err != nil {
+		return Page{Title: path}, err
+	}
+	page := Page{
+		Path:        path,
+		Title:       frontMatter.Title,
+		Description: frontMatter.Description,
+		Image:       frontMatter.Image,
+		Order:       frontMatter.Order,
+		Parent:      strings.Trim(frontMatter.Parent, "/"),
+		Content:     string(markdown.ToHTML(rest, nil, nil)),
+	}
+	if page.Title == "" {
+		if matches := H1RE.FindStringSubmatch(string(rest)); len(matches) == 2 {
+			page.Title = matches[1]
+		} else {
+			page.Title = path
+		}
+	}
+	return page, nil
+}
+
+func newDbPage(row PageRow) (Page, error) {
+	page, err := newPage(row.Path, []byte(row.Content))
+	if row.Title != "" {
+		page.Title = row.Title
+	}
+	return page, err
+}
+
+// GetPage returns a published page from the database, or else the markdown file in the pages directory
 func GetPage(path string) (Page, error) {
-	page := Page{Title: path}
-	filePath := Config.Pages.Path + "/" + path + ".md"
+	row, err := DbGetPublishedPage(path)
+	if err == nil {
+		return newDbPage(*row)
+	}
+	if err != sql.ErrNoRows {
+		return Page{Title: path}, errors.Wrap(err, "could not get page "+path)
+	}
+	if Config.Pages.Path == "" {
+		return Page{Title: path}, errors.New("no page " + path)
+	}
+
+	return getFilePage(path, Config.Pages.Path+"/"+path+".md")
+}
+
+type cachedPage struct {
+	page    Page
+	err     error
+	modTime time.Time
+	size    int64
+}
+
+// pageCache has the parsed markdown files by path, they are parsed again when the file is modified
+var pageCache = struct {
+	sync.Mutex
+	pages map[string]cachedPage
+}{pages: map[string]cachedPage{}}
+
+func getFilePage(path string, filePath string) (Page, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		pageCache.Lock()
+		delete(pageCache.pages, path)
+		pageCache.Unlock()
+		return Page{Title: path}, errors.Wrap(err, "could not read page: "+filePath)
+	}
+
+	pageCache.Lock()
+	cached, ok := pageCache.pages[path]
+	pageCache.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.page, cached.err
+	}
+
 	md, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		return page, errors.Wrap(err, "could not read page: "+filePath)
+		return Page{Title: path}, errors.Wrap(err, "could not read page: "+filePath)
 	}
-	page.Content = string(markdown.ToHTML(md, nil, nil))
+	page, err := newPage(path, md)
+	pageCache.Lock()
+	pageCache.pages[path] = cachedPage{page: page, err: err, modTime: info.ModTime(), size: info.Size()}
+	pageCache.Unlock()
+	return page, err
+}
 
-	matches := H1RE.FindStringSubmatch(string(md))
-	if len(matches) == 2 {
-		page.Title = matches[1]
+// allPages returns the published pages in the database and the files in the pages directory, without their content
+func allPages() []Page {
+	pages := map[string]Page{}
+	if Config.Pages.Path != "" {
+		err := filepath.WalkDir(Config.Pages.Path, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !strings.HasSuffix(filePath, ".md") {
+				return err
+			}
+			rel, err := filepath.Rel(Config.Pages.Path, filePath)
+			if err != nil {
+				return err
+			}
+			path := strings.TrimSuffix(filepath.ToSlash(rel), ".md")
+			page, err := getFilePage(path, filePath)
+			if err != nil {
+				slog.Warn("could not parse page", "path", filePath, "err", err)
+			}
+			page.Content = ""
+			pages[path] = page
+			return nil
+		})
+		if err != nil {
+			slog.Error("could not read pages", "err", err)
+		}
+	}
+	rows, err := DbListPages()
+	if err != nil {
+		slog.Error("could not get pages", "err", err)
+	}
+	for _, row := range rows {
+		if row.Published {
+			page, err := newDbPage(row)
+			if err != nil {
+				slog.Warn("could not parse page", "path", row.Path, "err", err)
+			}
+			page.Content = ""
+			pages[row.Path] = page
+		}
 	}
 
-	return page, nil
+	var list []Page
+	for _, page := range pages {
+		list = append(list, page)
+	}
+	return list
+}
+
+type NavItem struct {
+	Page     Page
+	Children []*NavItem
+}
+
+func sortNavItems(items []*NavItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Page.Order != items[j].Page.Order {
+			return items[i].Page.Order < items[j].Page.Order
+		}
+		return items[i].Page.Title < items[j].Page.Title
+	})
+	for _, item := range items {
+		sortNavItems(item.Children)
+	}
+}
+
+// buildNavigation returns the tree of the pages with an order, a page with an unknown parent is at the top
+func buildNavigation(pages []Page) []*NavItem {
+	items := map[string]*NavItem{}
+	for _, page := range pages {
+		if page.Order != 0 {
+			items[page.Path] = &NavItem{Page: page}
+		}
+	}
+	var roots []*NavItem
+	for _, item := range items {
+		if parent, ok := items[item.Page.Parent]; ok && item.Page.Parent != item.Page.Path {
+			parent.Children = append(parent.Children, item)
+		} else {
+			roots = append(roots, item)
+		}
+	}
+	sortNavItems(roots)
+	return roots
+}
+
+var navigation struct {
+	sync.RWMutex
+	roots []*NavItem
+}
+
+// LoadNavigation reads the front matter of all pages, it is done at startup and when the pages change
+func LoadNavigation() {
+	roots := buildNavigation(allPages())
+	navigation.Lock()
+	navigation.roots = roots
+	navigation.Unlock()
+}
+
+func Navigation() []*NavItem {
+	navigation.RLock()
+	defer navigation.RUnlock()
+	return navigation.roots
+}
+
+// placeholders like {{packages}} or {{link react}} in pages are replaced with live data when the page is shown
+var placeholderRE = regexp.MustCompile(`\{\{\s*([a-z_]+)(?:\s+([^\s}]+))?\s*\}\}`)
+
+// the counters are the number of rows of these tables
+var pageCounters = []string{"packages", "versions", "files", "vulnerabilities", "users", "watches"}
+
+func placeholderValue(name string, arg string) (string, bool) {
+	if contains(pageCounters, name) && arg == "" {
+		count, err := DbCountRows(name)
+		if err != nil {
+			slog.Error("could not count rows", "table", name, "err", err)
+			return "?", true
+		}
+		return strconv.FormatInt(count, 10), true
+	}
+	switch name {
+	case "vulnerabilities_updated":
+		vulnerability, err := DbLastVulnerability()
+		if err != nil || vulnerability == nil {
+			return "never", true
+		}
+		return gohtml.EscapeString(vulnerability.PublicationTime.Format("2006-01-02")), true
+	case "link":
+		if arg != "" && packageNameRE.MatchString(arg) {
+			return RenderNode(linkPackage(arg)), true
+		}
+	}
+	return "", false
+}
+
+// RenderPlaceholders replaces the placeholders in the html of a page, unknown placeholders are kept
+func RenderPlaceholders(content string) string {
+	return placeholderRE.ReplaceAllStringFunc(content, func(placeholder string) string {
+		match := placeholderRE.FindStringSubmatch(placeholder)
+		if value, ok := placeholderValue(match[1], match[2]); ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+const PAGES_WATCH_INTERVAL = 2 * time.Second
+
+// pagesSignature changes when a markdown file in the pages directory is added, removed or modified
+func pagesSignature() string {
+	var b strings.Builder
+	_ = filepath.WalkDir(Config.Pages.Path, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(filePath, ".md") {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", filePath, info.ModTime().UnixNano(), info.Size())
+		}
+		return nil
+	})
+	return b.String()
+}
+
+// watchPages polls the pages directory, and reloads the navigation when the pages change
+func watchPages() {
+	last := pagesSignature()
+	for {
+		time.Sleep(PAGES_WATCH_INTERVAL)
+		signature := pagesSignature()
+		if signature != last {
+			last = signature
+			slog.Info("pages changed, reload navigation")
+			LoadNavigation()
+		}
+	}
+}
+
+// NavigationSection returns the top level item that contains the page, or nil
+func NavigationSection(path string) *NavItem {
+	var contains func(item *NavItem) bool
+	contains = func(item *NavItem) bool {
+		if item.Page.Path == path {
+			return true
+		}
+		for _, child := range item.Children {
+			if contains(child) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, root := range Navigation() {
+		if contains(root) {
+			return root
+		}
+	}
+	return nil
 }
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CI_DEADLINE is generous, the dependencies of a new file may all have to be fetched
+const CI_DEADLINE = 10 * time.Minute
+
+// CI_WAIT leaves time to answer before the deadline when the analysis is not finished
+const CI_WAIT = CI_DEADLINE - 15*time.Second
+
+// SEVERITIES are ordered from low to critical
+var SEVERITIES = []Severity{Low, Medium, High, Critical}
+
+// severityRank returns the index of the severity in SEVERITIES, or -1 when it is unknown
+func severityRank(severity Severity) int {
+	for i, s := range SEVERITIES {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Violation is a rule of the policy that an analysis breaks
+type Violation struct {
+	Rule    string `json:"rule"` // vulnerabilities, score, packages, deprecated, licenses or errors
+	Message string `json:"message"`
+}
+
+// Verdict is the result of the ci check. Errors of the analysis fail it, the vulnerabilities of the packages that could
+// not be fetched are unknown, unless the policy allows errors.
+type Verdict struct {
+	Pass       bool        `json:"pass"`
+	Violations []Violation `json:"violations"`
+	Score      int         `json:"score"`
+	Grade      string      `json:"grade"`
+	Stats      Stats       `json:"stats"`
+	Errors     []string    `json:"errors"`
+	Report     string      `json:"report"` // the url of the analysis
+}
+
+// namesList lists a few of the names, for a message that stays readable
+func namesList(names []string) string {
+	sort.Strings(names)
+	if len(names) > 5 {
+		return strings.Join(names[:5], ", ") + " and " + strconv.Itoa(len(names)-5) + " more"
+	}
+	return strings.Join(names, ", ")
+}
+
+// CheckPolicy checks the analysis against the rules of the policy
+func (v *Version) CheckPolicy(policy PolicyConfig, now time.Time) Verdict {
+	violations := []Violation{}
+	add := func(rule string, format string, args ...interface{}) {
+		violations = append(violations, Violation{rule, fmt.Sprintf(format, args...)})
+	}
+
+	if rank := severityRank(Severity(policy.FailSeverity)); rank >= 0 {
+		vs := v.Stats.VulnerabilityStats
+		counts := []int{vs.LowCount, vs.MediumCount, vs.HighCount, vs.CriticalCount}
+		failing := 0
+		for _, count := range counts[rank:] {
+			failing += count
+		}
+		if failing > 0 {
+			add("vulnerabilities", "%d vulnerabilities of severity %s or higher", failing, policy.FailSeverity)
+		}
+	}
+
+	health := v.Health(Config.Stale.Years, now)
+	if policy.MinScore > 0 && health.Score < policy.MinScore {
+		add("score", "health score %d is lower than %d", health.Score, policy.MinScore)
+	}
+
+	if policy.MaxPackages > 0 && v.Stats.Packages > policy.MaxPackages {
+		add("packages", "%d packages are more than %d", v.Stats.Packages, policy.MaxPackages)
+	}
+
+	if policy.FailDeprecated {
+		var deprecated []string
+		seen := map[string]bool{}
+		for key, details := range v.Details {
+			name, _ := SplitDependencyKey(key)
+			if details.Deprecated && !seen[name] {
+				seen[name] = true
+				deprecated = append(deprecated, name)
+			}
+		}
+		if len(deprecated) > 0 {
+			add("deprecated", "deprecated dependencies: %s", namesList(deprecated))
+		}
+	}
+
+	if len(policy.DenyLicenses) > 0 {
+		denied := map[string]bool{}
+		for _, license := range policy.DenyLicenses {
+			denied[strings.ToLower(license)] = true
+		}
+		for _, usage := range v.LicenseUsages() {
+			if denied[strings.ToLower(usage.License)] {
+				add("licenses", "license %s is not allowed: %s", usage.License, namesList(usage.Packages))
+			}
+		}
+	}
+
+	if len(v.Errors) > 0 && !policy.AllowErrors {
+		add("errors", "the analysis is incomplete, errors: %d", len(v.Errors))
+	}
+
+	errors := v.Errors
+	if errors == nil {
+		errors = []string{}
+	}
+	return Verdict{
+		Pass:       len(violations) == 0,
+		Violations: violations,
+		Score:      health.Score,
+		Grade:      health.Grade,
+		Stats:      v.Stats,
+		Errors:     errors,
+	}
+}
+
+// sendPolicyAlert posts the violations of a failed check of the source to the webhooks
+func sendPolicyAlert(source string, verdict Verdict, url string) {
+	if verdict.Pass {
+		return
+	}
+	var messages []string
+	for _, violation := range verdict.Violations {
+		messages = append(messages, violation.Message)
+	}
+	SendAlert(Alert{
+		Kind:  ALERT_POLICY,
+		Title: "Policy check of " + source + " failed",
+		Text:  strings.Join(messages, "\n"),
+		Url:   url,
+	})
+}
+
+// ciCheckHandler analyzes the package.json or packages.lock.json in the body, and answers with the verdict of the
+// policy. A failed check is 422, so a pipeline can fail on the status alone, like with curl --fail.
+func ciCheckHandler(writer http.ResponseWriter, request *http.Request) {
+	bytes, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, MAX_UPLOAD_SIZE))
+	if err != nil {
+		jsonError(writer, http.StatusBadRequest, "the file is >1MB", err)
+		return
+	}
+	version, err := parseFile(bytes)
+	if err != nil {
+		jsonError(writer, http.StatusBadRequest, "could not parse file", err)
+		return
+	}
+	id, token, err := createFile(version, bytes, 0)
+	if err != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not store file", err)
+		return
+	}
+	report := absoluteUrl(fileHref(id, token))
+	analyzed, err := GetFile(request.Context(), id, CI_WAIT)
+	if err == TimeoutError {
+		writeJson(writer, http.StatusGatewayTimeout, map[string]string{
+			"error": "the analysis is not finished yet, try again later", "report": report})
+		return
+	}
+	if err == BusyError {
+		writer.Header().Set("Retry-After", strconv.Itoa(BUSY_RETRY_AFTER))
+		jsonError(writer, http.StatusServiceUnavailable, "the server is busy, try again later", nil)
+		return
+	}
+	if err != nil {
+		jsonError(writer, http.StatusInternalServerError, "could not analyze file", err)
+		return
+	}
+	verdict := analyzed.CheckPolicy(Config.Policy, time.Now())
+	verdict.Report = report
+	// the webhooks are shared, so the url has no token to delete the file
+	sendPolicyAlert(analyzed.Info.Name, verdict, absoluteUrl(fileHref(id, "")))
+	code := http.StatusOK
+	if !verdict.Pass {
+		code = http.StatusUnprocessableEntity
+	}
+	writeJson(writer, code, verdict)
+}
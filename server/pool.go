@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -16,72 +19,155 @@ type Result struct {
 
 // THREAD SAFE
 type Future struct {
-	channel chan Result
-	m       sync.Mutex // protects n and result
-	n       int
-	result  *Result
+	done         chan struct{} // closed when resolved
+	m            sync.Mutex    // protects the fields below
+	n            int           // number of waiters
+	result       *Result
+	lastInterest time.Time
+	keepUntil    time.Time // not abandoned before, for clients that poll less often than the grace period
+
+	// cancels the work for this future, when nobody is interested anymore
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	progress *Progress
 }
 
 func NewFuture() *Future {
-	return &Future{channel: make(chan Result)}
+	progress := &Progress{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), progressKey{}, progress))
+	return &Future{done: make(chan struct{}), lastInterest: time.Now(), ctx: ctx, cancel: cancel, progress: progress}
 }
 
 func NewFutureResolved(result Result) *Future {
-	return &Future{result: &result}
+	done := make(chan struct{})
+	close(done)
+	return &Future{done: done, result: &result}
 }
 
 func (f *Future) Resolve(result Result) *Future {
 	f.m.Lock()
 	defer f.m.Unlock()
+	if f.result != nil {
+		return f
+	}
 	f.result = &result
-	n := f.n
-
-	for i := 0; i < n; i++ {
-		f.channel <- result
+	close(f.done)
+	if f.cancel != nil {
+		f.cancel() // release the context
 	}
 	return f
 }
 
 func (f *Future) Await() Result {
-	f.m.Lock()
-	result := f.result
-	if result != nil {
-		f.m.Unlock()
-		return *result
-	} else {
-		f.n++
-		f.m.Unlock() // unlock here before waiting on the channel
-		return <-f.channel
-	}
+	return f.AwaitContext(context.Background(), 0)
 }
 
 var TimeoutError = errors.New("timeout waiting for future")
 
 func (f *Future) AwaitTimeout(d time.Duration) Result {
+	return f.AwaitContext(context.Background(), d)
+}
+
+// AwaitContext waits for the result, until the context is done or the timeout (if > 0) expires
+func (f *Future) AwaitContext(ctx context.Context, d time.Duration) Result {
 	f.m.Lock()
-	result := f.result
-	if result != nil {
+	f.lastInterest = time.Now()
+	if f.result != nil {
+		result := *f.result
 		f.m.Unlock()
-		return *result
-	} else {
-		f.n++
-		f.m.Unlock() // unlock here before waiting on the channel
-		select {
-		case res2 := <-f.channel:
-			return res2
-		case <-time.After(d):
-			f.m.Lock()
-			defer f.m.Unlock()
-			f.n--
-			return Result{Error: TimeoutError}
-		}
+		return result
+	}
+	f.n++
+	f.m.Unlock() // unlock here before waiting
+
+	var timeout <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-f.done:
+	case <-timeout:
+		err = TimeoutError
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.n--
+	f.lastInterest = time.Now()
+	if err != nil {
+		return Result{Error: err}
+	}
+	return *f.result
+}
+
+// Progress returns the progress of the work, it is nil for a future that was resolved right away
+func (f *Future) Progress() *Progress {
+	return f.progress
+}
+
+// Keep keeps the work going for the duration, also when nobody waits for it
+func (f *Future) Keep(duration time.Duration) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.keepUntil = time.Now().Add(duration)
+}
+
+// abandoned returns true if the future is not resolved and nobody waited for it during the grace period, or kept it
+func (f *Future) abandoned(grace time.Duration) bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.result == nil && f.n == 0 && time.Since(f.lastInterest) > grace && time.Now().After(f.keepUntil)
+}
+
+// Progress counts the steps of the work for a future, the performer reports them with ProgressFromContext. The methods
+// can be called on nil, so performers don't need to check if there is a progress.
+// THREAD SAFE, only use atomic operations
+type Progress struct {
+	done      int64
+	remaining int64
+}
+
+type progressKey struct{}
+
+// ProgressFromContext returns the progress of the future that is performed with the context, or nil
+func ProgressFromContext(ctx context.Context) *Progress {
+	progress, _ := ctx.Value(progressKey{}).(*Progress)
+	return progress
+}
+
+// Add adds n remaining steps
+func (p *Progress) Add(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.remaining, int64(n))
+	}
+}
+
+// Step marks a remaining step as done
+func (p *Progress) Step() {
+	if p != nil {
+		atomic.AddInt64(&p.remaining, -1)
+		atomic.AddInt64(&p.done, 1)
 	}
 }
 
+func (p *Progress) Get() (done int64, remaining int64) {
+	if p == nil {
+		return 0, 0
+	}
+	return atomic.LoadInt64(&p.done), atomic.LoadInt64(&p.remaining)
+}
+
 type SmartPerformer interface {
 	Get(key string) Data
 	Put(key string, data Data)
-	Perform(key string) Result
+	Perform(ctx context.Context, key string) Result
 }
 
 // THREAD SAFE
@@ -99,6 +185,9 @@ func (f *futureMap) getOrCreate(key string) (_future *Future, isNew bool) {
 	defer f.m.Unlock()
 	future, ok := f.futures[key]
 	if ok {
+		future.m.Lock()
+		future.lastInterest = time.Now()
+		future.m.Unlock()
 		return future, false
 	}
 	future = NewFuture()
@@ -106,27 +195,105 @@ func (f *futureMap) getOrCreate(key string) (_future *Future, isNew bool) {
 	return future, true
 }
 
-func (f *futureMap) finish(key string, result Result) {
+// ERROR_TTL is how long an error is kept, so a failing key is not performed again for every request while e.g. the
+// registry is down, but it is retried soon after
+const ERROR_TTL = time.Minute
+
+func (f *futureMap) finish(key string, future *Future, result Result) {
 	f.m.Lock()
 	defer f.m.Unlock()
-	future := f.futures[key]
 	future.Resolve(result)
 
-	// TODO remove from futureMap, should be cached in db
-	// delete(f.futures, key)
+	// a successful result is cached in the db, which also handles the expiration. Errors are kept for ERROR_TTL, and
+	// cancelled work is performed again by the next request. The future may already be removed, if it was abandoned.
+	if f.futures[key] != future {
+		return
+	}
+	if result.Error == nil {
+		if !databaseDisabled {
+			delete(f.futures, key)
+		}
+	} else if errors.Is(result.Error, context.Canceled) || errors.Is(result.Error, context.DeadlineExceeded) {
+		delete(f.futures, key)
+	} else {
+		time.AfterFunc(ERROR_TTL, func() { f.removeFuture(key, future) })
+	}
+}
+
+// removeFuture removes the future of the key, unless it was already replaced by a new one
+func (f *futureMap) removeFuture(key string, future *Future) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.futures[key] == future {
+		delete(f.futures, key)
+	}
+}
+
+func (f *futureMap) remove(key string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	delete(f.futures, key)
+}
+
+// forget removes the resolved futures for which match returns true, so the next request will get or perform them again
+func (f *futureMap) forget(match func(key string) bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for key, future := range f.futures {
+		future.m.Lock()
+		resolved := future.result != nil
+		future.m.Unlock()
+		if resolved && match(key) {
+			delete(f.futures, key)
+		}
+	}
+}
+
+// reap cancels and removes the futures that nobody is interested in anymore
+func (f *futureMap) reap(grace time.Duration) int {
+	f.m.Lock()
+	defer f.m.Unlock()
+	n := 0
+	for key, future := range f.futures {
+		if future.abandoned(grace) {
+			future.cancel()
+			delete(f.futures, key)
+			n++
+		}
+	}
+	return n
+}
+
+type PoolStats struct {
+	Queued    int64   `json:"queued"`
+	DbHits    int64   `json:"dbHits"`
+	Shared    int64   `json:"shared"`
+	Performed int64   `json:"performed"`
+	HitRatio  float64 `json:"hitRatio"`
+}
+
+type job struct {
+	key    string
+	future *Future
 }
 
 // THREAD SAFE, because all the fields are thread safe
 type SmartWorkPool struct {
 	performer SmartPerformer
-	workQueue chan string
+	workQueue chan job
 	futureMap *futureMap
+
+	// counters, only use atomic operations
+	queued    int64 // number of keys waiting for a worker
+	dbHits    int64
+	shared    int64
+	performed int64
 }
 
 func NewSmartWorkPool(performer SmartPerformer) *SmartWorkPool {
 	return &SmartWorkPool{
 		performer: performer,
-		workQueue: make(chan string),
+		workQueue: make(chan job),
 		futureMap: newFutureMap(),
 	}
 }
@@ -134,29 +301,94 @@ func NewSmartWorkPool(performer SmartPerformer) *SmartWorkPool {
 var databaseDisabled = false // for debugging
 
 func (s *SmartWorkPool) work(i int) {
-	for key := range s.workQueue {
-		result := s.performer.Perform(key)
+	for job := range s.workQueue {
+		atomic.AddInt64(&s.queued, -1)
+		ctx := job.future.ctx
+		var result Result
+		if ctx.Err() != nil {
+			result = Result{Error: ctx.Err()}
+		} else {
+			result = s.performer.Perform(ctx, job.key)
+		}
 		if result.Error == nil && !databaseDisabled {
-			s.performer.Put(key, result.Data)
+			s.performer.Put(job.key, result.Data)
 		}
-		s.futureMap.finish(key, result)
+		s.futureMap.finish(job.key, job.future, result)
 	}
 }
 
-func (s *SmartWorkPool) ProcessKey(key string) *Future {
+var BusyError = errors.New("too many keys are waiting to be processed")
+
+func (s *SmartWorkPool) processKey(key string, maxQueued int64) *Future {
 	if !databaseDisabled {
 		data := s.performer.Get(key)
 		if data != nil {
+			atomic.AddInt64(&s.dbHits, 1)
 			return NewFutureResolved(Result{Data: data})
 		}
 	}
 	future, isNew := s.futureMap.getOrCreate(key)
 	if isNew {
-		s.workQueue <- key
+		if maxQueued > 0 && atomic.AddInt64(&s.queued, 1) > maxQueued {
+			atomic.AddInt64(&s.queued, -1)
+			s.futureMap.remove(key)
+			future.Resolve(Result{Error: BusyError})
+			return future
+		} else if maxQueued <= 0 {
+			atomic.AddInt64(&s.queued, 1)
+		}
+		atomic.AddInt64(&s.performed, 1)
+		s.workQueue <- job{key, future}
+	} else {
+		atomic.AddInt64(&s.shared, 1)
 	}
 	return future
 }
 
+// ProcessKey returns a future for the key. If the key must be performed, it waits until a worker is available.
+func (s *SmartWorkPool) ProcessKey(key string) *Future {
+	return s.processKey(key, 0)
+}
+
+// TryProcessKey is like ProcessKey, but it resolves to BusyError instead of waiting when more than maxQueued keys
+// are already waiting for a worker. Use it for user requests, so they don't pile up when the pool is saturated.
+func (s *SmartWorkPool) TryProcessKey(key string, maxQueued int) *Future {
+	return s.processKey(key, int64(maxQueued))
+}
+
+// Stats returns the number of keys found in the db, shared with an earlier request and performed. The hit ratio
+// is the fraction of keys that did not need to be performed.
+func (s *SmartWorkPool) Stats() PoolStats {
+	stats := PoolStats{
+		Queued:    atomic.LoadInt64(&s.queued),
+		DbHits:    atomic.LoadInt64(&s.dbHits),
+		Shared:    atomic.LoadInt64(&s.shared),
+		Performed: atomic.LoadInt64(&s.performed),
+	}
+	total := stats.DbHits + stats.Shared + stats.Performed
+	if total > 0 {
+		stats.HitRatio = float64(stats.DbHits+stats.Shared) / float64(total)
+	}
+	return stats
+}
+
+func (s *SmartWorkPool) Forget(match func(key string) bool) {
+	s.futureMap.forget(match)
+}
+
+// CancelAbandoned periodically cancels the work for keys that nobody waited for during the grace period, e.g. because
+// the browser tab was closed. Only use it for pools that are not awaited by other pools.
+func (s *SmartWorkPool) CancelAbandoned(name string, grace time.Duration) {
+	go func() {
+		for {
+			time.Sleep(grace / 2)
+			if n := s.futureMap.reap(grace); n > 0 {
+				slog.Info("cancelled abandoned work", "pool", name, "count", n)
+			}
+		}
+	}()
+}
+
 func (s *SmartWorkPool) Start(n int) {
 	for i := 0; i < n; i++ {
 		go s.work(i)
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PROVENANCE_CONCURRENCY is the number of attestations that are fetched at the same time, per analysis
+const PROVENANCE_CONCURRENCY = 8
+
+const SLSA_PROVENANCE = "https://slsa.dev/provenance/v1"
+
+// DistAttestations points to the attestations of a version that was published with provenance
+type DistAttestations struct {
+	Url        string `json:"url"`
+	Provenance struct {
+		PredicateType string `json:"predicateType"`
+	} `json:"provenance"`
+}
+
+var repositoryShorthand = regexp.MustCompile(`^(?:(github|gitlab|bitbucket):)?([\w.-]+)/([\w.-]+)$`)
+
+// shorthandHosts are the hosts of the repository shorthands of npm, without a prefix it is github
+var shorthandHosts = map[string]string{"": "github.com", "github": "github.com", "gitlab": "gitlab.com", "bitbucket": "bitbucket.org"}
+var repositoryUrl = regexp.MustCompile(`^(?:git\+)?(?:[a-z]+://)?(?:[^@/]+@)?([\w.-]+)[:/]+([\w.-]+)/([\w.-]+?)(?:\.git)?(?:[/#?].*)?$`)
+
+// NormalizeRepository turns the repository field of a package.json, or a repository url, into host/owner/repo in
+// lower case, like github.com/heijmans/independ. It returns "" when it is not recognized.
+func NormalizeRepository(repository interface{}) string {
+	var raw string
+	switch r := repository.(type) {
+	case string:
+		raw = r
+	case map[string]interface{}:
+		raw, _ = r["url"].(string)
+	}
+	raw = strings.TrimSpace(raw)
+	if match := repositoryShorthand.FindStringSubmatch(raw); match != nil {
+		return strings.ToLower(shorthandHosts[match[1]] + "/" + match[2] + "/" + match[3])
+	}
+	if match := repositoryUrl.FindStringSubmatch(raw); match != nil {
+		return strings.ToLower(match[1] + "/" + match[2] + "/" + match[3])
+	}
+	return ""
+}
+
+type attestationsResponse struct {
+	Attestations []struct {
+		PredicateType string `json:"predicateType"`
+		Bundle        struct {
+			DsseEnvelope struct {
+				Payload string `json:"payload"` // base64 of an in-toto statement
+			} `json:"dsseEnvelope"`
+		} `json:"bundle"`
+	} `json:"attestations"`
+}
+
+type provenanceStatement struct {
+	Predicate struct {
+		BuildDefinition struct {
+			ExternalParameters struct {
+				Workflow struct {
+					Repository string `json:"repository"`
+				} `json:"workflow"`
+			} `json:"externalParameters"`
+		} `json:"buildDefinition"`
+	} `json:"predicate"`
+}
+
+// getProvenanceRepository gets the attestations of a version and returns the repository it was built from, according
+// to its slsa provenance. The signatures of the attestations are not verified.
+func getProvenanceRepository(ctx context.Context, url string) (string, error) {
+	response, err := getBodyConditional(ctx, url, "", "")
+	if err != nil {
+		return "", errors.Wrapf(err, "could not get attestations %s", url)
+	}
+	var attestations attestationsResponse
+	if err := json.Unmarshal(response.Body, &attestations); err != nil {
+		return "", errors.Wrapf(err, "could not parse attestations %s", url)
+	}
+	for _, attestation := range attestations.Attestations {
+		if attestation.PredicateType != SLSA_PROVENANCE {
+			continue
+		}
+		payload, err := base64.StdEncoding.DecodeString(attestation.Bundle.DsseEnvelope.Payload)
+		if err != nil {
+			return "", errors.Wrapf(err, "could not decode provenance %s", url)
+		}
+		var statement provenanceStatement
+		if err := json.Unmarshal(payload, &statement); err != nil {
+			return "", errors.Wrapf(err, "could not parse provenance %s", url)
+		}
+		return NormalizeRepository(statement.Predicate.BuildDefinition.ExternalParameters.Workflow.Repository), nil
+	}
+	return "", errors.Errorf("no provenance in attestations %s", url)
+}
+
+// ProvenanceRepository returns the repository of the provenance of a version, from the db or else from the registry.
+// Attestations don't change, so they are cached forever.
+func ProvenanceRepository(ctx context.Context, url string) (string, error) {
+	repository, found, err := DbGetProvenance(url)
+	if err != nil {
+		slog.Error("could not get provenance from db", "url", url, "err", err)
+	}
+	if found {
+		return repository, nil
+	}
+	repository, err = getProvenanceRepository(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	if err := DbPutProvenance(url, repository); err != nil {
+		slog.Error("could not put provenance in db", "url", url, "err", err)
+	}
+	return repository, nil
+}
+
+// GatherProvenance adds the repositories of the provenance of the dependencies that were published with provenance
+func (v *Version) GatherProvenance(ctx context.Context) {
+	urls := v.detailUrls(func(details DependencyDetails) string { return details.Attestations })
+	failed := fetchConcurrently(urls, PROVENANCE_CONCURRENCY, func(url string) (string, error) {
+		return ProvenanceRepository(ctx, url)
+	}, func(key string, repository string) {
+		details := v.Details[key]
+		details.Provenance = repository
+		v.Details[key] = details
+	})
+	if failed > 0 {
+		slog.Warn("could not get all provenances", "name", v.Info.Name, "failed", failed)
+	}
+}
+
+// RepositoryMismatch is a dependency whose declared repository is not the one it was built from. This is a sign of a
+// spoofed package, which claims the repository of a popular one.
+type RepositoryMismatch struct {
+	Key        string
+	Repository string // declared in package.json
+	Provenance string
+	Publisher  string
+}
+
+// RepositoryMismatches returns the dependencies with provenance from another repository than they declare, by key
+func (v *Version) RepositoryMismatches() []RepositoryMismatch {
+	var mismatches []RepositoryMismatch
+	for key, details := range v.Details {
+		if details.Provenance != "" && details.Repository != "" && details.Provenance != details.Repository {
+			mismatches = append(mismatches, RepositoryMismatch{key, details.Repository, details.Provenance, details.Publisher})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Key < mismatches[j].Key })
+	return mismatches
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// withBasePath strips the base path, so the routes don't need to know about it
+func withBasePath(handler http.Handler) http.Handler {
+	basePath := Config.Server.BasePath
+	if basePath == "" {
+		return handler
+	}
+	stripped := http.StripPrefix(basePath, handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		// /base is the prefix of /baseline too, it only matches up to a slash
+		if !strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		stripped.ServeHTTP(w, r)
+	})
+}
+
+// lastHeaderValue returns the right-most value of a header, which the trusted proxy added. The values before it come
+// from the client, and can be anything.
+func lastHeaderValue(r *http.Request, key string) string {
+	values := r.Header.Values(key)
+	if len(values) == 0 {
+		return ""
+	}
+	value := values[len(values)-1]
+	if i := strings.LastIndex(value, ","); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.TrimSpace(value)
+}
+
+// ProxyHeaders uses the X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers of a trusted reverse proxy
+// for the remote address, scheme and host of the request
+func ProxyHeaders(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if forwardedFor := lastHeaderValue(r, "X-Forwarded-For"); forwardedFor != "" {
+			r.RemoteAddr = net.JoinHostPort(forwardedFor, "0")
+		}
+		if proto := lastHeaderValue(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			r.URL.Scheme = proto
+		}
+		if host := lastHeaderValue(r, "X-Forwarded-Host"); host != "" {
+			r.Host = host
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const LOOKUP_DAY_FORMAT = "2006-01-02"
+
+// LOOKUP_WINDOW is the time in which the requests of a client for a package are one lookup, so the redirect to the
+// latest version, the reloads of the wait page and polls for the analysis are not counted again
+const LOOKUP_WINDOW = 10 * time.Minute
+
+var lookupLimiter = newRateLimiter(1, LOOKUP_WINDOW)
+
+// CountLookup registers that a user looked up a package, in the background to not slow down the request. A lookup is
+// counted when the client first requests the package.
+func CountLookup(request *http.Request, name string) {
+	if !lookupLimiter.allow(remoteIp(request)+"\t"+name, time.Now()) {
+		return
+	}
+	go func() {
+		if err := DbCountLookup(name, time.Now().Format(LOOKUP_DAY_FORMAT)); err != nil {
+			slog.Error("could not count lookup", "package", name, "err", err)
+		}
+	}()
+}
+
+// refreshPackage revalidates the package info, gathers the latest version again and replaces the cached rows
+func refreshPackage(name string) error {
+	stale, _ := DbGetStalePackage(name)
+	packageInfo, err := RevalidatePackageInfoRegistry(context.Background(), name, stale)
+	if err != nil {
+		return err
+	}
+	if err := DbPutPackage(name, packageInfo, calcExpire(packageInfo.LatestTime())); err != nil {
+		return err
+	}
+	packagePool.Forget(func(key string) bool { return key == name })
+
+	latest := packageInfo.DistTags.Latest
+	version, err := packageInfo.GatherDependencies(context.Background(), latest)
+	if err != nil {
+		return err
+	}
+	if err := DbPutVersion(name, latest, version, calcExpire(version.Time)); err != nil {
+		return err
+	}
+	key := name + "\t" + latest
+	versionPool.Forget(func(k string) bool { return k == key })
+	return nil
+}
+
+func refreshPopular() {
+	config := Config.Refresh
+	since := time.Now().AddDate(0, 0, -config.Days).Format(LOOKUP_DAY_FORMAT)
+	expireBefore := time.Now().Add(time.Duration(config.MarginMinutes) * time.Minute)
+	names, err := DbPopularExpiringPackages(since, config.Top, expireBefore)
+	if err != nil {
+		slog.Error("could not get popular packages", "err", err)
+		return
+	}
+	for _, name := range names {
+		slog.Info("refresh popular package", "package", name)
+		if err := refreshPackage(name); err != nil {
+			slog.Error("could not refresh popular package", "package", name, "err", err)
+		}
+	}
+}
+
+func scheduleRefresh() {
+	if Config.Refresh.Top <= 0 {
+		return
+	}
+	for {
+		refreshPopular()
+		time.Sleep(time.Duration(Config.Refresh.IntervalMinutes) * time.Minute)
+	}
+}
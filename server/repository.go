@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// analyze a repository by its url: the package.json or packages.lock.json in the root of its default branch is fetched
+// with the api of its host, and stored like an uploaded file
+
+const (
+	HOST_GITHUB    = "github"
+	HOST_GITLAB    = "gitlab"
+	HOST_BITBUCKET = "bitbucket"
+)
+
+// REPOSITORY_FILES are the files that are looked for in the root of a repository, in order
+var REPOSITORY_FILES = []string{"package.json", "packages.lock.json"}
+
+// the hosts answer 404 for a repository that does not exist or is private too
+var RepositoryFileNotFoundError = errors.New("no package.json or packages.lock.json in the root of the repository, " +
+	"or the repository does not exist or is private")
+
+// Repository is a repository on one of the configured hosts
+type Repository struct {
+	Host string // github, gitlab or bitbucket
+	// owner/repo on github, group/subgroup/project on gitlab, workspace/repo on bitbucket cloud and
+	// projects/KEY/repos/slug on bitbucket data center
+	Path string
+}
+
+// escapePath escapes every segment of a path, so a segment can't add a query or reach another endpoint of an api
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// hostPath returns the path of the url relative to the base url of a host, which can be in a subpath when self-hosted
+func hostPath(u *url.URL, base string) (string, bool) {
+	baseUrl, err := url.Parse(base)
+	if err != nil || !strings.EqualFold(u.Host, baseUrl.Host) {
+		return "", false
+	}
+	prefix := strings.TrimRight(baseUrl.Path, "/") + "/"
+	if !strings.HasPrefix(u.Path+"/", prefix) {
+		return "", false
+	}
+	path := strings.Trim(strings.TrimPrefix(u.Path, prefix), "/")
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return "", false
+		}
+	}
+	return path, true
+}
+
+// ParseRepositoryUrl parses the url of a repository, as it is shown in the browser or cloned with https. The page of a
+// file or branch in the repository is the repository itself.
+func ParseRepositoryUrl(raw string) (Repository, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return Repository{}, errors.Errorf("invalid repository url %s", raw)
+	}
+	if path, ok := hostPath(u, Config.Github.Url); ok {
+		parts := strings.Split(path, "/")
+		if len(parts) >= 2 {
+			return Repository{HOST_GITHUB, parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")}, nil
+		}
+	}
+	if path, ok := hostPath(u, Config.Gitlab.Url); ok {
+		// the project can be in nested groups, the pages in the project are after /-/
+		path, _, _ = strings.Cut(path, "/-/")
+		path = strings.TrimSuffix(strings.TrimSuffix(path, "/"), ".git")
+		if strings.Contains(path, "/") {
+			return Repository{HOST_GITLAB, path}, nil
+		}
+	}
+	if path, ok := hostPath(u, Config.Bitbucket.Url); ok {
+		parts := strings.Split(path, "/")
+		// bitbucket data center has projects/KEY/repos/slug, and users/name/repos/slug for personal repositories
+		if len(parts) >= 4 && (parts[0] == "projects" || parts[0] == "users") && parts[2] == "repos" {
+			project := parts[1]
+			if parts[0] == "users" {
+				project = "~" + project
+			}
+			return Repository{HOST_BITBUCKET, "projects/" + project + "/repos/" + strings.TrimSuffix(parts[3], ".git")}, nil
+		}
+		if len(parts) >= 2 && parts[0] != "projects" && parts[0] != "users" {
+			return Repository{HOST_BITBUCKET, parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")}, nil
+		}
+	}
+	return Repository{}, errors.Errorf("%s is not a repository on %s, %s or %s", raw, Config.Github.Url,
+		Config.Gitlab.Url, Config.Bitbucket.Url)
+}
+
+// repositoryGet gets a url of the api of a host, at most MAX_UPLOAD_SIZE like an uploaded file
+func repositoryGet(ctx context.Context, u string, header http.Header) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get "+u)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return nil, &StatusError{Code: response.StatusCode, Status: response.Status, Url: u}
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, MAX_UPLOAD_SIZE+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read "+u)
+	}
+	if len(body) > MAX_UPLOAD_SIZE {
+		return nil, errors.Errorf("%s is >1MB", u)
+	}
+	return body, nil
+}
+
+// authHeader returns the authorization for the api of the host. The github token is of the logged in user. The
+// tokens of the config can read private repositories that a visitor may not see, so they are only used for public
+// repositories.
+func (r Repository) authHeader(ctx context.Context, githubToken string) (http.Header, error) {
+	header := http.Header{}
+	switch r.Host {
+	case HOST_GITHUB:
+		if githubToken != "" {
+			header.Set("Authorization", "Bearer "+githubToken)
+		}
+	case HOST_GITLAB:
+		if Config.Gitlab.Token == "" {
+			return header, nil
+		}
+		header.Set("PRIVATE-TOKEN", Config.Gitlab.Token)
+		var project struct {
+			Visibility string `json:"visibility"`
+		}
+		if err := r.getJson(ctx, Config.Gitlab.ApiUrl+"/projects/"+url.PathEscape(r.Path), header, &project); err != nil {
+			return nil, err
+		}
+		if project.Visibility != "public" {
+			return nil, RepositoryFileNotFoundError
+		}
+	case HOST_BITBUCKET:
+		if Config.Bitbucket.Token == "" {
+			return header, nil
+		}
+		header.Set("Authorization", "Bearer "+Config.Bitbucket.Token)
+		var repository struct {
+			Public    bool `json:"public"`     // data center
+			IsPrivate bool `json:"is_private"` // cloud
+			Project   struct {
+				Public bool `json:"public"`
+			} `json:"project"`
+		}
+		public := false
+		if strings.HasPrefix(r.Path, "projects/") {
+			err := r.getJson(ctx, Config.Bitbucket.Url+"/rest/api/1.0/"+escapePath(r.Path), header, &repository)
+			if err != nil {
+				return nil, err
+			}
+			public = repository.Public || repository.Project.Public
+		} else {
+			err := r.getJson(ctx, Config.Bitbucket.ApiUrl+"/repositories/"+escapePath(r.Path), header, &repository)
+			if err != nil {
+				return nil, err
+			}
+			public = !repository.IsPrivate
+		}
+		if !public {
+			return nil, RepositoryFileNotFoundError
+		}
+	}
+	return header, nil
+}
+
+// getJson gets a url of the api of a host and parses it into v
+func (r Repository) getJson(ctx context.Context, u string, header http.Header, v interface{}) error {
+	body, err := repositoryGet(ctx, u, header)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errors.Wrapf(err, "could not parse %s repository", r.Host)
+	}
+	return nil
+}
+
+// getFile gets a file in the root of the default branch
+func (r Repository) getFile(ctx context.Context, name string, header http.Header) ([]byte, error) {
+	header = header.Clone()
+	switch r.Host {
+	case HOST_GITHUB:
+		header.Set("Accept", "application/vnd.github.raw")
+		return repositoryGet(ctx, Config.Github.ApiUrl+"/repos/"+escapePath(r.Path)+"/contents/"+name, header)
+	case HOST_GITLAB:
+		// the ref HEAD is the default branch
+		return repositoryGet(ctx, Config.Gitlab.ApiUrl+"/projects/"+url.PathEscape(r.Path)+"/repository/files/"+
+			url.PathEscape(name)+"/raw?ref=HEAD", header)
+	case HOST_BITBUCKET:
+		// without a ref, data center uses the default branch
+		if strings.HasPrefix(r.Path, "projects/") {
+			return repositoryGet(ctx, Config.Bitbucket.Url+"/rest/api/1.0/"+escapePath(r.Path)+"/raw/"+name, header)
+		}
+		// bitbucket cloud needs the name of the main branch
+		var repository struct {
+			MainBranch struct {
+				Name string `json:"name"`
+			} `json:"mainbranch"`
+		}
+		if err := r.getJson(ctx, Config.Bitbucket.ApiUrl+"/repositories/"+escapePath(r.Path), header, &repository); err != nil {
+			return nil, err
+		}
+		if repository.MainBranch.Name == "" {
+			return nil, errors.Errorf("bitbucket repository %s has no main branch", r.Path)
+		}
+		return repositoryGet(ctx, Config.Bitbucket.ApiUrl+"/repositories/"+escapePath(r.Path)+"/src/"+
+			url.PathEscape(repository.MainBranch.Name)+"/"+name, header)
+	}
+	return nil, errors.Errorf("unknown repository host %s", r.Host)
+}
+
+// GetFile gets the first of REPOSITORY_FILES that the repository has, or RepositoryFileNotFoundError
+func (r Repository) GetFile(ctx context.Context, githubToken string) ([]byte, error) {
+	header, err := r.authHeader(ctx, githubToken)
+	var statusError *StatusError
+	if errors.As(err, &statusError) && statusError.Code == http.StatusNotFound {
+		return nil, RepositoryFileNotFoundError
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range REPOSITORY_FILES {
+		bytes, err := r.getFile(ctx, name, header)
+		if errors.As(err, &statusError) && statusError.Code == http.StatusNotFound {
+			continue
+		}
+		return bytes, err
+	}
+	return nil, RepositoryFileNotFoundError
+}
+
+func repositoryHandler(writer http.ResponseWriter, request *http.Request) {
+	repository, err := ParseRepositoryUrl(request.FormValue("url"))
+	if err != nil {
+		httpError(writer, request, http.StatusBadRequest, "unknown repository", err)
+		return
+	}
+	githubToken := ""
+	if user := CurrentUser(request); user != nil {
+		githubToken = openGithubToken(user.GithubToken)
+	}
+	bytes, err := repository.GetFile(request.Context(), githubToken)
+	var statusError *StatusError
+	if err == RepositoryFileNotFoundError {
+		httpError(writer, request, http.StatusNotFound, "no package.json in repository", err)
+		return
+	}
+	if errors.As(err, &statusError) && statusError.Code < http.StatusInternalServerError {
+		// like a private repository without access
+		httpError(writer, request, http.StatusNotFound, "repository not found or private", err)
+		return
+	}
+	if err != nil {
+		httpError(writer, request, http.StatusBadGateway, "could not get repository", err)
+		return
+	}
+	storeFile(writer, request, bytes, nil)
+}
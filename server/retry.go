@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+const RETRY_ATTEMPTS = 3
+const RETRY_BASE_DELAY = 500 * time.Millisecond
+
+// backoff returns the delay before the given retry (1 based): exponential with full jitter
+func backoff(retry int, base time.Duration) time.Duration {
+	max := base << (retry - 1)
+	return time.Duration(rand.Int63n(int64(max))) + base/2
+}
+
+// retry calls f until it succeeds, returns a non transient error, or the attempts are exhausted
+func retry(ctx context.Context, description string, attempts int, base time.Duration, f func() error) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = f()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+		if attempt < attempts {
+			delay := backoff(attempt, base)
+			slog.Debug("retry", "description", description, "attempt", attempt, "delay", delay, "err", err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return err
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SEARCH_SIZE is the number of suggestions for a query
+const SEARCH_SIZE = 10
+
+// SEARCH_MAX_SIZE is the most results the registry returns for a search
+const SEARCH_MAX_SIZE = 250
+
+// SEARCH_MIN_LENGTH is the shortest query that is searched, shorter ones match too many packages to be useful
+const SEARCH_MIN_LENGTH = 2
+
+// SEARCH_MAX_LENGTH is the longest query, longer ones are cut off
+const SEARCH_MAX_LENGTH = 100
+
+// SEARCH_EXPIRE is how long search results are cached, new packages can show up later
+const SEARCH_EXPIRE = time.Hour
+
+// SEARCH_CACHE_CONTROL lets browsers cache the suggestions as long as the db does
+const SEARCH_CACHE_CONTROL = "public, max-age=3600"
+
+// SearchResult is a package that matches a search
+type SearchResult struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Date        string `json:"date,omitempty"` // of the latest version
+}
+
+type npmSearchResponse struct {
+	Objects []struct {
+		Package SearchResult `json:"package"`
+	} `json:"objects"`
+}
+
+// normalizeSearchQuery returns the query as it is cached, searches are case insensitive
+func normalizeSearchQuery(query string) string {
+	query = strings.ToLower(strings.Join(strings.Fields(query), " "))
+	if len(query) > SEARCH_MAX_LENGTH {
+		query = query[:SEARCH_MAX_LENGTH]
+	}
+	return query
+}
+
+// getSearchApi searches the default registry, private registries don't always implement search
+func getSearchApi(ctx context.Context, text string, size int) ([]SearchResult, error) {
+	u := Config.Registries.RegistryOf("") + "-/v1/search?text=" + url.QueryEscape(text) + "&size=" + strconv.Itoa(size)
+	response, err := getBodyConditional(ctx, u, "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not search")
+	}
+	var search npmSearchResponse
+	if err := json.Unmarshal(response.Body, &search); err != nil {
+		return nil, errors.Wrap(err, "could not parse search")
+	}
+	results := []SearchResult{}
+	for _, object := range search.Objects {
+		results = append(results, object.Package)
+	}
+	return results, nil
+}
+
+// cachedSearch returns the results of a search, from the db or else from the registry
+func cachedSearch(ctx context.Context, text string, size int) ([]SearchResult, error) {
+	key := text + "\t" + strconv.Itoa(size)
+	now := time.Now()
+	content, err := DbGetSearch(key, now)
+	if err != nil {
+		slog.Error("could not get search from db", "text", text, "err", err)
+	}
+	if content != "" {
+		var results []SearchResult
+		if err := json.Unmarshal([]byte(content), &results); err == nil {
+			return results, nil
+		}
+	}
+	results, err := getSearchApi(ctx, text, size)
+	if err != nil {
+		return nil, err
+	}
+	bytes, _ := json.Marshal(results)
+	if err := DbPutSearch(key, string(bytes), now.Add(SEARCH_EXPIRE)); err != nil {
+		slog.Error("could not put search in db", "text", text, "err", err)
+	}
+	return results, nil
+}
+
+// SearchPackages returns the packages that match the query
+func SearchPackages(ctx context.Context, query string) ([]SearchResult, error) {
+	query = normalizeSearchQuery(query)
+	if len(query) < SEARCH_MIN_LENGTH {
+		return []SearchResult{}, nil
+	}
+	return cachedSearch(ctx, query, SEARCH_SIZE)
+}
+
+// MaintainerPackages returns the packages that the npm account maintains, at most SEARCH_MAX_SIZE
+func MaintainerPackages(ctx context.Context, user string) ([]SearchResult, error) {
+	return cachedSearch(ctx, "maintainer:"+strings.ToLower(user), SEARCH_MAX_SIZE)
+}
+
+// searchHandler suggests package names for the package field of the home page
+func searchHandler(writer http.ResponseWriter, request *http.Request) {
+	results, err := SearchPackages(request.Context(), request.URL.Query().Get("q"))
+	if err != nil {
+		jsonError(writer, http.StatusBadGateway, "could not search packages", err)
+		return
+	}
+	writer.Header().Set("Cache-Control", SEARCH_CACHE_CONTROL)
+	writeJson(writer, http.StatusOK, results)
+}
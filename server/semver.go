@@ -0,0 +1,38 @@
+package server
+
+import (
+	"github.com/Masterminds/semver/v3"
+)
+
+// SemverMatch tells if a constraint matches a published version, and why not
+type SemverMatch struct {
+	Version string
+	Matches bool
+	Reason  string // from the semver library, like "1.0.0-beta is a prerelease version and ..."
+}
+
+// ExplainConstraint checks the constraint against every published version of the package, newest first. It also
+// returns the version that MaxVersion picks, which is "" when no version matches.
+func ExplainConstraint(info *PackageInfo, constraintRaw string) ([]SemverMatch, string, error) {
+	constraint, err := semver.NewConstraint(constraintRaw)
+	if err != nil {
+		return nil, "", err
+	}
+	var matches []SemverMatch
+	for _, versionRaw := range info.SortedVersions() {
+		match := SemverMatch{Version: versionRaw}
+		if version, err := semver.NewVersion(versionRaw); err != nil {
+			match.Reason = "not a valid semver version"
+		} else if ok, errs := constraint.Validate(version); ok {
+			match.Matches = true
+		} else if len(errs) > 0 {
+			match.Reason = errs[0].Error()
+		}
+		matches = append(matches, match)
+	}
+	picked := ""
+	if maxVersion, err := info.MaxVersion(constraintRaw); err == nil {
+		picked = maxVersion.Version
+	}
+	return matches, picked, nil
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// minimal client for the sentry store api, see https://develop.sentry.dev/sdk/store/
+
+type sentryDsn struct {
+	storeUrl  string
+	publicKey string
+}
+
+var sentry *sentryDsn
+
+// SetupSentry parses the dsn from the config, errors are reported to sentry when it is set
+func SetupSentry() {
+	if Config.Sentry.Dsn == "" {
+		return
+	}
+	dsn, err := parseSentryDsn(Config.Sentry.Dsn)
+	if err != nil {
+		log.Fatalln("invalid sentry config", err)
+	}
+	sentry = dsn
+}
+
+// a dsn looks like https://public_key@host/project_id
+func parseSentryDsn(raw string) (*sentryDsn, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse sentry dsn")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("sentry dsn has no public key")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	prefix, project := "", path
+	if i >= 0 {
+		prefix, project = "/"+path[:i], path[i+1:]
+	}
+	if project == "" {
+		return nil, errors.New("sentry dsn has no project id")
+	}
+	storeUrl := u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/store/"
+	return &sentryDsn{storeUrl: storeUrl, publicKey: u.User.Username()}, nil
+}
+
+type SentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+type SentryStacktrace struct {
+	Frames []SentryFrame `json:"frames"`
+}
+
+type SentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *SentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type SentryRequest struct {
+	Url         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type SentryEvent struct {
+	EventId     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Exception   []SentryException `json:"exception,omitempty"`
+	Request     *SentryRequest    `json:"request,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// sentryFrames converts program counters to sentry frames, which are ordered from the oldest call to the newest
+func sentryFrames(pcs []uintptr) *SentryStacktrace {
+	if len(pcs) == 0 {
+		return nil
+	}
+	var frames []SentryFrame
+	iter := runtime.CallersFrames(pcs)
+	for {
+		frame, more := iter.Next()
+		frames = append([]SentryFrame{{Function: frame.Function, Filename: frame.File, Lineno: frame.Line}}, frames...)
+		if !more {
+			break
+		}
+	}
+	return &SentryStacktrace{Frames: frames}
+}
+
+// errorPcs returns the stack of an error created with pkg/errors, if any
+func errorPcs(err error) []uintptr {
+	type stackTracer interface {
+		StackTrace() errors.StackTrace
+	}
+	var pcs []uintptr
+	for err != nil {
+		if tracer, ok := err.(stackTracer); ok {
+			pcs = nil
+			for _, frame := range tracer.StackTrace() {
+				pcs = append(pcs, uintptr(frame))
+			}
+		}
+		cause, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		err = cause.Unwrap()
+	}
+	return pcs // the innermost stack is the closest to the origin of the error
+}
+
+// headers that may contain secrets are not sent to sentry
+var sentryHiddenHeaders = map[string]bool{"Authorization": true, "Cookie": true}
+
+// query parameters that may contain secrets, like the token to delete a file, are redacted
+var sentryHiddenParams = []string{"token", "code", "state", "key", "secret", "password"}
+
+// sentryRedactQuery redacts the values of the query parameters that may contain secrets
+func sentryRedactQuery(rawQuery string) string {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+	for key := range query {
+		for _, hidden := range sentryHiddenParams {
+			if strings.Contains(strings.ToLower(key), hidden) {
+				query[key] = []string{"[redacted]"}
+			}
+		}
+	}
+	return query.Encode()
+}
+
+func NewSentryEvent(request *http.Request, errType string, message string, pcs []uintptr) *SentryEvent {
+	id := make([]byte, 16)
+	rand.Read(id)
+	hostname, _ := os.Hostname()
+	event := &SentryEvent{
+		EventId:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "independ",
+		ServerName:  hostname,
+		Environment: Config.Sentry.Environment,
+		Exception:   []SentryException{{Type: errType, Value: message, Stacktrace: sentryFrames(pcs)}},
+	}
+	if request != nil {
+		headers := map[string]string{}
+		for key, values := range request.Header {
+			if !sentryHiddenHeaders[key] {
+				headers[key] = strings.Join(values, ", ")
+			}
+		}
+		// the referer can be a page with a token in its query
+		if referer, err := url.Parse(request.Header.Get("Referer")); err == nil && referer.RawQuery != "" {
+			referer.RawQuery = sentryRedactQuery(referer.RawQuery)
+			headers["Referer"] = referer.String()
+		}
+		scheme := "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+		event.Request = &SentryRequest{
+			Url:         scheme + "://" + request.Host + request.URL.Path,
+			Method:      request.Method,
+			QueryString: sentryRedactQuery(request.URL.RawQuery),
+			Headers:     headers,
+		}
+	}
+	return event
+}
+
+func SendSentry(event *SentryEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("could not encode sentry event", "err", err)
+		return
+	}
+	request, err := http.NewRequest(http.MethodPost, sentry.storeUrl, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("could not create sentry request", "err", err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=independ/1.0, sentry_key=%s",
+		sentry.publicKey))
+	response, err := httpClient.Do(request)
+	if err != nil {
+		slog.Error("could not send sentry event", "err", err)
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		slog.Error("sentry rejected event", "status", response.Status)
+		return
+	}
+	slog.Info("sentry event sent", "id", event.EventId)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+const YEAR = 365 * 24 * time.Hour
+
+// StaleDependency is a package in the tree without a release for years
+type StaleDependency struct {
+	Name        string
+	Versions    []string
+	LastRelease time.Time
+}
+
+// StaleDependencies returns the packages whose last release is more than years before now, the oldest first
+func (v *Version) StaleDependencies(years int, now time.Time) []StaleDependency {
+	limit := now.Add(-time.Duration(years) * YEAR)
+	stale := map[string]*StaleDependency{}
+	for key, details := range v.Details {
+		if details.LastRelease.IsZero() || details.LastRelease.After(limit) {
+			continue
+		}
+		name, version := SplitDependencyKey(key)
+		if stale[name] == nil {
+			stale[name] = &StaleDependency{Name: name, LastRelease: details.LastRelease}
+		}
+		stale[name].Versions = append(stale[name].Versions, version)
+	}
+	var list []StaleDependency
+	for _, dependency := range stale {
+		sort.Strings(dependency.Versions)
+		list = append(list, *dependency)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].LastRelease.Equal(list[j].LastRelease) {
+			return list[i].LastRelease.Before(list[j].LastRelease)
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// MedianAge is the median time since the resolved versions of the dependencies were published, the lower the fresher.
+// It is zero when no publish times are known.
+func (v *Version) MedianAge(now time.Time) time.Duration {
+	var ages []time.Duration
+	for _, details := range v.Details {
+		if !details.Published.IsZero() {
+			ages = append(ages, now.Sub(details.Published))
+		}
+	}
+	if len(ages) == 0 {
+		return 0
+	}
+	sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+	middle := len(ages) / 2
+	if len(ages)%2 == 0 {
+		return (ages[middle-1] + ages[middle]) / 2
+	}
+	return ages[middle]
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+)
+
+// assetHashes contains the content hash of every public file, by absolute path
+var assetHashes = map[string]string{}
+
+func hashAssets(publicFs fs.FS) error {
+	return fs.WalkDir(publicFs, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(publicFs, path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		assetHashes["/"+path] = hex.EncodeToString(sum[:8])
+		return nil
+	})
+}
+
+// StaticHandler serves the public files. When the url contains the current content hash, the file is cached forever.
+// Other paths are passed to the not found handler, instead of the plain 404 of the file server.
+func StaticHandler(publicFs fs.FS, notFound http.Handler) http.Handler {
+	fileServer := http.FileServer(http.FS(publicFs))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash, ok := assetHashes[r.URL.Path]
+		if !ok {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Query().Get("v") == hash {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// the first file descriptor passed by systemd, see sd_listen_fds(3)
+const SD_LISTEN_FDS_START = 3
+
+var activatedListeners []net.Listener
+var activatedOnce sync.Once
+
+// systemdListeners returns the sockets passed by systemd socket activation, in the order of the socket unit
+func systemdListeners() []net.Listener {
+	activatedOnce.Do(func() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || n <= 0 {
+			return
+		}
+		// don't pass the sockets to child processes
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+
+		for fd := SD_LISTEN_FDS_START; fd < SD_LISTEN_FDS_START+n; fd++ {
+			file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+			listener, err := net.FileListener(file)
+			file.Close() // FileListener dups the descriptor
+			if err != nil {
+				slog.Error("could not use socket from systemd", "fd", fd, "err", err)
+				continue
+			}
+			activatedListeners = append(activatedListeners, listener)
+		}
+		slog.Info("using sockets from systemd", "count", len(activatedListeners))
+	})
+	return activatedListeners
+}
+
+// listen returns the i-th socket from systemd, if the server is socket activated, or else listens on the address
+func listen(addr string, i int) (net.Listener, error) {
+	listeners := systemdListeners()
+	if len(listeners) == 0 {
+		return net.Listen("tcp", addr)
+	}
+	if i >= len(listeners) {
+		return nil, errors.Errorf("systemd passed %d sockets, need at least %d", len(listeners), i+1)
+	}
+	return listeners[i], nil
+}
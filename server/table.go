@@ -0,0 +1,179 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TableQuery is how the visitor sorts, filters, pages and searches the tables of a version page, from the query
+// parameters sort, desc, q, page, publishers_page, maintainers_page, integrity_page and search
+type TableQuery struct {
+	Sort            string // a key of dependencySorts
+	Desc            bool
+	Filter          string
+	Page            int // of the dependency table, starts at 1
+	PublishersPage  int
+	MaintainersPage int
+	IntegrityPage   int
+	Search          string     // a package name to find in the whole tree
+	values          url.Values // the query of the request, so links keep the other parameters, like the token of a file
+}
+
+// dependencySorts are the columns that the dependency table can be sorted by, the value is true for the columns that
+// are sorted descending first, the largest is the most interesting
+var dependencySorts = map[string]bool{
+	"name":      false,
+	"versions":  true,
+	"size":      true,
+	"files":     true,
+	"publisher": false,
+	"downloads": true,
+}
+
+func ParseTableQuery(request *http.Request) TableQuery {
+	values := request.URL.Query()
+	query := TableQuery{
+		Sort:            values.Get("sort"),
+		Desc:            values.Get("desc") == "1",
+		Filter:          strings.TrimSpace(values.Get("q")),
+		Page:            pageParam(values.Get("page")),
+		PublishersPage:  pageParam(values.Get("publishers_page")),
+		MaintainersPage: pageParam(values.Get("maintainers_page")),
+		IntegrityPage:   pageParam(values.Get("integrity_page")),
+		Search:          strings.TrimSpace(values.Get("search")),
+		values:          values,
+	}
+	if _, ok := dependencySorts[query.Sort]; !ok {
+		query.Sort, query.Desc = "name", false
+	}
+	return query
+}
+
+func pageParam(raw string) int {
+	page, err := strconv.Atoi(raw)
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// Href returns a link to the same page, with the parameters changed. An empty value removes the parameter.
+func (q TableQuery) Href(params ...string) string {
+	values := url.Values{}
+	for key, value := range q.values {
+		values[key] = value
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i+1] == "" {
+			values.Del(params[i])
+		} else {
+			values.Set(params[i], params[i+1])
+		}
+	}
+	return "?" + values.Encode()
+}
+
+// SortHref links to the table sorted by the column, a second click reverses the order
+func (q TableQuery) SortHref(column string) string {
+	desc := dependencySorts[column]
+	if column == q.Sort {
+		desc = !q.Desc
+	}
+	// the rows move, so start at the first page again
+	if desc {
+		return q.Href("sort", column, "desc", "1", "page", "")
+	}
+	return q.Href("sort", column, "desc", "", "page", "")
+}
+
+// Hidden returns the parameters, except the excluded ones, as hidden inputs for a form that changes the others
+func (q TableQuery) Hidden(exclude ...string) []Node {
+	var keys []string
+	for key := range q.values {
+		if !contains(exclude, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return ForEach(keys, func(key string) Node {
+		return H("input type=hidden name=%s value=%s", key, q.values.Get(key))
+	})
+}
+
+// Paginate returns the rows of the page, the page clamped to the existing pages, and the number of pages
+func Paginate[T any](rows []T, page int) ([]T, int, int) {
+	size := Config.Tables.PageSize
+	pages := (len(rows) + size - 1) / size
+	if page > pages {
+		page = pages
+	}
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * size
+	end := start + size
+	if start > len(rows) {
+		start = len(rows)
+	}
+	if end > len(rows) {
+		end = len(rows)
+	}
+	return rows[start:end], page, pages
+}
+
+// DependencyRow is a package in the dependency table, with the details of its versions added up
+type DependencyRow struct {
+	Name       string
+	Versions   []string
+	Size       int64
+	Files      int
+	Publishers []string
+	Downloads  int64 // weekly, only known for the direct dependencies
+	Formats    []ModuleFormat
+}
+
+// DependencyRows returns the dependencies that contain the filter, sorted by the query
+func DependencyRows(version *Version, query TableQuery) []DependencyRow {
+	filter := strings.ToLower(query.Filter)
+	var rows []DependencyRow
+	for _, name := range sortedDependencyNames(version.Dependencies) {
+		if filter != "" && !strings.Contains(strings.ToLower(name), filter) {
+			continue
+		}
+		row := DependencyRow{Name: name, Versions: version.Dependencies[name], Downloads: version.Downloads[name]}
+		for _, v := range row.Versions {
+			details := version.Details[DependencyKey(name, v)]
+			row.Size += details.Size
+			row.Files += details.Files
+			if details.Publisher != "" && !contains(row.Publishers, details.Publisher) {
+				row.Publishers = append(row.Publishers, details.Publisher)
+			}
+			if details.Format != "" && !containsFormat(row.Formats, details.Format) {
+				row.Formats = append(row.Formats, details.Format)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	less := map[string]func(a, b DependencyRow) bool{
+		"name":      func(a, b DependencyRow) bool { return a.Name < b.Name },
+		"versions":  func(a, b DependencyRow) bool { return len(a.Versions) < len(b.Versions) },
+		"size":      func(a, b DependencyRow) bool { return a.Size < b.Size },
+		"files":     func(a, b DependencyRow) bool { return a.Files < b.Files },
+		"downloads": func(a, b DependencyRow) bool { return a.Downloads < b.Downloads },
+		"publisher": func(a, b DependencyRow) bool {
+			return strings.Join(a.Publishers, ", ") < strings.Join(b.Publishers, ", ")
+		},
+	}[query.Sort]
+	// the rows are sorted by name already, so equal rows stay in that order
+	sort.SliceStable(rows, func(i, j int) bool {
+		if query.Desc {
+			return less(rows[j], rows[i])
+		}
+		return less(rows[i], rows[j])
+	})
+	return rows
+}
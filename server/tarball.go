@@ -0,0 +1,190 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// TARBALL_CONCURRENCY is the number of HEAD requests for tarball sizes at the same time, per analysis
+const TARBALL_CONCURRENCY = 8
+
+// headTarballSize asks the registry for the size of a tarball, without downloading it
+func headTarballSize(ctx context.Context, url string) (int64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not get size of tarball %s", url)
+	}
+	response.Body.Close()
+	if response.StatusCode >= 400 {
+		return 0, &StatusError{Code: response.StatusCode, Status: response.Status, Url: url}
+	}
+	if response.ContentLength < 0 {
+		return 0, errors.Errorf("no content length for tarball %s", url)
+	}
+	return response.ContentLength, nil
+}
+
+// TarballSize returns the gzipped size of a tarball, from the db or else from the registry
+func TarballSize(ctx context.Context, url string) (int64, error) {
+	size, err := DbGetTarballSize(url)
+	if err != nil {
+		slog.Error("could not get tarball size from db", "url", url, "err", err)
+	}
+	if size > 0 {
+		return size, nil
+	}
+	size, err = headTarballSize(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	if err := DbPutTarballSize(url, size); err != nil {
+		slog.Error("could not put tarball size in db", "url", url, "err", err)
+	}
+	return size, nil
+}
+
+// GatherTarballSizes adds the download sizes of the package and its dependencies. The sizes that can't be fetched
+// are left out of the total, they are only informative.
+func (v *Version) GatherTarballSizes(ctx context.Context) {
+	urls := v.detailUrls(func(details DependencyDetails) string { return details.Tarball })
+	// the package itself has no dependency key, uploaded files have no tarball
+	if url := v.Info.Dist.Tarball; url != "" {
+		urls[""] = url
+	}
+	failed := fetchConcurrently(urls, TARBALL_CONCURRENCY, func(url string) (int64, error) {
+		return TarballSize(ctx, url)
+	}, func(key string, size int64) {
+		v.Stats.DownloadSize += size
+		if key != "" {
+			details := v.Details[key]
+			details.TarballSize = size
+			v.Details[key] = details
+		}
+	})
+	if failed > 0 {
+		slog.Warn("could not get all tarball sizes", "name", v.Info.Name, "failed", failed)
+	}
+}
+
+// inspectTarball downloads a tarball and counts the files and their size, for old versions without these stats in the
+// registry. Tarballs larger than the configured maximum are not inspected.
+func inspectTarball(ctx context.Context, url string) (TarballContentsRow, error) {
+	var row TarballContentsRow
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return row, err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return row, errors.Wrapf(err, "could not download tarball %s", url)
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 400 {
+		return row, &StatusError{Code: response.StatusCode, Status: response.Status, Url: url}
+	}
+	maxSize := int64(Config.Tarballs.MaxSizeMb) * 1000000
+	if response.ContentLength > maxSize {
+		return row, errors.Errorf("tarball %s is larger than %d MB", url, Config.Tarballs.MaxSizeMb)
+	}
+	gz, err := gzip.NewReader(io.LimitReader(response.Body, maxSize))
+	if err != nil {
+		return row, errors.Wrapf(err, "could not gunzip tarball %s", url)
+	}
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return row, nil
+		}
+		if err != nil {
+			return row, errors.Wrapf(err, "could not read tarball %s", url)
+		}
+		if header.Typeflag == tar.TypeReg {
+			row.FileCount++
+			row.UnpackedSize += header.Size
+		}
+	}
+}
+
+type TarballPerformer struct{}
+
+func (p TarballPerformer) Get(url string) Data {
+	row, err := DbGetTarballContents(url)
+	if err != nil || row == nil {
+		return nil
+	}
+	return *row
+}
+
+func (p TarballPerformer) Put(url string, data Data) {
+	if err := DbPutTarballContents(url, data.(TarballContentsRow)); err != nil {
+		slog.Error("could not put tarball contents in db", "url", url, "err", err)
+	}
+}
+
+func (p TarballPerformer) Perform(ctx context.Context, url string) Result {
+	row, err := inspectTarball(ctx, url)
+	if err != nil {
+		return Result{Error: err}
+	}
+	return Result{Data: row}
+}
+
+// tarballPool inspects tarballs, only when it is enabled in the config
+var tarballPool *SmartWorkPool
+
+// GatherMissingDistStats inspects the tarballs of the dependencies without a file count and size, and adds them to
+// the stats. It does nothing unless tarballs.inspect is enabled.
+func (v *Version) GatherMissingDistStats(ctx context.Context) {
+	if !Config.Tarballs.Inspect {
+		return
+	}
+	futures := map[string]*Future{}
+	for key, details := range v.Details {
+		if details.Tarball != "" && details.Files == 0 && details.Size == 0 {
+			futures[key] = tarballPool.ProcessKey(details.Tarball)
+		}
+	}
+	var root *Future
+	if dist := v.Info.Dist; dist.Tarball != "" && dist.FileCount == 0 && dist.UnpackedSize == 0 {
+		root = tarballPool.ProcessKey(dist.Tarball)
+	}
+
+	add := func(future *Future) (TarballContentsRow, bool) {
+		result := future.AwaitContext(ctx, 0)
+		if result.Error != nil {
+			slog.Warn("could not inspect tarball", "err", result.Error)
+			return TarballContentsRow{}, false
+		}
+		row := result.Data.(TarballContentsRow)
+		v.Stats.Files += row.FileCount
+		v.Stats.DiskSpace += row.UnpackedSize
+		return row, true
+	}
+	for key, future := range futures {
+		if row, ok := add(future); ok {
+			details := v.Details[key]
+			details.Files = row.FileCount
+			details.Size = row.UnpackedSize
+			v.Details[key] = details
+		}
+	}
+	if root != nil {
+		add(root)
+	}
+}
+
+func init() {
+	tarballPool = NewSmartWorkPool(TarballPerformer{})
+	tarballPool.Start(2)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// deadlines per kind of route, they replace the read and write timeouts of the server for these routes
+const UPLOAD_DEADLINE = 2 * time.Minute
+const ANALYSIS_DEADLINE = 30 * time.Second
+const STATIC_DEADLINE = 30 * time.Second
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// newServer returns a server with the timeouts from the config, so slow clients can't hold on to connections
+func newServer(addr string, handler http.Handler) *http.Server {
+	config := Config.Server
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: seconds(config.ReadHeaderTimeoutSeconds),
+		ReadTimeout:       seconds(config.ReadTimeoutSeconds),
+		WriteTimeout:      seconds(config.WriteTimeoutSeconds),
+		IdleTimeout:       seconds(config.IdleTimeoutSeconds),
+	}
+}
+
+// Deadline sets the read and write deadline of the connection and the deadline of the request context for a route
+func Deadline(d time.Duration, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		deadline := time.Now().Add(d)
+		controller := http.NewResponseController(writer)
+		if err := controller.SetReadDeadline(deadline); err != nil {
+			slog.Debug("could not set read deadline", "err", err)
+		}
+		if err := controller.SetWriteDeadline(deadline); err != nil {
+			slog.Debug("could not set write deadline", "err", err)
+		}
+		ctx, cancel := context.WithDeadline(request.Context(), deadline)
+		defer cancel()
+		handler(writer, request.WithContext(ctx))
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func tlsEnabled() bool {
+	return len(Config.Tls.Domains) > 0
+}
+
+// serveTls serves the handler with https, using certificates from Let's Encrypt for the configured domains. The http
+// port answers the acme challenges and redirects everything else to https.
+func serveTls(handler http.Handler) {
+	config := Config.Tls
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.Domains...),
+		Cache:      autocert.DirCache(config.CacheDir),
+		Email:      config.Email,
+	}
+
+	// with socket activation, the first socket is the http port and the second the https port
+	httpAddr := net.JoinHostPort(Config.Server.Host, strconv.Itoa(config.HttpPort))
+	httpListener, err := listen(httpAddr, 0)
+	if err != nil {
+		log.Panicln("could not listen", err)
+	}
+	go func() {
+		slog.Info("start listening for acme challenges at http://" + httpListener.Addr().String() + "...")
+		if err := newServer(httpAddr, manager.HTTPHandler(nil)).Serve(httpListener); err != nil {
+			log.Panicln("could not start http server", err)
+		}
+	}()
+
+	httpsAddr := net.JoinHostPort(Config.Server.Host, strconv.Itoa(config.HttpsPort))
+	httpsListener, err := listen(httpsAddr, 1)
+	if err != nil {
+		log.Panicln("could not listen", err)
+	}
+	server := newServer(httpsAddr, handler)
+	server.TLSConfig = manager.TLSConfig()
+	slog.Info("start listening at https://" + httpsListener.Addr().String() + "...")
+	if err := server.ServeTLS(httpsListener, "", ""); err != nil {
+		log.Panicln("could not start https server", err)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"math"
+	"sort"
+)
+
+type TreemapItem struct {
+	Label string
+	Size  int64
+}
+
+type TreemapRect struct {
+	TreemapItem
+	X, Y, W, H float64
+}
+
+// LayoutTreemap divides a width x height rectangle over the items, proportional to their sizes. It uses the squarified
+// algorithm of Bruls et al., which keeps the rectangles close to squares so they are easy to compare. Items without a
+// size are skipped.
+func LayoutTreemap(items []TreemapItem, width float64, height float64) []TreemapRect {
+	var sorted []TreemapItem
+	var total int64
+	for _, item := range items {
+		if item.Size > 0 {
+			sorted = append(sorted, item)
+			total += item.Size
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Size > sorted[j].Size })
+
+	scale := width * height / float64(total)
+	free := TreemapRect{W: width, H: height}
+	var rects []TreemapRect
+	var row []TreemapItem
+	for i := 0; i < len(sorted); {
+		side := math.Min(free.W, free.H)
+		with := append(row[:len(row):len(row)], sorted[i])
+		if len(row) == 0 || worstRatio(with, side, scale) <= worstRatio(row, side, scale) {
+			row = with
+			i++
+			continue
+		}
+		rects, free = layoutRow(rects, row, free, scale)
+		row = nil
+	}
+	rects, _ = layoutRow(rects, row, free, scale)
+	return rects
+}
+
+// worstRatio is the highest aspect ratio of the rectangles in a row along a side
+func worstRatio(row []TreemapItem, side float64, scale float64) float64 {
+	var sum, min, max float64
+	for i, item := range row {
+		area := float64(item.Size) * scale
+		sum += area
+		if i == 0 || area < min {
+			min = area
+		}
+		if area > max {
+			max = area
+		}
+	}
+	side2, sum2 := side*side, sum*sum
+	return math.Max(side2*max/sum2, sum2/(side2*min))
+}
+
+// layoutRow puts the row along the shorter side of the free rectangle, and returns what remains free
+func layoutRow(rects []TreemapRect, row []TreemapItem, free TreemapRect, scale float64) ([]TreemapRect, TreemapRect) {
+	var sum float64
+	for _, item := range row {
+		sum += float64(item.Size) * scale
+	}
+	if sum == 0 {
+		return rects, free
+	}
+	x, y := free.X, free.Y
+	if free.W >= free.H {
+		thickness := sum / free.H
+		for _, item := range row {
+			h := float64(item.Size) * scale / thickness
+			rects = append(rects, TreemapRect{item, x, y, thickness, h})
+			y += h
+		}
+		free.X += thickness
+		free.W -= thickness
+	} else {
+		thickness := sum / free.W
+		for _, item := range row {
+			w := float64(item.Size) * scale / thickness
+			rects = append(rects, TreemapRect{item, x, y, w, thickness})
+			x += w
+		}
+		free.Y += thickness
+		free.H -= thickness
+	}
+	return rects, free
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"strings"
+)
+
+// Typings tells where the typescript types of a package come from
+type Typings string
+
+const (
+	TypingsBundled         Typings = "bundled"
+	TypingsDefinitelyTyped Typings = "@types"
+	TypingsNone            Typings = "none"
+)
+
+// DefinitelyTypedName returns the name of the @types package for a package, @scope/name becomes @types/scope__name
+func DefinitelyTypedName(name string) string {
+	if strings.HasPrefix(name, "@") {
+		name = strings.Replace(name[1:], "/", "__", 1)
+	}
+	return "@types/" + name
+}
+
+// GatherTypings checks if the package and its direct dependencies bundle their types, or else if there is an @types
+// package for them. For an uploaded file the package itself is skipped, it is not published.
+func (v *Version) GatherTypings(ctx context.Context, file bool) {
+	typings := map[string]Typings{}
+	var missing []string
+	if !file {
+		if v.Info.HasTypes() {
+			typings[v.Info.Name] = TypingsBundled
+		} else {
+			missing = append(missing, v.Info.Name)
+		}
+	}
+	for _, key := range v.Edges[DependencyKey(v.Info.Name, v.Info.Version)] {
+		name, _ := SplitDependencyKey(key)
+		if v.Details[key].Types {
+			typings[name] = TypingsBundled
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	// get the @types packages at the same time, they are cached like the other packages
+	futures := make([]*Future, len(missing))
+	for i, name := range missing {
+		futures[i] = packagePool.ProcessKey(DefinitelyTypedName(name))
+	}
+	for i, name := range missing {
+		if result := futures[i].AwaitContext(ctx, 0); result.Error == nil {
+			typings[name] = TypingsDefinitelyTyped
+		} else {
+			typings[name] = TypingsNone
+		}
+	}
+	v.Typings = typings
+}
+
+// TypingsCoverage counts the direct dependencies with types, the package itself is not counted
+func (v *Version) TypingsCoverage() (bundled int, definitelyTyped int, total int) {
+	for name, typings := range v.Typings {
+		if name == v.Info.Name {
+			continue
+		}
+		total++
+		switch typings {
+		case TypingsBundled:
+			bundled++
+		case TypingsDefinitelyTyped:
+			definitelyTyped++
+		}
+	}
+	return
+}
@@ -2,62 +2,182 @@ package server
 
 import (
 	"fmt"
-	"os"
+	"math"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Href prefixes an absolute path with the base path, when the server is behind a path based proxy
+func Href(path string) string {
+	return Config.Server.BasePath + path
+}
+
 func npmHref(name string, version string) string {
 	if version == "" {
-		return "/npm/" + name
+		return Href("/npm/" + name)
 	} else {
-		return "/npm/" + name + "/" + version
+		return Href("/npm/" + name + "/" + version)
+	}
+}
+
+// packageHref links to a package of an ecosystem, npm when the ecosystem is empty. The trees of JSR packages also
+// contain npm packages, only the names with JSR_PREFIX are JSR packages.
+func packageHref(ecosystem string, name string, version string) string {
+	if isNpmPackage(ecosystem, name) {
+		return npmHref(name, version)
+	}
+	name = strings.TrimPrefix(name, JSR_PREFIX)
+	if version == "" {
+		return Href("/" + ecosystem + "/" + name)
 	}
+	return Href("/" + ecosystem + "/" + name + "/" + version)
 }
 
 var startTime = time.Now()
 
 func publicHref(path string) string {
-	filePath := "public" + path
+	if hash, ok := assetHashes[path]; ok {
+		return Href(path) + "?v=" + hash
+	}
+	// unknown file, so use the launch time
+	return fmt.Sprintf("%s?t=%d", Href(path), startTime.UnixMilli())
+}
+
+// Meta is the metadata of a page for search engines and link previews
+type Meta struct {
+	Description string
+	Image       string // absolute url or path of the site, the default is the preview image of the config
+	Path        string // the canonical path of the page without the base path, for og:url
+}
+
+func metaUrl(u string) string {
+	if strings.HasPrefix(u, "/") {
+		return absoluteUrl(u)
+	}
+	return u
+}
 
-	// if we cannot stat the file, it is perhaps embedded, so use the launch time
-	modTime := startTime
-	if stat, err := os.Stat(filePath); err == nil {
-		modTime = stat.ModTime()
+// metaNodes are the description, the open graph tags and the twitter card tags
+func metaNodes(title string, meta Meta) []Node {
+	var nodes []Node
+	property := func(key string, value string) {
+		if value != "" {
+			nodes = append(nodes, H("meta property=%s content=%s", key, value))
+		}
+	}
+	name := func(key string, value string) {
+		if value != "" {
+			nodes = append(nodes, H("meta name=%s content=%s", key, value))
+		}
+	}
+	image := meta.Image
+	if image == "" {
+		image = Config.Server.PreviewImage
 	}
-	return fmt.Sprintf("%s?t=%d", path, modTime.UnixMilli())
+	card := "summary"
+	if image != "" {
+		card = "summary_large_image"
+	}
+	name("description", meta.Description)
+	property("og:site_name", Config.Theme.SiteName)
+	property("og:type", "website")
+	property("og:title", title)
+	property("og:description", meta.Description)
+	if meta.Path != "" {
+		property("og:url", absoluteUrl(meta.Path))
+	}
+	if image != "" {
+		property("og:image", metaUrl(image))
+	}
+	name("twitter:card", card)
+	name("twitter:title", title)
+	name("twitter:description", meta.Description)
+	return nodes
 }
 
-func Layout(title string, content Node) Node {
+func Layout(l Locale, title string, content Node) Node {
+	return LayoutWithMeta(l, title, Meta{}, content)
+}
+
+// themeStyle sets the css variables of the theme, main.css uses them
+func themeStyle() Node {
+	theme := Config.Theme
+	return H("style", UnsafeRawContent(fmt.Sprintf(":root { --accent: %s; --accent-text: %s; }",
+		theme.AccentColor, theme.AccentTextColor)))
+}
+
+func logoNode() Node {
+	theme := Config.Theme
+	if theme.Logo == "" {
+		return TextNode(theme.SiteName)
+	}
+	logo := theme.Logo
+	if strings.HasPrefix(logo, "/") {
+		logo = Href(logo)
+	}
+	return H("img.logo src=%s alt=%s", logo, theme.SiteName)
+}
+
+func footerNode() Node {
+	if len(Config.Theme.Footer) == 0 {
+		return nil
+	}
+	var links []Node
+	for _, link := range Config.Theme.Footer {
+		href := link.Url
+		if strings.HasPrefix(href, "/") {
+			href = Href(href)
+		}
+		links = append(links, H("a href=%s", href, link.Title))
+	}
+	return H(".footer", links)
+}
+
+// LayoutWithMeta adds the meta tags, and the top level pages of the navigation as buttons in the header
+func LayoutWithMeta(l Locale, title string, meta Meta, content Node) Node {
 	var buttons []Node
+	paths := map[string]bool{}
+	for _, item := range Navigation() {
+		paths[item.Page.Path] = true
+		buttons = append(buttons, H("a href=%s", Href("/pages/"+item.Page.Path), item.Page.Title))
+	}
+	// the buttons of the config are for pages without front matter
 	for _, title := range Config.Pages.Buttons {
-		path := "/pages/" + strings.ReplaceAll(strings.ToLower(title), " ", "-")
-		buttons = append(buttons, H("a href=%s", path, title))
+		path := strings.ReplaceAll(strings.ToLower(title), " ", "-")
+		if !paths[path] {
+			buttons = append(buttons, H("a href=%s", Href("/pages/"+path), title))
+		}
 	}
+	buttons = append(buttons, H("a href=%s", Href("/account"), l.T("Account")))
 
-	return H("html",
+	return H("html lang=%s", string(l),
 		H("head",
 			H("meta charset=UTF-8"),
 			H("meta name=viewport content=%s", "width=640"),
-			H("title", title+" | independ"),
+			metaNodes(title, meta),
+			H("title", l.T("%s | %s", title, Config.Theme.SiteName)),
 			H("link rel=stylesheet href=%s", publicHref("/main.css")),
+			themeStyle(),
 		),
 		H("body",
 			H(".header",
-				H("a href=/", "independ"),
+				H("a href=%s", Href("/"), logoNode()),
 				buttons,
 			),
 			content,
+			footerNode(),
 			H("script src=%s", publicHref("/main.js")),
 		),
 	)
 }
 
-func renderVersions(name string, versions []string) Node {
+func renderVersions(ecosystem string, name string, versions []string) Node {
 	var links []Node
 	for _, v := range versions {
-		links = append(links, TextNode(", "), H("a href=%s", npmHref(name, v), v))
+		links = append(links, TextNode(", "), H("a href=%s", packageHref(ecosystem, name, v), v))
 	}
 	return H("td", links[1:])
 }
@@ -71,6 +191,187 @@ func sortedDependencyNames(dependencies map[string][]string) []string {
 	return names
 }
 
+// sortLink is a header of the dependency table, with an arrow when the table is sorted by it
+func sortLink(query TableQuery, column string, label string) Node {
+	if query.Sort == column {
+		if query.Desc {
+			label += " \u25bc"
+		} else {
+			label += " \u25b2"
+		}
+	}
+	return H("a href=%s", query.SortHref(column), label)
+}
+
+// dependencyFilter is a form that filters the dependency table by name, it keeps the other parameters
+func dependencyFilter(l Locale, query TableQuery) Node {
+	return H("form.table-filter method=get",
+		query.Hidden("q", "page"),
+		H("input type=search name=q value=%s placeholder=%s", query.Filter, l.T("Filter by name")),
+		H("button type=submit", l.T("Filter")),
+		If(query.Filter != "", H("a href=%s", query.Href("q", ""), l.T("Show all"))),
+	)
+}
+
+// renderPath shows a path of dependency keys from the root package, with arrows in between
+func renderPath(ecosystem string, root string, path []string) Node {
+	var links []Node
+	for _, key := range path {
+		name, v := SplitDependencyKey(key)
+		links = append(links, TextNode(" \u2192 "), H("a href=%s", packageHref(ecosystem, name, v), key))
+	}
+	return H("span", root, links)
+}
+
+// dependencySearch finds a package in the whole tree and shows how it is reached from the root
+func dependencySearch(l Locale, version *Version, query TableQuery) Node {
+	var results Node
+	if query.Search != "" {
+		paths := version.FindDependencies(query.Search)
+		results = H(".search-results",
+			If(len(paths) == 0, H("p", l.T("No dependencies match %s.", query.Search))),
+			If(len(paths) > 0, H("ul", ForEach(paths, func(path []string) Node {
+				return H("li", renderPath(version.Ecosystem, version.Info.Name, path))
+			}))),
+		)
+	}
+	return H(".dependency-search",
+		H("form method=get",
+			query.Hidden("search"),
+			H("input type=search name=search value=%s placeholder=%s", query.Search, l.T("Find a package in the dependencies")),
+			H("button type=submit", l.T("Search")),
+		),
+		results,
+	)
+}
+
+// typingsRow tells where the typescript types of the package come from, it is nil when that is not known
+func typingsRow(l Locale, name string, typings Typings) Node {
+	var node Node
+	switch typings {
+	case TypingsBundled:
+		node = TextNode(l.T("bundled"))
+	case TypingsDefinitelyTyped:
+		node = H("a href=%s", npmHref(DefinitelyTypedName(name), ""), DefinitelyTypedName(name))
+	case TypingsNone:
+		node = TextNode(l.T("none"))
+	default:
+		return nil
+	}
+	return H("tr", H("th", l.T("types:")), H("td", node))
+}
+
+// formatLabel names a module format, for the info and dependency tables
+func formatLabel(l Locale, format ModuleFormat) string {
+	switch format {
+	case ModuleESM:
+		return "ESM"
+	case ModuleCJS:
+		return "CJS"
+	case ModuleDual:
+		return l.T("ESM and CJS")
+	}
+	return ""
+}
+
+func formatLabels(l Locale, formats []ModuleFormat) string {
+	var labels []string
+	for _, format := range formats {
+		labels = append(labels, formatLabel(l, format))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// formatCount groups the digits by three, like 1,234,567
+func formatCount(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
+
+// formatAge shows an age in months, or in years from two years on
+func formatAge(l Locale, age time.Duration) string {
+	if age < 2*YEAR {
+		return l.T("%d months", int(age/(YEAR/12)))
+	}
+	return l.T("%.1f years", float64(age)/float64(YEAR))
+}
+
+func formatSize(bytes int64) string {
+	if bytes < 1e6 {
+		return fmt.Sprintf("%.1f kB", float64(bytes)/1e3)
+	}
+	return fmt.Sprintf("%.2f MB", float64(bytes)/1e6)
+}
+
+// renderTree shows the dependencies of key as a list of collapsible nodes. A version that was already expanded is not
+// expanded again, so shared and circular dependencies don't blow up the page.
+func renderTree(l Locale, ecosystem string, edges map[string][]string, key string, expanded map[string]bool) Node {
+	children := append([]string{}, edges[key]...)
+	sort.Strings(children)
+	return H("ul.tree", ForEach(children, func(child string) Node {
+		name, v := SplitDependencyKey(child)
+		label := H("span", H("a href=%s", packageHref(ecosystem, name, ""), name), " ",
+			H("a href=%s", packageHref(ecosystem, name, v), v))
+		if len(edges[child]) == 0 {
+			return H("li", label)
+		}
+		if expanded[child] {
+			return H("li", label, H("span.tree-seen", l.T("(expanded above)")))
+		}
+		expanded[child] = true
+		return H("li", H("details", H("summary", label), renderTree(l, ecosystem, edges, child, expanded)))
+	}))
+}
+
+// TREEMAP_MAX is the number of packages in the treemap, the smaller ones are combined
+const TREEMAP_MAX = 50
+const TREEMAP_WIDTH = 1000
+const TREEMAP_HEIGHT = 600
+
+// renderTreemap shows the disk space of the dependencies, the versions of a package are added up
+func renderTreemap(l Locale, details map[string]DependencyDetails) Node {
+	perName := map[string]int64{}
+	for key, d := range details {
+		name, _ := SplitDependencyKey(key)
+		perName[name] += d.Size
+	}
+	var items []TreemapItem
+	for name, size := range perName {
+		items = append(items, TreemapItem{name, size})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Size > items[j].Size })
+	if len(items) > TREEMAP_MAX {
+		other := TreemapItem{Label: l.T("%d other packages", len(items)-TREEMAP_MAX+1)}
+		for _, item := range items[TREEMAP_MAX-1:] {
+			other.Size += item.Size
+		}
+		items = append(items[:TREEMAP_MAX-1], other)
+	}
+
+	f := func(n float64) string { return strconv.FormatFloat(n, 'f', 1, 64) }
+	rects := LayoutTreemap(items, TREEMAP_WIDTH, TREEMAP_HEIGHT)
+	return H("svg.treemap", ForEach(rects, func(rect TreemapRect) Node {
+		size := fmt.Sprintf("%.2f MB", float64(rect.Size)/1e6)
+		var label Node
+		// only label the rectangles that fit the text, roughly 8 units per character
+		if rect.W > float64(len(rect.Label)+2)*8 && rect.H > 20 {
+			label = H("text", rect.Label).Attr("x", f(rect.X+4)).Attr("y", f(rect.Y+16))
+		}
+		return H("g",
+			H("title", rect.Label+": "+size),
+			H("rect").Attr("x", f(rect.X)).Attr("y", f(rect.Y)).Attr("width", f(rect.W)).Attr("height", f(rect.H)),
+			label,
+		)
+	})).Attr("viewBox", fmt.Sprintf("0 0 %d %d", TREEMAP_WIDTH, TREEMAP_HEIGHT))
+}
+
 type IntEntry struct {
 	Key   string
 	Value int
@@ -132,14 +433,14 @@ func RenderTabs(tabs []Tab) Node {
 	var tabContents []Node
 
 	for i, tab := range tabs {
-		buttonSpec := ".tab-button"
-		contentSpec := ".tab"
+		button := H(".tab-button", tab.Title).Data(map[string]string{"tab-id": tab.Id})
+		content := H(".tab", Attr("id", tab.Id), tab.Content)
 		if i == 0 {
-			buttonSpec += ".tab-button-active"
-			contentSpec += ".tab-active"
+			button.Class("tab-button-active")
+			content.Class("tab-active")
 		}
-		tabButtons = append(tabButtons, H(buttonSpec, Attr("data-tab-id", tab.Id), tab.Title))
-		tabContents = append(tabContents, H(contentSpec, Attr("id", tab.Id), tab.Content))
+		tabButtons = append(tabButtons, button)
+		tabContents = append(tabContents, content)
 	}
 
 	return H("div",
@@ -148,133 +449,632 @@ func RenderTabs(tabs []Tab) Node {
 	)
 }
 
-func VersionView(version *Version) Node {
-	info := version.Info
-	var description, homepage, license, npmUser Node
-	if info.Description != "" {
-		description = H("tr", H("th", "description:"), H("td", info.Description))
+func VersionView(l Locale, version *Version, query TableQuery) Node {
+	ecosystem := version.Ecosystem
+	if ecosystem == "" {
+		ecosystem = "npm"
+	}
+	name := strings.TrimPrefix(version.Info.Name, JSR_PREFIX)
+	return versionView(l, version, nil, "/"+ecosystem+"/"+name+"/"+version.Info.Version, query)
+}
+
+// csrfField is needed in every form that posts, see Csrf
+func csrfField(csrf string) Node {
+	return H("input type=hidden name=%s value=%s", CSRF_FIELD, csrf)
+}
+
+func FileView(l Locale, version *Version, id string, token string, csrf string, query TableQuery) Node {
+	var deleteForm Node
+	if token != "" {
+		var retention Node
+		if days := Config.Files.RetentionDays; days > 0 {
+			retention = H("p", l.T("Uploaded files are deleted automatically after %d days.", days))
+		}
+		deleteForm = H(".delete-file",
+			retention,
+			H("p", l.T("Bookmark this page if you want to delete the file yourself later.")),
+			H("form method=POST action=%s > p", Href("/file/"+id+"/delete"),
+				H("input type=hidden name=token value=%s", token),
+				csrfField(csrf),
+				H("button", l.T("Delete file")),
+			),
+		)
+	}
+	return versionView(l, version, deleteForm, "", query)
+}
+
+// versionDescription is the summary of the analysis in link previews
+func versionDescription(l Locale, version *Version) string {
+	stats := version.Stats
+	vs := stats.VulnerabilityStats
+	description := l.T("%d packages, %d versions, %d publishers, %.2f MB disk space, %d vulnerabilities",
+		stats.Packages, stats.Versions, len(version.Publishers), float64(stats.DiskSpace)/1e6, len(version.Vulnerabilities))
+	if vs.HighCount > 0 || vs.CriticalCount > 0 {
+		description += l.T(" (%d high, %d critical)", vs.HighCount, vs.CriticalCount)
+	}
+	if version.Info.Description != "" {
+		description += ". " + version.Info.Description
 	}
+	return description
+}
+
+// versionView shows the analysis of a version or an uploaded file, the path is empty for files, they are not public
+func versionView(l Locale, version *Version, extra Node, path string, query TableQuery) Node {
+	info := version.Info
+	// the module formats, checksums, badges and versions pages are only there for npm
+	npm := version.Ecosystem == ""
+	var homepage Node
 	if info.Homepage != nil && info.Homepage != "" {
 		var node Node
 		if s, ok := info.Homepage.(string); ok {
-			node = H("a href=%s target=_blank", s, s)
+			// the homepage comes from the registry, so Href skips it when it is unsafe
+			node = H("a target=_blank rel=%s", "nofollow noopener", s).Href(s)
 		} else {
 			node = TextNode(fmt.Sprint(info.Homepage))
 		}
-		homepage = H("tr", H("th", "homepage:"), H("td", node))
-	}
-	if info.License != nil && info.License != "" {
-		license = H("tr", H("th", "license:"), H("td", fmt.Sprint(info.License)))
+		homepage = H("tr", H("th", l.T("homepage:")), H("td", node))
 	}
 	publisher := info.GetPublisher()
-	if publisher != "" {
-		npmUser = H("tr", H("th", "published by:"), H("td", publisher))
-	}
-	publishedAt := H("tr", H("th", "published at:"), H("td", version.Time.Format("2006-01-02 15:04 Z07:00")))
 
-	var errors Node
-	if len(version.Errors) > 0 {
-		var list []Node
-		for _, e := range version.Errors {
-			list = append(list, H("li", e))
+	stats := version.Stats
+	vs := stats.VulnerabilityStats
+	typingsBundled, typingsDefinitelyTyped, typingsTotal := version.TypingsCoverage()
+	formats := version.ModuleFormatCounts()
+	now := time.Now()
+	medianAge := version.MedianAge(now)
+	staleDependencies := version.StaleDependencies(Config.Stale.Years, now)
+	conflicts := version.MajorConflicts()
+	mismatches := version.RepositoryMismatches()
+	license := LicenseName(info.License)
+	rootLicense := LicenseKindOf(license)
+	licenseConflicts := version.LicenseConflicts()
+	health := version.Health(Config.Stale.Years, now)
+	var penalties []string
+	for _, factor := range health.Factors {
+		if factor.Penalty > 0 {
+			penalties = append(penalties, fmt.Sprintf("%s -%d", l.T(factor.Name), factor.Penalty))
 		}
-		errors = H(".errors",
-			H("h3", "Errors"),
-			H("ul", list),
-		)
 	}
+	healthDetail := l.T("%d of 100 points", health.Score)
+	if len(penalties) > 0 {
+		healthDetail += ": " + strings.Join(penalties, ", ")
+	}
+	formatsTotal := formats[ModuleESM] + formats[ModuleDual] + formats[ModuleCJS]
+	statsNode := StatCards(
+		StatCard(StatCardProps{
+			Label:  l.T("health"),
+			Value:  health.Grade,
+			Detail: healthDetail,
+		}),
+		If(stats.Packages > 1 || stats.Versions > 1, Fragment(
+			StatCard(StatCardProps{Label: l.T("packages"), Value: strconv.Itoa(stats.Packages)}),
+			StatCard(StatCardProps{Label: l.T("versions"), Value: strconv.Itoa(stats.Versions)}),
+			StatCard(StatCardProps{Label: l.T("publishers"), Value: strconv.Itoa(len(version.Publishers))}),
+			If(len(version.Maintainers) > 0, StatCard(StatCardProps{
+				Label:  l.T("maintainers"),
+				Value:  strconv.Itoa(len(version.Maintainers)),
+				Detail: l.T("npm accounts that can publish"),
+			})),
+		)),
+		If(stats.Files > 0 || stats.DiskSpace > 0, Fragment(
+			StatCard(StatCardProps{Label: l.T("files"), Value: strconv.Itoa(stats.Files)}),
+			StatCard(StatCardProps{Label: l.T("disk space"), Value: fmt.Sprintf("%.2f MB", float64(stats.DiskSpace)/1e6)}),
+		)),
+		If(stats.DownloadSize > 0, StatCard(StatCardProps{
+			Label:  l.T("download size"),
+			Value:  fmt.Sprintf("%.2f MB", float64(stats.DownloadSize)/1e6),
+			Detail: l.T("gzipped tarballs"),
+		})),
+		If(version.Downloads[info.Name] > 0, StatCard(StatCardProps{
+			Label: l.T("weekly downloads"),
+			Value: formatCount(version.Downloads[info.Name]),
+		})),
+		If(typingsTotal > 0, StatCard(StatCardProps{
+			Label:  l.T("with types"),
+			Value:  fmt.Sprintf("%d/%d", typingsBundled+typingsDefinitelyTyped, typingsTotal),
+			Detail: l.T("%d bundled, %d from @types", typingsBundled, typingsDefinitelyTyped),
+		})),
+		If(formatsTotal > 0, StatCard(StatCardProps{
+			Label:  l.T("with ESM"),
+			Value:  fmt.Sprintf("%d/%d", formats[ModuleESM]+formats[ModuleDual], formatsTotal),
+			Detail: l.T("%d ESM only, %d dual, %d CJS only", formats[ModuleESM], formats[ModuleDual], formats[ModuleCJS]),
+		})),
+		If(medianAge > 0, StatCard(StatCardProps{
+			Label:  l.T("median age"),
+			Value:  formatAge(l, medianAge),
+			Detail: l.T("%d unmaintained for %d+ years", len(staleDependencies), Config.Stale.Years),
+		})),
+		If(len(version.Vulnerabilities) > 0, StatCard(StatCardProps{
+			Label: l.T("vulnerabilities"),
+			Value: strconv.Itoa(len(version.Vulnerabilities)),
+			Detail: l.T("low %d \u00a0 medium %d \u00a0 high %d \u00a0 critical %d",
+				vs.LowCount, vs.MediumCount, vs.HighCount, vs.CriticalCount),
+		})),
+	)
 
-	var packStats Node
-	if version.Stats.Packages > 1 || version.Stats.Versions > 1 {
-		packStats = H("h3", fmt.Sprintf("packages: %d \u00a0 versions: %d \u00a0 publishers: %d", version.Stats.Packages, version.Stats.Versions, len(version.Publishers)))
+	rankItems := func(offenders []Offender, format func(int64) string) []RankItem {
+		var items []RankItem
+		for _, offender := range offenders {
+			items = append(items, RankItem{
+				Label: offender.Name,
+				Href:  packageHref(version.Ecosystem, offender.Name, ""),
+				Value: format(offender.Value),
+			})
+		}
+		return items
 	}
-	var sizeStats Node
-	if version.Stats.Files > 0 || version.Stats.DiskSpace > 0 {
-		sizeStats = H("h3", fmt.Sprintf("files: %d \u00a0 disk space: %.2f MB", version.Stats.Files, float64(version.Stats.DiskSpace)/1e6))
+	count := func(n int64) string { return strconv.FormatInt(n, 10) }
+	offenders := version.Offenders
+	offendersNode := If(len(offenders.Files) > 0 || len(offenders.DiskSpace) > 0, StatCards(
+		RankCard(RankCardProps{Label: l.T("most files"), Items: rankItems(offenders.Files, count)}),
+		RankCard(RankCardProps{Label: l.T("most disk space"), Items: rankItems(offenders.DiskSpace, formatSize)}),
+		RankCard(RankCardProps{Label: l.T("most vulnerabilities"), Items: rankItems(offenders.Vulnerabilities, count)}),
+	))
+
+	type breakdownRow struct {
+		label string
+		stats BreakdownStats
 	}
-	var vulnStats Node
-	if len(version.Vulnerabilities) > 0 {
-		vs := version.Stats.VulnerabilityStats
-		vulnStats = H("h3", fmt.Sprintf("vulnerabilities: low %d \u00a0 medium %d \u00a0 high %d \u00a0 critical %d",
-			vs.LowCount, vs.MediumCount, vs.HighCount, vs.CriticalCount))
+	var breakdown Node
+	if stats.Direct.Versions > 0 {
+		breakdown = DataTable(DataTableProps[breakdownRow]{
+			Columns: []string{"", l.T("packages"), l.T("versions"), l.T("disk space"), l.T("vulnerabilities")},
+			Rows: []breakdownRow{
+				{l.T("direct"), stats.Direct},
+				{l.T("transitive"), stats.Transitive},
+			},
+			Row: func(row breakdownRow) Node {
+				return H("tr",
+					H("th", row.label),
+					H("td.number", row.stats.Packages),
+					H("td.number", row.stats.Versions),
+					H("td.number", formatSize(row.stats.DiskSpace)),
+					H("td.number", row.stats.Vulnerabilities),
+				)
+			},
+		})
 	}
-	stats := H("div", packStats, sizeStats, vulnStats)
 
 	var tabs []Tab
-
-	var depTable Node
 	if len(version.Dependencies) > 0 {
-		var dependencies []Node
-		for _, name := range sortedDependencyNames(version.Dependencies) {
-			versions := version.Dependencies[name]
-			dependencies = append(dependencies, H("tr",
-				H("td", H("a href=%s", npmHref(name, ""), name)),
-				renderVersions(name, versions),
-			))
+		depRows, page, pages := Paginate(DependencyRows(version, query), query.Page)
+		depTable := Fragment(
+			dependencyFilter(l, query),
+			DataTable(DataTableProps[DependencyRow]{
+				Heads: []Node{
+					sortLink(query, "name", l.T("name")),
+					sortLink(query, "versions", l.T("versions")),
+					sortLink(query, "size", l.T("size")),
+					sortLink(query, "files", l.T("files")),
+					sortLink(query, "publisher", l.T("publisher")),
+					sortLink(query, "downloads", l.T("weekly downloads")),
+					TextNode(l.T("format")),
+				},
+				Rows: depRows,
+				Row: func(row DependencyRow) Node {
+					return H("tr",
+						H("td", H("a href=%s", packageHref(version.Ecosystem, row.Name, ""), row.Name)),
+						renderVersions(version.Ecosystem, row.Name, row.Versions),
+						H("td.number", formatSize(row.Size)),
+						H("td.number", row.Files),
+						H("td", strings.Join(row.Publishers, ", ")),
+						H("td.number", If(row.Downloads > 0, TextNode(formatCount(row.Downloads)))),
+						H("td", formatLabels(l, row.Formats)),
+					)
+				},
+				Empty: l.T("No dependencies match %s.", query.Filter),
+			}),
+			Pagination(PaginationProps{Locale: l, Page: page, Pages: pages, Href: func(page int) string {
+				return query.Href("page", strconv.Itoa(page))
+			}}),
+		)
+		tabs = append(tabs, Tab{l.T("Dependencies"), "dependencies", depTable})
+	}
+	if len(version.Edges) > 0 {
+		root := DependencyKey(info.Name, info.Version)
+		tabs = append(tabs, Tab{l.T("Tree"), "tree", renderTree(l, version.Ecosystem, version.Edges, root, map[string]bool{root: true})})
+	}
+	if footprints := version.Footprints(); len(footprints) > 1 {
+		footprintTable := Fragment(
+			H("p", l.T("What every direct dependency adds by itself, the packages that no other direct dependency needs.")),
+			DataTable(DataTableProps[Footprint]{
+				Columns: []string{l.T("package"), l.T("packages"), l.T("files"), l.T("disk space")},
+				Rows:    footprints,
+				Row: func(footprint Footprint) Node {
+					name, v := SplitDependencyKey(footprint.Key)
+					return H("tr",
+						H("td", H("a href=%s", packageHref(version.Ecosystem, name, v), footprint.Key)),
+						H("td.number", footprint.Packages),
+						H("td.number", footprint.Files),
+						H("td.number", formatSize(footprint.DiskSpace)),
+					)
+				},
+			}),
+		)
+		tabs = append(tabs, Tab{l.T("Heaviest"), "heaviest", footprintTable})
+	}
+	if npm && len(version.Details) > 1 {
+		tabs = append(tabs, Tab{l.T("Disk space"), "disk-space", renderTreemap(l, version.Details)})
+	}
+	if len(staleDependencies) > 0 {
+		staleTable := Fragment(
+			H("p", l.T("The dependencies without a release in the last %d years.", Config.Stale.Years)),
+			DataTable(DataTableProps[StaleDependency]{
+				Columns: []string{l.T("package"), l.T("versions"), l.T("last release"), l.T("age")},
+				Rows:    staleDependencies,
+				Row: func(dependency StaleDependency) Node {
+					return H("tr",
+						H("td", H("a href=%s", packageHref(version.Ecosystem, dependency.Name, ""), dependency.Name)),
+						renderVersions(version.Ecosystem, dependency.Name, dependency.Versions),
+						H("td", dependency.LastRelease.Format("2006-01-02")),
+						H("td", formatAge(l, now.Sub(dependency.LastRelease))),
+					)
+				},
+			}),
+		)
+		tabs = append(tabs, Tab{l.T("Unmaintained"), "unmaintained", staleTable})
+	}
+	if len(version.Details) > 0 {
+		heads := []Node{TextNode(l.T("license")), TextNode(l.T("kind")), TextNode(l.T("packages"))}
+		for _, kind := range LicenseKinds {
+			head := l.T(string(kind))
+			if kind == rootLicense {
+				head = l.T("%s (this package)", head)
+			}
+			heads = append(heads, TextNode(head))
 		}
-		depTable = H("table", H("tr", H("th", "name"), H("th", "versions")), dependencies)
-		tabs = append(tabs, Tab{"Dependencies", "dependencies", depTable})
+		licenseTable := Fragment(
+			H("p", l.T("Which licenses of the dependencies can be used by a package with a license of each kind. "+
+				"This is a rough guide, not legal advice.")),
+			DataTable(DataTableProps[LicenseUsage]{
+				Heads: heads,
+				Rows:  version.LicenseUsages(),
+				Row: func(usage LicenseUsage) Node {
+					name := usage.License
+					if name == "" {
+						name = l.T("none")
+					}
+					return H("tr",
+						H("td", name),
+						H("td", l.T(string(usage.Kind))),
+						H("td", len(usage.Packages)),
+						ForEach(LicenseKinds, func(kind LicenseKind) Node {
+							badge := LicenseBadge(LicenseBadgeProps{Locale: l, Compatibility: CheckLicense(kind, usage.Kind)})
+							if kind == rootLicense {
+								return H("td.current", badge)
+							}
+							return H("td", badge)
+						}),
+					)
+				},
+			}),
+		)
+		tabs = append(tabs, Tab{l.T("Licenses"), "licenses", licenseTable})
 	}
-
-	var pubTable Node
-	if len(version.Publishers) > 1 {
-		var publishers []Node
-		for _, entry := range sortedMapByIntValue(version.Publishers) {
-			publishers = append(publishers, H("tr", H("td", entry.Key), H("td", entry.Value)))
+	if npm && len(version.Details) > 0 {
+		var keys []string
+		for key := range version.Details {
+			keys = append(keys, key)
 		}
-		pubTable = H("table", H("tr", H("th", "publisher"), H("th", "count")), publishers)
-		tabs = append(tabs, Tab{"Publishers", "publishers", pubTable})
+		sort.Strings(keys)
+		integrityRows, page, pages := Paginate(keys, query.IntegrityPage)
+		integrityTable := Fragment(
+			H("p", l.T("The checksums of the analyzed tarballs, to pin and verify exactly these versions. "+
+				"The signatures of the registry are shown with their key id, they are not verified here.")),
+			DataTable(DataTableProps[string]{
+				Columns: []string{l.T("package"), l.T("integrity"), l.T("shasum"), l.T("registry signature")},
+				Rows:    integrityRows,
+				Row: func(key string) Node {
+					details := version.Details[key]
+					name, v := SplitDependencyKey(key)
+					var keyids []string
+					for _, signature := range details.Signatures {
+						keyids = append(keyids, signature.Keyid)
+					}
+					return H("tr",
+						H("td", H("a href=%s", packageHref(version.Ecosystem, name, v), key)),
+						H("td.hash", details.Integrity),
+						H("td.hash", details.Shasum),
+						H("td.hash", strings.Join(keyids, ", ")),
+					)
+				},
+			}),
+			Pagination(PaginationProps{Locale: l, Page: page, Pages: pages, Href: func(page int) string {
+				return query.Href("integrity_page", strconv.Itoa(page)) + "#integrity"
+			}}),
+		)
+		tabs = append(tabs, Tab{l.T("Integrity"), "integrity", integrityTable})
+	}
+	if len(version.Publishers) > 1 {
+		pubRows, page, pages := Paginate(sortedMapByIntValue(version.Publishers), query.PublishersPage)
+		pubTable := Fragment(
+			DataTable(DataTableProps[IntEntry]{
+				Columns: []string{l.T("publisher"), l.T("count")},
+				Rows:    pubRows,
+				Row: func(entry IntEntry) Node {
+					return H("tr", H("td", entry.Key), H("td", entry.Value))
+				},
+			}),
+			// the hash opens the publishers tab again, see main.js
+			Pagination(PaginationProps{Locale: l, Page: page, Pages: pages, Href: func(page int) string {
+				return query.Href("publishers_page", strconv.Itoa(page)) + "#publishers"
+			}}),
+		)
+		tabs = append(tabs, Tab{l.T("Publishers"), "publishers", pubTable})
+	}
+	if len(version.Maintainers) > 0 {
+		maintainerRows, page, pages := Paginate(sortedMapByIntValue(version.Maintainers), query.MaintainersPage)
+		maintainerTable := Fragment(
+			H("p", l.T("Every npm account that can publish a new version of a package in the tree, with the number of versions.")),
+			DataTable(DataTableProps[IntEntry]{
+				Columns: []string{l.T("maintainer"), l.T("count")},
+				Rows:    maintainerRows,
+				Row: func(entry IntEntry) Node {
+					return H("tr",
+						H("td", H("a href=%s target=_blank", "https://www.npmjs.com/~"+entry.Key, entry.Key)),
+						H("td", entry.Value),
+					)
+				},
+			}),
+			Pagination(PaginationProps{Locale: l, Page: page, Pages: pages, Href: func(page int) string {
+				return query.Href("maintainers_page", strconv.Itoa(page)) + "#maintainers"
+			}}),
+		)
+		tabs = append(tabs, Tab{l.T("Maintainers"), "maintainers", maintainerTable})
 	}
-
-	var vulnTable Node
 	if len(version.Vulnerabilities) > 0 {
-		var vulns []Node
-		for _, vulnerability := range version.Vulnerabilities {
-			vulns = append(vulns, H("tr",
-				H("td", H("a href=%s", npmHref(vulnerability.PackageName, ""), vulnerability.PackageName)),
-				H("td", H("a href=%s target=_blank", "https://security.snyk.io/vuln/"+vulnerability.Id, vulnerability.Title)),
-				H("td", string(vulnerability.Severity)),
-				H("td", vulnerability.PublicationTime.Format("2006-01-02")),
-				H("td", strings.Join(vulnerability.Semver.Vulnerable, " \u00a0 ")),
-			))
-		}
-		vulnTable = H("table", H("tr",
-			H("th", "package"),
-			H("th", "title"),
-			H("th", "severity"),
-			H("th", "date"),
-			H("th", "affected"),
-		), vulns)
-		tabs = append(tabs, Tab{"Vulnerabilities", "vulnerabilities", vulnTable})
-	}
-
-	title := info.Name + " " + info.Version + " dependencies"
-	return Layout(title,
+		vulnTable := DataTable(DataTableProps[Vulnerability]{
+			Columns: []string{l.T("package"), l.T("title"), l.T("severity"), l.T("date"), l.T("affected")},
+			Rows:    version.Vulnerabilities,
+			Row: func(vulnerability Vulnerability) Node {
+				return H("tr",
+					H("td", H("a href=%s", packageHref(version.Ecosystem, vulnerability.PackageName, ""), vulnerability.PackageName)),
+					H("td", H("a href=%s target=_blank", vulnerabilityUrl(vulnerability), vulnerability.Title)),
+					H("td", SeverityBadge(SeverityBadgeProps{Locale: l, Severity: vulnerability.Severity})),
+					H("td", If(!vulnerability.PublicationTime.IsZero(), TextNode(vulnerability.PublicationTime.Format("2006-01-02")))),
+					H("td", strings.Join(vulnerability.Semver.Vulnerable, " \u00a0 ")),
+				)
+			},
+		})
+		tabs = append(tabs, Tab{l.T("Vulnerabilities"), "vulnerabilities", vulnTable})
+	}
+	if version.Locked {
+		driftTable := Fragment(
+			H("p", l.T("Where the versions in the package-lock.json do not follow the ranges in the package.json.")),
+			DataTable(DataTableProps[Drift]{
+				Columns: []string{l.T("package"), l.T("kind"), l.T("declared"), l.T("locked"), l.T("newest")},
+				Rows:    version.Drift,
+				Row: func(drift Drift) Node {
+					return H("tr",
+						H("td", H("a href=%s", packageHref(version.Ecosystem, drift.Name, ""), drift.Name)),
+						H("td", l.T(drift.Kind)),
+						H("td", drift.Declared),
+						H("td", drift.Locked),
+						H("td", drift.Newest),
+					)
+				},
+				Empty: l.T("The lockfile follows the package.json."),
+			}),
+		)
+		tabs = append(tabs, Tab{l.T("Drift"), "drift", driftTable})
+	}
+
+	title := l.T("%s %s dependencies", info.Name, info.Version)
+	return LayoutWithMeta(l, title, Meta{Description: versionDescription(l, version), Path: path},
 		H(".main",
 			H("h1", title),
 			H("table",
-				description,
+				If(info.Description != "", H("tr", H("th", l.T("description:")), H("td", info.Description))),
 				homepage,
-				license,
-				npmUser,
-				publishedAt,
+				If(license != "", H("tr", H("th", l.T("license:")), H("td", license))),
+				If(publisher != "", H("tr", H("th", l.T("published by:")), H("td", maintainerLink(info, publisher)))),
+				typingsRow(l, info.Name, version.Typings[info.Name]),
+				If(npm, H("tr", H("th", l.T("module format:")), H("td", formatLabel(l, info.ModuleFormat())))),
+				H("tr", H("th", l.T("published at:")), H("td", version.Time.Format("2006-01-02 15:04 Z07:00"))),
+				If(info.Dist.Integrity != "", H("tr", H("th", l.T("integrity:")), H("td.hash", info.Dist.Integrity))),
+				If(npm && path != "", H("tr", H("th", l.T("badge:")), H("td",
+					H("img src=%s alt=%s", Href("/badge"+path), "health: "+health.Grade),
+					H("code", fmt.Sprintf("[![health](%s)](%s)",
+						absoluteUrl("/badge/npm/"+info.Name), absoluteUrl("/npm/"+info.Name))),
+				))),
 			),
-			errors,
-			stats,
+			// uploaded files are not published, so they have no other versions
+			If(npm && path != "", H("p", H("a href=%s", Href("/npm/"+info.Name+"/versions"), l.T("all versions")))),
+			If(version.Readme != "", H("details.readme",
+				H("summary", l.T("Readme")),
+				UnsafeRawContent(RenderReadme(version.Readme)),
+			)),
+			If(len(conflicts) > 0 || len(mismatches) > 0 || len(licenseConflicts) > 0, H(".warnings",
+				H("h3", l.T("Warnings")),
+				H("ul",
+					ForEach(licenseConflicts, func(usage LicenseUsage) Node {
+						return H("li",
+							l.T("%s is licensed as %s (%s), which a package licensed as %s may not use.",
+								strings.Join(usage.Packages, ", "), usage.License, l.T(string(usage.Kind)), license),
+						)
+					}),
+					ForEach(mismatches, func(mismatch RepositoryMismatch) Node {
+						name, v := SplitDependencyKey(mismatch.Key)
+						return H("li",
+							H("a href=%s", packageHref(version.Ecosystem, name, v), mismatch.Key),
+							l.T("declares repository %s, but its provenance says it was built from %s. It was published by %s.",
+								mismatch.Repository, mismatch.Provenance, mismatch.Publisher),
+						)
+					}),
+					ForEach(conflicts, func(conflict MajorConflict) Node {
+						return H("li",
+							l.T("%s is resolved at %d major versions:", conflict.Name, len(conflict.Paths)),
+							H("ul", ForEach(conflict.Paths, func(path []string) Node {
+								return H("li", renderPath(version.Ecosystem, info.Name, path))
+							})),
+						)
+					}),
+				),
+			)),
+			If(len(version.Errors) > 0, H(".errors",
+				H("h3", l.T("Errors")),
+				H("ul", ForEach(version.Errors, func(e string) Node { return H("li", e) })),
+			)),
+			statsNode,
+			offendersNode,
+			breakdown,
+			extra,
 			H("hr"),
+			If(len(version.Edges) > 0, dependencySearch(l, version, query)),
 			RenderTabs(tabs),
 		),
 	)
 }
 
-func WaitView(name string) Node {
-	title := "Waiting for " + name + "..."
-	message := "Please wait while the dependencies of " + name + " are being fetched. " +
-		"This may take a minute or so, depending on the number of dependencies. " +
-		"This page will automatically refresh when it is ready."
-	script := UnsafeRawContent("setTimeout(() => document.location.reload(), 2000);")
+const TIMELINE_WIDTH = 1000
+const TIMELINE_HEIGHT = 120
 
-	return Layout(title,
+// releaseTimeline is a bar chart of the versions per month, with the years below it
+func releaseTimeline(l Locale, info *PackageInfo) Node {
+	first, counts := info.ReleasesPerMonth()
+	if len(counts) < 2 {
+		return nil
+	}
+	max := 0
+	for _, count := range counts {
+		if count > max {
+			max = count
+		}
+	}
+	f := func(n float64) string { return strconv.FormatFloat(n, 'f', 1, 64) }
+	barWidth := float64(TIMELINE_WIDTH) / float64(len(counts))
+	chartHeight := float64(TIMELINE_HEIGHT - 20) // room for the years
+	var bars []Node
+	for i, count := range counts {
+		month := first.AddDate(0, i, 0)
+		x := float64(i) * barWidth
+		if month.Month() == time.January || i == 0 {
+			bars = append(bars, H("text", month.Format("2006")).Attr("x", f(x)).Attr("y", f(TIMELINE_HEIGHT-4)))
+		}
+		if count == 0 {
+			continue
+		}
+		h := chartHeight * float64(count) / float64(max)
+		bars = append(bars, H("g",
+			H("title", l.T("%s: %d versions", month.Format("2006-01"), count)),
+			H("rect").Attr("x", f(x)).Attr("y", f(chartHeight-h)).Attr("width", f(math.Max(barWidth-1, 1))).Attr("height", f(h)),
+		))
+	}
+	return H(".timeline",
+		H("h3", l.T("Releases per month")),
+		H("svg", bars).Attr("viewBox", fmt.Sprintf("0 0 %d %d", TIMELINE_WIDTH, TIMELINE_HEIGHT)),
+	)
+}
+
+// historyChart is a bar chart of a stat of the snapshots, from old to new, with the first and last day below it
+func historyChart(heading string, snapshots []StatSnapshotRow, value func(StatSnapshotRow) int64,
+	format func(int64) string) Node {
+	var max int64
+	for _, snapshot := range snapshots {
+		if v := value(snapshot); v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		return nil
+	}
+	f := func(n float64) string { return strconv.FormatFloat(n, 'f', 1, 64) }
+	barWidth := float64(TIMELINE_WIDTH) / float64(len(snapshots))
+	chartHeight := float64(TIMELINE_HEIGHT - 20) // room for the days
+	var bars []Node
+	for i, snapshot := range snapshots {
+		if value(snapshot) == 0 {
+			continue
+		}
+		x := float64(i) * barWidth
+		h := chartHeight * float64(value(snapshot)) / float64(max)
+		bars = append(bars, H("g",
+			H("title", fmt.Sprintf("%s %s: %s", snapshot.Day, snapshot.Version, format(value(snapshot)))),
+			H("rect").Attr("x", f(x)).Attr("y", f(chartHeight-h)).Attr("width", f(math.Max(barWidth-1, 1))).Attr("height", f(h)),
+		))
+	}
+	last := snapshots[len(snapshots)-1].Day
+	bars = append(bars,
+		H("text", snapshots[0].Day).Attr("x", "0").Attr("y", f(TIMELINE_HEIGHT-4)),
+		H("text", last).Attr("x", f(TIMELINE_WIDTH)).Attr("y", f(TIMELINE_HEIGHT-4)).Attr("text-anchor", "end"),
+	)
+	return H(".timeline",
+		H("h3", heading),
+		H("svg", bars).Attr("viewBox", fmt.Sprintf("0 0 %d %d", TIMELINE_WIDTH, TIMELINE_HEIGHT)),
+	)
+}
+
+// statsHistory shows how the stats of the analyses of the package changed, when it was analyzed more than once
+func statsHistory(l Locale, snapshots []StatSnapshotRow) Node {
+	if len(snapshots) < 2 {
+		return nil
+	}
+	count := func(n int64) string { return strconv.FormatInt(n, 10) }
+	return Fragment(
+		historyChart(l.T("Dependencies over time"), snapshots,
+			func(s StatSnapshotRow) int64 { return int64(s.Packages) }, count),
+		historyChart(l.T("Disk space over time"), snapshots,
+			func(s StatSnapshotRow) int64 { return s.DiskSpace }, formatSize),
+		historyChart(l.T("Vulnerabilities over time"), snapshots,
+			func(s StatSnapshotRow) int64 { return int64(s.Vulnerabilities) }, count),
+	)
+}
+
+// PackageVersionsView lists the published versions of a package, newest first, with the stats of earlier analyses
+func PackageVersionsView(l Locale, info *PackageInfo, snapshots []StatSnapshotRow, query TableQuery) Node {
+	tags := info.DistTags.VersionTags()
+	rows, page, pages := Paginate(info.SortedVersions(), query.Page)
+	title := l.T("%s versions", info.Name)
+	return LayoutWithMeta(l, title, Meta{Path: "/npm/" + info.Name + "/versions"},
+		H(".main",
+			H("h1", title),
+			releaseTimeline(l, info),
+			statsHistory(l, snapshots),
+			DataTable(DataTableProps[string]{
+				Columns: []string{l.T("version"), l.T("published at"), l.T("tags")},
+				Rows:    rows,
+				Row: func(v string) Node {
+					var published string
+					if t, ok := info.Time[v]; ok {
+						published = t.Format("2006-01-02 15:04 Z07:00")
+					}
+					return H("tr",
+						H("td", H("a href=%s", npmHref(info.Name, v), v)),
+						H("td", published),
+						H("td", strings.Join(tags[v], ", ")),
+					)
+				},
+			}),
+			Pagination(PaginationProps{Locale: l, Page: page, Pages: pages, Href: func(page int) string {
+				return query.Href("page", strconv.Itoa(page))
+			}}),
+		),
+	)
+}
+
+// WaitView shows the progress from the event stream at eventsPath, and reloads when it sends "ready", see main.js
+func WaitView(l Locale, name string, eventsPath string) Node {
+	title := l.T("Waiting for %s...", name)
+	message := l.T("Please wait while the dependencies of %s are being fetched. "+
+		"This may take a minute or so, depending on the number of dependencies. "+
+		"This page will automatically refresh when it is ready.", name)
+
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", message),
+			H("p.wait-progress"),
+		).Data(map[string]string{"events": Href(eventsPath)}),
+	)
+}
+
+func BusyView(l Locale, retryAfter int) Node {
+	title := l.T("Too busy")
+	message := l.T("%s is fetching the dependencies of a lot of packages right now. "+
+		"Please try again in a minute. This page will automatically refresh.", Config.Theme.SiteName)
+	script := UnsafeRawContent(fmt.Sprintf("setTimeout(() => document.location.reload(), %d);", retryAfter*1000))
+
+	return Layout(l, title,
 		H(".main",
 			H("h1", title),
 			H("p", message),
@@ -283,16 +1083,42 @@ func WaitView(name string) Node {
 	)
 }
 
+// maintainerLink links the publisher to the other packages of its npm account
+func maintainerLink(info VersionInfo, publisher string) Node {
+	if info.NpmUser.Name == "" {
+		return TextNode(publisher)
+	}
+	return H("a href=%s", Href("/maintainer/"+url.PathEscape(info.NpmUser.Name)), publisher)
+}
+
 func linkPackage(name string) Node {
-	return H("a href=%s", "/npm/"+name, name)
+	return H("a href=%s", npmHref(name, ""), name)
 }
 
-func HomeView() Node {
-	title := "independ: know your dependencies"
-	return Layout(title,
+// watchLink is only shown when emails can be sent
+func watchLink(l Locale) Node {
+	if Config.Mail.Server == "" {
+		return nil
+	}
+	return H("p", H("a href=%s", Href("/watch"), l.T("Get a weekly digest of your packages by email")))
+}
+
+// HomeStats are the numbers of analyzed packages and versions on the home page, they are 0 when unknown
+type HomeStats struct {
+	Packages int64
+	Versions int64
+}
+
+func HomeView(l Locale, stats HomeStats, csrf string) Node {
+	title := l.T("%s: know your dependencies", Config.Theme.SiteName)
+	return Layout(l, title,
 		H(".main",
 			H("h1", title),
-			H("h3", "Check out some examples:"),
+			If(stats.Packages > 0, StatCards(
+				StatCard(StatCardProps{Label: l.T("packages analyzed"), Value: strconv.FormatInt(stats.Packages, 10)}),
+				StatCard(StatCardProps{Label: l.T("versions analyzed"), Value: strconv.FormatInt(stats.Versions, 10)}),
+			)),
+			H("h3", l.T("Check out some examples:")),
 			H("p",
 				linkPackage("@angular/cli"),
 				H("br"),
@@ -304,32 +1130,684 @@ func HomeView() Node {
 				H("br"),
 				linkPackage("webpack"),
 			),
-			H("h3", "Go to another package:"),
-			H("form action=/go > p",
-				H("input name=package placeholder=%s required=required", "Package name"),
-				H("button", "Go"),
+			H("p",
+				H("a href=%s", Href("/recent"), l.T("Recently analyzed and trending packages")),
+				H("br"),
+				H("a href=%s", Href("/vulnerabilities"), l.T("Vulnerability statistics")),
+				H("br"),
+				H("a href=%s", Href("/publishers"), l.T("Publishers in the most dependency trees")),
+				H("br"),
+				H("a href=%s", Href("/semver"), l.T("Semver constraint explainer")),
+			),
+			H("h3", l.T("Go to another package:")),
+			H("form action=%s > p", Href("/go"),
+				H("input name=package placeholder=%s required=required autocomplete=off list=package-suggestions data-search=%s",
+					l.T("Package name"), Href("/api/search")),
+				H("datalist id=package-suggestions"),
+				H("button", l.T("Go")),
 			),
-			H("h3", "Upload package.json:"),
-			H("form method=POST action=/upload enctype=multipart/form-data > p",
+			H("h3", l.T("Upload package.json or packages.lock.json of NuGet:")),
+			H("form method=POST action=%s enctype=multipart/form-data > p", Href("/upload"),
 				H("input type=file name=file required=required"),
-				H("button", "Upload"),
+				H("label", l.T("package-lock.json (optional) "), H("input type=file name=lockfile")),
+				csrfField(csrf),
+				H("button", l.T("Upload")),
+			),
+			H("h3", l.T("Or analyze a repository on GitHub, GitLab or Bitbucket:")),
+			H("form method=POST action=%s > p", Href("/repository"),
+				H("input type=url name=url placeholder=%s required=required", l.T("Repository url")),
+				csrfField(csrf),
+				H("button", l.T("Analyze")),
+			),
+			watchLink(l),
+		),
+	)
+}
+
+// trend compares the lookups of the last days with the days before
+func trend(l Locale, row TrendingRow) string {
+	if row.Previous == 0 {
+		return l.T("new")
+	}
+	return fmt.Sprintf("%+d%%", (row.Recent-row.Previous)*100/row.Previous)
+}
+
+func RecentView(l Locale, versions []RecentVersionRow, trending []TrendingRow) Node {
+	title := l.T("Recently analyzed and trending")
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("h3", l.T("Trending in the last %d days", TRENDING_DAYS)),
+			DataTable(DataTableProps[TrendingRow]{
+				Columns: []string{l.T("package"), l.T("lookups"), l.T("change")},
+				Rows:    trending,
+				Row: func(row TrendingRow) Node {
+					return H("tr",
+						H("td", linkPackage(row.Name)),
+						H("td.number", formatCount(row.Recent)),
+						H("td.number", trend(l, row)),
+					)
+				},
+				Empty: l.T("No packages were looked up in the last %d days.", TRENDING_DAYS),
+			}),
+			H("h3", l.T("Recently analyzed")),
+			DataTable(DataTableProps[RecentVersionRow]{
+				Columns: []string{l.T("package"), l.T("version"), l.T("analyzed at")},
+				Rows:    versions,
+				Row: func(row RecentVersionRow) Node {
+					day := row.CreateTime
+					if len(day) > 16 {
+						day = day[:16]
+					}
+					return H("tr",
+						H("td", linkPackage(row.Name)),
+						H("td", H("a href=%s", npmHref(row.Name, row.Version), row.Version)),
+						H("td", day),
+					)
+				},
+				Empty: l.T("No packages were analyzed yet."),
+			}),
+			H("p", l.T("Uploaded files are private, they are never listed here.")),
+		),
+	)
+}
+
+// VulnerabilitiesData is the summary of the vulnerability database
+type VulnerabilitiesData struct {
+	Totals   VulnerabilityStats
+	Newest   []Vulnerability
+	Packages IntEntries // with the most vulnerabilities
+	Sources  []VulnerabilitySourceRow
+}
+
+// formatLag shows how long ago a time in the db was, or "" when it is not set
+func formatLag(l Locale, raw string, now time.Time) string {
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return ""
+	}
+	lag := now.Sub(t)
+	if lag < 48*time.Hour {
+		return l.T("%d hours ago", int(lag.Hours()))
+	}
+	return l.T("%d days ago", int(lag.Hours()/24))
+}
+
+func VulnerabilitiesView(l Locale, data VulnerabilitiesData, now time.Time) Node {
+	title := l.T("Vulnerability statistics")
+	totals := data.Totals
+	total := totals.LowCount + totals.MediumCount + totals.HighCount + totals.CriticalCount
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			StatCards(
+				StatCard(StatCardProps{Label: l.T("vulnerabilities"), Value: strconv.Itoa(total)}),
+				StatCard(StatCardProps{Label: l.T("critical"), Value: strconv.Itoa(totals.CriticalCount)}),
+				StatCard(StatCardProps{Label: l.T("high"), Value: strconv.Itoa(totals.HighCount)}),
+				StatCard(StatCardProps{Label: l.T("medium"), Value: strconv.Itoa(totals.MediumCount)}),
+				StatCard(StatCardProps{Label: l.T("low"), Value: strconv.Itoa(totals.LowCount)}),
 			),
+			H("h3", l.T("Sources")),
+			DataTable(DataTableProps[VulnerabilitySourceRow]{
+				Columns: []string{l.T("source"), l.T("last update"), l.T("last error")},
+				Rows:    data.Sources,
+				Row: func(row VulnerabilitySourceRow) Node {
+					return H("tr",
+						H("td", row.Source),
+						H("td", formatLag(l, row.SyncTime, now)),
+						H("td", If(row.LastError != "", TextNode(row.LastError+" ("+formatLag(l, row.ErrorTime, now)+")"))),
+					)
+				},
+				Empty: l.T("The vulnerabilities were not updated yet."),
+			}),
+			H("h3", l.T("Newest vulnerabilities")),
+			DataTable(DataTableProps[Vulnerability]{
+				Columns: []string{l.T("package"), l.T("title"), l.T("severity"), l.T("date")},
+				Rows:    data.Newest,
+				Row: func(vulnerability Vulnerability) Node {
+					return H("tr",
+						H("td", linkPackage(vulnerability.PackageName)),
+						H("td", H("a href=%s target=_blank", vulnerabilityUrl(vulnerability), vulnerability.Title)),
+						H("td", SeverityBadge(SeverityBadgeProps{Locale: l, Severity: vulnerability.Severity})),
+						H("td", vulnerability.PublicationTime.Format("2006-01-02")),
+					)
+				},
+			}),
+			H("h3", l.T("Most affected packages")),
+			DataTable(DataTableProps[IntEntry]{
+				Columns: []string{l.T("package"), l.T("vulnerabilities")},
+				Rows:    data.Packages,
+				Row: func(entry IntEntry) Node {
+					return H("tr", H("td", linkPackage(entry.Key)), H("td.number", entry.Value))
+				},
+			}),
 		),
 	)
 }
 
-func ErrorView(title string, err string, trace string) Node {
-	return Layout(title,
+func PublishersView(l Locale, publishers []PublisherStatsRow, trees int64) Node {
+	title := l.T("Publishers in the most dependency trees")
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", l.T("The npm accounts that published a package in the dependency trees of the %d analyzed versions. "+
+				"When one of these accounts is compromised, all these trees are at risk.", trees)),
+			DataTable(DataTableProps[PublisherStatsRow]{
+				Columns: []string{l.T("publisher"), l.T("trees"), l.T("share"), l.T("versions")},
+				Rows:    publishers,
+				Row: func(row PublisherStatsRow) Node {
+					share := ""
+					if trees > 0 {
+						share = fmt.Sprintf("%.1f%%", float64(row.Trees)*100/float64(trees))
+					}
+					return H("tr",
+						H("td", row.Publisher),
+						H("td.number", row.Trees),
+						H("td.number", share),
+						H("td.number", row.Versions),
+					)
+				},
+				Empty: l.T("No versions were analyzed yet."),
+			}),
+		),
+	)
+}
+
+// MaintainerView shows the packages of an npm account with their downloads, most downloaded first
+func MaintainerView(l Locale, user string, results []SearchResult, downloads map[string]int64) Node {
+	title := l.T("Packages of %s", user)
+	sorted := append([]SearchResult{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return downloads[sorted[i].Name] > downloads[sorted[j].Name] })
+	var total int64
+	lastPublished := ""
+	for _, result := range results {
+		total += downloads[result.Name]
+		if result.Date > lastPublished {
+			lastPublished = result.Date
+		}
+	}
+	if len(lastPublished) > 10 {
+		lastPublished = lastPublished[:10]
+	}
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			If(len(results) > 0, StatCards(
+				StatCard(StatCardProps{Label: l.T("packages"), Value: strconv.Itoa(len(results))}),
+				StatCard(StatCardProps{Label: l.T("weekly downloads"), Value: formatCount(total)}),
+				StatCard(StatCardProps{Label: l.T("last published"), Value: lastPublished}),
+			)),
+			If(len(results) == SEARCH_MAX_SIZE, H("p", l.T("Only the first %d packages are shown.", SEARCH_MAX_SIZE))),
+			DataTable(DataTableProps[SearchResult]{
+				Columns: []string{l.T("package"), l.T("version"), l.T("published at"), l.T("weekly downloads"),
+					l.T("description")},
+				Rows: sorted,
+				Row: func(result SearchResult) Node {
+					day := result.Date
+					if len(day) > 10 {
+						day = day[:10]
+					}
+					return H("tr",
+						H("td", linkPackage(result.Name)),
+						H("td", H("a href=%s", npmHref(result.Name, result.Version), result.Version)),
+						H("td", day),
+						H("td.number", formatCount(downloads[result.Name])),
+						H("td", result.Description),
+					)
+				},
+				Empty: l.T("No packages were found for the npm account %s.", user),
+			}),
+		),
+	)
+}
+
+type SemverForm struct {
+	Package    string
+	Constraint string
+}
+
+// SemverView explains a constraint with the published versions of a package, matches is nil before the form is sent
+func SemverView(l Locale, form SemverForm, matches []SemverMatch, picked string, message string) Node {
+	title := l.T("Semver constraint explainer")
+	var result Node
+	if matches != nil {
+		count := 0
+		for _, match := range matches {
+			if match.Matches {
+				count++
+			}
+		}
+		pickedNode := H("p", l.T("No version matches, so %s can not be installed with this constraint.", form.Package))
+		if picked != "" {
+			pickedNode = H("p", l.T("%d of %d versions match, npm installs the highest one:", count, len(matches)),
+				H("a href=%s", npmHref(form.Package, picked), picked))
+		}
+		result = Fragment(
+			pickedNode,
+			DataTable(DataTableProps[SemverMatch]{
+				Columns: []string{l.T("version"), l.T("matches"), l.T("reason")},
+				Rows:    matches,
+				Row: func(match SemverMatch) Node {
+					matchesText := l.T("no")
+					if match.Matches {
+						matchesText = l.T("yes")
+					}
+					if match.Version == picked {
+						matchesText = l.T("yes, picked")
+					}
+					return H("tr",
+						H("td", H("a href=%s", npmHref(form.Package, match.Version), match.Version)),
+						H("td", matchesText),
+						H("td", match.Reason),
+					)
+				},
+			}),
+		)
+	}
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", l.T("See which published versions of a package match a version constraint, like in package.json.")),
+			H("form action=%s > p", Href("/semver"),
+				H("input name=package placeholder=%s required=required value=%s", l.T("Package name"), form.Package),
+				H("input name=constraint placeholder=%s required=required value=%s", "^1.2.0", form.Constraint),
+				H("button", l.T("Explain")),
+			),
+			If(message != "", H("p", H("b", message))),
+			result,
+			H("p", l.T("Prereleases, like 2.0.0-beta.1, only match a constraint with a prerelease of the same major, "+
+				"minor and patch version, like ^2.0.0-beta.0. So a prerelease is never installed by accident.")),
+		),
+	)
+}
+
+// NotFoundView is shown for unknown paths, most visitors are looking for a package
+func NotFoundView(l Locale, path string) Node {
+	title := l.T("Page not found")
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", l.T("There is no page at %s.", path)),
+			H("h3", l.T("Look up a package:")),
+			H("form action=%s > p", Href("/go"),
+				H("input name=package placeholder=%s required=required", l.T("Package name")),
+				H("button", l.T("Go")),
+			),
+			H("p", H("a href=%s", Href("/"), l.T("Go to the home page"))),
+		),
+	)
+}
+
+func FileDeletedView(l Locale) Node {
+	title := l.T("File deleted")
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", l.T("Your uploaded file has been deleted.")),
+		),
+	)
+}
+
+func ErrorView(l Locale, title string, err string, trace string) Node {
+	return Layout(l, title,
 		H("div",
 			H("h3", title),
 			H("p", err),
-			H("h4", "Technical Information"),
+			H("h4", l.T("Technical Information")),
 			H("pre", trace),
 		),
 	)
 }
 
-func PageView(page Page) Node {
-	content := UnsafeRawContent(page.Content)
-	return Layout(page.Title, content)
+func WatchView(l Locale, message string, csrf string) Node {
+	title := l.T("Weekly digest")
+	var messageNode Node
+	if message != "" {
+		messageNode = H("p", H("b", message))
+	}
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", l.T("Get a weekly email with the new versions, vulnerabilities and number of dependencies of your packages.")),
+			messageNode,
+			H("form method=POST action=%s", Href("/watch"),
+				H("p", H("input type=email name=email placeholder=%s required=required", l.T("Email address"))),
+				H("p", H("textarea name=packages rows=5 cols=40 placeholder=%s required=required", l.T("Package names"))),
+				H("p", H("input type=checkbox name=alerts value=1").BoolAttr("checked", true),
+					l.T(" Also email me right away about new vulnerabilities in the dependencies")),
+				csrfField(csrf),
+				H("p", H("button", l.T("Subscribe"))),
+			),
+		),
+	)
+}
+
+func authView(l Locale, title string, action string, message string, csrf string, button string, other Node) Node {
+	var messageNode Node
+	if message != "" {
+		messageNode = H("p", H("b", message))
+	}
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			messageNode,
+			H("form method=POST action=%s", Href(action),
+				H("p", H("input type=email name=email placeholder=%s required=required", l.T("Email address"))),
+				H("p", H("input type=password name=password placeholder=%s required=required", l.T("Password"))),
+				csrfField(csrf),
+				H("p", H("button", button)),
+			),
+			githubLoginLink(l),
+			other,
+		),
+	)
+}
+
+func githubLoginLink(l Locale) Node {
+	if Config.Github.ClientId == "" {
+		return nil
+	}
+	return H("p", H("a href=%s", Href("/login/github"), l.T("Sign in with GitHub")))
+}
+
+func SignupView(l Locale, message string, csrf string) Node {
+	return authView(l, l.T("Sign up"), "/signup", message, csrf, l.T("Sign up"),
+		H("p", l.T("The password needs at least %d characters. Already have an account? ", MIN_PASSWORD_LENGTH),
+			H("a href=%s", Href("/login"), l.T("Log in"))))
+}
+
+func LoginView(l Locale, message string, csrf string) Node {
+	return authView(l, l.T("Log in"), "/login", message, csrf, l.T("Log in"),
+		H("p", l.T("No account yet? "), H("a href=%s", Href("/signup"), l.T("Sign up"))))
+}
+
+func githubAccountNode(l Locale, user *UserRow) Node {
+	if user.GithubLogin != "" {
+		return H("p", l.T("GitHub account: "), H("a href=%s", Config.Github.Url+"/"+user.GithubLogin, user.GithubLogin))
+	}
+	if Config.Github.ClientId == "" {
+		return nil
+	}
+	return H("p", H("a href=%s", Href("/login/github"), l.T("Connect your GitHub account")))
+}
+
+func AccountView(l Locale, user *UserRow, files []UserFileRow, watches []WatchRow, csrf string) Node {
+	title := l.T("Account")
+	var fileItems []Node
+	for _, file := range files {
+		day := file.CreateTime
+		if len(day) > 10 {
+			day = day[:10]
+		}
+		fileItems = append(fileItems, H("li", H("a href=%s", Href("/file/"+file.Id), file.Id), l.T(" uploaded %s", day)))
+	}
+	var watchItems []Node
+	for _, watch := range watches {
+		status := ""
+		if !watch.Confirmed {
+			status = l.T(" (not confirmed)")
+		}
+		watchItems = append(watchItems, H("li",
+			l.T("%s to %s", strings.ReplaceAll(watch.Packages, " ", ", "), watch.Email)+status+" ",
+			H("a href=%s", Href("/watch/unsubscribe?token="+watch.Token), l.T("unsubscribe")),
+		))
+	}
+	var filesNode, watchesNode Node = H("p", l.T("No uploaded files.")), H("p", l.T("No weekly digests."))
+	if len(fileItems) > 0 {
+		filesNode = H("ul", fileItems)
+	}
+	if len(watchItems) > 0 {
+		watchesNode = H("ul", watchItems)
+	}
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", l.T("Logged in as %s", user.Email)),
+			githubAccountNode(l, user),
+			H("form method=POST action=%s", Href("/logout"), csrfField(csrf), H("button", l.T("Log out"))),
+			H("h3", l.T("Uploaded files")),
+			filesNode,
+			H("h3", l.T("Weekly digests")),
+			watchesNode,
+		),
+	)
+}
+
+func WatchMessageView(l Locale, title string, message string) Node {
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", message),
+		),
+	)
+}
+
+func ContactView(l Locale, message string, form ContactForm, csrf string) Node {
+	title := l.T("Contact")
+	var messageNode Node
+	if message != "" {
+		messageNode = H("p", H("b", message))
+	}
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", l.T("Questions, ideas or problems? Send us a message.")),
+			messageNode,
+			H("form method=POST action=%s", Href("/contact"),
+				H("p", H("input name=name placeholder=%s value=%s", l.T("Name"), form.Name)),
+				H("p", H("input type=email name=email placeholder=%s required=required value=%s", l.T("Email address"), form.Email)),
+				H("p", H("textarea name=message rows=10 cols=60 placeholder=%s required=required", l.T("Message"), form.Message)),
+				// the honeypot, see CONTACT_HONEYPOT
+				H("p.hidden-field", H("input name=%s autocomplete=off", CONTACT_HONEYPOT).IntAttr("tabindex", -1)),
+				csrfField(csrf),
+				H("p", H("button", l.T("Send"))),
+			),
+		),
+	)
+}
+
+func WatchUnsubscribeView(l Locale, token string, packages string, csrf string) Node {
+	title := l.T("Unsubscribe")
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			H("p", l.T("Stop the weekly digest for %s?", strings.ReplaceAll(packages, " ", ", "))),
+			H("form method=POST action=%s", Href("/watch/unsubscribe"),
+				H("input type=hidden name=token value=%s", token),
+				csrfField(csrf),
+				H("button", l.T("Unsubscribe")),
+			),
+		),
+	)
+}
+
+func WatchConfirmMailView(data WatchConfirmMailData) Node {
+	return H("div",
+		H("p", "Please confirm that you want a weekly digest for "+strings.Join(data.Packages, ", ")+":"),
+		H("p", H("a href=%s", data.ConfirmUrl, "Confirm")),
+		H("p", "If you did not ask for this, you can ignore this email."),
+	)
+}
+
+// change describes a number in the digest, with the previous value if it changed
+func change(current int, previous *int) string {
+	if previous == nil || *previous == current {
+		return strconv.Itoa(current)
+	}
+	return fmt.Sprintf("%d (was %d)", current, *previous)
+}
+
+func VulnerabilityAlertMailView(data VulnerabilityAlertMailData) Node {
+	var rows []Node
+	for _, match := range data.Matches {
+		vulnerability := match.Vulnerability
+		rows = append(rows, H("p",
+			H("b", match.Package+" "+match.Version), H("br"),
+			string(vulnerability.Severity)+" in "+vulnerability.PackageName+": ",
+			H("a href=%s", vulnerabilityUrl(vulnerability), vulnerability.Title),
+		))
+	}
+	return H("div",
+		H("h3", "New vulnerabilities in your packages"),
+		rows,
+		H("p", H("a href=%s", data.UnsubscribeUrl, "Unsubscribe")),
+	)
+}
+
+func DigestMailView(data DigestMailData) Node {
+	var rows []Node
+	for _, entry := range data.Entries {
+		if !entry.Analyzed {
+			rows = append(rows, H("p", H("b", entry.Name), H("br"), "not analyzed yet"))
+			continue
+		}
+		current := entry.Current
+		version := current.Latest
+		var packages, vulnerabilities *int
+		if previous := entry.Previous; previous != nil {
+			if previous.Latest != "" && previous.Latest != current.Latest {
+				version += " (new, was " + previous.Latest + ")"
+			}
+			packages, vulnerabilities = &previous.Packages, &previous.Vulnerabilities
+		}
+		href := absoluteUrl("/npm/" + entry.Name + "/" + current.Latest)
+		rows = append(rows, H("p",
+			H("b", H("a href=%s", href, entry.Name)), H("br"),
+			"latest version: "+version, H("br"),
+			"dependencies: "+change(current.Packages, packages), H("br"),
+			"vulnerabilities: "+change(current.Vulnerabilities, vulnerabilities),
+		))
+	}
+	return H("div",
+		H("h3", "Your weekly digest"),
+		rows,
+		H("p", H("a href=%s", data.UnsubscribeUrl, "Unsubscribe")),
+	)
+}
+
+func ContactMailView(data ContactMailData) Node {
+	return H("div",
+		H("p", "From: "+data.Name+" <"+data.Email+">", H("br"), "IP: "+data.RemoteIp),
+		H("pre", data.Message),
+	)
+}
+
+// MailLayout wraps the body of every email
+func MailLayout(content Node) Node {
+	return H("div",
+		content,
+		H("hr"),
+		H("p", "Sent by "+Config.Theme.SiteName),
+	)
+}
+
+// ErrorMailView is the body of the error email, it is also rendered as text for the plain text part
+func ErrorMailView(subject string, trace string) Node {
+	return H("div",
+		H("h3", subject),
+		H("pre", trace),
+	)
+}
+
+func AdminLoginView(l Locale, message string, csrf string) Node {
+	title := l.T("Admin")
+	var messageNode Node
+	if message != "" {
+		messageNode = H("p", H("b", message))
+	}
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			messageNode,
+			H("form method=POST action=%s", Href("/admin/login"),
+				H("p", H("input type=password name=token placeholder=%s required=required", l.T("Admin token"))),
+				csrfField(csrf),
+				H("p", H("button", l.T("Log in"))),
+			),
+		),
+	)
+}
+
+func AdminPagesView(l Locale, pages []PageRow, csrf string) Node {
+	title := l.T("Pages")
+	var rows []Node
+	for _, page := range pages {
+		status := l.T("draft")
+		if page.Published {
+			status = l.T("published")
+		}
+		rows = append(rows, H("tr",
+			H("td", H("a href=%s", Href("/admin/pages/"+strconv.Itoa(page.Id)), page.Title)),
+			H("td", H("a href=%s", Href("/pages/"+page.Path), "/pages/"+page.Path)),
+			H("td", status),
+		))
+	}
+	var table Node = H("p", l.T("No pages yet, the pages directory is used."))
+	if len(rows) > 0 {
+		table = H("table", H("tbody", rows))
+	}
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			table,
+			H("p", H("a href=%s", Href("/admin/pages/new"), l.T("New page"))),
+			H("form method=POST action=%s", Href("/admin/logout"), csrfField(csrf), H("button", l.T("Log out"))),
+		),
+	)
+}
+
+func AdminPageEditView(l Locale, page PageRow, message string, csrf string) Node {
+	title := l.T("New page")
+	action := Href("/admin/pages/new")
+	var deleteForm Node
+	if page.Id != 0 {
+		title = l.T("Edit page")
+		action = Href("/admin/pages/" + strconv.Itoa(page.Id))
+		deleteForm = H("form method=POST action=%s", action+"/delete", csrfField(csrf), H("button", l.T("Delete page")))
+	}
+	var messageNode Node
+	if message != "" {
+		messageNode = H("p", H("b", message))
+	}
+	published := H("input type=checkbox name=published value=1").BoolAttr("checked", page.Published)
+	return Layout(l, title,
+		H(".main",
+			H("h1", title),
+			messageNode,
+			H("form method=POST action=%s", action,
+				H("p", "/pages/", H("input name=path placeholder=%s required=required value=%s", "about", page.Path)),
+				H("p", H("input name=title placeholder=%s required=required size=40 value=%s", l.T("Title"), page.Title)),
+				H("p", H("textarea name=content rows=20 cols=80 placeholder=%s", l.T("Markdown"), page.Content)),
+				H("p", published, l.T(" Published")),
+				csrfField(csrf),
+				H("p", H("button", l.T("Save"))),
+			),
+			deleteForm,
+		),
+	)
+}
+
+func navList(items []*NavItem, current string) Node {
+	var lis []Node
+	for _, item := range items {
+		var link Node = H("a href=%s", Href("/pages/"+item.Page.Path), item.Page.Title)
+		if item.Page.Path == current {
+			link = H("b", item.Page.Title)
+		}
+		var children Node
+		if len(item.Children) > 0 {
+			children = navList(item.Children, current)
+		}
+		lis = append(lis, H("li", link, children))
+	}
+	return H("ul", lis)
+}
+
+func PageView(l Locale, page Page) Node {
+	var nav Node
+	if section := NavigationSection(page.Path); section != nil && len(section.Children) > 0 {
+		nav = H(".page-nav", navList([]*NavItem{section}, page.Path))
+	}
+	meta := Meta{Description: page.Description, Image: page.Image, Path: "/pages/" + page.Path}
+	return LayoutWithMeta(l, page.Title, meta, H("div", nav, UnsafeRawContent(page.Content)))
 }
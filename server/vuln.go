@@ -1,9 +1,10 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/pkg/errors"
@@ -30,6 +31,7 @@ type Vulnerability struct {
 	PublicationTime time.Time  `json:"publicationTime"`
 	Semver          SemverSpec `json:"semver"`
 	Severity        Severity   `json:"severity"`
+	Url             string     `json:"url,omitempty"` // of the advisory, when it is not on snyk
 }
 
 type VulnerabilityResponse struct {
@@ -44,10 +46,17 @@ type VulnerabilityStats struct {
 	CriticalCount int `json:"criticalCount"`
 }
 
+// VULNERABILITY_SOURCE is where the vulnerabilities come from, the updates are recorded per source
+const VULNERABILITY_SOURCE = "snyk"
+
 func GetVulnerabilities(page int) ([]Vulnerability, error) {
 	url := fmt.Sprintf("https://security.snyk.io/api/listing?type=npm&pageNumber=%d", page)
 	var response VulnerabilityResponse
-	body, err := getBody(url)
+	var body []byte
+	err := retry(context.Background(), "get vulnerabilities", RETRY_ATTEMPTS, RETRY_BASE_DELAY, func() (err error) {
+		body, err = getBody(url)
+		return
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "could not get vulnerabilities")
 	}
@@ -77,32 +86,51 @@ func GetVulnerabilityStats(vulnerabilities []Vulnerability) VulnerabilityStats {
 	return stats
 }
 
+// recordVulnerabilitySync stores the outcome of an update, so the lag behind the source can be shown
+func recordVulnerabilitySync(err error) {
+	if dbErr := DbPutVulnerabilitySync(VULNERABILITY_SOURCE, time.Now(), err); dbErr != nil {
+		slog.Error("could not record vulnerability sync", "source", VULNERABILITY_SOURCE, "err", dbErr)
+	}
+}
+
 func UpdateVulnerabilities() {
 	last, err := DbLastVulnerability()
 	if err != nil {
-		log.Println("could not get last vuln", err)
+		slog.Error("could not get last vulnerability", "err", err)
 		return
 	}
 
+	// don't alert for the initial import of all vulnerabilities
+	var added []Vulnerability
+	defer func() {
+		if last != nil && len(added) > 0 {
+			AlertWatchers(added)
+		}
+	}()
+
 	page := 1
 	for {
 		vulnerabilities, err := GetVulnerabilities(page)
 		if err != nil {
-			log.Println("could not get vuln, break", err)
+			slog.Error("could not get vulnerabilities, stop", "page", page, "err", err)
+			recordVulnerabilitySync(err)
 			return
 		}
 		if len(vulnerabilities) == 0 {
-			log.Println("received all vulns")
+			slog.Info("received all vulnerabilities")
+			recordVulnerabilitySync(nil)
 			return
 		}
 		for _, vulnerability := range vulnerabilities {
 			if last != nil && vulnerability.Id == last.Id {
-				log.Println("received known vuln: " + last.Id)
+				slog.Info("received known vulnerability", "id", last.Id)
+				recordVulnerabilitySync(nil)
 				return
 			}
 			if err := DbPutVulnerability(vulnerability); err != nil {
-				log.Println("could not put vuln", err)
+				slog.Error("could not put vulnerability", "id", vulnerability.Id, "err", err)
 			}
+			added = append(added, vulnerability)
 		}
 		page++
 	}
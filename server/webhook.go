@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// kinds of alerts, the webhooks config can limit the kinds that are posted
+const ALERT_VULNERABILITY = "vulnerability"
+const ALERT_ANALYSIS = "analysis"
+const ALERT_POLICY = "policy"
+
+var alertKinds = []string{ALERT_VULNERABILITY, ALERT_ANALYSIS, ALERT_POLICY}
+
+type Alert struct {
+	Kind  string
+	Title string
+	Text  string
+	Url   string
+}
+
+func alertEnabled(kind string) bool {
+	config := Config.Webhooks
+	if len(config.Slack) == 0 && len(config.Discord) == 0 {
+		return false
+	}
+	return contains(config.Events, kind)
+}
+
+// slackEscaper escapes the control characters of slack mrkdwn, the titles and texts contain names from uploads and
+// ci checks that would otherwise add links
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackPayload(alert Alert) interface{} {
+	text := "*" + slackEscaper.Replace(alert.Title) + "*\n" + slackEscaper.Replace(alert.Text)
+	if alert.Url != "" {
+		text += "\n<" + slackEscaper.Replace(alert.Url) + ">"
+	}
+	return map[string]string{"text": text}
+}
+
+// discordPayload does not let the content mention anyone, a name like @everyone/x would ping the channel
+func discordPayload(alert Alert) interface{} {
+	content := "**" + alert.Title + "**\n" + alert.Text
+	if alert.Url != "" {
+		content += "\n" + alert.Url
+	}
+	return map[string]interface{}{
+		"content":          content,
+		"allowed_mentions": map[string][]string{"parse": {}},
+	}
+}
+
+func postWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return retry(context.Background(), "post webhook", RETRY_ATTEMPTS, RETRY_BASE_DELAY, func() error {
+		request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		response, err := httpClient.Do(request)
+		if err != nil {
+			return errors.Wrap(err, "could not post webhook")
+		}
+		response.Body.Close()
+		if response.StatusCode >= 300 {
+			return &StatusError{Code: response.StatusCode, Status: response.Status, Url: "webhook"}
+		}
+		return nil
+	})
+}
+
+// SendAlert posts the alert to the configured slack and discord webhooks, in the background
+func SendAlert(alert Alert) {
+	if !alertEnabled(alert.Kind) {
+		return
+	}
+	go func() {
+		for _, url := range Config.Webhooks.Slack {
+			if err := postWebhook(url, slackPayload(alert)); err != nil {
+				slog.Error("could not post slack alert", "title", alert.Title, "err", err)
+			}
+		}
+		for _, url := range Config.Webhooks.Discord {
+			if err := postWebhook(url, discordPayload(alert)); err != nil {
+				slog.Error("could not post discord alert", "title", alert.Title, "err", err)
+			}
+		}
+	}()
+}